@@ -0,0 +1,460 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	AbuseOpen      = "open"
+	AbuseDismissed = "dismissed"
+	AbuseActioned  = "actioned"
+
+	// maxOpenAbuseReports bounds the review queue, so that a flood of
+	// reports cannot fill the disk
+	maxOpenAbuseReports = 10000
+	maxAbuseReportText  = 4096
+)
+
+// AbuseReport is a third party's complaint about an object this gateway
+// serves.
+type AbuseReport struct {
+	ID       string `json:"id"`
+	RootHash string `json:"root_hash"`
+	// Category is free-form, e.g. copyright, malware, csam or spam
+	Category string `json:"category"`
+	Details  string `json:"details,omitempty"`
+	// Contact is how the reporter can be reached about the report
+	Contact    string    `json:"contact,omitempty"`
+	ReporterIP string    `json:"reporter_ip"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReviewedAt time.Time `json:"reviewed_at,omitempty"`
+	// Note is the reviewer's comment on the decision
+	Note string `json:"note,omitempty"`
+}
+
+// BlockEntry keeps one object from being served.
+type BlockEntry struct {
+	RootHash string `json:"root_hash"`
+	Reason   string `json:"reason,omitempty"`
+	// ReportID is the report the block was decided on, if any
+	ReportID  string    `json:"report_id,omitempty"`
+	BlockedAt time.Time `json:"blocked_at"`
+}
+
+// AbuseDesk takes abuse reports from the public into a review queue and
+// keeps the blocklist operators build from them. Blocked objects are not
+// served by downloads, the gateway or to peers, whoever asks. Both are
+// persisted to path, when set. Objects the BLOCKLIST setting names are kept
+// apart and never persisted, so the setting alone decides them.
+type AbuseDesk struct {
+	limiter *KeyRateLimiter
+	perHour int
+
+	mu         sync.RWMutex
+	path       string
+	reports    map[string]*AbuseReport
+	blocked    map[string]*BlockEntry
+	configured map[string]*BlockEntry
+}
+
+type abuseSnapshot struct {
+	Reports   []*AbuseReport `json:"reports"`
+	Blocklist []*BlockEntry  `json:"blocklist"`
+}
+
+func NewAbuseDesk(cfg *Config, path string) (*AbuseDesk, error) {
+	d := &AbuseDesk{
+		limiter:    NewHourlyRateLimiter(cfg.AbuseReportRateLimit),
+		perHour:    cfg.AbuseReportRateLimit,
+		path:       path,
+		reports:    make(map[string]*AbuseReport),
+		blocked:    make(map[string]*BlockEntry),
+		configured: make(map[string]*BlockEntry),
+	}
+	for _, root := range cfg.Blocklist {
+		root = strings.ToLower(root)
+		d.configured[root] = &BlockEntry{RootHash: root, Reason: "BLOCKLIST"}
+	}
+	if path == "" {
+		return d, nil
+	}
+	var snap abuseSnapshot
+	if err := readJSONFile(path, &snap); err != nil {
+		return nil, fmt.Errorf("failed to load abuse reports: %v", err)
+	}
+	for _, r := range snap.Reports {
+		d.reports[r.ID] = r
+	}
+	for _, b := range snap.Blocklist {
+		// Earlier releases saved the BLOCKLIST entries along with the rest
+		if b.Reason == "BLOCKLIST" && b.ReportID == "" && b.BlockedAt.IsZero() {
+			continue
+		}
+		d.blocked[b.RootHash] = b
+	}
+	return d, nil
+}
+
+func (d *AbuseDesk) saveLocked() error {
+	if d.path == "" {
+		return nil
+	}
+	snap := abuseSnapshot{Reports: []*AbuseReport{}, Blocklist: []*BlockEntry{}}
+	for _, r := range d.reports {
+		snap.Reports = append(snap.Reports, r)
+	}
+	for _, b := range d.blocked {
+		snap.Blocklist = append(snap.Blocklist, b)
+	}
+	if err := writeJSONFile(d.path, snap); err != nil {
+		return fmt.Errorf("failed to write abuse reports: %v", err)
+	}
+	return nil
+}
+
+// Blocked reports whether rootHash is on the blocklist.
+func (d *AbuseDesk) Blocked(rootHash string) bool {
+	if d == nil {
+		return false
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	rootHash = strings.ToLower(rootHash)
+	if _, ok := d.configured[rootHash]; ok {
+		return true
+	}
+	_, ok := d.blocked[rootHash]
+	return ok
+}
+
+// Report files a report. A reporter repeating an open report of the same
+// object gets the existing one back.
+func (d *AbuseDesk) Report(r AbuseReport) (AbuseReport, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	open := 0
+	for _, existing := range d.reports {
+		if existing.Status != AbuseOpen {
+			continue
+		}
+		if existing.RootHash == r.RootHash && existing.ReporterIP == r.ReporterIP {
+			return *existing, nil
+		}
+		open++
+	}
+	if open >= maxOpenAbuseReports {
+		return AbuseReport{}, newAPIError(http.StatusServiceUnavailable, "Too many reports are waiting for review; try again later")
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return AbuseReport{}, err
+	}
+	r.ID = id
+	r.Status = AbuseOpen
+	r.CreatedAt = time.Now()
+	d.reports[id] = &r
+	return r, d.saveLocked()
+}
+
+// List returns reports with status, or all of them, newest first.
+func (d *AbuseDesk) List(status string) []AbuseReport {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	reports := []AbuseReport{}
+	for _, r := range d.reports {
+		if status == "" || r.Status == status {
+			reports = append(reports, *r)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].CreatedAt.After(reports[j].CreatedAt) })
+	return reports
+}
+
+// Review closes report id with status. Actioning a report blocks its object
+// and closes every other open report of it too.
+func (d *AbuseDesk) Review(id, status, note string) (AbuseReport, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r, ok := d.reports[id]
+	if !ok {
+		return AbuseReport{}, ErrNotFound
+	}
+	now := time.Now()
+	r.Status, r.Note, r.ReviewedAt = status, note, now
+	if status == AbuseActioned {
+		for _, other := range d.reports {
+			if other.Status == AbuseOpen && other.RootHash == r.RootHash {
+				other.Status, other.Note, other.ReviewedAt = AbuseActioned, "Blocked on report "+id, now
+			}
+		}
+		reason := r.Category
+		if note != "" {
+			reason += ": " + note
+		}
+		d.blocked[r.RootHash] = &BlockEntry{RootHash: r.RootHash, Reason: reason, ReportID: id, BlockedAt: now}
+	}
+	return *r, d.saveLocked()
+}
+
+// Block adds rootHash to the blocklist without a report.
+func (d *AbuseDesk) Block(rootHash, reason string) (BlockEntry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b := &BlockEntry{RootHash: strings.ToLower(rootHash), Reason: reason, BlockedAt: time.Now()}
+	d.blocked[b.RootHash] = b
+	return *b, d.saveLocked()
+}
+
+// Unblock takes rootHash off the blocklist, reporting whether it was on it.
+// Objects BLOCKLIST names cannot be unblocked here.
+func (d *AbuseDesk) Unblock(rootHash string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	rootHash = strings.ToLower(rootHash)
+	if _, ok := d.configured[rootHash]; ok {
+		return false, newAPIError(http.StatusConflict, "Blocked by the BLOCKLIST setting; remove it there and restart")
+	}
+	if _, ok := d.blocked[rootHash]; !ok {
+		return false, nil
+	}
+	delete(d.blocked, rootHash)
+	return true, d.saveLocked()
+}
+
+// Blocklist returns the blocked objects, most recently blocked first.
+func (d *AbuseDesk) Blocklist() []BlockEntry {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	list := make([]BlockEntry, 0, len(d.blocked)+len(d.configured))
+	for _, b := range d.configured {
+		list = append(list, *b)
+	}
+	for root, b := range d.blocked {
+		if _, ok := d.configured[root]; !ok {
+			list = append(list, *b)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].BlockedAt.After(list[j].BlockedAt) })
+	return list
+}
+
+// blocked answers 451 and returns true when rootHash is on the blocklist.
+func (s *Server) blocked(c *gin.Context, rootHash string) bool {
+	if !s.abuse.Blocked(rootHash) {
+		return false
+	}
+	noStore(c)
+	c.JSON(http.StatusUnavailableForLegalReasons, gin.H{"error": "Content blocked by the gateway operator"})
+	return true
+}
+
+// evictBlocked drops a newly blocked object from the download cache, so no
+// copy is left on disk.
+func (s *Server) evictBlocked(rootHash string) {
+	if s.cache != nil {
+		s.cache.Remove(rootHash)
+	}
+	log.Printf("⛔ Blocked %s", rootHash)
+}
+
+type AbuseReportRequest struct {
+	RootHash string `json:"root_hash" binding:"required"`
+	Category string `json:"category" binding:"required"`
+	Details  string `json:"details"`
+	Contact  string `json:"contact"`
+}
+
+type AbuseReportResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// @Summary Report abusive content
+// @Description Flags a file served by this gateway for the operator to review, e.g. for copyright infringement, malware or illegal content. It needs no API key. Each client IP may file a limited number of reports an hour (429 beyond that), and a repeated report of the same file returns the open one. Reports are reviewed by the operator, who may block the file from being served.
+// @Accept json
+// @Produce json
+// @Param request body AbuseReportRequest true "Report"
+// @Success 202 {object} AbuseReportResponse
+// @Failure 400 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /report [post]
+func (s *Server) handleReportAbuse(c *gin.Context) {
+	d := s.abuse
+	if d.limiter != nil {
+		st := d.limiter.Take("ip:" + c.ClientIP())
+		if st.Wait > 0 {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(st.Wait)))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": fmt.Sprintf("Reports are limited to %d an hour", d.perHour)})
+			return
+		}
+	}
+	var req AbuseReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rootHash := strings.ToLower(s.resolveRef(strings.TrimSpace(req.RootHash)))
+	if !isRootHash(rootHash) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "root_hash must be a root hash or public ID"})
+		return
+	}
+	if len(req.Category) > 64 || len(req.Details) > maxAbuseReportText || len(req.Contact) > 256 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("category, details or contact is too long (details take up to %d bytes)", maxAbuseReportText)})
+		return
+	}
+	report, err := d.Report(AbuseReport{
+		RootHash:   rootHash,
+		Category:   strings.ToLower(strings.TrimSpace(req.Category)),
+		Details:    req.Details,
+		Contact:    req.Contact,
+		ReporterIP: c.ClientIP(),
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	log.Printf("🚨 Abuse report %s (%s) for %s", report.ID, report.Category, rootHash)
+	c.JSON(http.StatusAccepted, AbuseReportResponse{ID: report.ID, Status: report.Status})
+}
+
+// @Summary List abuse reports
+// @Description The abuse review queue, newest first. status filters by open, dismissed or actioned.
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Param status query string false "Status"
+// @Success 200 {array} AbuseReport
+// @Router /admin/reports [get]
+func (s *Server) handleListAbuseReports(c *gin.Context) {
+	c.JSON(http.StatusOK, s.abuse.List(c.Query("status")))
+}
+
+type AbuseReviewRequest struct {
+	Note string `json:"note"`
+}
+
+// @Summary Block the file of an abuse report
+// @Description Closes the report, and every other open report of the same file, as actioned and adds the file to the blocklist. Downloads, the gateway and peers answer 451 for it from then on, and it is evicted from the download cache.
+// @Accept json
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Param id path string true "Report ID"
+// @Param request body AbuseReviewRequest false "Reviewer's note"
+// @Success 200 {object} AbuseReport
+// @Failure 404 {object} map[string]string
+// @Router /admin/reports/{id}/block [post]
+func (s *Server) handleBlockAbuseReport(c *gin.Context) {
+	s.reviewAbuseReport(c, AbuseActioned)
+}
+
+// @Summary Dismiss an abuse report
+// @Description Closes the report without blocking anything
+// @Accept json
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Param id path string true "Report ID"
+// @Param request body AbuseReviewRequest false "Reviewer's note"
+// @Success 200 {object} AbuseReport
+// @Failure 404 {object} map[string]string
+// @Router /admin/reports/{id}/dismiss [post]
+func (s *Server) handleDismissAbuseReport(c *gin.Context) {
+	s.reviewAbuseReport(c, AbuseDismissed)
+}
+
+func (s *Server) reviewAbuseReport(c *gin.Context, status string) {
+	var req AbuseReviewRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	report, err := s.abuse.Review(c.Param("id"), status, req.Note)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Report not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if status == AbuseActioned {
+		s.evictBlocked(report.RootHash)
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// @Summary List blocked files
+// @Description Files the gateway refuses to serve, most recently blocked first
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Success 200 {array} BlockEntry
+// @Router /admin/blocklist [get]
+func (s *Server) handleListBlocklist(c *gin.Context) {
+	c.JSON(http.StatusOK, s.abuse.Blocklist())
+}
+
+type BlockRequest struct {
+	RootHash string `json:"root_hash" binding:"required"`
+	Reason   string `json:"reason"`
+}
+
+// @Summary Block a file
+// @Description Adds a file to the blocklist without a report, e.g. on a legal notice received elsewhere
+// @Accept json
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Param request body BlockRequest true "File to block"
+// @Success 200 {object} BlockEntry
+// @Failure 400 {object} map[string]string
+// @Router /admin/blocklist [post]
+func (s *Server) handleBlock(c *gin.Context) {
+	var req BlockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rootHash := strings.ToLower(s.resolveRef(req.RootHash))
+	if !isRootHash(rootHash) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "root_hash must be a root hash or public ID"})
+		return
+	}
+	entry, err := s.abuse.Block(rootHash, req.Reason)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.evictBlocked(rootHash)
+	c.JSON(http.StatusOK, entry)
+}
+
+// @Summary Unblock a file
+// @Description Takes a file off the blocklist so it is served again
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Param root_hash path string true "Root hash"
+// @Success 204
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /admin/blocklist/{root_hash} [delete]
+func (s *Server) handleUnblock(c *gin.Context) {
+	ok, err := s.abuse.Unblock(c.Param("root_hash"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not on the blocklist"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
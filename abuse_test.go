@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlocklistSettingIsNeverPersisted(t *testing.T) {
+	configured := "0x" + strings.Repeat("a", 64)
+	blocked := "0x" + strings.Repeat("b", 64)
+	path := filepath.Join(t.TempDir(), "abuse.json")
+
+	d, err := NewAbuseDesk(&Config{Blocklist: []string{configured}}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Block(blocked, "legal notice"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.Unblock(configured)
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusConflict {
+		t.Errorf("Unblock of a BLOCKLIST entry = %v, want 409", err)
+	}
+	if !d.Blocked(configured) || len(d.Blocklist()) != 2 {
+		t.Errorf("blocklist = %+v, want both entries", d.Blocklist())
+	}
+
+	// Restarted with the hash taken out of BLOCKLIST
+	d, err = NewAbuseDesk(&Config{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Blocked(configured) {
+		t.Error("hash removed from BLOCKLIST is still blocked")
+	}
+	if !d.Blocked(blocked) {
+		t.Error("hash blocked through the API was not kept")
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccessRule allows or denies requests for which When evaluates to true.
+type AccessRule struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	When   string `json:"when"`
+	Reason string `json:"reason,omitempty"`
+
+	expr *Expr
+}
+
+// AccessPolicy authorizes API requests with operator-supplied expressions
+// over the request's subject, action and resource. Rules are evaluated in
+// order and the first whose expression holds decides.
+type AccessPolicy struct {
+	DefaultAction string       `json:"default_action"`
+	Rules         []AccessRule `json:"rules"`
+}
+
+// LoadAccessPolicy reads rules from a JSON file, or from inline JSON when the
+// value starts with "{". An empty source allows everything.
+func LoadAccessPolicy(source string) (*AccessPolicy, error) {
+	p := &AccessPolicy{DefaultAction: PolicyAllow}
+	if source == "" {
+		return p, nil
+	}
+
+	data := []byte(source)
+	if !strings.HasPrefix(strings.TrimSpace(source), "{") {
+		var err error
+		if data, err = os.ReadFile(source); err != nil {
+			return nil, fmt.Errorf("failed to read access policy: %v", err)
+		}
+	}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("failed to parse access policy: %v", err)
+	}
+	return p, p.compile()
+}
+
+func (p *AccessPolicy) compile() error {
+	if p.DefaultAction == "" {
+		p.DefaultAction = PolicyAllow
+	}
+	if p.DefaultAction != PolicyAllow && p.DefaultAction != PolicyDeny {
+		return fmt.Errorf("invalid default_action %q", p.DefaultAction)
+	}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.Action != PolicyAllow && r.Action != PolicyDeny {
+			return fmt.Errorf("rule %d: invalid action %q", i, r.Action)
+		}
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		expr, err := CompileExpr(r.When)
+		if err != nil {
+			return fmt.Errorf("rule %s: %v", r.Name, err)
+		}
+		r.expr = expr
+	}
+	return nil
+}
+
+// AccessRequest is what a policy expression sees: subject, action and
+// resource are its top-level variables.
+type AccessRequest struct {
+	Subject  map[string]interface{} `json:"subject"`
+	Action   string                 `json:"action"`
+	Resource map[string]interface{} `json:"resource"`
+}
+
+// Evaluate applies the first rule whose expression holds, or the default
+// action. A rule whose expression fails to evaluate (e.g. reading a missing
+// attribute without has()) denies the request, since it cannot be told
+// whether the rule was meant to match.
+func (p *AccessPolicy) Evaluate(req AccessRequest, withTrace bool) PolicyDecision {
+	vars := map[string]interface{}{
+		"subject":  req.Subject,
+		"action":   req.Action,
+		"resource": req.Resource,
+	}
+
+	var decision PolicyDecision
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		matched, err := r.expr.Bool(vars)
+		if withTrace {
+			trace := RuleTrace{Rule: r.Name, Matched: matched && err == nil}
+			if err != nil {
+				trace.Error = err.Error()
+			}
+			decision.Trace = append(decision.Trace, trace)
+		}
+		if err != nil {
+			decision.Allowed = false
+			decision.Rule = r.Name
+			decision.Reason = fmt.Sprintf("rule could not be evaluated: %v", err)
+			return decision
+		}
+		if matched {
+			decision.Allowed = r.Action == PolicyAllow
+			decision.Rule = r.Name
+			decision.Reason = r.Reason
+			return decision
+		}
+	}
+	decision.Allowed = p.DefaultAction == PolicyAllow
+	decision.Rule = "default"
+	return decision
+}
+
+// requestAction classifies an HTTP method for policies.
+func requestAction(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return "read"
+	case http.MethodDelete:
+		return "delete"
+	}
+	return "write"
+}
+
+func accessSubject(c *gin.Context) map[string]interface{} {
+	return newAccessSubject(tenantFrom(c), c.GetString(keyIDContextKey), c.ClientIP())
+}
+
+func newAccessSubject(tenant, keyID, ip string) map[string]interface{} {
+	return map[string]interface{}{
+		"tenant": tenant,
+		"key_id": keyID,
+		"ip":     ip,
+	}
+}
+
+// requestOrigin is the part of an upload's request that the access policy
+// sees again when the upload is checked, after the request may be gone.
+type requestOrigin struct {
+	IP     string
+	Route  string
+	Path   string
+	Method string
+}
+
+func requestOriginFrom(c *gin.Context) requestOrigin {
+	return requestOrigin{
+		IP:     c.ClientIP(),
+		Route:  c.FullPath(),
+		Path:   c.Request.URL.Path,
+		Method: c.Request.Method,
+	}
+}
+
+// authorize rejects a request the access policy denies.
+func (s *Server) authorize(req AccessRequest) error {
+	decision := s.access.Evaluate(req, false)
+	if decision.Allowed {
+		return nil
+	}
+	msg := fmt.Sprintf("Denied by access rule %q", decision.Rule)
+	if decision.Reason != "" {
+		msg += ": " + decision.Reason
+	}
+	log.Printf("⛔ %s %s for %v: %s", req.Action, req.Resource["route"], req.Subject["tenant"], msg)
+	return newAPIError(http.StatusForbidden, "%s", msg)
+}
+
+// authorizeRequest checks every API request against the access policy, after
+// authentication. Uploads are checked again once their content is known; see
+// authorizeUpload.
+func (s *Server) authorizeRequest(c *gin.Context) {
+	// Rules see the root hash a public ID stands for, in lower case, so a
+	// file cannot be reached past a rule under another name
+	rootHash := c.Param("root_hash")
+	if rootHash != "" {
+		rootHash = strings.ToLower(s.resolveRef(rootHash))
+	}
+	resource := map[string]interface{}{
+		"route":     c.FullPath(),
+		"path":      c.Request.URL.Path,
+		"method":    c.Request.Method,
+		"mime":      c.ContentType(),
+		"size":      c.Request.ContentLength,
+		"root_hash": rootHash,
+	}
+	err := s.authorize(AccessRequest{
+		Subject:  accessSubject(c),
+		Action:   requestAction(c.Request.Method),
+		Resource: resource,
+	})
+	if err != nil {
+		respondError(c, err)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// authorizeUpload checks a staged upload with action "upload" and the
+// sniffed MIME type and actual size of the file. The subject and resource
+// carry every attribute authorizeRequest sets, so a rule written for requests
+// evaluates on uploads too; those an upload has no value for, such as the
+// root hash or the IP of a released quarantined upload, are empty.
+func (s *Server) authorizeUpload(c UploadCandidate) error {
+	return s.authorize(AccessRequest{
+		Subject: newAccessSubject(c.Tenant, c.KeyID, c.IP),
+		Action:  "upload",
+		Resource: map[string]interface{}{
+			"route":     c.Route,
+			"path":      c.Path,
+			"method":    c.Method,
+			"mime":      c.ContentType,
+			"size":      c.Size,
+			"root_hash": "",
+			"filename":  c.Filename,
+			"extension": strings.ToLower(filepath.Ext(c.Filename)),
+		},
+	})
+}
+
+// @Summary Explain an access policy decision
+// @Description Dry-run of the access policy (ACCESS_POLICY): evaluates a hypothetical subject, action and resource and reports which rule decides and why the others did not match
+// @Accept json
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Param request body AccessRequest true "Hypothetical request"
+// @Success 200 {object} PolicyDecision
+// @Router /admin/access/explain [post]
+func (s *Server) handleExplainAccess(c *gin.Context) {
+	var req AccessRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, s.access.Evaluate(req, true))
+}
@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+func TestAccessPolicyDeniesWhenARuleCannotBeEvaluated(t *testing.T) {
+	p, err := LoadAccessPolicy(`{"default_action": "allow", "rules": [
+		{"name": "big-media", "action": "deny", "when": "action == 'upload' && resource.mime.startsWith('video/') && resource.size > 104857600"}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		resource map[string]interface{}
+		allowed  bool
+	}{
+		{"small video", map[string]interface{}{"mime": "video/mp4", "size": int64(1024)}, true},
+		{"big video", map[string]interface{}{"mime": "video/mp4", "size": int64(200 << 20)}, false},
+		{"size from JSON", map[string]interface{}{"mime": "video/mp4", "size": float64(200 << 20)}, false},
+		// A false operand decides && even when another one fails
+		{"small, missing mime", map[string]interface{}{"size": int64(1024)}, true},
+		{"big, missing mime", map[string]interface{}{"size": int64(200 << 20)}, false},
+	} {
+		d := p.Evaluate(AccessRequest{Action: "upload", Resource: tc.resource}, true)
+		if d.Allowed != tc.allowed {
+			t.Errorf("%s: allowed = %v, want %v (%s)", tc.name, d.Allowed, tc.allowed, d.Reason)
+		}
+	}
+
+	d := p.Evaluate(AccessRequest{Action: "upload", Resource: map[string]interface{}{"size": int64(200 << 20)}}, true)
+	if len(d.Trace) != 1 || d.Trace[0].Error == "" || d.Trace[0].Matched {
+		t.Errorf("trace = %+v, want the evaluation error of big-media", d.Trace)
+	}
+}
+
+func TestCompileExprRejectsNonBooleans(t *testing.T) {
+	if _, err := CompileExpr("resource.size + 1"); err == nil {
+		t.Error("CompileExpr accepted an expression that is not a boolean")
+	}
+	if _, err := CompileExpr("action == "); err == nil {
+		t.Error("CompileExpr accepted a syntax error")
+	}
+}
+
+func TestUploadChecksSeeTheRequestAttributes(t *testing.T) {
+	p, err := LoadAccessPolicy(`{"default_action": "allow", "rules": [
+		{"name": "office-only", "action": "deny", "when": "subject.key_id == 'ci' && !subject.ip.startsWith('10.') && resource.route.startsWith('/api/')"}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{access: p}
+
+	upload := UploadCandidate{Tenant: "acme", Filename: "a.txt", ContentType: "text/plain", Size: 1, KeyID: "ci", Route: "/api/v1/upload", Method: "POST"}
+	upload.IP = "10.0.0.7"
+	if err := s.authorizeUpload(upload); err != nil {
+		t.Errorf("upload from the office: %v", err)
+	}
+	upload.IP = "203.0.113.9"
+	if err := s.authorizeUpload(upload); err == nil {
+		t.Error("upload from outside the office was allowed")
+	}
+	upload.KeyID = "laptop"
+	if err := s.authorizeUpload(upload); err != nil {
+		t.Errorf("upload with another key: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Quota is a tenant's storage allowance. Bytes is 0 when unlimited.
+type Quota struct {
+	Bytes     int64 `json:"bytes"`
+	Used      int64 `json:"used"`
+	Remaining int64 `json:"remaining,omitempty"`
+}
+
+// Account is what a tenant can see about itself.
+type Account struct {
+	Tenant   string      `json:"tenant"`
+	Usage    TenantUsage `json:"usage"`
+	Quota    Quota       `json:"quota"`
+	Keys     []APIKey    `json:"keys"`
+	Webhooks int         `json:"webhooks"`
+}
+
+func (s *Server) quotaFor(tenant string) int64 {
+	if q, ok := s.quotas[tenant]; ok {
+		return q
+	}
+	return s.defaultQuota
+}
+
+func (s *Server) quota(tenant string) Quota {
+	q := Quota{Bytes: s.quotaFor(tenant), Used: s.catalog.Usage(tenant).Bytes}
+	if q.Bytes > 0 && q.Used < q.Bytes {
+		q.Remaining = q.Bytes - q.Used
+	}
+	return q
+}
+
+// quotaReservations counts the bytes of uploads that passed the quota check
+// but are not in the catalog yet, so concurrent uploads cannot together take
+// a tenant over its quota. The zero value is ready to use.
+type quotaReservations struct {
+	mu      sync.Mutex
+	tenants map[string]int64
+}
+
+// reserveQuota rejects an upload that would take tenant over its quota and
+// otherwise holds its size against the quota until release is called, once
+// the upload is in the catalog or has failed. Content the tenant already
+// references costs nothing.
+func (s *Server) reserveQuota(tenant, rootHash string, size int64) (release func(), err error) {
+	limit := s.quotaFor(tenant)
+	if limit <= 0 || s.catalog.HasReference(tenant, rootHash) {
+		return func() {}, nil
+	}
+	r := &s.reservations
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	used := s.catalog.Usage(tenant).Bytes + r.tenants[tenant]
+	if used+size > limit {
+		return nil, newAPIError(http.StatusRequestEntityTooLarge, "Storage quota exceeded: %d of %d bytes used", used, limit)
+	}
+	if r.tenants == nil {
+		r.tenants = make(map[string]int64)
+	}
+	r.tenants[tenant] += size
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if r.tenants[tenant] -= size; r.tenants[tenant] <= 0 {
+				delete(r.tenants, tenant)
+			}
+		})
+	}, nil
+}
+
+// @Summary Get the caller's account
+// @Description The calling tenant's usage, quota, API keys and number of webhooks
+// @Produce json
+// @Success 200 {object} Account
+// @Security ApiKeyAuth
+// @Router /me [get]
+func (s *Server) handleGetAccount(c *gin.Context) {
+	tenant := tenantFrom(c)
+	c.JSON(http.StatusOK, Account{
+		Tenant:   tenant,
+		Usage:    s.catalog.Usage(tenant),
+		Quota:    s.quota(tenant),
+		Keys:     s.keys.List(tenant),
+		Webhooks: len(s.webhooks.List(tenant)),
+	})
+}
+
+// @Summary List the caller's API keys
+// @Description Valid API keys of the calling tenant. Keys are never shown again after creation; prefix identifies them.
+// @Produce json
+// @Success 200 {array} APIKey
+// @Security ApiKeyAuth
+// @Router /me/keys [get]
+func (s *Server) handleListKeys(c *gin.Context) {
+	c.JSON(http.StatusOK, s.keys.List(tenantFrom(c)))
+}
+
+type RotateKeyRequest struct {
+	// Grace is how long the key used for this request keeps working, e.g.
+	// "1h" (default 24h, at most 168h; "0s" retires it immediately)
+	Grace string `json:"grace"`
+}
+
+type RotateKeyResponse struct {
+	// Key is the new API key. It is not shown again.
+	Key    string `json:"key"`
+	APIKey APIKey `json:"api_key"`
+}
+
+// @Summary Rotate the caller's API key
+// @Description Issues a new API key and retires the key used for this request after a grace period
+// @Accept json
+// @Produce json
+// @Param request body RotateKeyRequest false "Grace period"
+// @Success 201 {object} RotateKeyResponse
+// @Security ApiKeyAuth
+// @Router /me/keys/rotate [post]
+func (s *Server) handleRotateKey(c *gin.Context) {
+	if !s.keys.Enabled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "API keys are not enabled (set API_KEYS)"})
+		return
+	}
+	var req RotateKeyRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	grace := defaultKeyRotationGrace
+	if req.Grace != "" {
+		d, err := time.ParseDuration(req.Grace)
+		if err != nil || d < 0 || d > maxKeyRotationGrace {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("grace must be a duration between 0s and %s", maxKeyRotationGrace)})
+			return
+		}
+		grace = d
+	}
+
+	key, record, err := s.keys.Rotate(tenantFrom(c), c.GetString(keyIDContextKey), grace)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, RotateKeyResponse{Key: key, APIKey: record})
+}
+
+// @Summary Revoke one of the caller's API keys
+// @Description Retires a key immediately. The last remaining key cannot be revoked; rotate it instead.
+// @Produce json
+// @Param id path string true "Key ID"
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /me/keys/{id} [delete]
+func (s *Server) handleRevokeKey(c *gin.Context) {
+	err := s.keys.Revoke(tenantFrom(c), c.Param("id"))
+	if err == errLastAPIKey {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cannot revoke the last API key; rotate it instead"})
+		return
+	} else if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
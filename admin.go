@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireAdmin guards operator endpoints with the ADMIN_TOKEN shared secret.
+// Without a configured token the admin API is switched off.
+func (s *Server) requireAdmin(c *gin.Context) {
+	if s.adminToken == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin API is disabled (set ADMIN_TOKEN)"})
+		return
+	}
+	token := c.GetHeader("X-Admin-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "A valid X-Admin-Token header is required"})
+		return
+	}
+	c.Next()
+}
+
+type GCRun struct {
+	StartedAt    time.Time `json:"started_at"`
+	Duration     string    `json:"duration"`
+	CacheEvicted int       `json:"cache_evicted"`
+	CacheOrphans int       `json:"cache_orphans"`
+	SpoolOrphans int       `json:"spool_orphans"`
+	// Multipart uploads aborted for not being completed in time
+	MultipartExpired int `json:"multipart_expired"`
+	// Resumable uploads aborted for receiving nothing in time
+	ResumableExpired int   `json:"resumable_expired"`
+	FreedBytes       int64 `json:"freed_bytes"`
+	ManualTrigger    bool  `json:"manual_trigger"`
+}
+
+type GCReport struct {
+	Cache   CacheStats `json:"cache"`
+	Spool   SpoolStats `json:"spool"`
+	LastRun *GCRun     `json:"last_run,omitempty"`
+	// Peers is set when peer gateways share their caches
+	Peers *PeerStats `json:"peers,omitempty"`
+}
+
+// collectGarbage sweeps the download cache and the spool once.
+func (s *Server) collectGarbage(manual bool) GCRun {
+	s.gcMu.Lock()
+	defer s.gcMu.Unlock()
+
+	run := GCRun{StartedAt: time.Now(), ManualTrigger: manual}
+	if s.cache != nil {
+		evicted, orphans, freed := s.cache.Sweep()
+		run.CacheEvicted = evicted
+		run.CacheOrphans = orphans
+		run.FreedBytes += freed
+	}
+	run.MultipartExpired = s.multipart.Expire()
+	run.ResumableExpired = s.resumable.Expire()
+	removed, freed := s.spool.Sweep()
+	run.SpoolOrphans = removed
+	run.FreedBytes += freed
+	run.Duration = time.Since(run.StartedAt).String()
+
+	s.lastGC = &run
+	return run
+}
+
+func (s *Server) gcReport() GCReport {
+	report := GCReport{Spool: s.spool.Stats()}
+	if s.cache != nil {
+		report.Cache = s.cache.Stats()
+	}
+	if s.peers != nil {
+		stats := s.peers.Stats()
+		report.Peers = &stats
+	}
+	s.gcMu.Lock()
+	report.LastRun = s.lastGC
+	s.gcMu.Unlock()
+	return report
+}
+
+// runGC collects garbage every interval until ctx is done.
+func (s *Server) runGC(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			run := s.collectGarbage(false)
+			if run.FreedBytes > 0 {
+				log.Printf("🧹 GC freed %d bytes (%d evicted, %d cache orphans, %d spool orphans)",
+					run.FreedBytes, run.CacheEvicted, run.CacheOrphans, run.SpoolOrphans)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// @Summary Cache and spool garbage collection report
+// @Description Reports download cache hit rate, evictions, spool usage and orphaned files, plus the last GC cycle
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Success 200 {object} GCReport
+// @Router /admin/gc [get]
+func (s *Server) handleGCReport(c *gin.Context) {
+	c.JSON(http.StatusOK, s.gcReport())
+}
+
+// @Summary Run a garbage collection cycle
+// @Description Evicts cache entries over the size limit and deletes orphaned cache and spool files
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Success 200 {object} GCReport
+// @Router /admin/gc [post]
+func (s *Server) handleRunGC(c *gin.Context) {
+	s.collectGarbage(true)
+	c.JSON(http.StatusOK, s.gcReport())
+}
+
+type CacheEvictResponse struct {
+	RootHash string `json:"root_hash"`
+	// Evicted is false when the object was not cached
+	Evicted bool `json:"evicted"`
+}
+
+// @Summary Evict an object from the download cache
+// @Description Drops a file from the local download cache, and its cold tier, so that the next download fetches it from 0G again. Answers 404 when the gateway has no download cache.
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Param root_hash path string true "Root hash or public ID of the file"
+// @Success 200 {object} CacheEvictResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /cache/{root_hash} [delete]
+func (s *Server) handleEvictCache(c *gin.Context) {
+	if s.cache == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Download cache is disabled"})
+		return
+	}
+	rootHash := s.resolveRef(c.Param("root_hash"))
+	if !isRootHash(strings.ToLower(rootHash)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A valid root hash is required"})
+		return
+	}
+	evicted := s.cache.Remove(rootHash)
+	if evicted {
+		log.Printf("🧹 Evicted %s from the download cache", rootHash)
+	}
+	c.JSON(http.StatusOK, CacheEvictResponse{RootHash: rootHash, Evicted: evicted})
+}
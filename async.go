@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Phases of an async upload job. Submitting the transaction and uploading
+// segments happen inside one SDK call, so they are reported together as
+// uploading.
+const (
+	PhaseHashing    = "hashing"
+	PhaseUploading  = "uploading"
+	PhaseFinalizing = "finalizing"
+	PhaseFinalized  = "finalized"
+)
+
+// uploadPhases maps the stage an upload has started to its job phase and
+// progress percentage. While uploading, progress moves with the segments the
+// storage nodes have received; see transferPercent.
+var uploadPhases = map[UploadStage]struct {
+	phase   string
+	percent int
+}{
+	StageTransform: {PhaseHashing, 5},
+	StageHash:      {PhaseHashing, 10},
+	StageSubmit:    {PhaseUploading, 30},
+	StageFinalize:  {PhaseFinalizing, 90},
+}
+
+// transferPercent spreads the uploading phase's share of the progress bar
+// over the segments the slowest node has received.
+func transferPercent(p TransferProgress) int {
+	from, to := uploadPhases[StageSubmit].percent, uploadPhases[StageFinalize].percent
+	if p.Segments == 0 {
+		return from
+	}
+	done := p.SegmentsUploaded()
+	if done > p.Segments {
+		done = p.Segments
+	}
+	return from + int(uint64(to-from)*done/p.Segments)
+}
+
+type AsyncUploadResponse struct {
+	JobID    string `json:"job_id"`
+	State    string `json:"state"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+// respondUpload stores a staged upload and answers with the result. With
+// ?async=true it queues the upload for the worker pool instead and answers
+// 202 with the job ID at once. release frees what was staged, once the
+// upload no longer needs it.
+func (s *Server) respondUpload(c *gin.Context, req uploadRequest, share bool, shareTTL time.Duration, release func()) {
+	if req.Log == nil {
+		req.Log = requestLogger(c)
+	}
+	if c.Query("async") != "true" {
+		defer release()
+		resp, err := s.storeUpload(req)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		resp.Stages = req.Timer.Millis()
+		if share {
+			s.shareUpload(c, &resp, shareTTL)
+		}
+		c.JSON(uploadStatus(resp), resp)
+		return
+	}
+
+	if !s.featureEnabled(c, FlagAsyncUpload) {
+		release()
+		respondError(c, newAPIError(http.StatusForbidden, "Async uploads are not enabled for this tenant"))
+		return
+	}
+
+	// The request is over before the job runs
+	cc := c.Copy()
+	job, err := s.jobs.Queue(req.Tenant, "upload", traceFrom(c), s.uploadWorkers, func(ctx context.Context, job *JobHandle) (interface{}, error) {
+		defer release()
+		defer job.RecordStages(req.Timer)
+		req.Progress = func(stage UploadStage) {
+			if p, ok := uploadPhases[stage]; ok {
+				job.SetProgress(p.phase, p.percent)
+			}
+		}
+		req.Transfer = func(p TransferProgress) {
+			job.SetTransfer(p, transferPercent(p))
+		}
+		resp, err := s.storeUpload(req)
+		if err != nil {
+			return nil, err
+		}
+		job.Track(resp.RootHash, resp.TxHash)
+		resp.Stages = req.Timer.Millis()
+		if share {
+			s.shareUpload(cc, &resp, shareTTL)
+		}
+		job.SetProgress(PhaseFinalized, 100)
+		return resp, nil
+	})
+	if err != nil {
+		release()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Location", "/api/v1/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, AsyncUploadResponse{JobID: job.ID, State: job.State, Filename: req.Filename, Size: req.Size})
+}
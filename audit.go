@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Entries kept in memory for GET /admin/audit
+	auditMemory = 2000
+
+	impersonationContextKey = "impersonation"
+)
+
+// AuditEntry records one API request that changed something or was made
+// under impersonation.
+type AuditEntry struct {
+	At     time.Time `json:"at"`
+	Tenant string    `json:"tenant"`
+	KeyID  string    `json:"key_id,omitempty"`
+	Method string    `json:"method"`
+	Route  string    `json:"route"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+	IP     string    `json:"ip"`
+	// Impersonation is the ID of the support token the request was made
+	// with, and Reason the reason given when it was minted
+	Impersonation string `json:"impersonation,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+}
+
+// AuditLog keeps recent entries in memory and appends every entry as a JSON
+// line to path, when set.
+type AuditLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries []AuditEntry
+}
+
+func NewAuditLog(path string) (*AuditLog, error) {
+	a := &AuditLog{}
+	if path == "" {
+		return a, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	a.file = f
+	return a, nil
+}
+
+func (a *AuditLog) Record(e AuditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, e)
+	if len(a.entries) > auditMemory {
+		a.entries = a.entries[len(a.entries)-auditMemory:]
+	}
+	if a.file != nil {
+		line, _ := json.Marshal(e)
+		if _, err := a.file.Write(append(line, '\n')); err != nil {
+			log.Printf("⚠️  Failed to write audit log: %v", err)
+		}
+	}
+}
+
+// Query returns recent entries, newest first, optionally only a tenant's or
+// only impersonated ones.
+func (a *AuditLog) Query(tenant string, impersonatedOnly bool, limit int) []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entries := []AuditEntry{}
+	for i := len(a.entries) - 1; i >= 0 && len(entries) < limit; i-- {
+		e := a.entries[i]
+		if tenant != "" && e.Tenant != tenant {
+			continue
+		}
+		if impersonatedOnly && e.Impersonation == "" {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func (a *AuditLog) Close() {
+	if a.file != nil {
+		a.file.Close()
+	}
+}
+
+// auditRequest records API requests that may change state, and every request
+// made with an impersonation token, once they are answered.
+func (s *Server) auditRequest(c *gin.Context) {
+	c.Next()
+
+	imp, impersonated := c.Get(impersonationContextKey)
+	if requestAction(c.Request.Method) == "read" && !impersonated {
+		return
+	}
+	entry := AuditEntry{
+		At:     time.Now(),
+		Tenant: tenantFrom(c),
+		KeyID:  c.GetString(keyIDContextKey),
+		Method: c.Request.Method,
+		Route:  c.FullPath(),
+		Path:   c.Request.URL.Path,
+		Status: c.Writer.Status(),
+		IP:     c.ClientIP(),
+	}
+	if impersonated {
+		token := imp.(ImpersonationToken)
+		entry.Impersonation = token.ID
+		entry.Reason = token.Reason
+	}
+	s.audit.Record(entry)
+}
+
+// @Summary Audit log
+// @Description Recent API requests that changed state or were made under impersonation, newest first
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Param tenant query string false "Only this tenant's requests"
+// @Param impersonated query bool false "Only impersonated requests"
+// @Param limit query int false "Maximum entries (default 100)"
+// @Success 200 {array} AuditEntry
+// @Router /admin/audit [get]
+func (s *Server) handleAuditLog(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+		return
+	}
+	c.JSON(http.StatusOK, s.audit.Query(c.Query("tenant"), c.Query("impersonated") == "true", limit))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// DefaultTenant owns every request when no API keys are configured.
+	DefaultTenant = "default"
+
+	tenantContextKey = "tenant"
+	keyIDContextKey  = "api_key_id"
+)
+
+// parseAPIKeys reads "key:tenant" pairs separated by commas. A key without a
+// tenant is its own tenant.
+func parseAPIKeys(raw string) map[string]string {
+	keys := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, tenant, found := strings.Cut(pair, ":")
+		if !found || tenant == "" {
+			tenant = key
+		}
+		keys[strings.TrimSpace(key)] = strings.TrimSpace(tenant)
+	}
+	return keys
+}
+
+func apiKeyFromRequest(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// authenticate resolves the calling tenant from its API key. When no keys are
+// configured the sandbox stays open and every caller is DefaultTenant.
+func (s *Server) authenticate(c *gin.Context) {
+	if s.authenticateImpersonation(c, apiKeyFromRequest(c)) {
+		return
+	}
+	if s.authenticateUploadSession(c, apiKeyFromRequest(c)) {
+		return
+	}
+	if !s.keys.Enabled() {
+		// On a tenant domain the sandbox caller is that domain's tenant
+		if _, ok := domainTenantFrom(c); !ok {
+			c.Set(tenantContextKey, DefaultTenant)
+		}
+		c.Next()
+		return
+	}
+
+	key, ok := s.keys.Lookup(apiKeyFromRequest(c))
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "A valid API key is required"})
+		return
+	}
+	c.Set(tenantContextKey, key.Tenant)
+	c.Set(keyIDContextKey, key.ID)
+	c.Next()
+}
+
+func tenantFrom(c *gin.Context) string {
+	if tenant := c.GetString(tenantContextKey); tenant != "" {
+		return tenant
+	}
+	return DefaultTenant
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// adminJobOwner owns jobs started through the admin API. No tenant has an
+	// empty name, so tenants never see them.
+	adminJobOwner = ""
+
+	backfillLock = "metadata-backfill"
+	// Bytes read from the start of each file to sniff its type, as on upload
+	backfillSniffBytes = 512
+	// Errors kept in a backfill result; the rest are only counted
+	maxBackfillErrors = 100
+)
+
+type BackfillResult struct {
+	Scanned int `json:"scanned"`
+	// Updated counts file references that gained a content type or size
+	Updated int      `json:"updated"`
+	Failed  int      `json:"failed"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+type BackfillResponse struct {
+	JobID string `json:"job_id"`
+	Files int    `json:"files"`
+}
+
+// runMetadataBackfill sniffs the content type and reads the size of every
+// object uploaded before the catalog recorded them, from the first segment
+// on the storage nodes, and fills them into the catalog.
+func (s *Server) runMetadataBackfill(ctx context.Context, job *JobHandle, roots []string) (interface{}, error) {
+	result := BackfillResult{Errors: []string{}}
+	nodes, err := s.client.selectNodesFor(ClassBatch)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, root := range roots {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		result.Scanned++
+
+		lookupCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		head, size, err := s.client.Head(lookupCtx, nodes, root, backfillSniffBytes)
+		cancel()
+		if err != nil {
+			result.Failed++
+			if len(result.Errors) < maxBackfillErrors {
+				result.Errors = append(result.Errors, root+": "+err.Error())
+			}
+			continue
+		}
+		contentType := ""
+		if size > 0 {
+			contentType = http.DetectContentType(head)
+		}
+		changed, err := s.catalog.FillMetadata(root, contentType, size)
+		if err != nil {
+			return nil, err
+		}
+		result.Updated += changed
+		job.Note("%s: %s, %d bytes (%d/%d)", root, contentType, size, i+1, len(roots))
+	}
+	return result, nil
+}
+
+// @Summary Backfill missing file metadata
+// @Description Starts a job that finds catalog entries recorded without a content type or size (uploads from before they were tracked), reads each file's first segment from the storage nodes to sniff its type and size, and updates every reference to it. Poll the job with GET /admin/jobs/{id}.
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Success 202 {object} BackfillResponse
+// @Router /admin/catalog/backfill [post]
+func (s *Server) handleBackfillMetadata(c *gin.Context) {
+	roots := s.catalog.MissingMetadata()
+	if len(roots) == 0 {
+		c.JSON(http.StatusOK, BackfillResponse{})
+		return
+	}
+
+	token, ok, err := s.locks.TryAcquire(c.Request.Context(), backfillLock, time.Hour)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "A metadata backfill is already in progress"})
+		return
+	}
+
+	job, err := s.jobs.Start(adminJobOwner, "metadata_backfill", traceFrom(c), func(ctx context.Context, job *JobHandle) (interface{}, error) {
+		defer s.locks.Release(context.Background(), backfillLock, token)
+		return s.runMetadataBackfill(ctx, job, roots)
+	})
+	if err != nil {
+		s.locks.Release(context.Background(), backfillLock, token)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, BackfillResponse{JobID: job.ID, Files: len(roots)})
+}
+
+// @Summary Get an admin job
+// @Description Reports a job started through the admin API, such as a metadata backfill
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Param id path string true "Job ID"
+// @Success 200 {object} Job
+// @Router /admin/jobs/{id} [get]
+func (s *Server) handleGetAdminJob(c *gin.Context) {
+	job, ok := s.jobs.Get(adminJobOwner, c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
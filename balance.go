@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"github.com/openweb3/web3go/types"
+)
+
+// WalletStatus is the health of the account uploads are paid from.
+type WalletStatus struct {
+	Address    string `json:"address"`
+	BalanceWei string `json:"balance_wei"`
+	Balance    string `json:"balance"`
+	// PendingNonce counts the account's transactions, pending ones included
+	PendingNonce uint64 `json:"pending_nonce"`
+	// LowBalanceThreshold is WALLET_LOW_BALANCE; LowBalance is set while
+	// the balance is under it
+	LowBalanceThresholdWei string `json:"low_balance_threshold_wei"`
+	LowBalanceThreshold    string `json:"low_balance_threshold"`
+	LowBalance             bool   `json:"low_balance"`
+	// PoolAccounts counts further accounts of the wallet pool, reported
+	// by GET /admin/wallets
+	PoolAccounts int `json:"pool_accounts,omitempty"`
+}
+
+// WalletStatus reads the wallet's balance and nonce from the chain.
+func (c *StorageClient) WalletStatus() (WalletStatus, error) {
+	balance, err := c.web3Client.Eth.Balance(c.account, nil)
+	if err != nil || balance == nil {
+		return WalletStatus{}, fmt.Errorf("failed to get balance: %v", err)
+	}
+	pending := types.BlockNumberOrHashWithNumber(types.PendingBlockNumber)
+	nonce, err := c.web3Client.Eth.TransactionCount(c.account, &pending)
+	if err != nil || nonce == nil {
+		return WalletStatus{}, fmt.Errorf("failed to get nonce: %v", err)
+	}
+	threshold := c.lowBalance
+	if threshold == nil {
+		threshold = new(big.Int)
+	}
+	status := WalletStatus{
+		Address:                c.account.Hex(),
+		BalanceWei:             balance.String(),
+		Balance:                formatTokens(balance),
+		PendingNonce:           nonce.Uint64(),
+		LowBalanceThresholdWei: threshold.String(),
+		LowBalanceThreshold:    formatTokens(threshold),
+		LowBalance:             balance.Cmp(threshold) < 0,
+	}
+	if c.wallets != nil {
+		status.PoolAccounts = len(c.wallets.accounts) - 1
+	}
+	return status, nil
+}
+
+// spendableBalance is the most any one paying account holds: the wallet's
+// balance, or with a wallet pool the richest account's.
+func (c *StorageClient) spendableBalance() (common.Address, *big.Int, error) {
+	if c.wallets == nil {
+		balance, err := c.web3Client.Eth.Balance(c.account, nil)
+		if err != nil || balance == nil {
+			return c.account, nil, fmt.Errorf("failed to get balance: %v", err)
+		}
+		return c.account, balance, nil
+	}
+	var (
+		richest common.Address
+		most    *big.Int
+	)
+	for _, account := range c.wallets.accounts {
+		balance, err := account.web3Client.Eth.Balance(account.wallet.Address(), nil)
+		if err != nil || balance == nil {
+			continue
+		}
+		if most == nil || balance.Cmp(most) > 0 {
+			richest, most = account.wallet.Address(), balance
+		}
+	}
+	if most == nil {
+		return c.account, nil, fmt.Errorf("failed to get the balance of any pool account")
+	}
+	return richest, most, nil
+}
+
+// EnsureFunds refuses an upload of size bytes with 402 when no paying
+// account could cover its estimated fee. When the balance or the fee cannot
+// be read, the upload goes ahead and the chain has the last word.
+func (c *StorageClient) EnsureFunds(ctx context.Context, size int64) error {
+	est, err := c.EstimateFee(ctx, size)
+	if err != nil {
+		log.Printf("⚠️  Skipping balance check: %v", err)
+		return nil
+	}
+	account, balance, err := c.spendableBalance()
+	if err != nil {
+		log.Printf("⚠️  Skipping balance check: %v", err)
+		return nil
+	}
+	cost, _ := new(big.Int).SetString(est.TotalWei, 10)
+	if balance.Cmp(cost) < 0 {
+		return newAPIError(http.StatusPaymentRequired, "The gateway wallet %s holds %s 0G, less than the estimated %s 0G this upload costs", account.Hex(), formatTokens(balance), est.Total)
+	}
+	return nil
+}
+
+// @Summary Wallet balance and health
+// @Description The account the gateway pays for uploads from: its address, native balance in wei and in 0G, pending nonce, and whether the balance is under WALLET_LOW_BALANCE. Uploads the balance cannot cover are refused with 402.
+// @Produce json
+// @Success 200 {object} WalletStatus
+// @Failure 502 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /wallet [get]
+func (s *Server) handleWalletStatus(c *gin.Context) {
+	status, err := s.client.WalletStatus()
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// isRootHash reports whether s looks like a 0x-prefixed 32-byte hex root hash.
+func isRootHash(s string) bool {
+	return len(s) == 66 && strings.HasPrefix(s, "0x") && isHex(s[2:])
+}
+
+type cacheEntry struct {
+	rootHash   string
+	size       int64
+	lastAccess time.Time
+	// pins counts the paths handed out and not yet released; a pinned entry
+	// is not evicted, and one removed while pinned keeps its file until the
+	// last release
+	pins    int
+	removed bool
+}
+
+// errTooLargeToCache is returned by Put for an object bigger than the
+// whole cache, which would be evicted as soon as it was added.
+var errTooLargeToCache = errors.New("object is larger than the download cache")
+
+type CacheStats struct {
+	Enabled   bool    `json:"enabled"`
+	Dir       string  `json:"dir,omitempty"`
+	Entries   int     `json:"entries"`
+	Bytes     int64   `json:"bytes"`
+	MaxBytes  int64   `json:"max_bytes"`
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	HitRate   float64 `json:"hit_rate"`
+	Evictions int64   `json:"evictions"`
+
+	// Cold is set when evicted objects are demoted to a cold tier
+	Cold *ColdCacheStats `json:"cold,omitempty"`
+}
+
+// DiskCache keeps downloaded objects on local disk keyed by root hash and
+// evicts the least recently used ones once maxBytes is exceeded. Content on
+// 0G is immutable, so cached files never go stale. With a cold tier, evicted
+// objects are demoted there instead and promoted back when requested again.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu        sync.Mutex
+	lru       *list.List // front = most recently used
+	entries   map[string]*list.Element
+	bytes     int64
+	hits      int64
+	misses    int64
+	evictions int64
+	// retired counts removed entries still pinned, by root hash, so Sweep
+	// leaves their files alone
+	retired map[string]int
+
+	// Cold tier, see UseColdTier; cold is nil without one
+	cold         ColdTier
+	coldMaxBytes int64
+	coldLRU      *list.List
+	coldEntries  map[string]*list.Element
+	coldBytes    int64
+	// demoting holds evicted entries still being copied to the cold tier
+	demoting     map[string]*cacheEntry
+	demoteSlots  chan struct{}
+	demotions    int64
+	promotions   int64
+	coldFailures int64
+}
+
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	c := &DiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		retired:  make(map[string]int),
+	}
+
+	// Re-index what a previous run left behind, oldest first
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory: %v", err)
+	}
+	var found []cacheEntry
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil || !info.Mode().IsRegular() || !isRootHash(f.Name()) {
+			continue
+		}
+		found = append(found, cacheEntry{rootHash: f.Name(), size: info.Size(), lastAccess: info.ModTime()})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].lastAccess.Before(found[j].lastAccess) })
+	for i := range found {
+		c.entries[found[i].rootHash] = c.lru.PushFront(&found[i])
+		c.bytes += found[i].size
+	}
+	c.mu.Lock()
+	c.evictLocked()
+	c.mu.Unlock()
+	return c, nil
+}
+
+func (c *DiskCache) pathFor(rootHash string) string {
+	return filepath.Join(c.dir, rootHash)
+}
+
+// Get returns the cached file for rootHash and marks it recently used. An
+// object in the cold tier is promoted back first. The file is pinned until
+// release is called, so it is not evicted while it is being served.
+func (c *DiskCache) Get(rootHash string) (path string, release func(), ok bool) {
+	rootHash = strings.ToLower(rootHash)
+	c.mu.Lock()
+	el, ok := c.entries[rootHash]
+	if !ok {
+		cold := c.inColdLocked(rootHash)
+		c.mu.Unlock()
+		if cold {
+			path, release, err := c.promote(rootHash)
+			if err == nil {
+				c.mu.Lock()
+				c.hits++
+				c.mu.Unlock()
+				return path, release, true
+			}
+			log.Printf("⚠️  %v", err)
+		}
+		c.mu.Lock()
+		c.misses++
+		c.mu.Unlock()
+		return "", nil, false
+	}
+	defer c.mu.Unlock()
+	c.hits++
+	entry := el.Value.(*cacheEntry)
+	entry.lastAccess = time.Now()
+	c.lru.MoveToFront(el)
+	return c.pathFor(rootHash), c.pinLocked(entry), true
+}
+
+// pinLocked pins entry and returns the function releasing it, which may be
+// called more than once.
+func (c *DiskCache) pinLocked(entry *cacheEntry) func() {
+	entry.pins++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			entry.pins--
+			if entry.pins > 0 {
+				return
+			}
+			if entry.removed {
+				c.unretireLocked(entry.rootHash)
+				// Delete the file unless the object has been cached again or
+				// another reader still holds it
+				if _, cached := c.entries[entry.rootHash]; !cached && c.retired[entry.rootHash] == 0 {
+					os.Remove(c.pathFor(entry.rootHash))
+				}
+				return
+			}
+			c.evictLocked()
+		})
+	}
+}
+
+// retireLocked marks entry removed, keeping track of it while it is
+// pinned. It reports whether the entry is pinned.
+func (c *DiskCache) retireLocked(entry *cacheEntry) bool {
+	entry.removed = true
+	if entry.pins == 0 {
+		return false
+	}
+	c.retired[entry.rootHash]++
+	return true
+}
+
+func (c *DiskCache) unretireLocked(rootHash string) {
+	if c.retired[rootHash]--; c.retired[rootHash] <= 0 {
+		delete(c.retired, rootHash)
+	}
+}
+
+// LastAccess reports when rootHash was last served from the cache,
+// including objects demoted to the cold tier.
+func (c *DiskCache) LastAccess(rootHash string) (time.Time, bool) {
+	rootHash = strings.ToLower(rootHash)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[rootHash]; ok {
+		return el.Value.(*cacheEntry).lastAccess, true
+	}
+	if el, ok := c.coldEntries[rootHash]; ok {
+		return el.Value.(*cacheEntry).lastAccess, true
+	}
+	if entry, ok := c.demoting[rootHash]; ok {
+		return entry.lastAccess, true
+	}
+	return time.Time{}, false
+}
+
+// Put moves srcPath into the cache and returns the cached path, pinned
+// until release is called. An object larger than the cache is not cached:
+// Put returns errTooLargeToCache with srcPath left in place.
+func (c *DiskCache) Put(rootHash, srcPath string) (path string, release func(), err error) {
+	rootHash = strings.ToLower(rootHash)
+	if !isRootHash(rootHash) {
+		return "", nil, fmt.Errorf("invalid root hash %q", rootHash)
+	}
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.Size() > c.maxBytes {
+		return srcPath, nil, errTooLargeToCache
+	}
+
+	dst := c.pathFor(rootHash)
+	if err := os.Rename(srcPath, dst); err != nil {
+		// Different filesystem: fall back to copying
+		if err := copyFile(srcPath, dst); err != nil {
+			return "", nil, fmt.Errorf("failed to cache %s: %v", rootHash, err)
+		}
+		os.Remove(srcPath)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[rootHash]; ok {
+		// Same content under the same name; readers of the old entry keep it
+		old := el.Value.(*cacheEntry)
+		c.retireLocked(old)
+		c.bytes -= old.size
+		c.lru.Remove(el)
+	}
+	entry := &cacheEntry{rootHash: rootHash, size: info.Size(), lastAccess: time.Now()}
+	c.entries[rootHash] = c.lru.PushFront(entry)
+	c.bytes += info.Size()
+	release = c.pinLocked(entry)
+	c.evictLocked()
+	return dst, release, nil
+}
+
+// Remove drops rootHash from the cache and its cold tier. It reports
+// whether it was cached.
+func (c *DiskCache) Remove(rootHash string) bool {
+	rootHash = strings.ToLower(rootHash)
+	c.mu.Lock()
+	el, ok := c.entries[rootHash]
+	if ok {
+		c.removeLocked(el)
+	}
+	if _, pending := c.demoting[rootHash]; pending {
+		// demote deletes the copy once it finishes
+		delete(c.demoting, rootHash)
+		ok = true
+	}
+	var cold []string
+	if el, found := c.coldEntries[rootHash]; found {
+		cold = append(cold, c.removeColdLocked(el))
+		ok = true
+	}
+	c.mu.Unlock()
+	if len(cold) > 0 {
+		c.deleteCold(cold)
+	}
+	return ok
+}
+
+func (c *DiskCache) removeLocked(el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+	c.lru.Remove(el)
+	delete(c.entries, entry.rootHash)
+	c.bytes -= entry.size
+	if c.retireLocked(entry) {
+		// The last release deletes the file
+		return
+	}
+	os.Remove(c.pathFor(entry.rootHash))
+}
+
+// evictLocked evicts the least recently used entries that are not pinned
+// until the cache is within maxBytes or only pinned entries are left.
+func (c *DiskCache) evictLocked() int {
+	evicted := 0
+	el := c.lru.Back()
+	for c.bytes > c.maxBytes && el != nil {
+		prev := el.Prev()
+		if el.Value.(*cacheEntry).pins == 0 {
+			if c.cold != nil {
+				c.demoteLocked(el)
+			} else {
+				c.removeLocked(el)
+			}
+			c.evictions++
+			evicted++
+		}
+		el = prev
+	}
+	return evicted
+}
+
+// Sweep enforces the size limit and reconciles the index with the disk:
+// untracked files are deleted and entries whose file vanished are dropped.
+func (c *DiskCache) Sweep() (evicted, orphans int, freed int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	before := c.bytes
+	evicted = c.evictLocked()
+	freed = before - c.bytes
+
+	files, err := os.ReadDir(c.dir)
+	if err == nil {
+		for _, f := range files {
+			if _, tracked := c.entries[f.Name()]; tracked || c.retired[f.Name()] > 0 || f.Name() == coldStagingDir {
+				continue
+			}
+			info, err := f.Info()
+			if err != nil || time.Since(info.ModTime()) < orphanGracePeriod {
+				// Possibly a Put that has renamed its file but not indexed it yet
+				continue
+			}
+			freed += info.Size()
+			os.RemoveAll(filepath.Join(c.dir, f.Name()))
+			orphans++
+		}
+	}
+	for root, el := range c.entries {
+		if _, err := os.Stat(c.pathFor(root)); os.IsNotExist(err) {
+			entry := el.Value.(*cacheEntry)
+			c.lru.Remove(el)
+			delete(c.entries, root)
+			c.bytes -= entry.size
+			orphans++
+		}
+	}
+	return evicted, orphans, freed
+}
+
+func (c *DiskCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := CacheStats{
+		Enabled:   true,
+		Dir:       c.dir,
+		Entries:   len(c.entries),
+		Bytes:     c.bytes,
+		MaxBytes:  c.maxBytes,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+	if c.cold != nil {
+		stats.Cold = &ColdCacheStats{
+			Tier:       c.cold.Describe(),
+			Entries:    len(c.coldEntries),
+			Bytes:      c.coldBytes,
+			MaxBytes:   c.coldMaxBytes,
+			Pending:    len(c.demoting),
+			Demotions:  c.demotions,
+			Promotions: c.promotions,
+			Failures:   c.coldFailures,
+		}
+	}
+	if total := c.hits + c.misses; total > 0 {
+		stats.HitRate = float64(c.hits) / float64(total)
+	}
+	return stats
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTemp(t *testing.T, dir string, size int) string {
+	t.Helper()
+	f, err := os.CreateTemp(dir, "src-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(make([]byte, size))
+	f.Close()
+	return f.Name()
+}
+
+func TestCachePutRefusesObjectsLargerThanTheCache(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskCache(filepath.Join(dir, "cache"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := writeTemp(t, dir, 11)
+	path, _, err := c.Put("0x"+strings.Repeat("ab", 32), src)
+	if err != errTooLargeToCache {
+		t.Fatalf("Put = %v, want errTooLargeToCache", err)
+	}
+	if path != src {
+		t.Errorf("Put returned %s, want the source %s", path, src)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("source file is gone: %v", err)
+	}
+}
+
+func TestCacheKeepsPinnedFilesUntilReleased(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskCache(filepath.Join(dir, "cache"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := "0x" + strings.Repeat("ab", 32)
+	second := "0x" + strings.Repeat("cd", 32)
+
+	path, release, err := c.Put(first, writeTemp(t, dir, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, releaseSecond, err := c.Put(second, writeTemp(t, dir, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseSecond()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("pinned file was evicted: %v", err)
+	}
+
+	// Removed while pinned, the file stays until the last release
+	_, again, ok := c.Get(first)
+	if !ok {
+		t.Fatal("pinned entry is no longer cached")
+	}
+	c.Remove(first)
+	release()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("file deleted while still pinned: %v", err)
+	}
+	again()
+	again()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file kept after the last release: %v", err)
+	}
+}
@@ -0,0 +1,649 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Reasons a file record is hidden
+const (
+	hiddenByLifecycle  = "lifecycle"
+	hiddenByModeration = "moderation"
+)
+
+// StoredObject is a piece of content on 0G Storage. Identical content uploaded
+// by several tenants is stored once and shared through references.
+type StoredObject struct {
+	RootHash string `json:"root_hash"`
+	TxHash   string `json:"tx_hash"`
+	Size     int64  `json:"size"`
+	RefCount int    `json:"ref_count"`
+	// BlockNumber is the block the submission was mined in, once known
+	BlockNumber uint64    `json:"block_number,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	// TxFailed is set when the submission was not mined in time, so the
+	// content may not be on 0G; uploading it again submits it anew
+	TxFailed bool `json:"tx_failed,omitempty"`
+	// PublicID is a short ID download routes accept in place of the root
+	// hash, when PUBLIC_ID_SCHEME is set
+	PublicID string `json:"public_id,omitempty"`
+}
+
+// FileRecord is a tenant's reference to a stored object.
+type FileRecord struct {
+	Tenant      string            `json:"tenant"`
+	RootHash    string            `json:"root_hash"`
+	TxHash      string            `json:"tx_hash"`
+	Filename    string            `json:"filename"`
+	ContentType string            `json:"content_type,omitempty"`
+	Size        int64             `json:"size"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Hidden      bool              `json:"hidden,omitempty"`
+	BlockNumber uint64            `json:"block_number,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	// Encryption is set when the file is stored encrypted; Size is then the
+	// size of the ciphertext
+	Encryption *EncryptionInfo `json:"encryption,omitempty"`
+	// HiddenBy is what hid the record: a lifecycle rule or moderation
+	HiddenBy string `json:"hidden_by,omitempty"`
+	// TxFailed mirrors the stored object's flag
+	TxFailed bool `json:"tx_failed,omitempty"`
+}
+
+type TenantUsage struct {
+	Tenant string `json:"tenant"`
+	Files  int    `json:"files"`
+	Hidden int    `json:"hidden"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// Catalog tracks stored objects and per-tenant references to them. It lives in
+// memory and is optionally persisted as JSON to path.
+type Catalog struct {
+	mu      sync.RWMutex
+	path    string
+	objects map[string]*StoredObject
+	refs    map[string]map[string]*FileRecord // tenant -> root hash -> record
+	// publicIDs maps public IDs to root hashes
+	publicIDs map[string]string
+	// rev counts changes, so derived indexes know when to rebuild
+	rev uint64
+	// readOnly is set on a follower, which reloads what another process
+	// writes to path instead of writing it itself
+	readOnly bool
+	modTime  time.Time
+}
+
+type catalogSnapshot struct {
+	Objects []*StoredObject `json:"objects"`
+	Refs    []*FileRecord   `json:"refs"`
+}
+
+func NewCatalog(path string) (*Catalog, error) {
+	cat := &Catalog{
+		path:      path,
+		objects:   make(map[string]*StoredObject),
+		refs:      make(map[string]map[string]*FileRecord),
+		publicIDs: make(map[string]string),
+	}
+	if path == "" {
+		return cat, nil
+	}
+
+	if err := cat.load(); err != nil {
+		return nil, err
+	}
+	return cat, nil
+}
+
+// load replaces the contents with what is persisted at path. It must be
+// called with c.mu held, or before the catalog is shared.
+func (c *Catalog) load() error {
+	info, err := os.Stat(c.path)
+	if err == nil {
+		c.modTime = info.ModTime()
+	}
+	var snap catalogSnapshot
+	if err := readJSONFile(c.path, &snap); err != nil {
+		return fmt.Errorf("failed to load catalog: %v", err)
+	}
+	c.objects = make(map[string]*StoredObject, len(snap.Objects))
+	c.refs = make(map[string]map[string]*FileRecord)
+	for _, obj := range snap.Objects {
+		c.objects[obj.RootHash] = obj
+	}
+	for _, rec := range snap.Refs {
+		c.tenantRefs(rec.Tenant)[rec.RootHash] = rec
+	}
+	c.indexPublicIDs()
+	return nil
+}
+
+func (c *Catalog) indexPublicIDs() {
+	c.publicIDs = make(map[string]string)
+	for root, obj := range c.objects {
+		if obj.PublicID != "" {
+			c.publicIDs[obj.PublicID] = root
+		}
+	}
+}
+
+// Follow makes the catalog a read-only follower of the file another process
+// writes, reloading it whenever it changes, until ctx is done.
+func (c *Catalog) Follow(ctx context.Context, interval time.Duration) {
+	c.mu.Lock()
+	c.readOnly = true
+	c.mu.Unlock()
+	if c.path == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			info, err := os.Stat(c.path)
+			if err != nil {
+				continue
+			}
+			c.mu.Lock()
+			if info.ModTime().Equal(c.modTime) {
+				c.mu.Unlock()
+				continue
+			}
+			if err := c.load(); err != nil {
+				log.Printf("⚠️  Failed to reload catalog: %v", err)
+			} else {
+				c.rev++
+			}
+			c.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Object returns the stored object with the given root hash, if any.
+func (c *Catalog) Object(rootHash string) (StoredObject, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	obj, ok := c.objects[rootHash]
+	if !ok {
+		return StoredObject{}, false
+	}
+	return *obj, true
+}
+
+// ObjectsByTx returns every stored object submitted in transaction txHash,
+// ordered by root hash. A batched submission stores several in one
+// transaction.
+func (c *Catalog) ObjectsByTx(txHash string) []StoredObject {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var objects []StoredObject
+	for _, obj := range c.objects {
+		if strings.EqualFold(obj.TxHash, txHash) {
+			objects = append(objects, *obj)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].RootHash < objects[j].RootHash })
+	return objects
+}
+
+// Reference returns tenant's record for rootHash.
+func (c *Catalog) Reference(tenant, rootHash string) (FileRecord, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rec, ok := c.refs[tenant][rootHash]
+	if !ok {
+		return FileRecord{}, false
+	}
+	return *rec, true
+}
+
+// HasReference reports whether tenant holds a reference to rootHash.
+func (c *Catalog) HasReference(tenant, rootHash string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.refs[tenant][rootHash]
+	return ok
+}
+
+// AddReference records that rec.Tenant holds rec.RootHash, creating the
+// stored object on first use. Re-adding an existing reference only refreshes
+// its filename and metadata; the reference count is unchanged.
+func (c *Catalog) AddReference(rec FileRecord) (StoredObject, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	obj, ok := c.objects[rec.RootHash]
+	if !ok {
+		obj = &StoredObject{
+			RootHash:  rec.RootHash,
+			TxHash:    rec.TxHash,
+			Size:      rec.Size,
+			CreatedAt: rec.CreatedAt,
+		}
+		c.objects[rec.RootHash] = obj
+	} else if obj.TxFailed && rec.TxHash != "" && rec.TxHash != obj.TxHash {
+		// Submitted again after the first submission was given up on
+		obj.TxHash, obj.TxFailed, obj.BlockNumber = rec.TxHash, false, 0
+		for _, refs := range c.refs {
+			if r, ok := refs[rec.RootHash]; ok {
+				r.TxHash, r.TxFailed, r.BlockNumber = obj.TxHash, false, 0
+			}
+		}
+	}
+
+	refs := c.tenantRefs(rec.Tenant)
+	if existing, ok := refs[rec.RootHash]; ok {
+		existing.Filename = rec.Filename
+		existing.Metadata = rec.Metadata
+	} else {
+		rec.BlockNumber = obj.BlockNumber
+		rec.TxFailed = obj.TxFailed
+		refs[rec.RootHash] = &rec
+		obj.RefCount++
+	}
+
+	return *obj, c.save()
+}
+
+// RemoveReference drops a tenant's reference and returns how many references
+// remain on the shared object. The object itself is forgotten once nobody
+// references it.
+func (c *Catalog) RemoveReference(tenant, rootHash string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	refs := c.refs[tenant]
+	if _, ok := refs[rootHash]; !ok {
+		return 0, ErrNotFound
+	}
+	delete(refs, rootHash)
+
+	remaining := 0
+	if obj, ok := c.objects[rootHash]; ok {
+		obj.RefCount--
+		remaining = obj.RefCount
+		if remaining <= 0 {
+			delete(c.objects, rootHash)
+			delete(c.publicIDs, obj.PublicID)
+		}
+	}
+
+	return remaining, c.save()
+}
+
+// Usage reports the files and bytes a tenant holds. Shared objects count in
+// full against every tenant that references them.
+func (c *Catalog) Usage(tenant string) TenantUsage {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	usage := TenantUsage{Tenant: tenant}
+	for _, rec := range c.refs[tenant] {
+		usage.Files++
+		usage.Bytes += rec.Size
+		if rec.Hidden {
+			usage.Hidden++
+		}
+	}
+	return usage
+}
+
+// HideOlderThan hides a tenant's files created before cutoff whose filename
+// starts with prefix, and returns how many were hidden.
+func (c *Catalog) HideOlderThan(tenant string, cutoff time.Time, prefix string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hidden := 0
+	for _, rec := range c.refs[tenant] {
+		if !rec.Hidden && rec.CreatedAt.Before(cutoff) && strings.HasPrefix(rec.Filename, prefix) {
+			rec.Hidden, rec.HiddenBy = true, hiddenByLifecycle
+			hidden++
+		}
+	}
+	if hidden == 0 {
+		return 0, nil
+	}
+	return hidden, c.save()
+}
+
+// Files returns a tenant's visible records, oldest first.
+func (c *Catalog) Files(tenant string) []FileRecord {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	files := []FileRecord{}
+	for _, rec := range c.refs[tenant] {
+		if !rec.Hidden {
+			files = append(files, *rec)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].CreatedAt.Before(files[j].CreatedAt) })
+	return files
+}
+
+// UnminedTx is a submission transaction whose block is not yet known.
+type UnminedTx struct {
+	TxHash string
+	// Since is when the first object submitted with it was stored
+	Since time.Time
+}
+
+// UnminedTxs returns the submission transactions whose block is not yet
+// known and that have not been given up on.
+func (c *Catalog) UnminedTxs() []UnminedTx {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	since := make(map[string]time.Time)
+	for _, obj := range c.objects {
+		if obj.BlockNumber != 0 || obj.TxHash == "" || obj.TxFailed {
+			continue
+		}
+		if t, ok := since[obj.TxHash]; !ok || obj.CreatedAt.Before(t) {
+			since[obj.TxHash] = obj.CreatedAt
+		}
+	}
+	txs := make([]UnminedTx, 0, len(since))
+	for txHash, t := range since {
+		txs = append(txs, UnminedTx{TxHash: txHash, Since: t})
+	}
+	return txs
+}
+
+// SetBlocks records the block each transaction in blocks was mined in, and
+// marks the transactions in failed as given up on, on their objects and on
+// every reference to them. The catalog is saved once for all of them.
+func (c *Catalog) SetBlocks(blocks map[string]uint64, failed []string) error {
+	if len(blocks) == 0 && len(failed) == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	gaveUp := make(map[string]bool, len(failed))
+	for _, txHash := range failed {
+		gaveUp[txHash] = true
+	}
+	for _, obj := range c.objects {
+		if block, ok := blocks[obj.TxHash]; ok {
+			obj.BlockNumber = block
+		} else if gaveUp[obj.TxHash] {
+			obj.TxFailed = true
+		} else {
+			continue
+		}
+		for _, refs := range c.refs {
+			if rec, ok := refs[obj.RootHash]; ok {
+				rec.BlockNumber, rec.TxFailed = obj.BlockNumber, obj.TxFailed
+			}
+		}
+	}
+	return c.save()
+}
+
+// SetHidden hides every tenant's reference to rootHash on behalf of by, or
+// unhides the references by hid, and returns how many references changed.
+// References hidden for another reason are left alone.
+func (c *Catalog) SetHidden(rootHash string, hidden bool, by string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	changed := 0
+	for _, refs := range c.refs {
+		rec, ok := refs[rootHash]
+		if !ok || rec.Hidden == hidden || (!hidden && rec.HiddenBy != by) {
+			continue
+		}
+		rec.Hidden = hidden
+		if hidden {
+			rec.HiddenBy = by
+		} else {
+			rec.HiddenBy = ""
+		}
+		changed++
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+	return changed, c.save()
+}
+
+// MissingMetadata returns, sorted, the root hashes of objects that have no
+// size or a reference without a content type: uploads recorded before those
+// were tracked.
+func (c *Catalog) MissingMetadata() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	missing := make(map[string]bool)
+	for root, obj := range c.objects {
+		if obj.Size == 0 {
+			missing[root] = true
+		}
+	}
+	for _, refs := range c.refs {
+		for root, rec := range refs {
+			if rec.ContentType == "" || rec.Size == 0 {
+				missing[root] = true
+			}
+		}
+	}
+	roots := make([]string, 0, len(missing))
+	for root := range missing {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// FillMetadata sets the content type and size of rootHash wherever they are
+// missing, leaving recorded values alone, and returns how many references
+// changed.
+func (c *Catalog) FillMetadata(rootHash, contentType string, size int64) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	changed := 0
+	objChanged := false
+	if obj, ok := c.objects[rootHash]; ok && obj.Size == 0 && size > 0 {
+		obj.Size = size
+		objChanged = true
+	}
+	for _, refs := range c.refs {
+		rec, ok := refs[rootHash]
+		if !ok {
+			continue
+		}
+		updated := false
+		if rec.ContentType == "" && contentType != "" {
+			rec.ContentType = contentType
+			updated = true
+		}
+		if rec.Size == 0 && size > 0 {
+			rec.Size = size
+			updated = true
+		}
+		if updated {
+			changed++
+		}
+	}
+	if changed == 0 && !objChanged {
+		return 0, nil
+	}
+	return changed, c.save()
+}
+
+// AssignPublicID gives rootHash a public ID made by newID, unless it already
+// has one, and returns its ID.
+func (c *Catalog) AssignPublicID(rootHash string, newID func() (string, error)) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	obj, ok := c.objects[rootHash]
+	if !ok {
+		return "", ErrNotFound
+	}
+	if obj.PublicID != "" {
+		return obj.PublicID, nil
+	}
+	for {
+		id, err := newID()
+		if err != nil {
+			return "", err
+		}
+		if _, taken := c.publicIDs[id]; taken {
+			continue
+		}
+		obj.PublicID = id
+		c.publicIDs[id] = rootHash
+		return id, c.save()
+	}
+}
+
+// ResolvePublicID returns the root hash a public ID stands for.
+func (c *Catalog) ResolvePublicID(id string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	root, ok := c.publicIDs[id]
+	return root, ok
+}
+
+// Roots returns the root hashes a tenant references.
+func (c *Catalog) Roots(tenant string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	roots := make([]string, 0, len(c.refs[tenant]))
+	for root := range c.refs[tenant] {
+		roots = append(roots, root)
+	}
+	return roots
+}
+
+func (c *Catalog) tenantRefs(tenant string) map[string]*FileRecord {
+	refs, ok := c.refs[tenant]
+	if !ok {
+		refs = make(map[string]*FileRecord)
+		c.refs[tenant] = refs
+	}
+	return refs
+}
+
+// Export returns a copy of every object and reference.
+func (c *Catalog) Export() ([]StoredObject, []FileRecord) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	objects := make([]StoredObject, 0, len(c.objects))
+	for _, obj := range c.objects {
+		objects = append(objects, *obj)
+	}
+	var refs []FileRecord
+	for _, tenantRefs := range c.refs {
+		for _, rec := range tenantRefs {
+			refs = append(refs, *rec)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].RootHash < objects[j].RootHash })
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Tenant != refs[j].Tenant {
+			return refs[i].Tenant < refs[j].Tenant
+		}
+		return refs[i].RootHash < refs[j].RootHash
+	})
+	return objects, refs
+}
+
+// Import loads objects and references into an empty catalog.
+func (c *Catalog) Import(objects []StoredObject, refs []FileRecord) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.objects) > 0 || len(c.refs) > 0 {
+		return fmt.Errorf("catalog is not empty")
+	}
+	for i := range objects {
+		obj := objects[i]
+		c.objects[obj.RootHash] = &obj
+	}
+	for i := range refs {
+		rec := refs[i]
+		c.tenantRefs(rec.Tenant)[rec.RootHash] = &rec
+	}
+	c.indexPublicIDs()
+	return c.save()
+}
+
+// Empty reports whether the catalog holds nothing yet.
+func (c *Catalog) Empty() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.objects) == 0 && len(c.refs) == 0
+}
+
+// Revision changes whenever the catalog does.
+func (c *Catalog) Revision() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.rev
+}
+
+// save must be called with c.mu held.
+func (c *Catalog) save() error {
+	if c.readOnly {
+		return fmt.Errorf("catalog is read-only on a download-only instance")
+	}
+	c.rev++
+	if c.path == "" {
+		return nil
+	}
+
+	snap := catalogSnapshot{}
+	for _, obj := range c.objects {
+		snap.Objects = append(snap.Objects, obj)
+	}
+	for _, refs := range c.refs {
+		for _, rec := range refs {
+			snap.Refs = append(snap.Refs, rec)
+		}
+	}
+
+	if err := writeJSONFile(c.path, snap); err != nil {
+		return fmt.Errorf("failed to write catalog: %v", err)
+	}
+	return nil
+}
+
+// writeJSONFile replaces path atomically so a crash never leaves it truncated.
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readJSONFile loads path into v. A missing file leaves v untouched.
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
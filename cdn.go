@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OriginShield adapts the public content routes to sitting behind a CDN such
+// as Cloudflare or Fastly: only requests carrying the shared secret the CDN
+// adds are served, the CDN is told to cache mutable site routes for long and
+// those routes are purged by surrogate key whenever a site is repointed.
+type OriginShield struct {
+	header     string
+	secret     string
+	siteTTL    time.Duration
+	purgeURL   string
+	purgeToken string
+	http       *http.Client
+}
+
+// NewOriginShield returns nil unless ORIGIN_SECRET is configured.
+func NewOriginShield(cfg *Config) *OriginShield {
+	if cfg.OriginSecret == "" {
+		return nil
+	}
+	return &OriginShield{
+		header:     cfg.OriginSecretHeader,
+		secret:     cfg.OriginSecret,
+		siteTTL:    cfg.CDNSiteTTL,
+		purgeURL:   cfg.CDNPurgeURL,
+		purgeToken: cfg.CDNPurgeToken,
+		http:       &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func siteSurrogateKey(name string) string {
+	return "site-" + name
+}
+
+// Allowed reports whether a request came through the CDN.
+func (o *OriginShield) Allowed(r *http.Request) bool {
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(o.header)), []byte(o.secret)) == 1
+}
+
+// tagSite lets the CDN keep a site response until it is purged, while
+// browsers keep revalidating according to Cache-Control.
+func (o *OriginShield) tagSite(c *gin.Context, name string) {
+	ttl := int(o.siteTTL.Seconds())
+	c.Header("CDN-Cache-Control", fmt.Sprintf("public, max-age=%d", ttl))
+	c.Header("Surrogate-Control", fmt.Sprintf("max-age=%d", ttl))
+	c.Header("Surrogate-Key", siteSurrogateKey(name))
+	c.Header("Cache-Tag", siteSurrogateKey(name))
+}
+
+// Purge asks the CDN to drop everything tagged with keys. The request works
+// with Cloudflare's purge-by-tag body and Fastly's Surrogate-Key header.
+func (o *OriginShield) Purge(ctx context.Context, keys []string) error {
+	if o.purgeURL == "" {
+		return fmt.Errorf("no purge URL configured (set CDN_PURGE_URL)")
+	}
+	body, err := json.Marshal(map[string][]string{"tags": keys})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.purgeURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build purge request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Surrogate-Key", strings.Join(keys, " "))
+	if o.purgeToken != "" {
+		req.Header.Set("Authorization", "Bearer "+o.purgeToken)
+	}
+
+	resp, err := o.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to purge CDN: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("CDN purge returned %s", resp.Status)
+	}
+	return nil
+}
+
+// requireOrigin rejects content requests that bypassed the CDN.
+func (s *Server) requireOrigin(c *gin.Context) {
+	if s.shield != nil && !s.shield.Allowed(c.Request) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Requests must come through the CDN"})
+		return
+	}
+	c.Next()
+}
+
+// purgeSite invalidates a repointed site at the CDN in the background.
+func (s *Server) purgeSite(name string) {
+	if s.shield == nil || s.shield.purgeURL == "" {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := s.shield.Purge(ctx, []string{siteSurrogateKey(name)}); err != nil {
+			log.Printf("⚠️  Failed to purge site %s: %v", name, err)
+		}
+	}()
+}
+
+type PurgeRequest struct {
+	Sites []string `json:"sites"`
+	Keys  []string `json:"keys"`
+}
+
+type PurgeResponse struct {
+	Purged []string `json:"purged"`
+}
+
+// @Summary Purge CDN caches
+// @Description Invalidates sites and raw surrogate keys at the CDN configured with CDN_PURGE_URL. Meant to be called from deploy hooks; sites are purged automatically when repointed through the API.
+// @Accept json
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Param request body PurgeRequest true "Sites and surrogate keys to purge"
+// @Success 200 {object} PurgeResponse
+// @Router /admin/cdn/purge [post]
+func (s *Server) handleCDNPurge(c *gin.Context) {
+	if s.shield == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Origin shield mode is not enabled (set ORIGIN_SECRET)"})
+		return
+	}
+	var req PurgeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	keys := append([]string{}, req.Keys...)
+	for _, name := range req.Sites {
+		keys = append(keys, siteSurrogateKey(name))
+	}
+	if len(keys) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to purge"})
+		return
+	}
+
+	if err := s.shield.Purge(c.Request.Context(), keys); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, PurgeResponse{Purged: keys})
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestClass separates traffic someone is waiting on from bulk work, so the
+// two do not compete for the same workers, rate limits and storage nodes.
+type RequestClass int
+
+const (
+	// ClassInteractive is a client waiting on an API response
+	ClassInteractive RequestClass = iota
+	// ClassBatch is bulk traffic such as migrations, and background jobs
+	// nobody is waiting on
+	ClassBatch
+)
+
+const (
+	requestClassContextKey = "request_class"
+	requestClassHeader     = "X-Request-Class"
+)
+
+func (rc RequestClass) String() string {
+	if rc == ClassBatch {
+		return "batch"
+	}
+	return "interactive"
+}
+
+func parseRequestClass(s string) (RequestClass, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "interactive":
+		return ClassInteractive, nil
+	case "batch":
+		return ClassBatch, nil
+	}
+	return ClassInteractive, fmt.Errorf("unknown request class %q (want interactive or batch)", s)
+}
+
+// parseTenantClasses reads "tenant=class" pairs, skipping unknown classes.
+func parseTenantClasses(raw string) map[string]RequestClass {
+	classes := make(map[string]RequestClass)
+	for tenant, v := range parseKeyValueList(raw) {
+		class, err := parseRequestClass(v)
+		if err != nil {
+			log.Printf("⚠️  TENANT_CLASSES: %s: %v", tenant, err)
+			continue
+		}
+		classes[tenant] = class
+	}
+	return classes
+}
+
+// ClassLimits bounds one class: how many of its requests run at once and how
+// many may start per second (0 is unlimited).
+type ClassLimits struct {
+	Workers    int
+	RatePerSec int
+}
+
+// classLane is the worker pool and rate limit of one class.
+type classLane struct {
+	workers chan struct{}
+	rate    *tokenBucket
+	// queued counts requests waiting for a worker
+	queued int64
+}
+
+// RequestClasses assigns each API request a class and runs it in that class's
+// lane, so a bulk migration saturating the batch lane leaves interactive
+// uploads and downloads unaffected.
+type RequestClasses struct {
+	tenants map[string]RequestClass
+	lanes   map[RequestClass]*classLane
+}
+
+func NewRequestClasses(tenants map[string]RequestClass, limits map[RequestClass]ClassLimits) *RequestClasses {
+	rc := &RequestClasses{tenants: tenants, lanes: make(map[RequestClass]*classLane)}
+	for class, l := range limits {
+		lane := &classLane{}
+		if l.Workers > 0 {
+			lane.workers = make(chan struct{}, l.Workers)
+		}
+		if l.RatePerSec > 0 {
+			lane.rate = newTokenBucket(float64(l.RatePerSec), l.RatePerSec)
+		}
+		rc.lanes[class] = lane
+	}
+	return rc
+}
+
+// Classify picks the class of a request: the X-Request-Class header, except
+// that tenants configured as batch cannot promote themselves to interactive.
+func (rc *RequestClasses) Classify(tenant, header string) (RequestClass, error) {
+	class := rc.tenants[tenant]
+	if header == "" {
+		return class, nil
+	}
+	requested, err := parseRequestClass(header)
+	if err != nil {
+		return class, err
+	}
+	if requested == ClassBatch {
+		class = ClassBatch
+	}
+	return class, nil
+}
+
+func requestClassFrom(c *gin.Context) RequestClass {
+	if v, ok := c.Get(requestClassContextKey); ok {
+		return v.(RequestClass)
+	}
+	return ClassInteractive
+}
+
+// classifyRequest admits an API request into its class's lane: rejected with
+// 429 over the class's rate limit, otherwise queued until one of its workers
+// is free. Responses report the rate limit in X-RateLimit-Limit, -Remaining
+// and -Reset, and a request that had to queue reports the position it joined
+// at in X-Queue-Position and how long it waited in X-Queue-Wait.
+func (s *Server) classifyRequest(c *gin.Context) {
+	class, err := s.classes.Classify(tenantFrom(c), c.GetHeader(requestClassHeader))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Set(requestClassContextKey, class)
+	c.Header(requestClassHeader, class.String())
+
+	lane := s.classes.lanes[class]
+	if lane == nil {
+		c.Next()
+		return
+	}
+	if lane.rate != nil {
+		st := lane.rate.Take()
+		c.Header("X-RateLimit-Limit", strconv.Itoa(st.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(st.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(st.Reset)))
+		if st.Wait > 0 {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(st.Wait)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": fmt.Sprintf("Rate limit for %s requests exceeded", class)})
+			return
+		}
+	}
+	if lane.workers != nil {
+		if !lane.acquire(c) {
+			c.Abort()
+			return
+		}
+		defer func() { <-lane.workers }()
+	}
+	c.Next()
+}
+
+// acquire takes a worker, queueing when all are busy. It fails if the client
+// goes away first.
+func (l *classLane) acquire(c *gin.Context) bool {
+	select {
+	case l.workers <- struct{}{}:
+		return true
+	default:
+	}
+
+	// Waiters are not strictly served in order, so the position is a hint
+	position := atomic.AddInt64(&l.queued, 1)
+	defer atomic.AddInt64(&l.queued, -1)
+	c.Header("X-Queue-Position", strconv.FormatInt(position, 10))
+	start := time.Now()
+	select {
+	case l.workers <- struct{}{}:
+		c.Header("X-Queue-Wait", strconv.FormatInt(time.Since(start).Milliseconds(), 10))
+		return true
+	case <-c.Request.Context().Done():
+		return false
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// tokenBucket allows bursts of up to burst requests and refills at rate
+// tokens per second.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// bucketState is a token bucket as seen by one request.
+type bucketState struct {
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// Wait is how long until a token is available; zero when one was taken
+	Wait time.Duration
+}
+
+// Take consumes a token if one is available.
+func (b *tokenBucket) Take() bucketState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	st := bucketState{Limit: int(b.burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+	} else {
+		st.Wait = b.after(1 - b.tokens)
+	}
+	st.Remaining = int(b.tokens)
+	st.Reset = b.after(b.burst - b.tokens)
+	return st
+}
+
+// Peek reports the bucket's state without taking a token.
+func (b *tokenBucket) Peek() bucketState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tokens := math.Min(b.burst, b.tokens+time.Since(b.last).Seconds()*b.rate)
+	return bucketState{Limit: int(b.burst), Remaining: int(tokens), Reset: b.after(b.burst - tokens)}
+}
+
+// after is how long the bucket takes to refill n tokens.
+func (b *tokenBucket) after(n float64) time.Duration {
+	return time.Duration(n / b.rate * float64(time.Second))
+}
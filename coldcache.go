@@ -0,0 +1,344 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// coldStagingDir holds evicted files until they are demoted, and files
+	// being promoted until they are cached again. It lives in the cache
+	// directory so both moves are renames.
+	coldStagingDir = ".cold"
+	// coldDemoteWorkers bounds the demotions copying to the cold tier at once
+	coldDemoteWorkers = 2
+	// coldTierTimeout bounds one copy to or from an S3 cold tier
+	coldTierTimeout = 10 * time.Minute
+)
+
+// ColdTier is a cheaper, slower place for objects evicted from the download
+// cache, such as a network volume or a bucket. Objects are stored under
+// their root hash and, like the cache, never go stale.
+type ColdTier interface {
+	// Describe names the tier in logs and stats
+	Describe() string
+	Store(rootHash, path string) error
+	// Fetch copies rootHash to dst
+	Fetch(rootHash, dst string) error
+	Delete(rootHash string) error
+	// List returns the size of every object the tier holds
+	List() (map[string]int64, error)
+}
+
+// dirColdTier keeps demoted objects in a directory, typically on a network
+// volume.
+type dirColdTier struct {
+	dir string
+}
+
+func NewDirColdTier(dir string) (ColdTier, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cold cache directory: %v", err)
+	}
+	return &dirColdTier{dir: dir}, nil
+}
+
+func (t *dirColdTier) Describe() string {
+	return t.dir
+}
+
+func (t *dirColdTier) Store(rootHash, path string) error {
+	// Copy under a temporary name so a half-written file is never listed
+	tmp := filepath.Join(t.dir, "."+rootHash+".tmp")
+	if err := copyFile(path, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(t.dir, rootHash))
+}
+
+func (t *dirColdTier) Fetch(rootHash, dst string) error {
+	return copyFile(filepath.Join(t.dir, rootHash), dst)
+}
+
+func (t *dirColdTier) Delete(rootHash string) error {
+	if err := os.Remove(filepath.Join(t.dir, rootHash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (t *dirColdTier) List() (map[string]int64, error) {
+	files, err := os.ReadDir(t.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cold cache directory: %v", err)
+	}
+	sizes := make(map[string]int64)
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil || !info.Mode().IsRegular() || !isRootHash(f.Name()) {
+			continue
+		}
+		sizes[f.Name()] = info.Size()
+	}
+	return sizes, nil
+}
+
+// s3ColdTier keeps demoted objects in an S3-compatible bucket under prefix.
+type s3ColdTier struct {
+	client *S3Client
+	bucket string
+	prefix string
+}
+
+func NewS3ColdTier(s3 S3Config) (ColdTier, error) {
+	client, err := NewS3Client(s3.Endpoint, s3.Region, s3.Bucket, s3.AccessKey, s3.SecretKey)
+	if err != nil {
+		return nil, err
+	}
+	return &s3ColdTier{client: client, bucket: s3.Bucket, prefix: s3.Prefix}, nil
+}
+
+func (t *s3ColdTier) Describe() string {
+	return "s3://" + t.bucket + "/" + t.prefix
+}
+
+func (t *s3ColdTier) Store(rootHash, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), coldTierTimeout)
+	defer cancel()
+	_, err = t.client.PutObject(ctx, t.prefix+rootHash, f)
+	return err
+}
+
+func (t *s3ColdTier) Fetch(rootHash, dst string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), coldTierTimeout)
+	defer cancel()
+	body, err := t.client.GetObject(ctx, t.prefix+rootHash)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, body); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (t *s3ColdTier) Delete(rootHash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), coldTierTimeout)
+	defer cancel()
+	return t.client.DeleteObject(ctx, t.prefix+rootHash)
+}
+
+func (t *s3ColdTier) List() (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), coldTierTimeout)
+	defer cancel()
+	objects, err := t.client.ListObjects(ctx, t.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cold cache bucket: %v", err)
+	}
+	sizes := make(map[string]int64)
+	for _, obj := range objects {
+		if root := strings.TrimPrefix(obj.Key, t.prefix); isRootHash(root) {
+			sizes[root] = obj.Size
+		}
+	}
+	return sizes, nil
+}
+
+type ColdCacheStats struct {
+	Tier       string `json:"tier"`
+	Entries    int    `json:"entries"`
+	Bytes      int64  `json:"bytes"`
+	MaxBytes   int64  `json:"max_bytes"`
+	Pending    int    `json:"pending"`
+	Demotions  int64  `json:"demotions"`
+	Promotions int64  `json:"promotions"`
+	Failures   int64  `json:"failures"`
+}
+
+// UseColdTier demotes evicted objects to tier instead of deleting them,
+// keeping up to maxBytes there (0 is no limit), and promotes them back on
+// access. The tier's current contents are indexed first.
+func (c *DiskCache) UseColdTier(tier ColdTier, maxBytes int64) error {
+	staging := filepath.Join(c.dir, coldStagingDir)
+	// Demotions a previous run did not finish are dropped
+	os.RemoveAll(staging)
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return fmt.Errorf("failed to create cold staging directory: %v", err)
+	}
+	sizes, err := tier.List()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cold = tier
+	c.coldMaxBytes = maxBytes
+	c.coldLRU = list.New()
+	c.coldEntries = make(map[string]*list.Element)
+	c.demoting = make(map[string]*cacheEntry)
+	c.demoteSlots = make(chan struct{}, coldDemoteWorkers)
+	for root, size := range sizes {
+		c.coldEntries[root] = c.coldLRU.PushBack(&cacheEntry{rootHash: root, size: size})
+		c.coldBytes += size
+	}
+	c.trimColdLocked()
+	return nil
+}
+
+func (c *DiskCache) stagingPath(rootHash string) string {
+	return filepath.Join(c.dir, coldStagingDir, rootHash)
+}
+
+// demoteLocked takes an evicted entry out of the hot tier and starts
+// copying it to the cold one. Objects the cold tier already holds are only
+// dropped locally, since cached content never changes.
+func (c *DiskCache) demoteLocked(el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+	c.lru.Remove(el)
+	delete(c.entries, entry.rootHash)
+	c.bytes -= entry.size
+
+	if cold, ok := c.coldEntries[entry.rootHash]; ok {
+		cold.Value.(*cacheEntry).lastAccess = entry.lastAccess
+		c.coldLRU.MoveToFront(cold)
+		os.Remove(c.pathFor(entry.rootHash))
+		return
+	}
+	if _, ok := c.demoting[entry.rootHash]; ok {
+		os.Remove(c.pathFor(entry.rootHash))
+		return
+	}
+	staged := c.stagingPath(entry.rootHash)
+	if err := os.Rename(c.pathFor(entry.rootHash), staged); err != nil {
+		os.Remove(c.pathFor(entry.rootHash))
+		return
+	}
+	c.demoting[entry.rootHash] = entry
+	go c.demote(entry, staged)
+}
+
+// demote copies a staged file to the cold tier and indexes it there.
+func (c *DiskCache) demote(entry *cacheEntry, staged string) {
+	c.demoteSlots <- struct{}{}
+	err := c.cold.Store(entry.rootHash, staged)
+	<-c.demoteSlots
+	os.Remove(staged)
+
+	c.mu.Lock()
+	_, wanted := c.demoting[entry.rootHash]
+	delete(c.demoting, entry.rootHash)
+	if err != nil {
+		c.coldFailures++
+		c.mu.Unlock()
+		log.Printf("⚠️  Failed to demote %s to the cold cache: %v", entry.rootHash, err)
+		return
+	}
+	if !wanted {
+		// Removed from the cache while it was being copied
+		c.mu.Unlock()
+		c.deleteCold([]string{entry.rootHash})
+		return
+	}
+	c.coldEntries[entry.rootHash] = c.coldLRU.PushFront(entry)
+	c.coldBytes += entry.size
+	c.demotions++
+	victims := c.trimColdLocked()
+	c.mu.Unlock()
+	c.deleteCold(victims)
+}
+
+// trimColdLocked drops the least recently used cold entries beyond the
+// limit and returns them; the caller deletes them outside the lock.
+func (c *DiskCache) trimColdLocked() []string {
+	var victims []string
+	for c.coldMaxBytes > 0 && c.coldBytes > c.coldMaxBytes && c.coldLRU.Len() > 0 {
+		victims = append(victims, c.removeColdLocked(c.coldLRU.Back()))
+	}
+	return victims
+}
+
+func (c *DiskCache) removeColdLocked(el *list.Element) string {
+	entry := el.Value.(*cacheEntry)
+	c.coldLRU.Remove(el)
+	delete(c.coldEntries, entry.rootHash)
+	c.coldBytes -= entry.size
+	return entry.rootHash
+}
+
+func (c *DiskCache) deleteCold(roots []string) {
+	for _, root := range roots {
+		if err := c.cold.Delete(root); err != nil {
+			log.Printf("⚠️  Failed to delete %s from the cold cache: %v", root, err)
+		}
+	}
+}
+
+// inColdLocked reports whether rootHash is in, or on its way to, the cold
+// tier.
+func (c *DiskCache) inColdLocked(rootHash string) bool {
+	if c.cold == nil {
+		return false
+	}
+	if _, ok := c.coldEntries[rootHash]; ok {
+		return true
+	}
+	_, ok := c.demoting[rootHash]
+	return ok
+}
+
+// promote brings rootHash back from the cold tier, or from staging when its
+// demotion has not finished, and caches it again. The cold copy is kept, so
+// evicting the object later costs nothing. The cached file is pinned as by
+// Get.
+func (c *DiskCache) promote(rootHash string) (string, func(), error) {
+	tmp, err := os.CreateTemp(filepath.Join(c.dir, coldStagingDir), "promote-*")
+	if err != nil {
+		return "", nil, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	c.mu.Lock()
+	_, pending := c.demoting[rootHash]
+	c.mu.Unlock()
+	if pending {
+		err = copyFile(c.stagingPath(rootHash), tmp.Name())
+	}
+	if !pending || err != nil {
+		err = c.cold.Fetch(rootHash, tmp.Name())
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to promote %s from the cold cache: %v", rootHash, err)
+	}
+	path, release, err := c.Put(rootHash, tmp.Name())
+	if err != nil {
+		return "", nil, err
+	}
+
+	c.mu.Lock()
+	if el, ok := c.coldEntries[rootHash]; ok {
+		c.coldLRU.MoveToFront(el)
+	}
+	c.promotions++
+	c.mu.Unlock()
+	return path, release, nil
+}
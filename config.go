@@ -0,0 +1,644 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the runtime settings read from the environment (.env is
+// loaded before LoadConfig is called).
+type Config struct {
+	PrivateKey string
+	UseTurbo   bool
+	Port       string
+	// TrustedProxies are the addresses or CIDRs whose X-Forwarded-For and
+	// X-Real-IP headers are believed; with none, a client's IP is the
+	// connection's peer address
+	TrustedProxies []string
+	// ShutdownDrainTimeout bounds how long a shutdown waits for requests and
+	// jobs in flight
+	ShutdownDrainTimeout time.Duration
+	// ResourceProfile names the defaults worker pools, caches and buffers
+	// are sized by: standard or low (see profile.go)
+	ResourceProfile string
+
+	// SponsoredUploads opens POST /sponsored/upload: keyless uploads of at
+	// most SponsoredMaxBytes of SponsoredTypes, paid from the gateway's
+	// wallet, SponsoredRateLimit an hour per IP and within
+	// SponsoredDailyBudget 0G a day
+	SponsoredUploads     bool
+	SponsoredTenant      string
+	SponsoredMaxBytes    int64
+	SponsoredTypes       []string
+	SponsoredRateLimit   int
+	SponsoredDailyBudget string
+
+	// LogFormat is text or json; LogLevel is debug, info, warn or error
+	LogFormat string
+	LogLevel  string
+	// TraceURLTemplate links a trace ID to the operator's tracing UI, with
+	// {trace_id} standing for the ID
+	TraceURLTemplate string
+	// OTLPEndpoint (or OTLPTracesEndpoint, the full URL) is the
+	// OpenTelemetry collector spans are exported to over OTLP/HTTP
+	OTLPEndpoint       string
+	OTLPTracesEndpoint string
+	OTLPHeaders        map[string]string
+	OTelServiceName    string
+
+	// WalletBackend picks where the gateway's key lives: raw (PrivateKey),
+	// keystore, kms, vault or ledger
+	WalletBackend              string
+	WalletKeystore             string
+	WalletKeystorePasswordFile string
+	WalletKMS                  KMSConfig
+	WalletVault                VaultConfig
+	WalletLedgerPath           string
+	// WalletPoolKeys are further funded accounts uploads are spread across,
+	// alongside the wallet, so that they do not wait on each other's nonces
+	WalletPoolKeys []string
+	// WalletLowBalance is the balance, in 0G, under which the wallet is
+	// reported low
+	WalletLowBalance string
+
+	// Network names the 0G network profile (NETWORK or --network);
+	// NetworkConfig is a YAML file of further profiles (NETWORK_CONFIG or
+	// --network-config)
+	Network       string
+	NetworkConfig string
+
+	// ServiceMode is all, upload or download; split deployments point both
+	// halves at the same CatalogPath, and download instances reload it every
+	// CatalogReloadInterval
+	ServiceMode           string
+	CatalogReloadInterval time.Duration
+
+	MaxJSONUploadBytes int64
+	// MaxUploadBytes caps multipart uploads, enforced while the body streams
+	// in since chunked requests have no Content-Length (0 is unlimited)
+	MaxUploadBytes int64
+	// MultipartMemoryBytes is the most of a multipart form held in memory
+	MultipartMemoryBytes int64
+	// AsyncUploadWorkers is how many async uploads run at once
+	AsyncUploadWorkers int
+
+	// NodeAllowlist holds the storage node URLs downloads may be pinned to
+	NodeAllowlist []string
+
+	// Storage node probing: extra nodes to probe besides those the indexer
+	// selects, how often, and the average latency above which a node is only
+	// used for background work
+	StorageNodes      []string
+	NodeProbeInterval time.Duration
+	NodeSlowLatency   time.Duration
+	// NodeRetry is how often steps against storage nodes are retried
+	NodeRetry RetryPolicy
+	// Node pool: how long a health-checked node selection is reused, and
+	// how old one may be served when the indexer cannot answer
+	NodePoolTTL      time.Duration
+	NodePoolMaxStale time.Duration
+
+	// Request classes: tenants whose requests are batch by default, and the
+	// workers and rate limit of each class
+	TenantClasses map[string]RequestClass
+	ClassLimits   map[RequestClass]ClassLimits
+
+	// Uploads arriving within UploadBatchWindow of each other are submitted in
+	// one transaction, up to UploadBatchMax files; 0 submits each on its own
+	UploadBatchWindow time.Duration
+	UploadBatchMax    int
+
+	// Upper bounds for the X-Transfer-Concurrency and X-Segment-Size-Hint
+	// client hints, in parallel segments and segments per upload task; 0
+	// ignores the hint
+	MaxTransferConcurrency int
+	MaxTaskSegments        int
+	// MaxUploadReplicas bounds the replicas an upload may ask for
+	MaxUploadReplicas int
+
+	// Transforms applied to uploads before hashing: the default for every
+	// tenant and per-tenant overrides
+	UploadTransforms []string
+	TenantTransforms map[string][]string
+
+	// UploadPolicy is a JSON policy file path or inline JSON document
+	UploadPolicy string
+	// AccessPolicy holds expression rules authorizing API requests, as a
+	// file path or inline JSON document
+	AccessPolicy string
+
+	APIKeys map[string]string
+
+	// Storage quotas in bytes: the default for every tenant (0 is unlimited)
+	// and per-tenant overrides
+	DefaultQuotaBytes int64
+	TenantQuotas      map[string]int64
+	// Bytes a tenant may upload per UTC day (0 is unlimited), per-tenant
+	// overrides of it, and per-tenant file size limits below MaxUploadBytes
+	DailyUploadBytes       int64
+	TenantDailyUploadBytes map[string]int64
+	TenantMaxFileBytes     map[string]int64
+	// KeyRateLimit is the requests per second each API key, or client IP
+	// without one, may make (0 is unlimited), in bursts of KeyRateBurst
+	KeyRateLimit int
+	KeyRateBurst int
+
+	// DataDir holds local state (catalog, links, ...). Empty keeps it in memory.
+	DataDir     string
+	CatalogPath string
+	// CatalogBootstrapRoot is a catalog snapshot on 0G loaded into an empty
+	// catalog at startup
+	CatalogBootstrapRoot string
+	// AutoMigrate applies pending data directory migrations at startup;
+	// when off, the migrate subcommand has to be run first
+	AutoMigrate bool
+
+	AdminToken string
+
+	// UploadSessionSecret signs the upload session tokens dApp backends
+	// hand to browsers; replicas must share it
+	UploadSessionSecret string
+	// SignedURLAudience names this gateway in the signed URLs it issues;
+	// by default the host each request was made to
+	SignedURLAudience string
+
+	// ResumeTokenSecret signs download resumption tokens; replicas must share it
+	ResumeTokenSecret string
+	// StreamDownloads sends uncached whole-file downloads to the client
+	// segment by segment instead of staging them on disk first
+	StreamDownloads bool
+	// PublicIDScheme makes short IDs for uploads that download routes accept
+	// in place of root hashes: root (none), base58 or uuid
+	PublicIDScheme string
+	// MetadataStore keeps the upload history: sqlite (in DATA_DIR/uploads.db
+	// unless MetadataDSN says otherwise), postgres (MetadataDSN is then
+	// required) or none. Unset, it is sqlite in builds with the sqlhistory
+	// tag and none otherwise
+	MetadataStore string
+	MetadataDSN   string
+
+	// Feature flags: defaults (flag=on|off,...), tenants per flag
+	// (flag=tenant|!tenant,...), rollout percentages (flag=percent,...) and
+	// the API key IDs allowed to override them with X-Feature-Flags
+	FeatureFlags           string
+	FeatureFlagTenants     map[string]string
+	FeatureFlagRollout     map[string]string
+	FeatureFlagTrustedKeys []string
+
+	// SignResponses adds a signature over what was served to downloads, made
+	// with ResponseSigningKey (default: PrivateKey, or the wallet when
+	// there is none)
+	SignResponses      bool
+	ResponseSigningKey string
+
+	// Origin shield mode for running behind a CDN
+	OriginSecret       string
+	OriginSecretHeader string
+	CDNSiteTTL         time.Duration
+	CDNPurgeURL        string
+	CDNPurgeToken      string
+
+	// Local disk usage: download cache, upload/download staging, housekeeping
+	CacheDir      string
+	CacheMaxBytes int64
+	SpoolDir      string
+	SpoolS3       S3Config
+	GCInterval    time.Duration
+	// Cold tier evicted cache objects are demoted to: a directory or a bucket
+	CacheColdDir      string
+	CacheColdS3       S3Config
+	CacheColdMaxBytes int64
+	// Peer gateways whose caches are asked before the storage nodes
+	PeerGateways []string
+	PeerSecret   string
+	// Prefetch hints: how many top results of a listing or search are
+	// fetched into the cache, the largest file worth it, how many may
+	// wait and how many are fetched at once
+	PrefetchOnList   int
+	PrefetchMaxBytes int64
+	PrefetchQueue    int
+	PrefetchWorkers  int
+
+	LifecycleInterval time.Duration
+
+	// Usage export for billing; off unless a sink is set
+	MeteringInterval      time.Duration
+	MeteringFormat        string
+	MeteringDir           string
+	MeteringS3            S3Config
+	MeteringWebhookURL    string
+	MeteringWebhookSecret string
+
+	// How often submission transactions are checked for the block they were mined in
+	TxWatchInterval time.Duration
+	// TxWatchTimeout is how long a submission may stay unmined before the
+	// watcher marks it failed
+	TxWatchTimeout time.Duration
+
+	// UploadResumePasses is how many rounds of node selection segments the
+	// nodes turned away get (0 restarts failed uploads from scratch)
+	UploadResumePasses int
+
+	// Moderation sends new image and text uploads to an HTTP classifier
+	ModerationURL       string
+	ModerationToken     string
+	ModerationNotifyURL string
+	ModerationMaxBytes  int64
+	// Abuse reports: how many one client IP may file an hour, and root
+	// hashes blocked from the start besides those blocked on reports
+	AbuseReportRateLimit int
+	Blocklist            []string
+
+	// IntegrityNotifyURL receives a JSON report of every segment a storage
+	// node served that failed verification
+	IntegrityNotifyURL string
+
+	// HashWorkers is how many goroutines hash one large file (0 is
+	// GOMAXPROCS, which also caps it); HashReads bounds the segment reads
+	// in flight across all files being hashed
+	HashWorkers int
+	HashReads   int
+
+	// EncryptionMasterKey (32 bytes in hex) derives the keys of uploads
+	// encrypted at the gateway; EncryptUploads encrypts every upload with it
+	EncryptionMasterKey string
+	EncryptUploads      bool
+
+	// WebhookURL receives every tenant's webhook events, signed with
+	// WebhookSecret, which also signs per-upload callbacks by default
+	WebhookURL    string
+	WebhookSecret string
+
+	// RedisURL is shared by replicas for coordination (redis://[:password@]host:port[/db])
+	RedisURL string
+
+	// KVNodeRPC is a 0G KV node used to read KV streams
+	KVNodeRPC string
+	// App settings live in this KV stream and are cached for SettingsCacheTTL
+	SettingsStream   string
+	SettingsCacheTTL time.Duration
+
+	// Static site hosting: site name -> manifest root, hostname -> site name
+	Sites     map[string]string
+	SiteHosts map[string]string
+
+	// Tenant domains: hostname -> tenant, tenant -> branding headers
+	TenantHosts   map[string]string
+	TenantHeaders map[string]string
+
+	// Shadow mode mirrors every upload to a secondary network.
+	ShadowEvmRPC     string
+	ShadowIndexerRPC string
+	ShadowPrivateKey string
+	ShadowHistory    int
+	// ShadowConcurrency caps the mirrored uploads in flight; uploads
+	// finishing while it is reached are not mirrored
+	ShadowConcurrency int
+}
+
+func LoadConfig() *Config {
+	profile := resourceProfile(envString("RESOURCE_PROFILE", ResourceStandard))
+	cfg := &Config{
+		PrivateKey: os.Getenv("PRIVATE_KEY"),
+		UseTurbo:   envBool("USE_TURBO", true),
+		Port:       envString("PORT", "8080"),
+
+		TrustedProxies: parseList(os.Getenv("TRUSTED_PROXIES")),
+
+		ShutdownDrainTimeout: envDuration("SHUTDOWN_DRAIN_TIMEOUT", time.Minute),
+		ResourceProfile:      profile.Name,
+		TraceURLTemplate:     os.Getenv("TRACE_URL_TEMPLATE"),
+		LogFormat:            envString("LOG_FORMAT", LogText),
+		LogLevel:             envString("LOG_LEVEL", "info"),
+
+		OTLPEndpoint:       os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		OTLPTracesEndpoint: os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"),
+		OTLPHeaders:        parseKeyValueList(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")),
+		OTelServiceName:    envString("OTEL_SERVICE_NAME", "0g-storage-gateway"),
+
+		SponsoredUploads:     envBool("SPONSORED_UPLOADS", false),
+		SponsoredTenant:      envString("SPONSORED_TENANT", "sponsored"),
+		SponsoredMaxBytes:    int64(envInt("SPONSORED_MAX_BYTES", 1<<20)),
+		SponsoredTypes:       parseList(envString("SPONSORED_TYPES", "image/*,text/plain,application/json,application/pdf")),
+		SponsoredRateLimit:   envInt("SPONSORED_RATE_LIMIT", 10),
+		SponsoredDailyBudget: envString("SPONSORED_DAILY_BUDGET", "0.1"),
+
+		WalletBackend:              envString("WALLET_BACKEND", WalletRaw),
+		WalletKeystore:             os.Getenv("WALLET_KEYSTORE"),
+		WalletKeystorePasswordFile: os.Getenv("WALLET_KEYSTORE_PASSWORD_FILE"),
+		WalletKMS: KMSConfig{
+			KeyID:        os.Getenv("WALLET_KMS_KEY_ID"),
+			Region:       envString("WALLET_KMS_REGION", os.Getenv("AWS_REGION")),
+			Endpoint:     os.Getenv("WALLET_KMS_ENDPOINT"),
+			AccessKey:    envString("WALLET_KMS_ACCESS_KEY", os.Getenv("AWS_ACCESS_KEY_ID")),
+			SecretKey:    envString("WALLET_KMS_SECRET_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+			SessionToken: envString("WALLET_KMS_SESSION_TOKEN", os.Getenv("AWS_SESSION_TOKEN")),
+		},
+		WalletVault: VaultConfig{
+			Addr:       os.Getenv("VAULT_ADDR"),
+			Token:      os.Getenv("VAULT_TOKEN"),
+			Mount:      envString("WALLET_VAULT_MOUNT", "transit"),
+			Key:        os.Getenv("WALLET_VAULT_KEY"),
+			Ciphertext: os.Getenv("WALLET_VAULT_CIPHERTEXT"),
+		},
+		WalletLedgerPath: os.Getenv("WALLET_LEDGER_PATH"),
+		WalletPoolKeys:   parseList(os.Getenv("WALLET_POOL_KEYS")),
+		WalletLowBalance: envString("WALLET_LOW_BALANCE", "1"),
+
+		Network:       envString("NETWORK", ""),
+		NetworkConfig: envString("NETWORK_CONFIG", ""),
+
+		ServiceMode:           envString("SERVICE_MODE", string(ModeAll)),
+		CatalogReloadInterval: envDuration("CATALOG_RELOAD_INTERVAL", 5*time.Second),
+
+		MaxJSONUploadBytes: int64(envInt("MAX_JSON_UPLOAD_BYTES", 10<<20)),
+		MaxUploadBytes:     int64(envInt("MAX_UPLOAD_BYTES", 0)),
+
+		MultipartMemoryBytes: int64(envInt("MULTIPART_MEMORY_BYTES", int(profile.MultipartMemoryBytes))),
+		AsyncUploadWorkers:   envInt("ASYNC_UPLOAD_WORKERS", profile.AsyncUploadWorkers),
+
+		NodeAllowlist: parseList(os.Getenv("STORAGE_NODE_ALLOWLIST")),
+
+		StorageNodes:      parseList(os.Getenv("STORAGE_NODES")),
+		NodeProbeInterval: envDuration("NODE_PROBE_INTERVAL", time.Minute),
+		NodeSlowLatency:   envDuration("NODE_SLOW_LATENCY", defaultNodeSlowLatency),
+		NodeRetry: RetryPolicy{
+			Attempts:   envInt("NODE_RETRY_ATTEMPTS", defaultNodeRetry.Attempts),
+			Backoff:    envDuration("NODE_RETRY_BACKOFF", defaultNodeRetry.Backoff),
+			MaxBackoff: envDuration("NODE_RETRY_MAX_BACKOFF", defaultNodeRetry.MaxBackoff),
+		},
+
+		NodePoolTTL:      envDuration("NODE_POOL_TTL", time.Minute),
+		NodePoolMaxStale: envDuration("NODE_POOL_MAX_STALE", 10*time.Minute),
+
+		TenantClasses: parseTenantClasses(os.Getenv("TENANT_CLASSES")),
+		ClassLimits: map[RequestClass]ClassLimits{
+			ClassInteractive: {
+				Workers:    envInt("INTERACTIVE_WORKERS", profile.InteractiveWorkers),
+				RatePerSec: envInt("INTERACTIVE_RATE_LIMIT", 0),
+			},
+			ClassBatch: {
+				Workers:    envInt("BATCH_WORKERS", profile.BatchWorkers),
+				RatePerSec: envInt("BATCH_RATE_LIMIT", 10),
+			},
+		},
+
+		UploadBatchWindow: envDuration("UPLOAD_BATCH_WINDOW", 0),
+		UploadBatchMax:    envInt("UPLOAD_BATCH_MAX", 16),
+
+		MaxTransferConcurrency: envInt("MAX_TRANSFER_CONCURRENCY", profile.MaxTransferConcurrency),
+		MaxTaskSegments:        envInt("MAX_TASK_SEGMENTS", profile.MaxTaskSegments),
+		MaxUploadReplicas:      envInt("MAX_UPLOAD_REPLICAS", 3),
+
+		UploadTransforms: parseTransformList(os.Getenv("UPLOAD_TRANSFORMS")),
+		TenantTransforms: parseTenantTransforms(os.Getenv("TENANT_TRANSFORMS")),
+
+		UploadPolicy: os.Getenv("UPLOAD_POLICY"),
+		AccessPolicy: os.Getenv("ACCESS_POLICY"),
+
+		APIKeys: parseAPIKeys(os.Getenv("API_KEYS")),
+
+		DefaultQuotaBytes: int64(envInt("TENANT_QUOTA_BYTES", 0)),
+		TenantQuotas:      parseQuotas(os.Getenv("TENANT_QUOTAS")),
+
+		DailyUploadBytes:       int64(envInt("DAILY_UPLOAD_BYTES", 0)),
+		TenantDailyUploadBytes: parseQuotas(os.Getenv("TENANT_DAILY_UPLOAD_BYTES")),
+		TenantMaxFileBytes:     parseQuotas(os.Getenv("TENANT_MAX_FILE_BYTES")),
+		KeyRateLimit:           envInt("KEY_RATE_LIMIT", 0),
+		KeyRateBurst:           envInt("KEY_RATE_BURST", 0),
+
+		DataDir:     os.Getenv("DATA_DIR"),
+		CatalogPath: os.Getenv("CATALOG_PATH"),
+
+		CatalogBootstrapRoot: os.Getenv("CATALOG_BOOTSTRAP_ROOT"),
+		AutoMigrate:          envBool("AUTO_MIGRATE", true),
+
+		AdminToken: os.Getenv("ADMIN_TOKEN"),
+
+		UploadSessionSecret: os.Getenv("UPLOAD_SESSION_SECRET"),
+		SignedURLAudience:   os.Getenv("SIGNED_URL_AUDIENCE"),
+
+		ResumeTokenSecret: os.Getenv("RESUME_TOKEN_SECRET"),
+		StreamDownloads:   envBool("STREAM_DOWNLOADS", true),
+		PublicIDScheme:    envString("PUBLIC_ID_SCHEME", "root"),
+		MetadataStore:     envString("METADATA_STORE", ""),
+		MetadataDSN:       os.Getenv("METADATA_DSN"),
+
+		FeatureFlags:           os.Getenv("FEATURE_FLAGS"),
+		FeatureFlagTenants:     parseKeyValueList(os.Getenv("FEATURE_FLAG_TENANTS")),
+		FeatureFlagRollout:     parseKeyValueList(os.Getenv("FEATURE_FLAG_ROLLOUT")),
+		FeatureFlagTrustedKeys: parseList(os.Getenv("FEATURE_FLAG_TRUSTED_KEYS")),
+
+		SignResponses:      envBool("SIGN_RESPONSES", false),
+		ResponseSigningKey: os.Getenv("RESPONSE_SIGNING_KEY"),
+
+		OriginSecret:       os.Getenv("ORIGIN_SECRET"),
+		OriginSecretHeader: envString("ORIGIN_SECRET_HEADER", "X-Origin-Secret"),
+		CDNSiteTTL:         envDuration("CDN_SITE_TTL", 24*time.Hour),
+		CDNPurgeURL:        os.Getenv("CDN_PURGE_URL"),
+		CDNPurgeToken:      os.Getenv("CDN_PURGE_TOKEN"),
+
+		CacheDir:      os.Getenv("CACHE_DIR"),
+		CacheMaxBytes: int64(envInt("CACHE_MAX_BYTES", int(profile.CacheMaxBytes))),
+		SpoolDir:      envString("SPOOL_DIR", defaultSpoolDir()),
+		SpoolS3: S3Config{
+			Endpoint:  envString("SPOOL_S3_ENDPOINT", "https://s3.amazonaws.com"),
+			Region:    envString("SPOOL_S3_REGION", "us-east-1"),
+			Bucket:    os.Getenv("SPOOL_S3_BUCKET"),
+			Prefix:    envString("SPOOL_S3_PREFIX", "spool/"),
+			AccessKey: os.Getenv("SPOOL_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("SPOOL_S3_SECRET_KEY"),
+		},
+		GCInterval: envDuration("GC_INTERVAL", profile.GCInterval),
+
+		CacheColdDir: os.Getenv("CACHE_COLD_DIR"),
+		CacheColdS3: S3Config{
+			Endpoint:  envString("CACHE_COLD_S3_ENDPOINT", "https://s3.amazonaws.com"),
+			Region:    envString("CACHE_COLD_S3_REGION", "us-east-1"),
+			Bucket:    os.Getenv("CACHE_COLD_S3_BUCKET"),
+			Prefix:    envString("CACHE_COLD_S3_PREFIX", "cache/"),
+			AccessKey: os.Getenv("CACHE_COLD_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("CACHE_COLD_S3_SECRET_KEY"),
+		},
+		CacheColdMaxBytes: int64(envInt("CACHE_COLD_MAX_BYTES", 0)),
+		PeerGateways:      parseList(os.Getenv("PEER_GATEWAYS")),
+		PeerSecret:        os.Getenv("PEER_SECRET"),
+
+		PrefetchOnList:   envInt("PREFETCH_ON_LIST", 0),
+		PrefetchMaxBytes: int64(envInt("PREFETCH_MAX_BYTES", 16<<20)),
+		PrefetchQueue:    envInt("PREFETCH_QUEUE", 64),
+		PrefetchWorkers:  envInt("PREFETCH_WORKERS", 2),
+
+		LifecycleInterval: envDuration("LIFECYCLE_INTERVAL", time.Hour),
+
+		MeteringInterval: envDuration("METERING_INTERVAL", time.Hour),
+		MeteringFormat:   envString("METERING_FORMAT", MeteringCSV),
+		MeteringDir:      os.Getenv("METERING_DIR"),
+		MeteringS3: S3Config{
+			Endpoint:  envString("METERING_S3_ENDPOINT", "https://s3.amazonaws.com"),
+			Region:    envString("METERING_S3_REGION", "us-east-1"),
+			Bucket:    os.Getenv("METERING_S3_BUCKET"),
+			Prefix:    envString("METERING_S3_PREFIX", "metering/"),
+			AccessKey: os.Getenv("METERING_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("METERING_S3_SECRET_KEY"),
+		},
+		MeteringWebhookURL:    os.Getenv("METERING_WEBHOOK_URL"),
+		MeteringWebhookSecret: os.Getenv("METERING_WEBHOOK_SECRET"),
+
+		TxWatchInterval: envDuration("TX_WATCH_INTERVAL", 30*time.Second),
+		TxWatchTimeout:  envDuration("TX_WATCH_TIMEOUT", 24*time.Hour),
+
+		UploadResumePasses: envInt("UPLOAD_RESUME_PASSES", 3),
+
+		ModerationURL:       os.Getenv("MODERATION_URL"),
+		ModerationToken:     os.Getenv("MODERATION_TOKEN"),
+		ModerationNotifyURL: os.Getenv("MODERATION_NOTIFY_URL"),
+		ModerationMaxBytes:  int64(envInt("MODERATION_MAX_BYTES", 20<<20)),
+
+		AbuseReportRateLimit: envInt("ABUSE_REPORT_RATE_LIMIT", 10),
+		Blocklist:            parseList(os.Getenv("BLOCKLIST")),
+
+		IntegrityNotifyURL: os.Getenv("INTEGRITY_NOTIFY_URL"),
+
+		HashWorkers: envInt("HASH_WORKERS", profile.HashWorkers),
+		HashReads:   envInt("HASH_READS", profile.HashReads),
+
+		EncryptionMasterKey: os.Getenv("ENCRYPTION_MASTER_KEY"),
+		EncryptUploads:      envBool("ENCRYPT_UPLOADS", false),
+
+		WebhookURL:    os.Getenv("WEBHOOK_URL"),
+		WebhookSecret: os.Getenv("WEBHOOK_SECRET"),
+
+		RedisURL: os.Getenv("REDIS_URL"),
+
+		KVNodeRPC: os.Getenv("KV_NODE_RPC"),
+
+		SettingsStream:   envString("SETTINGS_KV_STREAM", "app-settings"),
+		SettingsCacheTTL: envDuration("SETTINGS_CACHE_TTL", 30*time.Second),
+
+		Sites:     parseKeyValueList(os.Getenv("SITES")),
+		SiteHosts: parseKeyValueList(os.Getenv("SITE_HOSTS")),
+
+		TenantHosts:   parseKeyValueList(os.Getenv("TENANT_HOSTS")),
+		TenantHeaders: parseKeyValueList(os.Getenv("TENANT_HEADERS")),
+
+		ShadowEvmRPC:     os.Getenv("SHADOW_EVM_RPC"),
+		ShadowIndexerRPC: os.Getenv("SHADOW_INDEXER_RPC"),
+		ShadowPrivateKey: os.Getenv("SHADOW_PRIVATE_KEY"),
+		ShadowHistory:    envInt("SHADOW_HISTORY", 100),
+		// Uploads finishing while this many are mirrored are not mirrored
+		ShadowConcurrency: envInt("SHADOW_CONCURRENCY", 2),
+	}
+	if cfg.ShadowEvmRPC == "" {
+		cfg.ShadowEvmRPC = EvmRPC
+	}
+	if cfg.ShadowPrivateKey == "" {
+		cfg.ShadowPrivateKey = cfg.PrivateKey
+	}
+	if cfg.ResponseSigningKey == "" {
+		cfg.ResponseSigningKey = cfg.PrivateKey
+	}
+	if cfg.CatalogPath == "" {
+		cfg.CatalogPath = cfg.DataPath("catalog.json")
+	}
+	if cfg.CacheDir == "" {
+		cfg.CacheDir = cfg.DataPath("cache")
+	}
+	if profile.InMemoryMetadata && (cfg.MetadataStore == "" || cfg.MetadataStore == "sqlite") && cfg.MetadataDSN == "" {
+		cfg.MetadataDSN = ":memory:"
+	}
+	return cfg
+}
+
+// S3Config locates an S3-compatible bucket. Bucket is empty when unused.
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+}
+
+// DataPath returns where a piece of local state named name is persisted, or ""
+// when no DATA_DIR is configured.
+func (c *Config) DataPath(name string) string {
+	if c.DataDir == "" {
+		return ""
+	}
+	return filepath.Join(c.DataDir, name)
+}
+
+// ShadowEnabled reports whether uploads should be mirrored to a secondary network.
+func (c *Config) ShadowEnabled() bool {
+	return c.ShadowIndexerRPC != ""
+}
+
+func envString(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+func envBool(key string, def bool) bool {
+	v, err := strconv.ParseBool(strings.TrimSpace(os.Getenv(key)))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func envInt(key string, def int) int {
+	v, err := strconv.Atoi(strings.TrimSpace(os.Getenv(key)))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// parseList reads comma-separated values, skipping empty ones.
+func parseList(raw string) []string {
+	var values []string
+	for _, v := range strings.Split(raw, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// parseKeyValueList reads "key=value" pairs separated by commas.
+func parseKeyValueList(raw string) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || key == "" {
+			continue
+		}
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return values
+}
+
+// parseQuotas reads "tenant=bytes" pairs separated by commas.
+func parseQuotas(raw string) map[string]int64 {
+	quotas := make(map[string]int64)
+	for tenant, v := range parseKeyValueList(raw) {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			quotas[tenant] = n
+		}
+	}
+	return quotas
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v, err := time.ParseDuration(strings.TrimSpace(os.Getenv(key)))
+	if err != nil {
+		return def
+	}
+	return v
+}
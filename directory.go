@@ -0,0 +1,275 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DirectoryUploadResponse describes an uploaded directory. Until the upload
+// has finished only JobID and Files are set.
+type DirectoryUploadResponse struct {
+	JobID        string `json:"job_id"`
+	ManifestRoot string `json:"manifest_root,omitempty"`
+	TxHash       string `json:"tx_hash,omitempty"`
+	Files        int    `json:"files"`
+	// Entries maps each relative path to the file's root hash
+	Entries map[string]ManifestEntry `json:"entries,omitempty"`
+}
+
+// readTarDirectory stages the regular files of a tar archive, keyed by their
+// path inside it. Directory entries are skipped; links and other special
+// files are refused.
+func (s *Server) readTarDirectory(r io.Reader) (members []publishMember, err error) {
+	defer func() {
+		if err != nil {
+			for _, m := range members {
+				s.spool.Release(m.LocalPath)
+			}
+			members = nil
+		}
+	}()
+
+	tr := tar.NewReader(r)
+	seen := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return members, nil
+		}
+		if err != nil {
+			return members, newAPIError(http.StatusBadRequest, "Invalid tar archive: %v", err)
+		}
+		mode := hdr.FileInfo().Mode()
+		if mode.IsDir() {
+			continue
+		}
+		if !mode.IsRegular() {
+			return members, newAPIError(http.StatusBadRequest, "%q is not a regular file", hdr.Name)
+		}
+
+		p, err := normalizeManifestPath(hdr.Name)
+		if err != nil {
+			return members, newAPIError(http.StatusBadRequest, "%v", err)
+		}
+		if seen[p] {
+			return members, newAPIError(http.StatusBadRequest, "duplicate path %q", p)
+		}
+		seen[p] = true
+		if len(members) == maxPublishFiles {
+			return members, newAPIError(http.StatusBadRequest, "At most %d files can be sent at once", maxPublishFiles)
+		}
+		if s.maxUploadBytes > 0 && hdr.Size > s.maxUploadBytes {
+			return members, uploadTooLarge(s.maxUploadBytes)
+		}
+
+		staged, err := s.spool.Stage(tr, "dir-*")
+		if err != nil {
+			return members, err
+		}
+		members = append(members, publishMember{Path: p, LocalPath: staged.Path, Filename: hdr.FileInfo().Name(), Size: staged.Size()})
+	}
+}
+
+// readFormDirectory stages the files of a multipart form, placed at their
+// paths values or, without them, at their file names.
+func (s *Server) readFormDirectory(c *gin.Context) ([]publishMember, error) {
+	form, err := s.readStreamedForm(c, maxPublishFiles)
+	if err != nil {
+		return nil, err
+	}
+	files := form.Files["files"]
+	paths := form.Values["paths"]
+	fail := func(err error) ([]publishMember, error) {
+		form.Release(s.spool)
+		return nil, err
+	}
+	if len(paths) > 0 && len(paths) != len(files) {
+		return fail(newAPIError(http.StatusBadRequest, "paths must have one value per file"))
+	}
+
+	members := make([]publishMember, 0, len(files))
+	seen := make(map[string]bool, len(files))
+	for i, file := range files {
+		name := file.Filename
+		if len(paths) > 0 {
+			name = paths[i]
+		}
+		p, err := normalizeManifestPath(name)
+		if err != nil {
+			return fail(newAPIError(http.StatusBadRequest, "%v", err))
+		}
+		if seen[p] {
+			return fail(newAPIError(http.StatusBadRequest, "duplicate path %q", p))
+		}
+		seen[p] = true
+		members = append(members, publishMember{Path: p, LocalPath: file.LocalPath, Filename: file.Filename, Size: file.Size})
+	}
+	// The members now own the files; other file fields are dropped
+	delete(form.Files, "files")
+	form.Release(s.spool)
+	return members, nil
+}
+
+// @Summary Upload a directory
+// @Description Uploads every file of a directory, then a manifest mapping their relative paths to root hashes, and returns the manifest root. Send the directory as a tar archive (Content-Type application/x-tar, or application/gzip for .tar.gz) or as a multipart form with repeated files fields and optional paths values, one per file. Files are fetched back with /download/dir/{manifest_root}/{path}.
+// @Description The upload runs as a background job. If it has not finished within timeout (default and maximum 2m), the response is 202 with the job ID to wait on.
+// @Accept application/x-tar
+// @Accept multipart/form-data
+// @Produce json
+// @Param files formData file false "Files of the directory (repeat the field)"
+// @Param paths formData []string false "Relative path of each file, in the same order" collectionFormat(multi)
+// @Param timeout query string false "How long to wait for the upload to finish, e.g. 60s"
+// @Success 200 {object} DirectoryUploadResponse
+// @Success 202 {object} DirectoryUploadResponse
+// @Security ApiKeyAuth
+// @Router /upload/dir [post]
+func (s *Server) handleUploadDirectory(c *gin.Context) {
+	timeout := maxJobWait
+	if raw := c.Query("timeout"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "timeout must be a non-negative duration such as 60s"})
+			return
+		}
+		if d < maxJobWait {
+			timeout = d
+		}
+	}
+
+	timer := s.stages.NewTimer(c.Request.Context())
+	start := time.Now()
+	var members []publishMember
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(c.ContentType())
+	switch mediaType {
+	case "multipart/form-data":
+		members, err = s.readFormDirectory(c)
+	case "application/x-tar", "application/tar":
+		body := io.Reader(c.Request.Body)
+		if c.GetHeader("Content-Encoding") == "gzip" {
+			var gz *gzip.Reader
+			if gz, err = gzip.NewReader(body); err != nil {
+				err = newAPIError(http.StatusBadRequest, "Invalid gzip body: %v", err)
+				break
+			}
+			body = gz
+		}
+		members, err = s.readTarDirectory(body)
+	case "application/gzip", "application/x-gzip", "application/x-gtar":
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(c.Request.Body); err != nil {
+			err = newAPIError(http.StatusBadRequest, "Invalid gzip body: %v", err)
+			break
+		}
+		members, err = s.readTarDirectory(gz)
+	default:
+		err = newAPIError(http.StatusUnsupportedMediaType, "Send a tar archive or a multipart/form-data body")
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if len(members) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No files provided"})
+		return
+	}
+	timer.Since(StageSpool, start)
+
+	tenant := tenantFrom(c)
+	job, err := s.jobs.Start(tenant, "upload_dir", traceFrom(c), func(ctx context.Context, job *JobHandle) (interface{}, error) {
+		return s.runPublish(ctx, job, publishPlan{
+			Tenant:  tenant,
+			Members: members,
+			Timer:   timer,
+		})
+	})
+	if err != nil {
+		for _, m := range members {
+			s.spool.Release(m.LocalPath)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := DirectoryUploadResponse{JobID: job.ID, Files: len(members)}
+	job, _ = s.jobs.Wait(c.Request.Context(), tenant, job.ID, timeout)
+	switch job.State {
+	case JobSucceeded:
+		var result PublishResult
+		if err := json.Unmarshal(job.Result, &result); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		resp.ManifestRoot = result.ManifestRoot
+		resp.TxHash = result.TxHash
+		if m, ok := s.manifests.get(result.ManifestRoot); ok {
+			resp.Entries = m.Files
+		}
+		c.JSON(http.StatusOK, resp)
+	case JobFailed:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": job.Error, "job_id": job.ID})
+	default:
+		c.JSON(http.StatusAccepted, resp)
+	}
+}
+
+// @Summary Download a file from an uploaded directory
+// @Description Serves the file at path inside the directory manifest, with the same range and resume support as /download/{root_hash}
+// @Produce octet-stream
+// @Param manifest_root path string true "Manifest root hash or public ID"
+// @Param path path string true "Relative path of the file"
+// @Success 200 {file} binary
+// @Security ApiKeyAuth
+// @Router /download/dir/{manifest_root}/{path} [get]
+func (s *Server) handleDownloadDirectoryFile(c *gin.Context) {
+	manifestRoot := s.resolveRef(c.Param("manifest_root"))
+	if s.withheld(c, manifestRoot, false) || s.outsideDomain(c, manifestRoot) {
+		return
+	}
+	p, err := normalizeManifestPath(c.Param("path"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	m, raw, err := s.loadManifest(manifestRoot)
+	if err == errNotManifest {
+		raw.Release()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Object is not a directory manifest"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to load manifest: %v", err)})
+		return
+	}
+	entry, ok := m.Lookup(p)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found in manifest"})
+		return
+	}
+	if s.withheld(c, entry.RootHash, false) {
+		return
+	}
+
+	obj, err := s.fetchObjectWith(requestClassFrom(c), transferTuningFrom(c), entry.RootHash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer obj.Release()
+
+	if contentType := contentTypeFor(p, entry.ContentType); contentType != "" {
+		c.Header("Content-Type", contentType)
+	}
+	setContentDisposition(c, p)
+	s.serveDownload(c, entry.RootHash, obj.Path)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const domainTenantContextKey = "domain_tenant"
+
+// TenantDomains maps hostnames to the tenants they serve. A request on a
+// tenant's domain only reaches content that tenant references, counts as
+// that tenant's, and carries the tenant's branding headers.
+type TenantDomains struct {
+	hosts   map[string]string
+	headers map[string]http.Header
+}
+
+// NewTenantDomains returns nil when no hostnames are mapped. headers holds
+// each tenant's branding headers as "Name: value" pairs separated by ";".
+func NewTenantDomains(hosts, headers map[string]string) *TenantDomains {
+	if len(hosts) == 0 {
+		return nil
+	}
+	d := &TenantDomains{hosts: make(map[string]string), headers: make(map[string]http.Header)}
+	for host, tenant := range hosts {
+		d.hosts[strings.ToLower(host)] = tenant
+	}
+	for tenant, raw := range headers {
+		h := make(http.Header)
+		for _, pair := range strings.Split(raw, ";") {
+			name, value, found := strings.Cut(pair, ":")
+			name = strings.TrimSpace(name)
+			if !found || name == "" {
+				if strings.TrimSpace(pair) != "" {
+					log.Printf("⚠️  TENANT_HEADERS: %s: ignoring %q", tenant, pair)
+				}
+				continue
+			}
+			h.Add(name, strings.TrimSpace(value))
+		}
+		d.headers[tenant] = h
+	}
+	return d
+}
+
+// TenantForHost returns the tenant a Host header belongs to.
+func (d *TenantDomains) TenantForHost(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	tenant, ok := d.hosts[strings.ToLower(host)]
+	return tenant, ok
+}
+
+// scopeTenantDomain marks requests on a tenant domain with the tenant and
+// adds its branding headers.
+func (s *Server) scopeTenantDomain(c *gin.Context) {
+	tenant, ok := s.domains.TenantForHost(c.Request.Host)
+	if !ok {
+		c.Next()
+		return
+	}
+	for name, values := range s.domains.headers[tenant] {
+		c.Writer.Header()[name] = values
+	}
+	c.Set(domainTenantContextKey, tenant)
+	c.Set(tenantContextKey, tenant)
+	c.Next()
+}
+
+func domainTenantFrom(c *gin.Context) (string, bool) {
+	tenant := c.GetString(domainTenantContextKey)
+	return tenant, tenant != ""
+}
+
+// onTenantDomain runs h only for requests on a tenant domain, so public
+// routes there are metered and limited as the tenant's.
+func (s *Server) onTenantDomain(h gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := domainTenantFrom(c); ok {
+			h(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireDomainTenant refuses API requests on a tenant domain made as
+// another tenant.
+func (s *Server) requireDomainTenant(c *gin.Context) {
+	if tenant, ok := domainTenantFrom(c); ok && tenantFrom(c) != tenant {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This domain only serves tenant " + tenant})
+		return
+	}
+	c.Next()
+}
+
+// outsideDomain answers 404 for content the domain's tenant does not
+// reference, so a tenant domain never serves anyone else's files.
+func (s *Server) outsideDomain(c *gin.Context, rootHash string) bool {
+	tenant, ok := domainTenantFrom(c)
+	if !ok || s.catalog.HasReference(tenant, strings.ToLower(rootHash)) {
+		return false
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+	return true
+}
+
+// ownerOffDomain is outsideDomain for links and sites, which have an owner.
+func ownerOffDomain(c *gin.Context, owner string) bool {
+	tenant, ok := domainTenantFrom(c)
+	if !ok || owner == tenant {
+		return false
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+	return true
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	EncryptionAlgorithm = "AES-256-GCM"
+	// Where the key of an encrypted file comes from
+	EncryptionKeyMaster = "master"
+	EncryptionKeyClient = "client"
+
+	encryptionKeyHeader = "X-Encryption-Key"
+	// Encrypted files start with encryptionMagic and the nonce prefix, and
+	// are then sealed in chunks of encryptionChunkSize plaintext bytes, so
+	// that files of any size are encrypted and decrypted as they stream
+	encryptionMagic       = "0GE1"
+	encryptionNonceBytes  = 8
+	encryptionHeaderBytes = len(encryptionMagic) + encryptionNonceBytes
+	encryptionChunkSize   = 64 << 10
+	// encryptionTagBytes is what GCM adds to every chunk
+	encryptionTagBytes = 16
+)
+
+// EncryptionInfo describes how a stored file was encrypted. It is kept in
+// the catalog and returned on upload; neither holds the key itself.
+type EncryptionInfo struct {
+	Algorithm string `json:"algorithm"`
+	// KeySource is master when the gateway derives the key, or client when
+	// the uploader's X-Encryption-Key must be sent again to download
+	KeySource string `json:"key_source"`
+	// KeyID is mixed into the key, so every file has its own
+	KeyID string `json:"key_id"`
+	// Nonce is the prefix of every chunk's nonce; chunks append their index
+	Nonce string `json:"nonce"`
+	// PlainSize is the size before encryption
+	PlainSize int64 `json:"plain_size"`
+}
+
+// UploadKey is the key an upload asked to be encrypted with: the master key,
+// or one the client supplied.
+type UploadKey struct {
+	Source string
+	// Key is the client's key; empty for the master key
+	Key []byte
+}
+
+// EncryptionSettings holds the master key, when one is configured, and
+// whether uploads are encrypted without asking.
+type EncryptionSettings struct {
+	master []byte
+	always bool
+}
+
+// NewEncryptionSettings reads the master key, 32 bytes in hex. always needs
+// a master key.
+func NewEncryptionSettings(masterHex string, always bool) (*EncryptionSettings, error) {
+	e := &EncryptionSettings{always: always}
+	if masterHex != "" {
+		key, err := hex.DecodeString(masterHex)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("ENCRYPTION_MASTER_KEY must be 32 bytes in hex")
+		}
+		e.master = key
+	}
+	if always && e.master == nil {
+		return nil, fmt.Errorf("ENCRYPT_UPLOADS needs ENCRYPTION_MASTER_KEY")
+	}
+	return e, nil
+}
+
+// Requested returns the key an upload is to be encrypted with, from the
+// X-Encryption-Key header (base64 of 32 bytes) or, with encrypt=true or
+// ENCRYPT_UPLOADS, the master key. It returns nil for a plain upload.
+func (e *EncryptionSettings) Requested(c *gin.Context) (*UploadKey, error) {
+	if raw := c.GetHeader(encryptionKeyHeader); raw != "" {
+		key, err := base64.StdEncoding.DecodeString(raw)
+		if err != nil || len(key) != 32 {
+			return nil, newAPIError(http.StatusBadRequest, "%s must be 32 bytes in base64", encryptionKeyHeader)
+		}
+		return &UploadKey{Source: EncryptionKeyClient, Key: key}, nil
+	}
+	if c.Query("encrypt") != "true" && !e.always {
+		return nil, nil
+	}
+	if e.master == nil {
+		return nil, newAPIError(http.StatusBadRequest, "Encryption needs a %s header; no master key is configured", encryptionKeyHeader)
+	}
+	return &UploadKey{Source: EncryptionKeyMaster}, nil
+}
+
+// deriveKey makes the key of one file from the master or client key and
+// the file's key ID.
+func deriveKey(base []byte, keyID string) []byte {
+	mac := hmac.New(sha256.New, base)
+	mac.Write([]byte("0g-storage-file-key/" + keyID))
+	return mac.Sum(nil)
+}
+
+// fileKey returns the key a file was encrypted with. Files encrypted with a
+// client key need that key in the request's X-Encryption-Key header.
+func (e *EncryptionSettings) fileKey(c *gin.Context, info *EncryptionInfo) ([]byte, error) {
+	if info.KeySource == EncryptionKeyMaster {
+		if e.master == nil {
+			return nil, newAPIError(http.StatusServiceUnavailable, "File is encrypted with a master key that is no longer configured")
+		}
+		return deriveKey(e.master, info.KeyID), nil
+	}
+	raw := c.GetHeader(encryptionKeyHeader)
+	if raw == "" {
+		return nil, newAPIError(http.StatusUnauthorized, "File is encrypted; send its key in %s", encryptionKeyHeader)
+	}
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil || len(key) != 32 {
+		return nil, newAPIError(http.StatusBadRequest, "%s must be 32 bytes in base64", encryptionKeyHeader)
+	}
+	return deriveKey(key, info.KeyID), nil
+}
+
+func newChunkCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce is the nonce of chunk index: the file's prefix and the index.
+func chunkNonce(prefix []byte, index uint32) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptionNonceBytes:], index)
+	return nonce
+}
+
+// chunkAAD marks the last chunk, so a truncated file does not decrypt.
+func chunkAAD(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encryptFile writes the encryption of size bytes read from r to w.
+func encryptFile(w io.Writer, r io.Reader, size int64, key, prefix []byte) error {
+	aead, err := newChunkCipher(key)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append([]byte(encryptionMagic), prefix...)); err != nil {
+		return err
+	}
+	chunks := encryptedChunks(size)
+	buf := make([]byte, encryptionChunkSize)
+	sealed := make([]byte, 0, encryptionChunkSize+aead.Overhead())
+	for i := int64(0); i < chunks; i++ {
+		n := encryptionChunkSize
+		if rest := size - i*encryptionChunkSize; rest < int64(n) {
+			n = int(rest)
+		}
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return err
+		}
+		sealed = aead.Seal(sealed[:0], chunkNonce(prefix, uint32(i)), buf[:n], chunkAAD(i == chunks-1))
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errDecrypt = errors.New("wrong key or damaged file")
+
+// decryptFile writes the plaintext of an encrypted file of size bytes read
+// from r to w.
+func decryptFile(w io.Writer, r io.Reader, size int64, key []byte) error {
+	aead, err := newChunkCipher(key)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, encryptionHeaderBytes)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return fmt.Errorf("not an encrypted file")
+	}
+	prefix := header[len(encryptionMagic):]
+
+	sealedChunk := int64(encryptionChunkSize + aead.Overhead())
+	body := size - int64(encryptionHeaderBytes)
+	chunks := (body + sealedChunk - 1) / sealedChunk
+	buf := make([]byte, sealedChunk)
+	plain := make([]byte, 0, encryptionChunkSize)
+	for i := int64(0); i < chunks; i++ {
+		n := sealedChunk
+		if rest := body - i*sealedChunk; rest < n {
+			n = rest
+		}
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return err
+		}
+		plain, err = aead.Open(plain[:0], chunkNonce(prefix, uint32(i)), buf[:n], chunkAAD(i == chunks-1))
+		if err != nil {
+			return errDecrypt
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encryptUpload encrypts a staged file into a new spool file the caller
+// releases, under a fresh key ID and nonce.
+func (s *Server) encryptUpload(path string, key *UploadKey) (string, int64, *EncryptionInfo, error) {
+	keyID, err := randomHex(16)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	prefix := make([]byte, encryptionNonceBytes)
+	if _, err := rand.Read(prefix); err != nil {
+		return "", 0, nil, err
+	}
+	base := key.Key
+	if key.Source == EncryptionKeyMaster {
+		base = s.encryption.master
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	out, err := s.spool.Through(&Staged{Path: path}, "encrypt-*", func(r io.Reader, w io.Writer) error {
+		return encryptFile(w, r, info.Size(), deriveKey(base, keyID), prefix)
+	})
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("failed to encrypt upload: %v", err)
+	}
+	sealed := int64(encryptionHeaderBytes) + info.Size() + encryptedChunks(info.Size())*encryptionTagBytes
+	return out.Path, sealed, &EncryptionInfo{
+		Algorithm: EncryptionAlgorithm,
+		KeySource: key.Source,
+		KeyID:     keyID,
+		Nonce:     hex.EncodeToString(prefix),
+		PlainSize: info.Size(),
+	}, nil
+}
+
+// encryptedChunks is how many chunks a file of size bytes is sealed in.
+func encryptedChunks(size int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + encryptionChunkSize - 1) / encryptionChunkSize
+}
+
+// encryptionOf returns how the tenant's file was encrypted, or nil.
+func (s *Server) encryptionOf(tenant, rootHash string) *EncryptionInfo {
+	rec, ok := s.catalog.Reference(tenant, rootHash)
+	if !ok {
+		return nil
+	}
+	return rec.Encryption
+}
+
+// serveDecrypted downloads an encrypted file and serves its plaintext.
+func (s *Server) serveDecrypted(c *gin.Context, rootHash string, info *EncryptionInfo) {
+	key, err := s.encryption.fileKey(c, info)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	obj, err := s.fetchObjectWith(requestClassFrom(c), transferTuningFrom(c), rootHash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer obj.Release()
+
+	out, err := s.spool.Through(obj, "decrypt-*", func(r io.Reader, w io.Writer) error {
+		return decryptFile(w, r, obj.Size(), key)
+	})
+	if err == errDecrypt && info.KeySource == EncryptionKeyClient {
+		respondError(c, newAPIError(http.StatusBadRequest, "Failed to decrypt: %v", err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt: " + err.Error()})
+		return
+	}
+	defer out.Release()
+	s.serveDownload(c, rootHash, out.Path)
+}
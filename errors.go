@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiError carries the HTTP status a failure should be reported with.
+type apiError struct {
+	Status  int
+	Message string
+	// RetryAfter, when set, is sent as Retry-After
+	RetryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func newAPIError(status int, format string, args ...interface{}) error {
+	return &apiError{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// respondError writes err as a JSON error, using the status of an apiError
+// and 500 for anything else.
+func respondError(c *gin.Context, err error) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		if apiErr.RetryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(apiErr.RetryAfter)))
+		}
+		c.JSON(apiErr.Status, gin.H{"error": apiErr.Message})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
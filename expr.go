@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+)
+
+// exprEnv declares the variables access policy expressions see: subject and
+// resource are maps of attributes, action is a string.
+var exprEnv = mustExprEnv()
+
+func mustExprEnv() *cel.Env {
+	env, err := cel.NewEnv(
+		cel.Variable("subject", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("action", cel.StringType),
+		cel.Variable("resource", cel.MapType(cel.StringType, cel.DynType)),
+		// Sizes are ints from requests but doubles from JSON in explain
+		cel.CrossTypeNumericComparisons(true),
+	)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create the policy expression environment: %v", err))
+	}
+	return env
+}
+
+// Expr is a compiled CEL policy expression.
+type Expr struct {
+	source  string
+	program cel.Program
+}
+
+// CompileExpr parses and checks source once so it can be evaluated per
+// request. The expression must yield a boolean.
+func CompileExpr(source string) (*Expr, error) {
+	ast, issues := exprEnv.Compile(source)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if !ast.OutputType().IsExactType(cel.BoolType) && !ast.OutputType().IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("expression yields %s, not a boolean", ast.OutputType())
+	}
+	program, err := exprEnv.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+	return &Expr{source: source, program: program}, nil
+}
+
+// Bool evaluates the expression against vars; it must yield a boolean.
+func (e *Expr) Bool(vars map[string]interface{}) (bool, error) {
+	out, _, err := e.program.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expression yields %s, not a boolean", out.Type())
+	}
+	return b, nil
+}
+
+func (e *Expr) String() string {
+	return e.source
+}
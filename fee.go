@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0glabs/0g-storage-client/core"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+	"github.com/openweb3/web3go/types"
+)
+
+const (
+	// defaultSubmitGas is assumed for a submission until one has been mined
+	// and the gas it used observed.
+	defaultSubmitGas = 300000
+	// sectorPriceTTL is how long a price read from the market is reused
+	sectorPriceTTL = time.Minute
+)
+
+var (
+	marketSelector         = crypto.Keccak256([]byte("market()"))[:4]
+	pricePerSectorSelector = crypto.Keccak256([]byte("pricePerSector()"))[:4]
+	weiPerToken            = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+)
+
+// FeeEstimate is what storing a file of Size bytes would cost. Amounts are
+// given in wei and in 0G tokens.
+type FeeEstimate struct {
+	Size int64 `json:"size"`
+	// PaddedSize is what the flow contract charges for: the file padded
+	// the way its submission is, in 256-byte sectors
+	PaddedSize int64  `json:"padded_size"`
+	Sectors    uint64 `json:"sectors"`
+	Segments   uint64 `json:"segments"`
+
+	PricePerSectorWei string `json:"price_per_sector_wei"`
+	StorageFeeWei     string `json:"storage_fee_wei"`
+	StorageFee        string `json:"storage_fee"`
+	// Gas is what recent submissions used, or a typical amount before any
+	Gas         uint64 `json:"gas"`
+	GasPriceWei string `json:"gas_price_wei"`
+	GasCostWei  string `json:"gas_cost_wei"`
+	GasCost     string `json:"gas_cost"`
+	TotalWei    string `json:"total_wei"`
+	Total       string `json:"total"`
+}
+
+// submitGasAverage is a moving average of the gas submissions used.
+type submitGasAverage struct {
+	mu  sync.Mutex
+	gas uint64
+}
+
+func (a *submitGasAverage) Observe(gas uint64) {
+	if gas == 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.gas == 0 {
+		a.gas = gas
+		return
+	}
+	a.gas = (a.gas*7 + gas) / 8
+}
+
+func (a *submitGasAverage) Get() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.gas == 0 {
+		return defaultSubmitGas
+	}
+	return a.gas
+}
+
+// sectorPrice keeps the market's price per sector for a short while, since
+// every upload checks it.
+type sectorPrice struct {
+	mu    sync.Mutex
+	price *big.Int
+	at    time.Time
+}
+
+// formatTokens renders wei as a decimal amount of 0G.
+func formatTokens(wei *big.Int) string {
+	s := new(big.Rat).SetFrac(wei, weiPerToken).FloatString(18)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// parseTokens reads a decimal amount of 0G, such as "0.5", in wei.
+func parseTokens(raw string) (*big.Int, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(raw))
+	if !ok || r.Sign() < 0 {
+		return nil, fmt.Errorf("invalid amount %q", raw)
+	}
+	r.Mul(r, new(big.Rat).SetInt(weiPerToken))
+	return new(big.Int).Quo(r.Num(), r.Denom()), nil
+}
+
+// callUint reads a contract view function without arguments that returns
+// a single word.
+func (c *StorageClient) callUint(to common.Address, selector []byte) (*big.Int, error) {
+	out, err := c.web3Client.Eth.Call(types.CallRequest{To: &to, Data: selector}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) < 32 {
+		return nil, fmt.Errorf("unexpected result %x", out)
+	}
+	return new(big.Int).SetBytes(out[:32]), nil
+}
+
+// pricePerSector asks the flow contract's market what one 256-byte sector
+// costs to store. The answer is reused for sectorPriceTTL.
+func (c *StorageClient) pricePerSector(ctx context.Context) (*big.Int, error) {
+	c.sectorPrice.mu.Lock()
+	defer c.sectorPrice.mu.Unlock()
+	if c.sectorPrice.price != nil && time.Since(c.sectorPrice.at) < sectorPriceTTL {
+		return c.sectorPrice.price, nil
+	}
+
+	nodes, err := c.selectNodes()
+	if err != nil {
+		return nil, err
+	}
+	status, err := nodes[0].GetStatus(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node status: %v", err)
+	}
+	market, err := c.callUint(status.NetworkIdentity.FlowContractAddress, marketSelector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get market contract: %v", err)
+	}
+	price, err := c.callUint(common.BigToAddress(market), pricePerSectorSelector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get price per sector: %v", err)
+	}
+	c.sectorPrice.price, c.sectorPrice.at = price, time.Now()
+	return price, nil
+}
+
+// EstimateFee prices storing size bytes at the current sector and gas
+// prices.
+func (c *StorageClient) EstimateFee(ctx context.Context, size int64) (FeeEstimate, error) {
+	chunks := core.NumSplits(size, core.DefaultChunkSize)
+	sectors, _ := core.ComputePaddedSize(chunks)
+	est := FeeEstimate{
+		Size:       size,
+		PaddedSize: int64(sectors) * core.DefaultChunkSize,
+		Sectors:    sectors,
+		Segments:   (sectors-1)/core.DefaultSegmentMaxChunks + 1,
+		Gas:        c.submitGas.Get(),
+	}
+
+	price, err := c.pricePerSector(ctx)
+	if err != nil {
+		return FeeEstimate{}, err
+	}
+	gasPrice, err := c.web3Client.Eth.GasPrice()
+	if err != nil {
+		return FeeEstimate{}, fmt.Errorf("failed to get gas price: %v", err)
+	}
+	storageFee := new(big.Int).Mul(price, new(big.Int).SetUint64(sectors))
+	gasCost := new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(est.Gas))
+	total := new(big.Int).Add(storageFee, gasCost)
+
+	est.PricePerSectorWei = price.String()
+	est.StorageFeeWei = storageFee.String()
+	est.StorageFee = formatTokens(storageFee)
+	est.GasPriceWei = gasPrice.String()
+	est.GasCostWei = gasCost.String()
+	est.GasCost = formatTokens(gasCost)
+	est.TotalWei = total.String()
+	est.Total = formatTokens(total)
+	return est, nil
+}
+
+type EstimateRequest struct {
+	Size int64 `json:"size"`
+}
+
+// @Summary Estimate the cost of an upload
+// @Description Prices storing a file before it is uploaded: the storage fee the flow contract charges for its padded size, the gas of the submission at the current gas price, and the total, in wei and in 0G. Send {"size": n} as JSON, or the file itself as multipart form field "file" (it is only counted, not stored).
+// @Accept json,mpfd
+// @Produce json
+// @Param request body EstimateRequest false "File size in bytes"
+// @Param file formData file false "File to price"
+// @Success 200 {object} FeeEstimate
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /estimate [post]
+func (s *Server) handleEstimate(c *gin.Context) {
+	var size int64
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		n, err := multipartFileSize(c.Request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		size = n
+	} else {
+		var req EstimateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+			return
+		}
+		size = req.Size
+	}
+	if size <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "size must be positive"})
+		return
+	}
+	if s.maxUploadBytes > 0 && size > s.maxUploadBytes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Files over %d bytes are not accepted", s.maxUploadBytes)})
+		return
+	}
+
+	est, err := s.client.EstimateFee(c.Request.Context(), size)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, est)
+}
+
+// multipartFileSize counts the bytes of the "file" field of a multipart
+// request without keeping them.
+func multipartFileSize(r *http.Request) (int64, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return 0, fmt.Errorf("invalid multipart request: %v", err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return 0, fmt.Errorf("file is required")
+		}
+		if err != nil {
+			return 0, fmt.Errorf("invalid multipart request: %v", err)
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+		n, err := io.Copy(io.Discard, part)
+		part.Close()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read file: %v", err)
+		}
+		return n, nil
+	}
+}
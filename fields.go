@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Envelope wraps a response when ?envelope=true is given, so clients get the
+// item count without walking the payload.
+type Envelope struct {
+	Data  interface{} `json:"data"`
+	Count int         `json:"count"`
+}
+
+// respondSelected writes v as JSON, trimmed to the top-level fields named in
+// ?fields= (comma separated) and wrapped in an Envelope on ?envelope=true.
+// Lists are trimmed item by item.
+func respondSelected(c *gin.Context, status int, v interface{}) {
+	fields := selectedFields(c.Query("fields"))
+	envelope := c.Query("envelope") == "true" || c.Query("envelope") == "1"
+	if fields == nil && !envelope {
+		c.JSON(status, v)
+		return
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Numbers stay json.Number, so int64 values such as sizes and block
+	// numbers above 2^53 keep their precision
+	var generic interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&generic); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	count := 1
+	switch value := generic.(type) {
+	case []interface{}:
+		count = len(value)
+		for i, item := range value {
+			value[i] = trimFields(item, fields)
+		}
+	default:
+		generic = trimFields(generic, fields)
+	}
+
+	if envelope {
+		c.JSON(status, Envelope{Data: generic, Count: count})
+		return
+	}
+	c.JSON(status, generic)
+}
+
+func selectedFields(raw string) map[string]bool {
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	fields := make(map[string]bool)
+	for _, f := range strings.Split(raw, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields[f] = true
+		}
+	}
+	return fields
+}
+
+func trimFields(v interface{}, fields map[string]bool) interface{} {
+	obj, ok := v.(map[string]interface{})
+	if !ok || fields == nil {
+		return v
+	}
+	for key := range obj {
+		if !fields[key] {
+			delete(obj, key)
+		}
+	}
+	return obj
+}
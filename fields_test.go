@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRespondSelectedKeepsLargeIntegers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/?fields=size&envelope=true", nil)
+
+	respondSelected(c, http.StatusOK, struct {
+		Size int64  `json:"size"`
+		Name string `json:"name"`
+	}{Size: 1<<53 + 1, Name: "big.bin"})
+
+	want := `{"data":{"size":9007199254740993},"count":1}`
+	if got := strings.TrimSpace(w.Body.String()); got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0glabs/0g-storage-client/node"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+// Root hashes accepted by one bulk info request, and how many are looked up at once.
+const (
+	maxBulkInfo         = 100
+	bulkInfoConcurrency = 8
+)
+
+type BulkInfoRequest struct {
+	RootHashes []string `json:"root_hashes" binding:"required"`
+}
+
+type FileInfoResult struct {
+	RootHash  string `json:"root_hash"`
+	Available bool   `json:"available"`
+	Size      uint64 `json:"size,omitempty"`
+	Finalized bool   `json:"finalized"`
+	Error     string `json:"error,omitempty"`
+}
+
+// FileStatus is what the storage nodes know of a file.
+type FileStatus struct {
+	RootHash string `json:"root_hash"`
+	// Available is set when at least one node knows the file, and
+	// Finalized once one holds all of it and it can be downloaded
+	Available bool             `json:"available"`
+	Finalized bool             `json:"finalized"`
+	Size      uint64           `json:"size,omitempty"`
+	TxSeq     uint64           `json:"tx_seq,omitempty"`
+	Segments  uint64           `json:"segments,omitempty"`
+	Nodes     []NodeFileStatus `json:"nodes"`
+}
+
+type NodeFileStatus struct {
+	URL              string `json:"url"`
+	Known            bool   `json:"known"`
+	SegmentsUploaded uint64 `json:"segments_uploaded"`
+	Finalized        bool   `json:"finalized"`
+	Pruned           bool   `json:"pruned,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+type DeleteFileResponse struct {
+	RootHash          string `json:"root_hash"`
+	RemainingRefCount int    `json:"remaining_ref_count"`
+}
+
+// @Summary List the caller's files
+// @Description Files referenced by the caller, oldest first. from_block and to_block (inclusive) keep only files whose submission was mined in that range; files whose block is not known yet are left out when either is given. filename and content_type filter further, and limit and offset page through the result. GET /files/history lists every upload instead.
+// @Produce json
+// @Param from_block query int false "First block"
+// @Param to_block query int false "Last block"
+// @Param filename query string false "Substring of the filename (case-insensitive)"
+// @Param content_type query string false "Content type prefix, e.g. image/"
+// @Param limit query int false "Maximum files (default all)"
+// @Param offset query int false "Files to skip"
+// @Param fields query string false "Comma-separated fields to return"
+// @Param envelope query bool false "Wrap the response in {data, count}"
+// @Success 200 {array} FileRecord
+// @Security ApiKeyAuth
+// @Router /files [get]
+func (s *Server) handleListFiles(c *gin.Context) {
+	from, err := blockParam(c, "from_block")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	to, err := blockParam(c, "to_block")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil || limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a non-negative number"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative number"})
+		return
+	}
+	filename := strings.ToLower(c.Query("filename"))
+	contentType := c.Query("content_type")
+
+	files := s.catalog.Files(tenantFrom(c))
+	if from != nil || to != nil || filename != "" || contentType != "" {
+		matched := []FileRecord{}
+		for _, rec := range files {
+			if (from != nil || to != nil) && (rec.BlockNumber == 0 || (from != nil && rec.BlockNumber < *from) || (to != nil && rec.BlockNumber > *to)) {
+				continue
+			}
+			if !strings.Contains(strings.ToLower(rec.Filename), filename) || !strings.HasPrefix(rec.ContentType, contentType) {
+				continue
+			}
+			matched = append(matched, rec)
+		}
+		files = matched
+	}
+	if offset > len(files) {
+		offset = len(files)
+	}
+	files = files[offset:]
+	if limit > 0 && limit < len(files) {
+		files = files[:limit]
+	}
+	s.hint(files)
+	respondSelected(c, http.StatusOK, files)
+}
+
+func blockParam(c *gin.Context, name string) (*uint64, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return nil, nil
+	}
+	block, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", name, raw)
+	}
+	return &block, nil
+}
+
+// @Summary Look up several files at once
+// @Description Reports availability, size and finality on the storage nodes for up to 100 root hashes, looked up concurrently. Results are in request order.
+// @Accept json
+// @Produce json
+// @Param request body BulkInfoRequest true "Root hashes"
+// @Param fields query string false "Comma-separated fields to return"
+// @Param envelope query bool false "Wrap the response in {data, count}"
+// @Success 200 {array} FileInfoResult
+// @Failure 400 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /files/info [post]
+func (s *Server) handleBulkFileInfo(c *gin.Context) {
+	var req BulkInfoRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.RootHashes) > maxBulkInfo {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d root hashes per request", maxBulkInfo)})
+		return
+	}
+	for i, root := range req.RootHashes {
+		root = strings.ToLower(root)
+		if !isRootHash(root) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("root_hashes[%d] is not a valid root hash: %q", i, req.RootHashes[i])})
+			return
+		}
+		req.RootHashes[i] = root
+	}
+
+	nodes, err := s.client.selectNodes()
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Minute)
+	defer cancel()
+
+	results := make([]FileInfoResult, len(req.RootHashes))
+	sem := make(chan struct{}, bulkInfoConcurrency)
+	var wg sync.WaitGroup
+	for i, root := range req.RootHashes {
+		wg.Add(1)
+		go func(i int, root string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result := FileInfoResult{RootHash: root}
+			info, err := s.client.FileInfo(ctx, nodes, root)
+			switch {
+			case err != nil:
+				result.Error = err.Error()
+			case info != nil:
+				result.Available = true
+				result.Size = info.Tx.Size
+				result.Finalized = info.Finalized
+			}
+			results[i] = result
+		}(i, root)
+	}
+	wg.Wait()
+
+	respondSelected(c, http.StatusOK, results)
+}
+
+// @Summary File availability and finality
+// @Description Asks each selected storage node what it knows of a file: whether its submission has reached the node, how many segments it holds, and whether it is finalized. Check it before downloading: a file is only downloadable once finalized is true. Answers 502 when no node could be asked.
+// @Produce json
+// @Param root_hash path string true "Root hash or public ID of the file"
+// @Success 200 {object} FileStatus
+// @Failure 400 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /files/{root_hash}/status [get]
+func (s *Server) handleFileStatus(c *gin.Context) {
+	rootHash := s.resolveRef(c.Param("root_hash"))
+	if !isRootHash(rootHash) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid root hash"})
+		return
+	}
+	nodes, err := s.client.selectNodes()
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	status := FileStatus{RootHash: rootHash, Nodes: make([]NodeFileStatus, len(nodes))}
+	txs := make([]node.Transaction, len(nodes))
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n *node.ZgsClient) {
+			defer wg.Done()
+			ns := NodeFileStatus{URL: n.URL()}
+			info, err := n.GetFileInfo(ctx, common.HexToHash(rootHash), true)
+			switch {
+			case err != nil:
+				ns.Error = err.Error()
+			case info != nil:
+				ns.Known = true
+				ns.SegmentsUploaded = info.UploadedSegNum
+				ns.Finalized = info.Finalized
+				ns.Pruned = info.Pruned
+				txs[i] = info.Tx
+			}
+			status.Nodes[i] = ns
+		}(i, n)
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, ns := range status.Nodes {
+		if ns.Error != "" {
+			failed++
+		}
+		if ns.Known {
+			status.Available = true
+			status.Size, status.TxSeq = txs[i].Size, txs[i].Seq
+		}
+	}
+	segments := (status.Size + segmentSize - 1) / segmentSize
+	for i, ns := range status.Nodes {
+		status.Finalized = status.Finalized || ns.Finalized
+		if ns.Finalized {
+			status.Nodes[i].SegmentsUploaded = segments
+		}
+	}
+	if failed == len(nodes) {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "No storage node could be asked: " + status.Nodes[0].Error})
+		return
+	}
+	status.Segments = segments
+	c.JSON(http.StatusOK, status)
+}
+
+// @Summary Delete a file reference
+// @Description Removes the caller's reference to a file. Content on 0G is immutable; shared content stays available to other tenants that still reference it.
+// @Produce json
+// @Param root_hash path string true "Root hash of the file"
+// @Success 200 {object} DeleteFileResponse
+// @Security ApiKeyAuth
+// @Router /files/{root_hash} [delete]
+func (s *Server) handleDeleteFile(c *gin.Context) {
+	rootHash := c.Param("root_hash")
+
+	remaining, err := s.catalog.RemoveReference(tenantFrom(c), rootHash)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, DeleteFileResponse{
+		RootHash:          rootHash,
+		RemainingRefCount: remaining,
+	})
+}
+
+// @Summary Storage usage of the caller
+// @Description Files and bytes referenced by the calling tenant. Deduplicated content counts in full for every tenant that references it.
+// @Produce json
+// @Param fields query string false "Comma-separated fields to return"
+// @Param envelope query bool false "Wrap the response in {data, count}"
+// @Success 200 {object} TenantUsage
+// @Security ApiKeyAuth
+// @Router /usage [get]
+func (s *Server) handleUsage(c *gin.Context) {
+	respondSelected(c, http.StatusOK, s.catalog.Usage(tenantFrom(c)))
+}
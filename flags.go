@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Feature flags gating behaviors that are still being rolled out.
+const (
+	FlagStreamingDownload = "streaming_download"
+	FlagBatching          = "batching"
+	FlagAsyncUpload       = "async_upload"
+
+	featureFlagsHeader     = "X-Feature-Flags"
+	featureFlagsContextKey = "feature_flags"
+)
+
+// FlagSet holds whether each feature flag is on for one request.
+type FlagSet map[string]bool
+
+func (f FlagSet) String() string {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		state := "off"
+		if f[name] {
+			state = "on"
+		}
+		pairs[i] = name + "=" + state
+	}
+	return strings.Join(pairs, ",")
+}
+
+// FeatureFlags decides which flags are on for a request. A flag is on for a
+// tenant listed for it, otherwise for the given percentage of tenants,
+// otherwise by its default. Requests made with a trusted API key can
+// override any flag with the X-Feature-Flags header.
+type FeatureFlags struct {
+	defaults map[string]bool
+	// tenants lists, per flag, the tenants it is turned on or off for
+	tenants map[string]map[string]bool
+	// rollout is, per flag, the percentage of tenants it is on for
+	rollout map[string]int
+	trusted map[string]bool
+}
+
+// NewFeatureFlags reads the flag configuration. streaming_download defaults
+// to STREAM_DOWNLOADS; the other flags default to on.
+func NewFeatureFlags(cfg *Config) (*FeatureFlags, error) {
+	f := &FeatureFlags{
+		defaults: map[string]bool{
+			FlagStreamingDownload: cfg.StreamDownloads,
+			FlagBatching:          true,
+			FlagAsyncUpload:       true,
+		},
+		tenants: make(map[string]map[string]bool),
+		rollout: make(map[string]int),
+		trusted: make(map[string]bool),
+	}
+	overrides, err := f.parse(cfg.FeatureFlags)
+	if err != nil {
+		return nil, fmt.Errorf("FEATURE_FLAGS: %v", err)
+	}
+	for name, on := range overrides {
+		f.defaults[name] = on
+	}
+
+	for name, raw := range cfg.FeatureFlagTenants {
+		if _, ok := f.defaults[name]; !ok {
+			return nil, fmt.Errorf("FEATURE_FLAG_TENANTS: unknown flag %q", name)
+		}
+		f.tenants[name] = make(map[string]bool)
+		for _, tenant := range strings.Split(raw, "|") {
+			tenant = strings.TrimSpace(tenant)
+			if off := strings.TrimPrefix(tenant, "!"); off != tenant {
+				f.tenants[name][off] = false
+			} else if tenant != "" {
+				f.tenants[name][tenant] = true
+			}
+		}
+	}
+	for name, raw := range cfg.FeatureFlagRollout {
+		if _, ok := f.defaults[name]; !ok {
+			return nil, fmt.Errorf("FEATURE_FLAG_ROLLOUT: unknown flag %q", name)
+		}
+		percent, err := strconv.Atoi(raw)
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("FEATURE_FLAG_ROLLOUT: %s must be a percentage, got %q", name, raw)
+		}
+		f.rollout[name] = percent
+	}
+	for _, id := range cfg.FeatureFlagTrustedKeys {
+		f.trusted[id] = true
+	}
+	return f, nil
+}
+
+// parse reads "flag=on,flag=off" pairs.
+func (f *FeatureFlags) parse(raw string) (FlagSet, error) {
+	set := make(FlagSet)
+	for _, pair := range parseList(raw) {
+		name, value, _ := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if _, ok := f.defaults[name]; !ok {
+			return nil, fmt.Errorf("unknown flag %q", name)
+		}
+		switch strings.TrimSpace(value) {
+		case "on", "true", "1":
+			set[name] = true
+		case "off", "false", "0":
+			set[name] = false
+		default:
+			return nil, fmt.Errorf("%s must be on or off", name)
+		}
+	}
+	return set, nil
+}
+
+// inRollout places tenant in one of 100 buckets, the same for every flag,
+// so that raising a percentage only ever adds tenants.
+func inRollout(tenant string, percent int) bool {
+	h := fnv.New32a()
+	h.Write([]byte(tenant))
+	return int(h.Sum32()%100) < percent
+}
+
+// Resolve returns the flags of a request by tenant, made with keyID. header
+// is honored for trusted keys only.
+func (f *FeatureFlags) Resolve(tenant, keyID, header string) (FlagSet, error) {
+	set := make(FlagSet, len(f.defaults))
+	for name, on := range f.defaults {
+		if listed, ok := f.tenants[name][tenant]; ok {
+			on = listed
+		} else if percent, ok := f.rollout[name]; ok {
+			on = inRollout(tenant, percent)
+		}
+		set[name] = on
+	}
+	if header == "" {
+		return set, nil
+	}
+	if !f.trusted[keyID] {
+		return nil, newAPIError(http.StatusForbidden, "%s is only accepted from trusted API keys", featureFlagsHeader)
+	}
+	overrides, err := f.parse(header)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, "%s: %v", featureFlagsHeader, err)
+	}
+	for name, on := range overrides {
+		set[name] = on
+	}
+	return set, nil
+}
+
+// On reports whether flag is on in set, falling back to its default for
+// work done outside a request.
+func (f *FeatureFlags) On(set FlagSet, flag string) bool {
+	if on, ok := set[flag]; ok {
+		return on
+	}
+	return f.defaults[flag]
+}
+
+// featureEnabled reports whether flag is on for the request.
+func (s *Server) featureEnabled(c *gin.Context, flag string) bool {
+	return s.flags.On(featureFlagsFrom(c), flag)
+}
+
+func featureFlagsFrom(c *gin.Context) FlagSet {
+	if v, ok := c.Get(featureFlagsContextKey); ok {
+		return v.(FlagSet)
+	}
+	return nil
+}
+
+// resolveFeatureFlags works out the request's flags and echoes them in
+// X-Feature-Flags.
+func (s *Server) resolveFeatureFlags(c *gin.Context) {
+	set, err := s.flags.Resolve(tenantFrom(c), c.GetString(keyIDContextKey), c.GetHeader(featureFlagsHeader))
+	if err != nil {
+		c.Abort()
+		respondError(c, err)
+		return
+	}
+	c.Set(featureFlagsContextKey, set)
+	c.Header(featureFlagsHeader, set.String())
+	c.Next()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fetchObject makes rootHash available locally, serving from the download
+// cache, or a peer gateway's, when possible and populating it otherwise.
+func (s *Server) fetchObject(rootHash string) (*Staged, error) {
+	return s.fetchObjectAs(ClassInteractive, rootHash)
+}
+
+// fetchObjectAs is fetchObject for work of the given class.
+func (s *Server) fetchObjectAs(class RequestClass, rootHash string) (*Staged, error) {
+	return s.fetchObjectWith(class, TransferTuning{}, rootHash)
+}
+
+// fetchObjectWith is fetchObjectAs with a request's transfer tuning applied
+// when the object has to be downloaded.
+func (s *Server) fetchObjectWith(class RequestClass, tuning TransferTuning, rootHash string) (*Staged, error) {
+	if s.cache != nil {
+		if path, release, ok := s.cache.Get(rootHash); ok {
+			return &Staged{Path: path, release: release}, nil
+		}
+	}
+	if obj, ok := s.fetchFromPeers(context.Background(), rootHash); ok {
+		return obj, nil
+	}
+
+	tempFile := s.spool.Path("download")
+	if err := s.client.DownloadFileWith(class, tuning, rootHash, tempFile); err != nil {
+		s.spool.Release(tempFile)
+		return nil, err
+	}
+	return s.keepInCache(rootHash, s.spool.staged(tempFile, 0)), nil
+}
+
+// loadManifest returns the parsed manifest stored under rootHash. When the
+// object turns out not to be a manifest, errNotManifest is returned together
+// with the fetched object so the caller can serve it without fetching twice.
+func (s *Server) loadManifest(rootHash string) (*Manifest, *Staged, error) {
+	if m, ok := s.manifests.get(rootHash); ok {
+		return m, nil, nil
+	}
+
+	obj, err := s.fetchObject(rootHash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := os.Stat(obj.Path)
+	if err != nil {
+		obj.Release()
+		return nil, nil, err
+	}
+	if info.Size() > maxManifestSize {
+		return nil, obj, errNotManifest
+	}
+
+	data, err := os.ReadFile(obj.Path)
+	if err != nil {
+		obj.Release()
+		return nil, nil, err
+	}
+	m, err := ParseManifest(data)
+	if err != nil {
+		return nil, obj, errNotManifest
+	}
+
+	obj.Release()
+	s.manifests.put(rootHash, m)
+	return m, nil, nil
+}
+
+func contentTypeFor(name, declared string) string {
+	if declared != "" {
+		return declared
+	}
+	return mime.TypeByExtension(path.Ext(name))
+}
+
+// serveLocalFile writes a downloaded object to the response. name drives the
+// Content-Type when none is declared; content is sniffed as a last resort.
+// gzipped bodies are compressed on the fly and do not support ranges.
+func serveLocalFile(c *gin.Context, status int, localPath, name, contentType string, gzipped bool) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+
+	if ct := contentTypeFor(name, contentType); ct != "" {
+		c.Header("Content-Type", ct)
+	}
+
+	if gzipped {
+		c.Header("Content-Encoding", "gzip")
+		c.Status(status)
+		if c.Request.Method == http.MethodHead {
+			return
+		}
+		gz := gzip.NewWriter(c.Writer)
+		if _, err := io.Copy(gz, f); err != nil {
+			log.Printf("⚠️  Failed to serve %s: %v", name, err)
+		}
+		gz.Close()
+		return
+	}
+
+	if status == http.StatusOK {
+		http.ServeContent(c.Writer, c.Request, name, time.Time{}, f)
+		return
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Length", fmt.Sprint(info.Size()))
+	c.Status(status)
+	io.Copy(c.Writer, f)
+}
+
+// serveObject fetches rootHash and writes it to the response. A conditional
+// request that still matches is answered without fetching anything.
+func (s *Server) serveObject(c *gin.Context, status int, rootHash, name, contentType, cacheControl string) {
+	if s.withheld(c, rootHash, true) {
+		return
+	}
+	gzipped := setCacheHeaders(c, rootHash, contentTypeFor(name, contentType), cacheControl)
+	if status == http.StatusOK && etagMatches(c.GetHeader("If-None-Match"), c.Writer.Header().Get("ETag")) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	obj, err := s.fetchObject(rootHash)
+	if err != nil {
+		noStore(c)
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	defer obj.Release()
+
+	s.signResponse(c, rootHash, obj.Path, status == http.StatusOK && !gzipped)
+	serveLocalFile(c, status, obj.Path, name, contentType, gzipped)
+}
+
+// serveManifestPath resolves a request path inside a manifest the way a static
+// web server would: directories map to index.html, missing files fall back to
+// 404.html. cacheControl applies to everything served from the manifest.
+func (s *Server) serveManifestPath(c *gin.Context, m *Manifest, requestPath, cacheControl string) {
+	rel := strings.TrimPrefix(path.Clean("/"+requestPath), "/")
+	isDir := rel == "" || strings.HasSuffix(requestPath, "/")
+
+	candidate := rel
+	if isDir {
+		candidate = path.Join(rel, "index.html")
+	}
+	if entry, ok := m.Lookup(candidate); ok {
+		s.serveObject(c, http.StatusOK, entry.RootHash, candidate, entry.ContentType, cacheControl)
+		return
+	}
+
+	// "/docs" should behave like "/docs/" so relative links in its index resolve
+	if !isDir {
+		if _, ok := m.Lookup(path.Join(rel, "index.html")); ok {
+			c.Header("Cache-Control", cacheControl)
+			c.Redirect(http.StatusMovedPermanently, c.Request.URL.Path+"/")
+			return
+		}
+	}
+
+	if entry, ok := m.Lookup("404.html"); ok {
+		s.serveObject(c, http.StatusNotFound, entry.RootHash, "404.html", entry.ContentType, cacheControl)
+		return
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "File not found in manifest"})
+}
+
+// handleGateway serves /gw/{root_hash}/{path}: a plain file when the root is
+// not a manifest, otherwise the path resolved inside the manifest.
+func (s *Server) handleGateway(c *gin.Context) {
+	rootHash := s.resolveRef(c.Param("root_hash"))
+	requestPath := c.Param("path")
+	if s.withheld(c, rootHash, true) || s.outsideDomain(c, rootHash) {
+		return
+	}
+
+	m, raw, err := s.loadManifest(rootHash)
+	if err == errNotManifest {
+		defer raw.Release()
+		if strings.Trim(requestPath, "/") != "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Object is not a directory manifest"})
+			return
+		}
+		setCacheHeaders(c, rootHash, "", immutableCacheControl)
+		s.signResponse(c, rootHash, raw.Path, true)
+		serveLocalFile(c, http.StatusOK, raw.Path, rootHash, "", false)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.serveManifestPath(c, m, requestPath, immutableCacheControl)
+}
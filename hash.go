@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/0glabs/0g-storage-client/core"
+	"github.com/0glabs/0g-storage-client/core/merkle"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// Files of fewer segments are hashed serially by the SDK; spreading a
+	// couple of megabytes over goroutines costs more than it saves
+	parallelHashMinSegments = 8
+	// defaultHashReads bounds the segment reads in flight across all files
+	// being hashed, so concurrent uploads do not thrash the spool disk
+	defaultHashReads = 4
+)
+
+// RootHasher computes Merkle roots of large files on several goroutines.
+// Segment roots are independent, so workers hash segments in any order and
+// the tree is built from them in order at the end. Workers per file are
+// bounded by GOMAXPROCS, and reads across all files by an I/O limit.
+type RootHasher struct {
+	workers int
+	reads   chan struct{}
+}
+
+// NewRootHasher hashes each file on up to workers goroutines (0 is
+// GOMAXPROCS) with up to reads segment reads in flight overall.
+func NewRootHasher(workers, reads int) *RootHasher {
+	if procs := runtime.GOMAXPROCS(0); workers <= 0 || workers > procs {
+		workers = procs
+	}
+	if reads <= 0 {
+		reads = defaultHashReads
+	}
+	return &RootHasher{workers: workers, reads: make(chan struct{}, reads)}
+}
+
+// Root returns the Merkle root 0G Storage assigns to the file at path.
+func (h *RootHasher) Root(path string) (common.Hash, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to open file: %v", err)
+	}
+	size := info.Size()
+
+	// The tree has a leaf for every segment of the flow-padded file,
+	// including trailing segments of padding only
+	chunksPadded, _ := core.ComputePaddedSize(core.NumSplits(size, core.DefaultChunkSize))
+	segments := (chunksPadded-1)/core.DefaultSegmentMaxChunks + 1
+	workers := h.workers
+	if size == 0 || segments < parallelHashMinSegments || workers < 2 {
+		return serialRoot(path)
+	}
+	if uint64(workers) > segments {
+		workers = int(segments)
+	}
+
+	roots := make([]common.Hash, segments)
+	var next atomic.Uint64
+	var failed atomic.Bool
+	var once sync.Once
+	var firstErr error
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, core.DefaultSegmentSize)
+			for !failed.Load() {
+				i := next.Add(1) - 1
+				if i >= segments {
+					return
+				}
+				root, err := h.segmentRoot(f, i, size, buf)
+				if err != nil {
+					once.Do(func() { firstErr = err })
+					failed.Store(true)
+					return
+				}
+				roots[i] = root
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return common.Hash{}, firstErr
+	}
+
+	var builder merkle.TreeBuilder
+	for _, root := range roots {
+		builder.AppendHash(root)
+	}
+	return builder.Build().Root(), nil
+}
+
+// segmentRoot reads segment index of a file of size bytes into buf and
+// returns its root, padded the way the storage nodes pad it.
+func (h *RootHasher) segmentRoot(f *os.File, index uint64, size int64, buf []byte) (common.Hash, error) {
+	offset := int64(index) * core.DefaultSegmentSize
+	n := 0
+	if offset < size {
+		want := size - offset
+		if want > core.DefaultSegmentSize {
+			want = core.DefaultSegmentSize
+		}
+		h.reads <- struct{}{}
+		read, err := f.ReadAt(buf[:want], offset)
+		<-h.reads
+		if err != nil && !(err == io.EOF && int64(read) == want) {
+			return common.Hash{}, fmt.Errorf("failed to read segment %d: %v", index, err)
+		}
+		n = read
+	}
+	// A partial last chunk is zero-filled
+	padded := (n + core.DefaultChunkSize - 1) / core.DefaultChunkSize * core.DefaultChunkSize
+	for i := n; i < padded; i++ {
+		buf[i] = 0
+	}
+	root, _ := core.PaddedSegmentRoot(index, buf[:padded], size)
+	return root, nil
+}
+
+// serialRoot hashes a file with the SDK, one segment after another.
+func serialRoot(path string) (common.Hash, error) {
+	file, err := core.Open(path)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	tree, err := core.MerkleTree(file)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to compute merkle root: %v", err)
+	}
+	return tree.Root(), nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	transferConcurrencyHeader = "X-Transfer-Concurrency"
+	segmentSizeHintHeader     = "X-Segment-Size-Hint"
+	transferTuningContextKey  = "transfer_tuning"
+)
+
+// TransferTuning adjusts how one request moves data to or from storage
+// nodes. Zero fields keep the SDK's defaults.
+type TransferTuning struct {
+	// Concurrency is the number of segments transferred in parallel
+	Concurrency int
+	// TaskSegments is how many segments an upload sends to a node per request
+	TaskSegments uint
+	// Replicas is how many storage nodes an upload stores each segment on
+	Replicas uint
+}
+
+func (t TransferTuning) IsZero() bool {
+	return t.Concurrency == 0 && t.TaskSegments == 0 && t.Replicas == 0
+}
+
+// TransferPolicy bounds the tuning clients may ask for; a zero maximum
+// ignores the corresponding hint.
+type TransferPolicy struct {
+	MaxConcurrency  int
+	MaxTaskSegments uint
+	// MaxReplicas bounds the replicas an upload may ask for; the network's
+	// default is always allowed
+	MaxReplicas uint
+}
+
+// Replicas reads an upload's replicas value, 0 when it asked for none.
+// Unlike the hints, a count over the limit is refused: fewer replicas than
+// asked for is not what the client wants stored.
+func (p TransferPolicy) Replicas(raw string, def uint) (uint, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil || n < 1 {
+		return 0, newAPIError(http.StatusBadRequest, "replicas must be a positive number")
+	}
+	limit := p.MaxReplicas
+	if limit < def {
+		limit = def
+	}
+	if uint(n) > limit {
+		return 0, newAPIError(http.StatusBadRequest, "replicas may be at most %d", limit)
+	}
+	return uint(n), nil
+}
+
+// Tune turns client hints into tuning within the policy. Hints above the
+// limits are lowered to them rather than refused.
+func (p TransferPolicy) Tune(concurrencyHint, segmentHint string) (TransferTuning, error) {
+	var t TransferTuning
+	if v := strings.TrimSpace(concurrencyHint); v != "" && p.MaxConcurrency > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return TransferTuning{}, fmt.Errorf("%s must be a positive number", transferConcurrencyHeader)
+		}
+		if n > p.MaxConcurrency {
+			n = p.MaxConcurrency
+		}
+		t.Concurrency = n
+	}
+	if v := strings.TrimSpace(segmentHint); v != "" && p.MaxTaskSegments > 0 {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			return TransferTuning{}, fmt.Errorf("%s must be a positive number of bytes", segmentSizeHintHeader)
+		}
+		// 0G segments have a fixed size, so the hint picks how many go
+		// out together
+		segments := uint((n + segmentSize - 1) / segmentSize)
+		if segments > p.MaxTaskSegments {
+			segments = p.MaxTaskSegments
+		}
+		t.TaskSegments = segments
+	}
+	return t, nil
+}
+
+func transferTuningFrom(c *gin.Context) TransferTuning {
+	if v, ok := c.Get(transferTuningContextKey); ok {
+		return v.(TransferTuning)
+	}
+	return TransferTuning{}
+}
+
+// readTransferHints applies X-Transfer-Concurrency and X-Segment-Size-Hint
+// to the request, and echoes the values actually used so clients can see
+// where the policy bounded them.
+func (s *Server) readTransferHints(c *gin.Context) {
+	tuning, err := s.transfers.Tune(c.GetHeader(transferConcurrencyHeader), c.GetHeader(segmentSizeHintHeader))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if tuning.Concurrency > 0 {
+		c.Header(transferConcurrencyHeader, strconv.Itoa(tuning.Concurrency))
+	}
+	if tuning.TaskSegments > 0 {
+		c.Header(segmentSizeHintHeader, strconv.FormatInt(int64(tuning.TaskSegments)*segmentSize, 10))
+	}
+	c.Set(transferTuningContextKey, tuning)
+	c.Next()
+}
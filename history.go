@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+	historyWriteTimeout = 5 * time.Second
+)
+
+// UploadHistoryRecord is one upload that went through the gateway. Unlike
+// the catalog, which holds what each tenant references now, the history
+// keeps every upload, including repeats and files deleted since.
+type UploadHistoryRecord struct {
+	ID       int64  `json:"id"`
+	Tenant   string `json:"tenant"`
+	KeyID    string `json:"key_id,omitempty"`
+	Filename string `json:"filename"`
+	// ContentType is sniffed from the content
+	ContentType  string    `json:"content_type,omitempty"`
+	Size         int64     `json:"size"`
+	RootHash     string    `json:"root_hash"`
+	TxHash       string    `json:"tx_hash"`
+	Deduplicated bool      `json:"deduplicated"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+}
+
+// UploadHistoryQuery selects a tenant's uploads, newest first. Zero fields
+// do not filter.
+type UploadHistoryQuery struct {
+	Tenant string
+	// Filename matches case-insensitively anywhere in the name
+	Filename string
+	// ContentType matches as a prefix, so image/ selects every image
+	ContentType string
+	RootHash    string
+	Since       time.Time
+	Until       time.Time
+	// Before is a cursor: only uploads with a smaller ID are returned
+	Before int64
+	Limit  int
+}
+
+// UploadHistory records every upload. Implementations must be safe for
+// concurrent use.
+type UploadHistory interface {
+	Record(ctx context.Context, rec UploadHistoryRecord) error
+	List(ctx context.Context, q UploadHistoryQuery) ([]UploadHistoryRecord, error)
+	Close() error
+}
+
+// OpenUploadHistory opens the store METADATA_STORE names. It returns nil
+// when the store is "none", or when it is left unset in a build without the
+// SQL stores.
+func OpenUploadHistory(cfg *Config) (UploadHistory, error) {
+	switch cfg.MetadataStore {
+	case "none":
+		return nil, nil
+	case "", "sqlite", "postgres":
+		return openSQLHistory(cfg)
+	default:
+		return nil, fmt.Errorf("unknown metadata store %q (want sqlite, postgres or none)", cfg.MetadataStore)
+	}
+}
+
+// recordUpload adds a finished upload to the history, if one is kept. A
+// failure is logged; the upload itself has succeeded.
+func (s *Server) recordUpload(req uploadRequest, contentType string, started time.Time, resp UploadResponse) {
+	if s.history == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), historyWriteTimeout)
+	defer cancel()
+	err := s.history.Record(ctx, UploadHistoryRecord{
+		Tenant:       req.Tenant,
+		KeyID:        req.KeyID,
+		Filename:     req.Filename,
+		ContentType:  contentType,
+		Size:         req.Size,
+		RootHash:     resp.RootHash,
+		TxHash:       resp.TxHash,
+		Deduplicated: resp.Deduplicated,
+		StartedAt:    started,
+		FinishedAt:   time.Now(),
+	})
+	if err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+}
+
+type UploadHistoryResponse struct {
+	Uploads []UploadHistoryRecord `json:"uploads"`
+	// NextCursor fetches the next page as ?cursor=; empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// @Summary Upload history
+// @Description Every upload the caller made through the gateway, newest first, with the original filename, content type, size, root and transaction hashes, the API key used and when it started and finished. Unlike GET /files it keeps repeated uploads and files deleted since. Pages are limit long; pass next_cursor back as cursor for the next one.
+// @Produce json
+// @Param filename query string false "Substring of the filename (case-insensitive)"
+// @Param content_type query string false "Content type prefix, e.g. image/"
+// @Param root_hash query string false "Root hash"
+// @Param since query string false "Uploads started at or after this time (RFC 3339)"
+// @Param until query string false "Uploads started before this time (RFC 3339)"
+// @Param limit query int false "Page size (default 50, at most 500)"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Success 200 {object} UploadHistoryResponse
+// @Security ApiKeyAuth
+// @Router /files/history [get]
+func (s *Server) handleUploadHistory(c *gin.Context) {
+	if s.history == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Upload history is not kept"})
+		return
+	}
+	q := UploadHistoryQuery{
+		Tenant:      tenantFrom(c),
+		Filename:    c.Query("filename"),
+		ContentType: c.Query("content_type"),
+		RootHash:    c.Query("root_hash"),
+	}
+	var err error
+	q.Limit, err = strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultHistoryLimit)))
+	if err != nil || q.Limit < 1 || q.Limit > maxHistoryLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxHistoryLimit)})
+		return
+	}
+	if raw := c.Query("cursor"); raw != "" {
+		if q.Before, err = strconv.ParseInt(raw, 10, 64); err != nil || q.Before < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+	}
+	for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if raw := c.Query(name); raw != "" {
+			if *t, err = time.Parse(time.RFC3339, raw); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be an RFC 3339 time", name)})
+				return
+			}
+		}
+	}
+
+	// One extra row tells whether there is a next page
+	limit := q.Limit
+	q.Limit++
+	records, err := s.history.List(c.Request.Context(), q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp := UploadHistoryResponse{Uploads: records}
+	if len(records) > limit {
+		resp.Uploads = records[:limit]
+		resp.NextCursor = strconv.FormatInt(records[limit-1].ID, 10)
+	}
+	c.JSON(http.StatusOK, resp)
+}
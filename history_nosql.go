@@ -0,0 +1,19 @@
+//go:build !sqlhistory
+
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// openSQLHistory stands in for the SQL stores in builds without the
+// sqlhistory tag, which leave out the SQLite and Postgres drivers. An unset
+// METADATA_STORE then keeps no history; asking for a SQL store is an error.
+func openSQLHistory(cfg *Config) (UploadHistory, error) {
+	if cfg.MetadataStore == "" {
+		log.Printf("⚠️  Upload history is off: this build has no SQL store (build with -tags sqlhistory)")
+		return nil, nil
+	}
+	return nil, fmt.Errorf("the %s metadata store needs a build with -tags sqlhistory", cfg.MetadataStore)
+}
@@ -0,0 +1,164 @@
+//go:build sqlhistory
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// sqlUploadHistory keeps the history in a SQL database: SQLite by default,
+// or Postgres.
+type sqlUploadHistory struct {
+	db       *sql.DB
+	postgres bool
+}
+
+// openSQLHistory opens the SQLite or Postgres store, creating its table if
+// needed.
+func openSQLHistory(cfg *Config) (UploadHistory, error) {
+	var driver, serial string
+	dsn := cfg.MetadataDSN
+	switch cfg.MetadataStore {
+	case "", "sqlite":
+		driver, serial = "sqlite", "INTEGER PRIMARY KEY AUTOINCREMENT"
+		if dsn == "" {
+			dsn = cfg.DataPath("uploads.db")
+		}
+	case "postgres":
+		driver, serial = "pgx", "BIGSERIAL PRIMARY KEY"
+		if dsn == "" {
+			return nil, fmt.Errorf("METADATA_DSN is required for the postgres store")
+		}
+	default:
+		return nil, fmt.Errorf("unknown metadata store %q (want sqlite, postgres or none)", cfg.MetadataStore)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metadata store: %v", err)
+	}
+	if driver == "sqlite" {
+		// SQLite allows one writer at a time
+		db.SetMaxOpenConns(1)
+	}
+	schema := []string{
+		`CREATE TABLE IF NOT EXISTS uploads (
+			id ` + serial + `,
+			tenant TEXT NOT NULL,
+			key_id TEXT NOT NULL DEFAULT '',
+			filename TEXT NOT NULL,
+			content_type TEXT NOT NULL DEFAULT '',
+			size BIGINT NOT NULL,
+			root_hash TEXT NOT NULL,
+			tx_hash TEXT NOT NULL DEFAULT '',
+			deduplicated BOOLEAN NOT NULL DEFAULT FALSE,
+			started_at BIGINT NOT NULL,
+			finished_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS uploads_tenant_id ON uploads (tenant, id)`,
+		`CREATE INDEX IF NOT EXISTS uploads_root_hash ON uploads (root_hash)`,
+	}
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create metadata schema: %v", err)
+		}
+	}
+	return &sqlUploadHistory{db: db, postgres: driver == "pgx"}, nil
+}
+
+// rebind turns ? placeholders into Postgres' numbered ones.
+func (h *sqlUploadHistory) rebind(query string) string {
+	if !h.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (h *sqlUploadHistory) Record(ctx context.Context, rec UploadHistoryRecord) error {
+	_, err := h.db.ExecContext(ctx, h.rebind(`INSERT INTO uploads
+		(tenant, key_id, filename, content_type, size, root_hash, tx_hash, deduplicated, started_at, finished_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		rec.Tenant, rec.KeyID, rec.Filename, rec.ContentType, rec.Size, rec.RootHash, rec.TxHash,
+		rec.Deduplicated, rec.StartedAt.UnixMilli(), rec.FinishedAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to record upload: %v", err)
+	}
+	return nil
+}
+
+// likeEscaper makes user input match literally in a LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (h *sqlUploadHistory) List(ctx context.Context, q UploadHistoryQuery) ([]UploadHistoryRecord, error) {
+	query := `SELECT id, tenant, key_id, filename, content_type, size, root_hash, tx_hash, deduplicated, started_at, finished_at
+		FROM uploads WHERE tenant = ?`
+	args := []interface{}{q.Tenant}
+	if q.Before > 0 {
+		query += ` AND id < ?`
+		args = append(args, q.Before)
+	}
+	if q.Filename != "" {
+		query += ` AND LOWER(filename) LIKE ? ESCAPE '\'`
+		args = append(args, "%"+likeEscaper.Replace(strings.ToLower(q.Filename))+"%")
+	}
+	if q.ContentType != "" {
+		query += ` AND content_type LIKE ? ESCAPE '\'`
+		args = append(args, likeEscaper.Replace(q.ContentType)+"%")
+	}
+	if q.RootHash != "" {
+		query += ` AND root_hash = ?`
+		args = append(args, q.RootHash)
+	}
+	if !q.Since.IsZero() {
+		query += ` AND started_at >= ?`
+		args = append(args, q.Since.UnixMilli())
+	}
+	if !q.Until.IsZero() {
+		query += ` AND started_at < ?`
+		args = append(args, q.Until.UnixMilli())
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, q.Limit)
+
+	rows, err := h.db.QueryContext(ctx, h.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query upload history: %v", err)
+	}
+	defer rows.Close()
+	records := []UploadHistoryRecord{}
+	for rows.Next() {
+		var rec UploadHistoryRecord
+		var started, finished int64
+		if err := rows.Scan(&rec.ID, &rec.Tenant, &rec.KeyID, &rec.Filename, &rec.ContentType, &rec.Size,
+			&rec.RootHash, &rec.TxHash, &rec.Deduplicated, &started, &finished); err != nil {
+			return nil, fmt.Errorf("failed to read upload history: %v", err)
+		}
+		rec.StartedAt = time.UnixMilli(started).UTC()
+		rec.FinishedAt = time.UnixMilli(finished).UTC()
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+func (h *sqlUploadHistory) Close() error {
+	return h.db.Close()
+}
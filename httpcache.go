@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Content under /gw/{root_hash} can never change
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	// Sites can be repointed, so shared caches revalidate them quickly
+	siteCacheControl = "public, max-age=60, must-revalidate"
+)
+
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// compressible reports whether gzip is worth negotiating for a content type.
+func compressible(contentType string) bool {
+	ct := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(ct, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// etagMatches implements the weak comparison If-None-Match calls for.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// downloadETag is the ETag of a download: its root hash, which names the
+// content for good.
+func downloadETag(rootHash string) string {
+	return strconv.Quote(strings.ToLower(rootHash))
+}
+
+// noStore keeps browsers and CDNs from caching an error response.
+func noStore(c *gin.Context) {
+	h := c.Writer.Header()
+	h.Set("Cache-Control", "no-store")
+	h.Del("CDN-Cache-Control")
+	h.Del("Surrogate-Control")
+}
+
+// setCacheHeaders sets Cache-Control, Vary and an ETag derived from the
+// content's root hash, and reports whether the body should be gzipped. Each
+// encoding gets its own ETag so caches never mix up the variants.
+func setCacheHeaders(c *gin.Context, rootHash, contentType, cacheControl string) (gzipped bool) {
+	h := c.Writer.Header()
+	h.Set("Cache-Control", cacheControl)
+	if compressible(contentType) {
+		h.Add("Vary", "Accept-Encoding")
+		gzipped = acceptsGzip(c.Request) && c.GetHeader("Range") == ""
+	}
+
+	etag := strings.ToLower(rootHash)
+	if gzipped {
+		etag += "-gzip"
+	}
+	h.Set("ETag", strconv.Quote(etag))
+	return gzipped
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+)
+
+// IDScheme makes the short public IDs content can be referred to by instead
+// of its root hash.
+type IDScheme struct {
+	Name string
+	New  func() (string, error)
+}
+
+// idSchemes is the registry of schemes PUBLIC_ID_SCHEME can name; "root"
+// (the default) keeps root hashes as the only IDs.
+var idSchemes = map[string]IDScheme{
+	"base58": {Name: "base58", New: newBase58ID},
+	"uuid":   {Name: "uuid", New: newUUID},
+}
+
+func LoadIDScheme(name string) (*IDScheme, error) {
+	if name == "" || name == "root" {
+		return nil, nil
+	}
+	scheme, ok := idSchemes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown ID scheme %q (want root, base58 or uuid)", name)
+	}
+	return &scheme, nil
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// newBase58ID encodes 16 random bytes in the Bitcoin base58 alphabet, which
+// leaves out look-alike characters: about 22 characters, URL safe.
+func newBase58ID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	n := new(big.Int).SetBytes(buf)
+	base, mod := big.NewInt(58), new(big.Int)
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, base, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out), nil
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// assignPublicID returns the public ID of a stored object, giving it one
+// first if needed. It returns "" when no scheme is configured.
+func (s *Server) assignPublicID(rootHash string) string {
+	if s.publicIDs == nil {
+		return ""
+	}
+	id, err := s.catalog.AssignPublicID(rootHash, s.publicIDs.New)
+	if err != nil {
+		log.Printf("⚠️  Failed to assign a public ID to %s: %v", rootHash, err)
+		return ""
+	}
+	return id
+}
+
+// resolveRef turns a reference taken from a download route, either a root
+// hash or a public ID, into a root hash. Public IDs are resolved whichever
+// scheme made them, so changing PUBLIC_ID_SCHEME keeps old links working.
+func (s *Server) resolveRef(ref string) string {
+	if isRootHash(ref) {
+		return ref
+	}
+	if root, ok := s.catalog.ResolvePublicID(ref); ok {
+		return root
+	}
+	return ref
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	impersonationTokenPrefix = "imp_"
+	defaultImpersonationTTL  = 15 * time.Minute
+	maxImpersonationTTL      = 4 * time.Hour
+)
+
+// ImpersonationToken lets support staff act as a tenant for a short while.
+// Tokens live in memory only, so a restart revokes them all.
+type ImpersonationToken struct {
+	ID       string    `json:"id"`
+	Tenant   string    `json:"tenant"`
+	Reason   string    `json:"reason"`
+	ReadOnly bool      `json:"read_only"`
+	IssuedAt time.Time `json:"issued_at"`
+	Expires  time.Time `json:"expires_at"`
+}
+
+type ImpersonationStore struct {
+	mu     sync.Mutex
+	tokens map[string]*ImpersonationToken // key hash -> token
+}
+
+func NewImpersonationStore() *ImpersonationStore {
+	return &ImpersonationStore{tokens: make(map[string]*ImpersonationToken)}
+}
+
+// Issue mints a token and returns the secret, which is only shown here.
+func (s *ImpersonationStore) Issue(tenant, reason string, readOnly bool, ttl time.Duration) (string, ImpersonationToken, error) {
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", ImpersonationToken{}, err
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return "", ImpersonationToken{}, err
+	}
+	raw := impersonationTokenPrefix + secret
+	now := time.Now()
+	token := &ImpersonationToken{
+		ID:       id,
+		Tenant:   tenant,
+		Reason:   reason,
+		ReadOnly: readOnly,
+		IssuedAt: now,
+		Expires:  now.Add(ttl),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, t := range s.tokens {
+		if now.After(t.Expires) {
+			delete(s.tokens, hash)
+		}
+	}
+	s.tokens[hashAPIKey(raw)] = token
+	return raw, *token, nil
+}
+
+func (s *ImpersonationStore) Lookup(raw string) (ImpersonationToken, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[hashAPIKey(raw)]
+	if !ok || time.Now().After(t.Expires) {
+		return ImpersonationToken{}, false
+	}
+	return *t, true
+}
+
+// List returns the tokens that have not expired.
+func (s *ImpersonationStore) List() []ImpersonationToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	tokens := []ImpersonationToken{}
+	for _, t := range s.tokens {
+		if !now.After(t.Expires) {
+			tokens = append(tokens, *t)
+		}
+	}
+	return tokens
+}
+
+func (s *ImpersonationStore) Revoke(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, t := range s.tokens {
+		if t.ID == id {
+			delete(s.tokens, hash)
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateImpersonation handles a request presenting an impersonation
+// token; ok is false when the key is not one.
+func (s *Server) authenticateImpersonation(c *gin.Context, key string) (ok bool) {
+	if !strings.HasPrefix(key, impersonationTokenPrefix) {
+		return false
+	}
+	token, valid := s.impersonation.Lookup(key)
+	if !valid {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Impersonation token is invalid or expired"})
+		return true
+	}
+	if token.ReadOnly && requestAction(c.Request.Method) != "read" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Impersonation token is read-only"})
+		return true
+	}
+	c.Set(tenantContextKey, token.Tenant)
+	c.Set(keyIDContextKey, "impersonation:"+token.ID)
+	c.Set(impersonationContextKey, token)
+	c.Header("X-Impersonating", token.Tenant)
+	c.Next()
+	return true
+}
+
+type ImpersonateRequest struct {
+	Tenant string `json:"tenant" binding:"required"`
+	// Reason is recorded with every request made with the token
+	Reason string `json:"reason" binding:"required"`
+	// TTL is a Go duration, default 15m and at most 4h
+	TTL      string `json:"ttl"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+type ImpersonateResponse struct {
+	ImpersonationToken
+	// Token is sent as X-API-Key; it is only returned once
+	Token string `json:"token"`
+}
+
+// @Summary Mint an impersonation token
+// @Description Issues a short-lived token that acts as the given tenant on /api/v1, for support and debugging. Every request made with it is recorded in the audit log as impersonated, with the reason given. read_only tokens can only read.
+// @Accept json
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Param request body ImpersonateRequest true "Tenant, reason and lifetime"
+// @Success 201 {object} ImpersonateResponse
+// @Router /admin/impersonate [post]
+func (s *Server) handleImpersonate(c *gin.Context) {
+	var req ImpersonateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ttl := defaultImpersonationTTL
+	if req.TTL != "" {
+		parsed, err := time.ParseDuration(req.TTL)
+		if err != nil || parsed <= 0 || parsed > maxImpersonationTTL {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ttl must be a positive duration of at most 4h"})
+			return
+		}
+		ttl = parsed
+	}
+
+	raw, token, err := s.impersonation.Issue(req.Tenant, req.Reason, req.ReadOnly, ttl)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.audit.Record(AuditEntry{
+		At:            time.Now(),
+		Tenant:        req.Tenant,
+		Method:        c.Request.Method,
+		Route:         c.FullPath(),
+		Path:          c.Request.URL.Path,
+		Status:        http.StatusCreated,
+		IP:            c.ClientIP(),
+		Impersonation: token.ID,
+		Reason:        req.Reason,
+	})
+	c.JSON(http.StatusCreated, ImpersonateResponse{ImpersonationToken: token, Token: raw})
+}
+
+// @Summary List impersonation tokens
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Success 200 {array} ImpersonationToken
+// @Router /admin/impersonate [get]
+func (s *Server) handleListImpersonations(c *gin.Context) {
+	c.JSON(http.StatusOK, s.impersonation.List())
+}
+
+// @Summary Revoke an impersonation token
+// @Param X-Admin-Token header string true "Admin token"
+// @Param id path string true "Token ID"
+// @Success 204
+// @Router /admin/impersonate/{id} [delete]
+func (s *Server) handleRevokeImpersonation(c *gin.Context) {
+	if !s.impersonation.Revoke(c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Impersonation token not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+type inflightResult struct {
+	TxHash   string
+	RootHash string
+	// Replicas is how many storage nodes store each segment
+	Replicas uint
+	Elapsed  time.Duration
+	Err      error
+}
+
+type inflightCall struct {
+	done   chan struct{}
+	result inflightResult
+}
+
+// inflightUploads tracks root hashes currently being submitted on chain. A
+// second upload of the same content while the first is running (typically a
+// client retrying after a network timeout) waits for that submission instead
+// of paying for another one.
+type inflightUploads struct {
+	mu    sync.Mutex
+	calls map[string]*inflightCall
+}
+
+func newInflightUploads() *inflightUploads {
+	return &inflightUploads{calls: make(map[string]*inflightCall)}
+}
+
+// Do runs upload unless an upload of rootHash is already in flight, in which
+// case it waits for that one. shared reports whether the result was borrowed.
+// The upload that ran stays in flight until finish is called, once its
+// result is in the catalog, so that an upload arriving in between waits for
+// it instead of submitting the content again. finish may be called more than
+// once.
+func (f *inflightUploads) Do(rootHash string, upload func() inflightResult) (result inflightResult, shared bool, finish func()) {
+	key := strings.ToLower(rootHash)
+
+	f.mu.Lock()
+	if call, ok := f.calls[key]; ok {
+		f.mu.Unlock()
+		<-call.done
+		return call.result, true, func() {}
+	}
+	call := &inflightCall{done: make(chan struct{})}
+	f.calls[key] = call
+	f.mu.Unlock()
+
+	var once sync.Once
+	finish = func() {
+		once.Do(func() {
+			f.mu.Lock()
+			delete(f.calls, key)
+			f.mu.Unlock()
+			close(call.done)
+		})
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			finish()
+			panic(r)
+		}
+	}()
+	call.result = upload()
+	return call.result, false, finish
+}
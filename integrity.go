@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How many integrity failures the report keeps
+const integrityHistory = 200
+
+// IntegrityFailure is a segment a storage node served that did not verify
+// against the file's root hash.
+type IntegrityFailure struct {
+	Time     time.Time `json:"time"`
+	Node     string    `json:"node"`
+	RootHash string    `json:"root_hash"`
+	Segment  uint64    `json:"segment"`
+	// SegmentRoot is the Merkle root of the data the node returned
+	SegmentRoot string `json:"segment_root,omitempty"`
+	Bytes       int    `json:"bytes"`
+	Error       string `json:"error"`
+}
+
+// NodeIntegrity counts the integrity failures of one storage node.
+type NodeIntegrity struct {
+	URL         string    `json:"url"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LastRoot    string    `json:"last_root_hash"`
+}
+
+type IntegrityReport struct {
+	Nodes  []NodeIntegrity    `json:"nodes"`
+	Recent []IntegrityFailure `json:"recent"`
+}
+
+// IntegrityMonitor records segments that failed verification, so bad
+// storage nodes can be found and reported. Operators are told about each
+// failure at notifyURL, when set.
+type IntegrityMonitor struct {
+	notifyURL string
+	http      *http.Client
+
+	mu     sync.Mutex
+	recent []IntegrityFailure
+	nodes  map[string]*NodeIntegrity
+}
+
+func NewIntegrityMonitor(notifyURL string) *IntegrityMonitor {
+	return &IntegrityMonitor{
+		notifyURL: notifyURL,
+		http:      &http.Client{Timeout: 30 * time.Second},
+		nodes:     make(map[string]*NodeIntegrity),
+	}
+}
+
+// Record notes a failed segment and notifies operators in the background.
+func (m *IntegrityMonitor) Record(f IntegrityFailure) {
+	f.Time = time.Now()
+	f.Node = normalizeNodeURL(f.Node)
+
+	m.mu.Lock()
+	m.recent = append(m.recent, f)
+	if len(m.recent) > integrityHistory {
+		m.recent = m.recent[len(m.recent)-integrityHistory:]
+	}
+	st, ok := m.nodes[f.Node]
+	if !ok {
+		st = &NodeIntegrity{URL: f.Node}
+		m.nodes[f.Node] = st
+	}
+	st.Failures++
+	st.LastFailure = f.Time
+	st.LastRoot = f.RootHash
+	m.mu.Unlock()
+
+	log.Printf("🧬 Segment %d of %s from %s failed verification: %s", f.Segment, f.RootHash, f.Node, f.Error)
+	if m.notifyURL != "" {
+		go m.notify(f)
+	}
+}
+
+func (m *IntegrityMonitor) notify(f IntegrityFailure) {
+	body, err := json.Marshal(f)
+	if err != nil {
+		return
+	}
+	resp, err := m.http.Post(m.notifyURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("⚠️  Failed to report bad node %s: %v", f.Node, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("⚠️  Bad node report for %s returned %s", f.Node, resp.Status)
+	}
+}
+
+// Report lists nodes by failure count, most first, and the recent failures,
+// newest first.
+func (m *IntegrityMonitor) Report() IntegrityReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	report := IntegrityReport{
+		Nodes:  make([]NodeIntegrity, 0, len(m.nodes)),
+		Recent: make([]IntegrityFailure, 0, len(m.recent)),
+	}
+	for _, st := range m.nodes {
+		report.Nodes = append(report.Nodes, *st)
+	}
+	sort.Slice(report.Nodes, func(i, j int) bool {
+		if report.Nodes[i].Failures != report.Nodes[j].Failures {
+			return report.Nodes[i].Failures > report.Nodes[j].Failures
+		}
+		return report.Nodes[i].URL < report.Nodes[j].URL
+	})
+	for i := len(m.recent) - 1; i >= 0; i-- {
+		report.Recent = append(report.Recent, m.recent[i])
+	}
+	return report
+}
+
+// @Summary Transfer integrity report
+// @Description Lists storage nodes that served segments failing Merkle proof verification, most failures first, and the most recent failures with the offending node, segment and mismatch. Failures are detected on streamed downloads, which check every segment as it arrives.
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Success 200 {object} IntegrityReport
+// @Router /admin/integrity [get]
+func (s *Server) handleIntegrityReport(c *gin.Context) {
+	c.JSON(http.StatusOK, s.client.integrity.Report())
+}
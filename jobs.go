@@ -0,0 +1,591 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+
+	// Finished jobs beyond this many are forgotten, oldest first.
+	maxFinishedJobs = 1000
+
+	// Long-poll bounds for GET /jobs/{id}/wait
+	defaultJobWait = 30 * time.Second
+	maxJobWait     = 2 * time.Minute
+
+	// jobEventsKeepAlive is how often an idle event stream sends a comment,
+	// so proxies do not close it
+	jobEventsKeepAlive = 15 * time.Second
+)
+
+type JobEvent struct {
+	At      time.Time `json:"at"`
+	State   string    `json:"state"`
+	Message string    `json:"message,omitempty"`
+}
+
+// Job is a unit of background work owned by a tenant.
+type Job struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Tenant string          `json:"tenant"`
+	State  string          `json:"state"`
+	Error  string          `json:"error,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	// Refs are the root and transaction hashes the job produced
+	Refs []string `json:"refs,omitempty"`
+	// Phase and Progress (a percentage) are reported by jobs that move
+	// through known steps, such as async uploads
+	Phase    string `json:"phase,omitempty"`
+	Progress int    `json:"progress,omitempty"`
+	// Transfer is how far an async upload has got on the storage nodes
+	Transfer *TransferProgress `json:"transfer,omitempty"`
+	// Stages is the time in milliseconds the job's uploads spent in each
+	// pipeline stage, summed over its files
+	Stages    map[string]float64 `json:"stage_ms,omitempty"`
+	History   []JobEvent         `json:"history"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+	// TraceRef is the trace of the request that started the job, to quote
+	// when reporting it
+	TraceRef
+}
+
+func (j *Job) finished() bool {
+	return j.State == JobSucceeded || j.State == JobFailed
+}
+
+// JobHandle lets a running job report on itself.
+type JobHandle struct {
+	store *JobStore
+	id    string
+}
+
+// Note records a progress message in the job's history.
+func (h *JobHandle) Note(format string, args ...interface{}) {
+	h.store.transition(h.id, JobRunning, fmt.Sprintf(format, args...))
+}
+
+// Track records root or transaction hashes the job produced, so the job can
+// be found from them later.
+func (h *JobHandle) Track(refs ...string) {
+	h.store.mu.Lock()
+	defer h.store.mu.Unlock()
+	if job, ok := h.store.jobs[h.id]; ok {
+		for _, ref := range refs {
+			if ref != "" {
+				job.Refs = append(job.Refs, strings.ToLower(ref))
+			}
+		}
+	}
+}
+
+// RecordStages stores the upload stage times the job has accumulated.
+func (h *JobHandle) RecordStages(timer *StageTimer) {
+	ms := timer.Millis()
+	h.store.mu.Lock()
+	defer h.store.mu.Unlock()
+	if job, ok := h.store.jobs[h.id]; ok {
+		job.Stages = ms
+	}
+}
+
+// SetProgress records the step the job has reached and how far along it is
+// in percent.
+func (h *JobHandle) SetProgress(phase string, percent int) {
+	h.store.mu.Lock()
+	if job, ok := h.store.jobs[h.id]; ok {
+		job.Phase = phase
+		job.Progress = percent
+	}
+	h.store.mu.Unlock()
+	h.store.transition(h.id, JobRunning, phase)
+}
+
+// SetTransfer records how far an upload has got and the percentage that
+// makes. Unlike SetProgress it adds nothing to the history, as it changes
+// every few seconds.
+func (h *JobHandle) SetTransfer(p TransferProgress, percent int) {
+	h.store.mu.Lock()
+	defer h.store.mu.Unlock()
+	if job, ok := h.store.jobs[h.id]; ok {
+		job.Transfer = &p
+		job.Progress = percent
+		job.UpdatedAt = time.Now()
+		h.store.notifyLocked(h.id)
+	}
+}
+
+// JobFunc performs a job; the returned value becomes the job's result.
+type JobFunc func(ctx context.Context, job *JobHandle) (interface{}, error)
+
+// JobStore tracks background jobs in memory.
+type JobStore struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+	// done is closed once a job has finished and its result is recorded
+	done map[string]chan struct{}
+	// watchers are signalled whenever a job changes
+	watchers map[string]map[chan struct{}]struct{}
+}
+
+func NewJobStore() *JobStore {
+	return &JobStore{
+		jobs:     make(map[string]*Job),
+		done:     make(map[string]chan struct{}),
+		watchers: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// Start registers a job and runs fn in the background. trace is the trace
+// of the request starting it.
+func (s *JobStore) Start(tenant, jobType string, trace TraceRef, fn JobFunc) (Job, error) {
+	return s.Queue(tenant, jobType, trace, nil, fn)
+}
+
+// Queue is Start for a job that needs one of a pool's slots: it stays queued
+// until a slot is free. A nil pool runs it straight away.
+func (s *JobStore) Queue(tenant, jobType string, trace TraceRef, slots chan struct{}, fn JobFunc) (Job, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return Job{}, fmt.Errorf("failed to generate job id: %v", err)
+	}
+	now := time.Now()
+	job := &Job{
+		ID:        id,
+		Type:      jobType,
+		Tenant:    tenant,
+		State:     JobQueued,
+		TraceRef:  trace,
+		History:   []JobEvent{{At: now, State: JobQueued}},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	s.mu.Lock()
+	s.jobs[id] = job
+	s.done[id] = make(chan struct{})
+	s.pruneLocked()
+	snapshot := *job
+	s.mu.Unlock()
+
+	go s.run(id, trace.ID, slots, fn)
+	return snapshot, nil
+}
+
+func (s *JobStore) run(id, traceID string, slots chan struct{}, fn JobFunc) {
+	if slots != nil {
+		slots <- struct{}{}
+		defer func() { <-slots }()
+	}
+	s.transition(id, JobRunning, "")
+	result, err := fn(context.Background(), &JobHandle{store: s, id: id})
+	if err != nil {
+		log.Printf("⚠️  Job %s failed (trace %s): %v", id, traceID, err)
+		s.finish(id, nil, err)
+		return
+	}
+	s.finish(id, result, nil)
+}
+
+func (s *JobStore) transition(id, state, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	job.State = state
+	job.UpdatedAt = now
+	job.History = append(job.History, JobEvent{At: now, State: state, Message: message})
+	s.notifyLocked(id)
+}
+
+// notifyLocked signals the job's watchers without waiting for them; a
+// watcher that has not caught up yet still sees the latest state.
+func (s *JobStore) notifyLocked(id string) {
+	for ch := range s.watchers[id] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Watch returns a channel signalled whenever a job owned by tenant changes,
+// and a function to stop watching.
+func (s *JobStore) Watch(tenant, id string) (<-chan struct{}, func(), bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok || job.Tenant != tenant {
+		return nil, nil, false
+	}
+	ch := make(chan struct{}, 1)
+	if s.watchers[id] == nil {
+		s.watchers[id] = make(map[chan struct{}]struct{})
+	}
+	s.watchers[id][ch] = struct{}{}
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.watchers[id], ch)
+		if len(s.watchers[id]) == 0 {
+			delete(s.watchers, id)
+		}
+	}, true
+}
+
+func (s *JobStore) finish(id string, result interface{}, jobErr error) {
+	var raw json.RawMessage
+	if result != nil {
+		data, err := json.Marshal(result)
+		if err != nil && jobErr == nil {
+			jobErr = fmt.Errorf("failed to encode result: %v", err)
+		}
+		raw = data
+	}
+
+	state, message := JobSucceeded, ""
+	if jobErr != nil {
+		state, message = JobFailed, jobErr.Error()
+	}
+	s.transition(id, state, message)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok {
+		job.Result = raw
+		job.Error = message
+		s.notifyLocked(id)
+	}
+	if done, ok := s.done[id]; ok {
+		close(done)
+	}
+}
+
+// pruneLocked forgets the oldest finished jobs once there are too many.
+func (s *JobStore) pruneLocked() {
+	var done []*Job
+	for _, job := range s.jobs {
+		if job.finished() {
+			done = append(done, job)
+		}
+	}
+	if len(done) <= maxFinishedJobs {
+		return
+	}
+	sort.Slice(done, func(i, j int) bool { return done[i].UpdatedAt.Before(done[j].UpdatedAt) })
+	for _, job := range done[:len(done)-maxFinishedJobs] {
+		delete(s.jobs, job.ID)
+		delete(s.done, job.ID)
+	}
+}
+
+// Drain waits until no job is queued or running, or ctx is done, and returns
+// how many are still unfinished.
+func (s *JobStore) Drain(ctx context.Context) int {
+	for {
+		s.mu.RLock()
+		var pending []chan struct{}
+		for id, job := range s.jobs {
+			if !job.finished() {
+				pending = append(pending, s.done[id])
+			}
+		}
+		s.mu.RUnlock()
+		if len(pending) == 0 {
+			return 0
+		}
+		select {
+		case <-pending[0]:
+		case <-ctx.Done():
+			return len(pending)
+		}
+	}
+}
+
+// Save writes every job to path, recording the unfinished ones as failed,
+// since their work ends with the process. Load restores them at the next
+// start, so clients polling a job across a restart learn how it ended.
+func (s *JobStore) Save(path string) error {
+	if path == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		if !job.finished() {
+			job.State = JobFailed
+			job.Error = "Interrupted by a gateway shutdown; start it again"
+			job.UpdatedAt = now
+			job.History = append(job.History, JobEvent{At: now, State: JobFailed, Message: job.Error})
+			s.notifyLocked(job.ID)
+		}
+		jobs = append(jobs, job)
+	}
+	return writeJSONFile(path, jobs)
+}
+
+// Load restores the jobs Save wrote to path.
+func (s *JobStore) Load(path string) error {
+	if path == "" {
+		return nil
+	}
+	var jobs []*Job
+	if err := readJSONFile(path, &jobs); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range jobs {
+		if _, ok := s.jobs[job.ID]; ok || !job.finished() {
+			continue
+		}
+		done := make(chan struct{})
+		close(done)
+		s.jobs[job.ID] = job
+		s.done[job.ID] = done
+	}
+	s.pruneLocked()
+	return nil
+}
+
+// Get returns a job owned by tenant.
+func (s *JobStore) Get(tenant, id string) (Job, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	job, ok := s.jobs[id]
+	if !ok || job.Tenant != tenant {
+		return Job{}, false
+	}
+	snapshot := *job
+	snapshot.History = append([]JobEvent(nil), job.History...)
+	if job.Transfer != nil {
+		transfer := *job.Transfer
+		transfer.Nodes = append([]NodeTransferProgress(nil), job.Transfer.Nodes...)
+		snapshot.Transfer = &transfer
+	}
+	return snapshot, true
+}
+
+// Wait blocks until a job owned by tenant has finished, timeout elapses or
+// ctx is done, and returns the job as it is then.
+func (s *JobStore) Wait(ctx context.Context, tenant, id string, timeout time.Duration) (Job, bool) {
+	s.mu.RLock()
+	done := s.done[id]
+	s.mu.RUnlock()
+	if _, ok := s.Get(tenant, id); !ok || done == nil {
+		return Job{}, false
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return s.Get(tenant, id)
+}
+
+// WithRef returns a tenant's jobs that produced ref, oldest first.
+func (s *JobStore) WithRef(tenant, ref string) []Job {
+	ref = strings.ToLower(ref)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var jobs []Job
+	for _, job := range s.jobs {
+		if job.Tenant != tenant {
+			continue
+		}
+		for _, r := range job.Refs {
+			if r == ref {
+				snapshot := *job
+				snapshot.History = append([]JobEvent(nil), job.History...)
+				jobs = append(jobs, snapshot)
+				break
+			}
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	return jobs
+}
+
+// List returns a tenant's jobs, newest first, without their history.
+func (s *JobStore) List(tenant string) []Job {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	jobs := []Job{}
+	for _, job := range s.jobs {
+		if job.Tenant == tenant {
+			snapshot := *job
+			snapshot.History = nil
+			jobs = append(jobs, snapshot)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	return jobs
+}
+
+// Count returns how many jobs of a type, across tenants, are in state.
+func (s *JobStore) Count(jobType, state string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for _, job := range s.jobs {
+		if job.Type == jobType && job.State == state {
+			n++
+		}
+	}
+	return n
+}
+
+// @Summary List background jobs
+// @Description Jobs started by the caller, newest first
+// @Produce json
+// @Param fields query string false "Comma-separated fields to return"
+// @Param envelope query bool false "Wrap the response in {data, count}"
+// @Success 200 {array} Job
+// @Security ApiKeyAuth
+// @Router /jobs [get]
+func (s *Server) handleListJobs(c *gin.Context) {
+	respondSelected(c, http.StatusOK, s.jobs.List(tenantFrom(c)))
+}
+
+// @Summary Get a background job
+// @Description Reports a job's state, its history of state changes and progress notes, and its result once it has succeeded
+// @Produce json
+// @Param id path string true "Job ID"
+// @Param fields query string false "Comma-separated fields to return"
+// @Param envelope query bool false "Wrap the response in {data, count}"
+// @Success 200 {object} Job
+// @Security ApiKeyAuth
+// @Router /jobs/{id} [get]
+func (s *Server) handleGetJob(c *gin.Context) {
+	job, ok := s.jobs.Get(tenantFrom(c), c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	respondSelected(c, http.StatusOK, job)
+}
+
+// @Summary Wait for a background job
+// @Description Long-polls a job: answers as soon as it has succeeded or failed, or with its current state once the timeout elapses, so clients can wait for completion without polling GET /jobs/{id} in a loop. Check state to tell the two apart.
+// @Produce json
+// @Param id path string true "Job ID"
+// @Param timeout query string false "How long to wait, as a Go duration (default 30s, at most 2m)"
+// @Success 200 {object} Job
+// @Security ApiKeyAuth
+// @Router /jobs/{id}/wait [get]
+func (s *Server) handleWaitJob(c *gin.Context) {
+	timeout := defaultJobWait
+	if raw := c.Query("timeout"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "timeout must be a non-negative duration such as 60s"})
+			return
+		}
+		if d > maxJobWait {
+			d = maxJobWait
+		}
+		timeout = d
+	}
+
+	job, ok := s.jobs.Wait(c.Request.Context(), tenantFrom(c), c.Param("id"), timeout)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// JobProgressEvent is the data of a progress event on a job's event stream.
+type JobProgressEvent struct {
+	State    string            `json:"state"`
+	Phase    string            `json:"phase,omitempty"`
+	Progress int               `json:"progress"`
+	Transfer *TransferProgress `json:"transfer,omitempty"`
+	Message  string            `json:"message,omitempty"`
+}
+
+// @Summary Stream a background job's progress
+// @Description Server-Sent Events for a job: a progress event whenever its state, phase or transfer progress changes, then a done event carrying the whole job once it has succeeded or failed, after which the stream ends. For async uploads, transfer reports bytes split into segments, whether a storage node has seen the submission on chain, the segments each node has, and finalization. Idle streams carry a comment every 15 seconds.
+// @Produce text/event-stream
+// @Param id path string true "Job ID"
+// @Success 200 {object} JobProgressEvent
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /jobs/{id}/events [get]
+func (s *Server) handleJobEvents(c *gin.Context) {
+	tenant, id := tenantFrom(c), c.Param("id")
+	changed, stop, ok := s.jobs.Watch(tenant, id)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	defer stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Keep nginx from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	keepAlive := time.NewTicker(jobEventsKeepAlive)
+	defer keepAlive.Stop()
+	var last []byte
+	seq := 0
+	for {
+		job, ok := s.jobs.Get(tenant, id)
+		if !ok {
+			return
+		}
+		if job.finished() {
+			data, _ := json.Marshal(job)
+			seq++
+			fmt.Fprintf(c.Writer, "id: %d\nevent: done\ndata: %s\n\n", seq, data)
+			c.Writer.Flush()
+			return
+		}
+		event := JobProgressEvent{State: job.State, Phase: job.Phase, Progress: job.Progress, Transfer: job.Transfer}
+		if n := len(job.History); n > 0 {
+			event.Message = job.History[n-1].Message
+		}
+		data, _ := json.Marshal(event)
+		if string(data) != string(last) {
+			seq++
+			fmt.Fprintf(c.Writer, "id: %d\nevent: progress\ndata: %s\n\n", seq, data)
+			c.Writer.Flush()
+			last = data
+		}
+
+		select {
+		case <-changed:
+		case <-keepAlive.C:
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
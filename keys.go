@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultKeyRotationGrace = 24 * time.Hour
+	maxKeyRotationGrace     = 7 * 24 * time.Hour
+)
+
+// APIKey describes a tenant's API key. Only a hash of the key is kept.
+type APIKey struct {
+	ID     string `json:"id"`
+	Tenant string `json:"tenant"`
+	Hash   string `json:"hash,omitempty"`
+	// Prefix is the start of the key, enough to recognize it
+	Prefix string `json:"prefix"`
+	// FromEnv marks keys configured through API_KEYS; once retired they stay
+	// recorded so they do not come back on the next start.
+	FromEnv   bool       `json:"from_env,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (k *APIKey) expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyStore holds the API keys tenants authenticate with: the ones from
+// API_KEYS plus those tenants created by rotating their own keys.
+type APIKeyStore struct {
+	mu      sync.RWMutex
+	path    string
+	keys    map[string]*APIKey // hash -> key
+	enabled bool
+}
+
+// NewAPIKeyStore loads persisted keys from path and adds the configured ones
+// that were not retired.
+func NewAPIKeyStore(path string, configured map[string]string) (*APIKeyStore, error) {
+	store := &APIKeyStore{path: path, keys: make(map[string]*APIKey)}
+	if path != "" {
+		var keys []*APIKey
+		if err := readJSONFile(path, &keys); err != nil {
+			return nil, fmt.Errorf("failed to load API keys: %v", err)
+		}
+		for _, k := range keys {
+			store.keys[k.Hash] = k
+		}
+	}
+
+	configuredHashes := make(map[string]bool)
+	for key := range configured {
+		configuredHashes[hashAPIKey(key)] = true
+	}
+	for hash, k := range store.keys {
+		// Removed from API_KEYS: no longer valid
+		if k.FromEnv && !configuredHashes[hash] {
+			delete(store.keys, hash)
+		}
+	}
+
+	for key, tenant := range configured {
+		hash := hashAPIKey(key)
+		if k, ok := store.keys[hash]; ok {
+			// API_KEYS decides which tenant a configured key belongs to
+			k.Tenant = tenant
+			continue
+		}
+		id, err := randomHex(8)
+		if err != nil {
+			return nil, err
+		}
+		store.keys[hash] = &APIKey{
+			ID:        id,
+			Tenant:    tenant,
+			Hash:      hash,
+			Prefix:    keyPrefix(key),
+			FromEnv:   true,
+			CreatedAt: time.Now(),
+		}
+	}
+	store.enabled = len(store.keys) > 0
+	return store, nil
+}
+
+func keyPrefix(key string) string {
+	if len(key) > 6 {
+		return key[:6]
+	}
+	return ""
+}
+
+// Enabled reports whether keys were configured at startup; without keys the
+// sandbox is open and every caller is DefaultTenant.
+func (s *APIKeyStore) Enabled() bool {
+	return s.enabled
+}
+
+// Lookup returns the key record for a presented key that is still valid.
+func (s *APIKeyStore) Lookup(key string) (APIKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.keys[hashAPIKey(key)]
+	if !ok || k.expired(time.Now()) {
+		return APIKey{}, false
+	}
+	return *k, true
+}
+
+// List returns a tenant's keys that are still valid, oldest first.
+func (s *APIKeyStore) List(tenant string) []APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	keys := []APIKey{}
+	for _, k := range s.keys {
+		if k.Tenant == tenant && !k.expired(now) {
+			key := *k
+			key.Hash = ""
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+// Rotate issues a new key for tenant and retires the key with ID current
+// after grace, so clients can switch over. The new key is only returned here.
+func (s *APIKeyStore) Rotate(tenant, current string, grace time.Duration) (string, APIKey, error) {
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	raw := "zg_" + secret
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, k := range s.keys {
+		if k.ID == current && k.Tenant == tenant && !k.expired(now) {
+			retire := now.Add(grace)
+			k.ExpiresAt = &retire
+		}
+	}
+	key := &APIKey{
+		ID:        id,
+		Tenant:    tenant,
+		Hash:      hashAPIKey(raw),
+		Prefix:    keyPrefix(raw),
+		CreatedAt: now,
+	}
+	s.keys[key.Hash] = key
+	s.pruneLocked(now)
+
+	created := *key
+	created.Hash = ""
+	return raw, created, s.saveLocked()
+}
+
+// errLastAPIKey refuses to revoke a tenant's only valid key.
+var errLastAPIKey = errors.New("cannot revoke the last API key")
+
+// Revoke retires one of tenant's keys immediately. The last valid key is
+// kept, failing with errLastAPIKey.
+func (s *APIKeyStore) Revoke(tenant, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	valid := 0
+	for _, k := range s.keys {
+		if k.Tenant == tenant && !k.expired(now) {
+			valid++
+		}
+	}
+	for _, k := range s.keys {
+		if k.ID == id && k.Tenant == tenant && !k.expired(now) {
+			if valid <= 1 {
+				return errLastAPIKey
+			}
+			k.ExpiresAt = &now
+			s.pruneLocked(now)
+			return s.saveLocked()
+		}
+	}
+	return ErrNotFound
+}
+
+// pruneLocked forgets expired keys, except configured ones which must stay
+// retired.
+func (s *APIKeyStore) pruneLocked(now time.Time) {
+	for hash, k := range s.keys {
+		if k.expired(now) && !k.FromEnv {
+			delete(s.keys, hash)
+		}
+	}
+}
+
+func (s *APIKeyStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	keys := make([]*APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	if err := writeJSONFile(s.path, keys); err != nil {
+		return fmt.Errorf("failed to write API keys: %v", err)
+	}
+	return nil
+}
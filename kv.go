@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/0glabs/0g-storage-client/kv"
+	"github.com/0glabs/0g-storage-client/node"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"github.com/openweb3/web3go"
+)
+
+const (
+	maxKVValueSize = 64 << 10
+	// A batch is one transaction, so it is kept to what one write should cost
+	maxKVBatchPairs = 256
+	maxKVBatchBytes = 1 << 20
+)
+
+// KVPair is a single key/value write to a 0G KV stream.
+type KVPair struct {
+	Key   []byte
+	Value []byte
+}
+
+// KVClient reads from a 0G KV node and writes through the storage network,
+// where each batch of writes becomes one on-chain transaction.
+type KVClient struct {
+	storage *StorageClient
+	reader  *kv.Client
+	node    *node.KvClient
+}
+
+func NewKVClient(storage *StorageClient, kvNodeRPC string) (*KVClient, error) {
+	kvNode, err := node.NewKvClient(kvNodeRPC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kv node client: %v", err)
+	}
+	return &KVClient{
+		storage: storage,
+		reader:  kv.NewClient(kvNode),
+		node:    kvNode,
+	}, nil
+}
+
+func (c *KVClient) Close() {
+	c.node.Close()
+}
+
+// StreamID maps a user-facing stream name to a KV stream ID. 32-byte hex IDs
+// are used verbatim; any other name is hashed, so the KV node must be
+// configured to sync the resulting ID.
+func StreamID(name string) common.Hash {
+	hexID := strings.TrimPrefix(name, "0x")
+	if len(hexID) == 64 && isHex(hexID) {
+		return common.HexToHash(hexID)
+	}
+	return common.BytesToHash(sha256Sum([]byte(name)))
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// kvKey is where a caller's raw key lives in a stream. Keys are prefixed with
+// the tenant, as settings and log streams are, so no tenant can reach another
+// tenant's keys through /kv.
+func kvKey(tenant, key string) []byte {
+	return []byte(tenant + "/" + key)
+}
+
+func sha256Sum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// Get returns the latest value of key, or nil if it has never been written.
+func (c *KVClient) Get(ctx context.Context, streamID common.Hash, key []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	val, err := c.reader.GetValue(ctx, streamID, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kv value: %v", err)
+	}
+	if val == nil || val.Size == 0 {
+		return nil, nil
+	}
+	return val.Data, nil
+}
+
+// Set writes all pairs to the stream in a single transaction and returns its hash.
+func (c *KVClient) Set(ctx context.Context, streamID common.Hash, pairs []KVPair) (string, error) {
+	nodes, err := c.storage.selectNodes()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	var txHash common.Hash
+	err = c.storage.withAccount(ctx, func(web3Client *web3go.Client, _ *big.Int) (common.Hash, error) {
+		batcher := kv.NewBatcher(math.MaxUint64, nodes, web3Client, sdkLogOptions...)
+		for _, p := range pairs {
+			batcher.Set(streamID, p.Key, p.Value)
+		}
+		var err error
+		txHash, err = batcher.Exec(ctx)
+		return txHash, err
+	})
+	if err != nil {
+		return "", fmt.Errorf("kv write failed: %v", err)
+	}
+	return txHash.String(), nil
+}
+
+type KVWriteResponse struct {
+	StreamID string `json:"stream_id"`
+	Keys     int    `json:"keys"`
+	TxHash   string `json:"tx_hash"`
+}
+
+type KVBatchPair struct {
+	Key string `json:"key" binding:"required"`
+	// Value is written as UTF-8 text; ValueBase64 carries binary values
+	Value       string `json:"value"`
+	ValueBase64 string `json:"value_base64"`
+}
+
+type KVBatchRequest struct {
+	Pairs []KVBatchPair `json:"pairs" binding:"required"`
+}
+
+// @Summary Write a KV value
+// @Description Writes the raw request body (max 64 KiB) as the value of key in a 0G KV stream, in one transaction. The stream ID is either a 32-byte hex KV stream ID or a name that is hashed into one. Keys are stored under the caller's tenant, so each tenant has its own keys in a shared stream. An empty body clears the key.
+// @Accept octet-stream
+// @Produce json
+// @Param stream_id path string true "Stream ID or name"
+// @Param key path string true "Key"
+// @Success 200 {object} KVWriteResponse
+// @Failure 413 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /kv/{stream_id}/{key} [put]
+func (s *Server) handleKVPut(c *gin.Context) {
+	if s.kv == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "KV is not configured (set KV_NODE_RPC)"})
+		return
+	}
+
+	value, err := io.ReadAll(io.LimitReader(c.Request.Body, maxKVValueSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
+	if len(value) > maxKVValueSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Values are limited to %d bytes", maxKVValueSize)})
+		return
+	}
+
+	id := StreamID(c.Param("stream_id"))
+	txHash, err := s.kv.Set(c.Request.Context(), id, []KVPair{{Key: kvKey(tenantFrom(c), c.Param("key")), Value: value}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, KVWriteResponse{
+		StreamID: id.Hex(),
+		Keys:     1,
+		TxHash:   txHash,
+	})
+}
+
+// @Summary Read a KV value
+// @Description Returns the latest value of the caller's key in a 0G KV stream as it was written. Values written moments ago may not be readable until the KV node has synced them.
+// @Produce octet-stream
+// @Param stream_id path string true "Stream ID or name"
+// @Param key path string true "Key"
+// @Success 200 {file} binary
+// @Failure 404 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /kv/{stream_id}/{key} [get]
+func (s *Server) handleKVGet(c *gin.Context) {
+	if s.kv == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "KV is not configured (set KV_NODE_RPC)"})
+		return
+	}
+
+	id := StreamID(c.Param("stream_id"))
+	value, err := s.kv.Get(c.Request.Context(), id, kvKey(tenantFrom(c), c.Param("key")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if value == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+		return
+	}
+	c.Header("X-KV-Stream-ID", id.Hex())
+	c.Data(http.StatusOK, "application/octet-stream", value)
+}
+
+// @Summary Write several KV values at once
+// @Description Writes up to 256 of the caller's keys (1 MiB of values in total) to a 0G KV stream in a single transaction, so they become visible together. Each value is given as text in value or as binary in value_base64.
+// @Accept json
+// @Produce json
+// @Param stream_id path string true "Stream ID or name"
+// @Param request body KVBatchRequest true "Keys and values"
+// @Success 200 {object} KVWriteResponse
+// @Failure 400 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /kv/{stream_id} [post]
+func (s *Server) handleKVBatch(c *gin.Context) {
+	if s.kv == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "KV is not configured (set KV_NODE_RPC)"})
+		return
+	}
+
+	var req KVBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Pairs) == 0 || len(req.Pairs) > maxKVBatchPairs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A batch takes 1 to %d pairs", maxKVBatchPairs)})
+		return
+	}
+
+	tenant := tenantFrom(c)
+	pairs := make([]KVPair, 0, len(req.Pairs))
+	total := 0
+	for i, p := range req.Pairs {
+		if p.Key == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("pairs[%d]: key is required", i)})
+			return
+		}
+		value := []byte(p.Value)
+		if p.ValueBase64 != "" {
+			if p.Value != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("pairs[%d]: set value or value_base64, not both", i)})
+				return
+			}
+			decoded, err := base64.StdEncoding.DecodeString(p.ValueBase64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("pairs[%d]: invalid value_base64", i)})
+				return
+			}
+			value = decoded
+		}
+		if len(value) > maxKVValueSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("pairs[%d]: values are limited to %d bytes", i, maxKVValueSize)})
+			return
+		}
+		if total += len(value); total > maxKVBatchBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("A batch's values are limited to %d bytes in total", maxKVBatchBytes)})
+			return
+		}
+		pairs = append(pairs, KVPair{Key: kvKey(tenant, p.Key), Value: value})
+	}
+
+	id := StreamID(c.Param("stream_id"))
+	txHash, err := s.kv.Set(c.Request.Context(), id, pairs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, KVWriteResponse{
+		StreamID: id.Hex(),
+		Keys:     len(pairs),
+		TxHash:   txHash,
+	})
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// LifecycleHide hides catalog entries created more than AfterDays ago.
+	LifecycleHide = "hide"
+	// LifecyclePurgeCache drops cached copies not served for AfterDays.
+	LifecyclePurgeCache = "purge_cache"
+	// LifecycleNotifyLinkExpiry warns AfterDays before a short link expires.
+	LifecycleNotifyLinkExpiry = "notify_link_expiry"
+)
+
+var lifecycleActions = map[string]bool{
+	LifecycleHide:             true,
+	LifecyclePurgeCache:       true,
+	LifecycleNotifyLinkExpiry: true,
+}
+
+// LifecycleRule is evaluated against one tenant's data on every lifecycle run.
+type LifecycleRule struct {
+	ID        string    `json:"id"`
+	Tenant    string    `json:"tenant"`
+	Action    string    `json:"action"`
+	AfterDays int       `json:"after_days"`
+	Prefix    string    `json:"prefix,omitempty"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+
+	LastRunAt    *time.Time `json:"last_run_at,omitempty"`
+	LastAffected int        `json:"last_affected"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+type LifecycleRuleRequest struct {
+	Tenant    string `json:"tenant" binding:"required"`
+	Action    string `json:"action" binding:"required"`
+	AfterDays int    `json:"after_days"`
+	// Prefix limits hide rules to filenames starting with it
+	Prefix  string `json:"prefix"`
+	Enabled *bool  `json:"enabled"`
+}
+
+func (r *LifecycleRuleRequest) validate() error {
+	if !lifecycleActions[r.Action] {
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	if r.AfterDays <= 0 {
+		return fmt.Errorf("after_days must be positive")
+	}
+	return nil
+}
+
+// LifecycleStore holds lifecycle rules, persisted to path.
+type LifecycleStore struct {
+	mu    sync.RWMutex
+	path  string
+	rules map[string]*LifecycleRule
+}
+
+func NewLifecycleStore(path string) (*LifecycleStore, error) {
+	store := &LifecycleStore{path: path, rules: make(map[string]*LifecycleRule)}
+	if path == "" {
+		return store, nil
+	}
+
+	var rules []*LifecycleRule
+	if err := readJSONFile(path, &rules); err != nil {
+		return nil, fmt.Errorf("failed to load lifecycle rules: %v", err)
+	}
+	for _, r := range rules {
+		store.rules[r.ID] = r
+	}
+	return store, nil
+}
+
+func (s *LifecycleStore) Create(req LifecycleRuleRequest) (LifecycleRule, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return LifecycleRule{}, err
+	}
+	rule := &LifecycleRule{
+		ID:        id,
+		Tenant:    req.Tenant,
+		Action:    req.Action,
+		AfterDays: req.AfterDays,
+		Prefix:    req.Prefix,
+		Enabled:   req.Enabled == nil || *req.Enabled,
+		CreatedAt: time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules[id] = rule
+	return *rule, s.saveLocked()
+}
+
+func (s *LifecycleStore) Update(id string, req LifecycleRuleRequest) (LifecycleRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rule, ok := s.rules[id]
+	if !ok {
+		return LifecycleRule{}, ErrNotFound
+	}
+	rule.Tenant = req.Tenant
+	rule.Action = req.Action
+	rule.AfterDays = req.AfterDays
+	rule.Prefix = req.Prefix
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	return *rule, s.saveLocked()
+}
+
+func (s *LifecycleStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rules[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.rules, id)
+	return s.saveLocked()
+}
+
+func (s *LifecycleStore) List() []LifecycleRule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rules := make([]LifecycleRule, 0, len(s.rules))
+	for _, r := range s.rules {
+		rules = append(rules, *r)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].CreatedAt.Before(rules[j].CreatedAt) })
+	return rules
+}
+
+func (s *LifecycleStore) recordRun(id string, at time.Time, affected int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rule, ok := s.rules[id]
+	if !ok {
+		return
+	}
+	rule.LastRunAt = &at
+	rule.LastAffected = affected
+	rule.LastError = ""
+	if err != nil {
+		rule.LastError = err.Error()
+	}
+	if saveErr := s.saveLocked(); saveErr != nil {
+		log.Printf("⚠️  %v", saveErr)
+	}
+}
+
+func (s *LifecycleStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	rules := make([]*LifecycleRule, 0, len(s.rules))
+	for _, r := range s.rules {
+		rules = append(rules, r)
+	}
+	if err := writeJSONFile(s.path, rules); err != nil {
+		return fmt.Errorf("failed to write lifecycle rules: %v", err)
+	}
+	return nil
+}
+
+// applyLifecycleRule runs one rule and returns how many items it affected.
+func (s *Server) applyLifecycleRule(rule LifecycleRule) (int, error) {
+	window := time.Duration(rule.AfterDays) * 24 * time.Hour
+
+	switch rule.Action {
+	case LifecycleHide:
+		return s.catalog.HideOlderThan(rule.Tenant, time.Now().Add(-window), rule.Prefix)
+
+	case LifecyclePurgeCache:
+		if s.cache == nil {
+			return 0, nil
+		}
+		purged := 0
+		for _, root := range s.catalog.Roots(rule.Tenant) {
+			if last, ok := s.cache.LastAccess(root); ok && time.Since(last) > window && s.cache.Remove(root) {
+				purged++
+			}
+		}
+		return purged, nil
+
+	case LifecycleNotifyLinkExpiry:
+		links := s.links.Expiring(rule.Tenant, window)
+		for _, link := range links {
+			s.webhooks.Publish(WebhookEvent{
+				Type:      EventLinkExpiring,
+				Tenant:    link.Owner,
+				RootHash:  link.RootHash,
+				LinkID:    link.ID,
+				ExpiresAt: link.ExpiresAt,
+			})
+			s.links.MarkExpiryNotified(link.ID)
+		}
+		return len(links), nil
+	}
+	return 0, fmt.Errorf("unknown action %q", rule.Action)
+}
+
+// localLifecycleAction reports whether an action only touches this replica's
+// local state and therefore has to run on every replica.
+func localLifecycleAction(action string) bool {
+	return action == LifecyclePurgeCache
+}
+
+// runLifecycleRules applies every enabled rule that include selects once.
+func (s *Server) runLifecycleRules(include func(LifecycleRule) bool) []LifecycleRule {
+	for _, rule := range s.lifecycle.List() {
+		if !rule.Enabled || !include(rule) {
+			continue
+		}
+		started := time.Now()
+		affected, err := s.applyLifecycleRule(rule)
+		if err != nil {
+			log.Printf("⚠️  Lifecycle rule %s failed: %v", rule.ID, err)
+		} else if affected > 0 {
+			log.Printf("♻️  Lifecycle rule %s (%s, tenant %s) affected %d items", rule.ID, rule.Action, rule.Tenant, affected)
+		}
+		s.lifecycle.recordRun(rule.ID, started, affected, err)
+	}
+	return s.lifecycle.List()
+}
+
+// runLifecycle applies lifecycle rules every interval until ctx is done.
+func (s *Server) runLifecycle(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.runLifecycleRules(func(r LifecycleRule) bool { return localLifecycleAction(r.Action) })
+			// The rest touch shared state and send notifications: one replica only
+			s.runOnce("lifecycle", interval, func() {
+				s.runLifecycleRules(func(r LifecycleRule) bool { return !localLifecycleAction(r.Action) })
+			})
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// @Summary List lifecycle rules
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Success 200 {array} LifecycleRule
+// @Router /admin/lifecycle [get]
+func (s *Server) handleListLifecycleRules(c *gin.Context) {
+	c.JSON(http.StatusOK, s.lifecycle.List())
+}
+
+// @Summary Create a lifecycle rule
+// @Description Actions: hide (hide a tenant's entries older than after_days, optionally only filenames starting with prefix), purge_cache (drop cached copies of the tenant's objects not served for after_days), notify_link_expiry (send a link.expiring webhook after_days before a short link expires)
+// @Accept json
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Param request body LifecycleRuleRequest true "Rule"
+// @Success 200 {object} LifecycleRule
+// @Router /admin/lifecycle [post]
+func (s *Server) handleCreateLifecycleRule(c *gin.Context) {
+	var req LifecycleRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule, err := s.lifecycle.Create(req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// @Summary Update a lifecycle rule
+// @Accept json
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Param id path string true "Rule ID"
+// @Param request body LifecycleRuleRequest true "Rule"
+// @Success 200 {object} LifecycleRule
+// @Router /admin/lifecycle/{id} [put]
+func (s *Server) handleUpdateLifecycleRule(c *gin.Context) {
+	var req LifecycleRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule, err := s.lifecycle.Update(c.Param("id"), req)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// @Summary Delete a lifecycle rule
+// @Param X-Admin-Token header string true "Admin token"
+// @Param id path string true "Rule ID"
+// @Success 204
+// @Router /admin/lifecycle/{id} [delete]
+func (s *Server) handleDeleteLifecycleRule(c *gin.Context) {
+	err := s.lifecycle.Delete(c.Param("id"))
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Run lifecycle rules now
+// @Description Applies every enabled rule immediately instead of waiting for the scheduler. Returns 409 while a run, scheduled or manual, is in progress on any replica.
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Success 200 {array} LifecycleRule
+// @Router /admin/lifecycle/run [post]
+func (s *Server) handleRunLifecycle(c *gin.Context) {
+	ctx := c.Request.Context()
+	// The scheduler's lease, so a manual run never overlaps a scheduled one
+	token, ok, err := s.locks.TryAcquire(ctx, "lifecycle", 10*time.Minute)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "A lifecycle run is already in progress"})
+		return
+	}
+	defer s.locks.Release(context.Background(), "lifecycle", token)
+
+	c.JSON(http.StatusOK, s.runLifecycleRules(func(LifecycleRule) bool { return true }))
+}
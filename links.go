@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	linkIDLength   = 7
+	linkIDAlphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+// Link is an immutable short alias for /gw/{root_hash}/{path}.
+type Link struct {
+	ID          string     `json:"id"`
+	RootHash    string     `json:"root_hash"`
+	Path        string     `json:"path,omitempty"`
+	Owner       string     `json:"owner"`
+	Clicks      int64      `json:"clicks"`
+	LastClickAt *time.Time `json:"last_click_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+
+	// ExpiryNotified is set once the owner was warned about the expiry.
+	ExpiryNotified bool `json:"expiry_notified,omitempty"`
+	// SingleUse links redirect once; UsedAt is when they did
+	SingleUse bool       `json:"single_use,omitempty"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
+func (l *Link) Expired() bool {
+	return l.ExpiresAt != nil && time.Now().After(*l.ExpiresAt)
+}
+
+// Target is the gateway URL the link redirects to. Each segment of the path
+// is escaped, so names with "?", "#" or "%" reach the gateway as they are.
+func (l *Link) Target() string {
+	target := "/gw/" + url.PathEscape(l.RootHash) + "/"
+	if l.Path != "" {
+		segments := strings.Split(strings.TrimPrefix(l.Path, "/"), "/")
+		for i, seg := range segments {
+			segments[i] = url.PathEscape(seg)
+		}
+		target += strings.Join(segments, "/")
+	}
+	return target
+}
+
+type CreateLinkRequest struct {
+	RootHash string `json:"root_hash" binding:"required"`
+	Path     string `json:"path"`
+	// ExpiresIn is an optional Go duration such as "72h"
+	ExpiresIn string `json:"expires_in"`
+	// SingleUse makes the link stop working after it is followed once
+	SingleUse bool `json:"single_use"`
+}
+
+type LinkResponse struct {
+	Link
+	ShortURL string `json:"short_url"`
+	Target   string `json:"target"`
+}
+
+// LinkStore keeps short links in memory, persisting them to path. Click
+// counters change on every redirect, so they are flushed periodically rather
+// than on each hit.
+type LinkStore struct {
+	mu    sync.RWMutex
+	path  string
+	links map[string]*Link
+	dirty bool
+}
+
+func NewLinkStore(path string) (*LinkStore, error) {
+	store := &LinkStore{path: path, links: make(map[string]*Link)}
+	if path == "" {
+		return store, nil
+	}
+
+	var links []*Link
+	if err := readJSONFile(path, &links); err != nil {
+		return nil, fmt.Errorf("failed to load links: %v", err)
+	}
+	for _, l := range links {
+		store.links[l.ID] = l
+	}
+	return store, nil
+}
+
+func newLinkID() (string, error) {
+	id := make([]byte, linkIDLength)
+	max := big.NewInt(int64(len(linkIDAlphabet)))
+	for i := range id {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		id[i] = linkIDAlphabet[n.Int64()]
+	}
+	return string(id), nil
+}
+
+func (s *LinkStore) Create(rootHash, linkPath, owner string, expiresAt *time.Time, singleUse bool) (Link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var id string
+	for {
+		candidate, err := newLinkID()
+		if err != nil {
+			return Link{}, fmt.Errorf("failed to generate link id: %v", err)
+		}
+		if _, taken := s.links[candidate]; !taken {
+			id = candidate
+			break
+		}
+	}
+
+	link := &Link{
+		ID:        id,
+		RootHash:  rootHash,
+		Path:      linkPath,
+		Owner:     owner,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+		SingleUse: singleUse,
+	}
+	s.links[id] = link
+	return *link, s.saveLocked()
+}
+
+func (s *LinkStore) Get(id string) (Link, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	link, ok := s.links[id]
+	if !ok {
+		return Link{}, false
+	}
+	return *link, true
+}
+
+// Hit counts a click and returns the link.
+func (s *LinkStore) Hit(id string) (Link, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link, ok := s.links[id]
+	if !ok {
+		return Link{}, false
+	}
+	now := time.Now()
+	link.Clicks++
+	link.LastClickAt = &now
+	s.dirty = true
+	return *link, true
+}
+
+// Use marks a single-use link used, failing with 410 if it already was.
+func (s *LinkStore) Use(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link, ok := s.links[id]
+	if !ok {
+		return newAPIError(http.StatusNotFound, "Link not found")
+	}
+	if link.UsedAt != nil {
+		return newAPIError(http.StatusGone, "This link was already used")
+	}
+	now := time.Now()
+	link.UsedAt = &now
+	return s.saveLocked()
+}
+
+// Expiring returns owner's links that expire within the given window and
+// whose owner has not been warned yet.
+func (s *LinkStore) Expiring(owner string, within time.Duration) []Link {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	deadline := time.Now().Add(within)
+	var links []Link
+	for _, l := range s.links {
+		if l.Owner == owner && l.ExpiresAt != nil && !l.ExpiryNotified && !l.Expired() && l.ExpiresAt.Before(deadline) {
+			links = append(links, *l)
+		}
+	}
+	return links
+}
+
+// MarkExpiryNotified records that the owner of a link was warned.
+func (s *LinkStore) MarkExpiryNotified(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.links[id]; ok {
+		l.ExpiryNotified = true
+		s.dirty = true
+	}
+}
+
+// Flush persists click counters gathered since the last save.
+func (s *LinkStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	return s.saveLocked()
+}
+
+// RunFlusher flushes click counters every interval until ctx is done.
+func (s *LinkStore) RunFlusher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				log.Printf("⚠️  Failed to persist link stats: %v", err)
+			}
+		case <-ctx.Done():
+			s.Flush()
+			return
+		}
+	}
+}
+
+func (s *LinkStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	links := make([]*Link, 0, len(s.links))
+	for _, l := range s.links {
+		links = append(links, l)
+	}
+	if err := writeJSONFile(s.path, links); err != nil {
+		return fmt.Errorf("failed to write links: %v", err)
+	}
+	s.dirty = false
+	return nil
+}
+
+func linkResponse(c *gin.Context, link Link) LinkResponse {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return LinkResponse{
+		Link:     link,
+		ShortURL: fmt.Sprintf("%s://%s/l/%s", scheme, c.Request.Host, link.ID),
+		Target:   link.Target(),
+	}
+}
+
+// parseShareTTL reads an optional link lifetime such as "72h"; zero means the
+// link does not expire.
+func parseShareTTL(raw, param string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl <= 0 {
+		return 0, newAPIError(http.StatusBadRequest, "%s must be a positive duration such as 72h", param)
+	}
+	return ttl, nil
+}
+
+// shareUpload creates a short link to a file just uploaded, for the "upload
+// and share" flow. It is only called when the client asked for one; with
+// share_single_use=true the link works once.
+func (s *Server) shareUpload(c *gin.Context, resp *UploadResponse, ttl time.Duration) {
+	if resp.Quarantine != nil {
+		// Nothing is stored to link to until the upload is released
+		return
+	}
+	var expiresAt *time.Time
+	if ttl > 0 {
+		t := time.Now().Add(ttl)
+		expiresAt = &t
+	}
+	singleUse, _ := strconv.ParseBool(c.Query("share_single_use"))
+	link, err := s.links.Create(resp.RootHash, "", tenantFrom(c), expiresAt, singleUse)
+	if err != nil {
+		// The upload itself succeeded; the client can still create a link later
+		log.Printf("⚠️  Failed to create share link for %s: %v", resp.RootHash, err)
+		return
+	}
+	share := linkResponse(c, link)
+	resp.Share = &share
+}
+
+// @Summary Create a short link
+// @Description Creates an immutable short ID that redirects to /gw/{root_hash}/{path}. With expires_in the link stops working after that duration, and with single_use it redirects only once, on any replica, and answers 410 afterwards.
+// @Accept json
+// @Produce json
+// @Param request body CreateLinkRequest true "Link target"
+// @Success 200 {object} LinkResponse
+// @Security ApiKeyAuth
+// @Router /links [post]
+func (s *Server) handleCreateLink(c *gin.Context) {
+	var req CreateLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	linkPath := ""
+	if strings.Trim(req.Path, "/") != "" {
+		clean, err := normalizeManifestPath(req.Path)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		linkPath = clean
+		if strings.HasSuffix(req.Path, "/") {
+			linkPath += "/"
+		}
+	}
+
+	var expiresAt *time.Time
+	ttl, err := parseShareTTL(req.ExpiresIn, "expires_in")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if ttl > 0 {
+		t := time.Now().Add(ttl)
+		expiresAt = &t
+	}
+
+	link, err := s.links.Create(req.RootHash, linkPath, tenantFrom(c), expiresAt, req.SingleUse)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, linkResponse(c, link))
+}
+
+// @Summary Short link statistics
+// @Produce json
+// @Param id path string true "Link ID"
+// @Param fields query string false "Comma-separated fields to return"
+// @Param envelope query bool false "Wrap the response in {data, count}"
+// @Success 200 {object} LinkResponse
+// @Security ApiKeyAuth
+// @Router /links/{id} [get]
+func (s *Server) handleGetLink(c *gin.Context) {
+	link, ok := s.links.Get(c.Param("id"))
+	if !ok || link.Owner != tenantFrom(c) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		return
+	}
+	respondSelected(c, http.StatusOK, linkResponse(c, link))
+}
+
+// handleFollowLink redirects /l/{id} to the gateway URL behind the link.
+func (s *Server) handleFollowLink(c *gin.Context) {
+	if link, ok := s.links.Get(c.Param("id")); ok && ownerOffDomain(c, link.Owner) {
+		return
+	}
+	link, ok := s.links.Hit(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		return
+	}
+	if link.Expired() {
+		c.JSON(http.StatusGone, gin.H{"error": "Link has expired"})
+		return
+	}
+	if link.SingleUse {
+		until := time.Now().Add(singleUseLeaseTTL)
+		if link.ExpiresAt != nil {
+			until = *link.ExpiresAt
+		}
+		if err := s.spendOnce(c.Request.Context(), "link/"+link.ID, until); err != nil {
+			respondError(c, err)
+			return
+		}
+		if err := s.links.Use(link.ID); err != nil {
+			respondError(c, err)
+			return
+		}
+	}
+	c.Redirect(http.StatusFound, link.Target())
+}
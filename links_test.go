@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestLinkTargetEscapesEachPathSegment(t *testing.T) {
+	root := "0xab"
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{"", "/gw/0xab/"},
+		{"/docs/index.html", "/gw/0xab/docs/index.html"},
+		{"a b/c?d#e%f.txt", "/gw/0xab/a%20b/c%3Fd%23e%25f.txt"},
+	} {
+		l := &Link{RootHash: root, Path: tc.path}
+		if got := l.Target(); got != tc.want {
+			t.Errorf("Target(%q) = %s, want %s", tc.path, got, tc.want)
+		}
+	}
+}
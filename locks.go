@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Locker hands out leases that at most one replica holds at a time.
+type Locker interface {
+	// TryAcquire takes the lease name for ttl unless someone else holds it,
+	// returning a token to release it with.
+	TryAcquire(ctx context.Context, name string, ttl time.Duration) (token string, ok bool, err error)
+	// Release gives up a lease early if token still holds it.
+	Release(ctx context.Context, name, token string) error
+}
+
+// instanceID identifies this replica as a lease holder.
+func instanceID() string {
+	host, _ := os.Hostname()
+	suffix, _ := randomHex(4)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), suffix)
+}
+
+func newLeaseToken(owner string) (string, error) {
+	nonce, err := randomHex(8)
+	if err != nil {
+		return "", err
+	}
+	return owner + "/" + nonce, nil
+}
+
+type localLease struct {
+	token   string
+	expires time.Time
+}
+
+// localLocker coordinates within one process, for single-replica setups.
+type localLocker struct {
+	owner string
+
+	mu     sync.Mutex
+	leases map[string]localLease
+}
+
+func newLocalLocker(owner string) *localLocker {
+	return &localLocker{owner: owner, leases: make(map[string]localLease)}
+}
+
+func (l *localLocker) TryAcquire(ctx context.Context, name string, ttl time.Duration) (string, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lease, ok := l.leases[name]; ok && time.Now().Before(lease.expires) {
+		return "", false, nil
+	}
+	token, err := newLeaseToken(l.owner)
+	if err != nil {
+		return "", false, err
+	}
+	l.leases[name] = localLease{token: token, expires: time.Now().Add(ttl)}
+	return token, true, nil
+}
+
+func (l *localLocker) Release(ctx context.Context, name, token string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lease, ok := l.leases[name]; ok && lease.token == token {
+		delete(l.leases, name)
+	}
+	return nil
+}
+
+// releaseScript deletes a lease only if it still belongs to the caller.
+const releaseScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+
+// redisLocker coordinates replicas sharing a Redis instance.
+type redisLocker struct {
+	owner  string
+	redis  *RedisClient
+	prefix string
+}
+
+func newRedisLocker(owner string, redis *RedisClient) *redisLocker {
+	return &redisLocker{owner: owner, redis: redis, prefix: "0g-storage:lock:"}
+}
+
+func (l *redisLocker) TryAcquire(ctx context.Context, name string, ttl time.Duration) (string, bool, error) {
+	token, err := newLeaseToken(l.owner)
+	if err != nil {
+		return "", false, err
+	}
+	_, err = l.redis.Do(ctx, "SET", l.prefix+name, token, "NX", "PX", fmt.Sprint(ttl.Milliseconds()))
+	if err == errRedisNil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to acquire lease %s: %v", name, err)
+	}
+	return token, true, nil
+}
+
+func (l *redisLocker) Release(ctx context.Context, name, token string) error {
+	if _, err := l.redis.Do(ctx, "EVAL", releaseScript, "1", l.prefix+name, token); err != nil {
+		return fmt.Errorf("failed to release lease %s: %v", name, err)
+	}
+	return nil
+}
+
+// runOnce runs fn if this replica wins the lease for the current period.
+// That lease is kept for the whole period rather than released after fn, so
+// replicas whose timers fire a little later skip the period too. fn itself
+// runs under the lease name, which manual runs of the same work take as
+// well, so the two never overlap. It is only for work on state the replicas
+// share; work on a replica's own state has to run on every replica.
+func (s *Server) runOnce(name string, period time.Duration, fn func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, ok, err := s.locks.TryAcquire(ctx, name+"/period", period*9/10)
+	if err != nil {
+		log.Printf("⚠️  Skipping %s: %v", name, err)
+		return
+	}
+	if !ok {
+		return
+	}
+	token, ok, err := s.locks.TryAcquire(ctx, name, period)
+	if err != nil {
+		log.Printf("⚠️  Skipping %s: %v", name, err)
+		return
+	}
+	if !ok {
+		return
+	}
+	defer s.locks.Release(context.Background(), name, token)
+	fn()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	zgcommon "github.com/0glabs/0g-storage-client/common"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	LogText = "text"
+	LogJSON = "json"
+)
+
+// sdkLogOptions route the 0G SDK's own logging (node selection, segment
+// uploads, submitted transactions) to the configured format and level.
+var sdkLogOptions []zgcommon.LogOption
+
+// setupLogging makes every log line structured: slog's default logger
+// writes format (text or json) at level and above, and the log package's
+// lines go through it too, at info, or warn for those flagged ⚠️.
+func setupLogging(format, level string) error {
+	var min slog.Level
+	if err := min.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid LOG_LEVEL %q (want debug, info, warn or error)", level)
+	}
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	sdk := logrus.New()
+	sdk.SetOutput(os.Stderr)
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case LogText:
+		handler = slog.NewTextHandler(os.Stderr, opts)
+		sdk.SetFormatter(&logrus.TextFormatter{DisableColors: true, FullTimestamp: true})
+	case LogJSON:
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+		sdk.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT %q (want text or json)", format)
+	}
+	slog.SetDefault(slog.New(&warnHandler{Handler: handler, min: min}))
+
+	sdkLevel, err := logrus.ParseLevel(min.String())
+	if err != nil {
+		sdkLevel = logrus.InfoLevel
+	}
+	sdk.SetLevel(sdkLevel)
+	sdkLogOptions = []zgcommon.LogOption{{Logger: sdk, LogLevel: sdkLevel}}
+	return nil
+}
+
+// warnHandler filters records below min, logging the log package's ⚠️
+// lines, which all arrive at info, as warnings.
+type warnHandler struct {
+	slog.Handler
+	min slog.Level
+}
+
+func (h *warnHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.min || (level == slog.LevelInfo && h.min <= slog.LevelWarn)
+}
+
+func (h *warnHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level == slog.LevelInfo && strings.HasPrefix(r.Message, "⚠️") {
+		r.Level = slog.LevelWarn
+	}
+	if r.Level < h.min {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *warnHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &warnHandler{Handler: h.Handler.WithAttrs(attrs), min: h.min}
+}
+
+func (h *warnHandler) WithGroup(name string) slog.Handler {
+	return &warnHandler{Handler: h.Handler.WithGroup(name), min: h.min}
+}
+
+// requestLogger logs with the request's ID, which is its trace ID.
+func requestLogger(c *gin.Context) *slog.Logger {
+	if id := traceFrom(c).ID; id != "" {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// logRequest writes the access log, one line per request once it is
+// answered. Server errors are logged as errors and client errors as
+// warnings.
+func (s *Server) logRequest(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	if strings.HasPrefix(c.Request.URL.Path, "/swagger/") {
+		return
+	}
+
+	status := c.Writer.Status()
+	level := slog.LevelInfo
+	switch {
+	case status >= 500:
+		level = slog.LevelError
+	case status >= 400:
+		level = slog.LevelWarn
+	}
+	attrs := []any{
+		"method", c.Request.Method,
+		"path", c.Request.URL.Path,
+		"route", c.FullPath(),
+		"status", status,
+		"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+		"bytes", c.Writer.Size(),
+		"client_ip", c.ClientIP(),
+	}
+	if tenant := c.GetString(tenantContextKey); tenant != "" {
+		attrs = append(attrs, "tenant", tenant)
+	}
+	if errs := c.Errors.String(); errs != "" {
+		attrs = append(attrs, "errors", errs)
+	}
+	requestLogger(c).Log(c.Request.Context(), level, "request", attrs...)
+}
@@ -4,22 +4,33 @@
 // @host           localhost:8080
 // @BasePath       /api/v1
 // @schemes        http https
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
 
 package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"math/big"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/0glabs/0g-storage-client/common/blockchain"
+	"github.com/0glabs/0g-storage-client/core"
 	"github.com/0glabs/0g-storage-client/indexer"
+	"github.com/0glabs/0g-storage-client/node"
 	"github.com/0glabs/0g-storage-client/transfer"
 	_ "github.com/0glabs/0g-storage-starter/docs"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/openweb3/web3go"
@@ -39,84 +50,329 @@ type StorageClient struct {
 	web3Client    *web3go.Client
 	indexerClient *indexer.Client
 	ctx           context.Context
+
+	replicas uint
+	finality transfer.FinalityRequirement
+	timeout  time.Duration
+
+	// prober keeps node latencies that steer node selection
+	prober *NodeProber
+	// integrity records nodes that served segments failing verification
+	integrity *IntegrityMonitor
+	// hasher computes the roots of large files in parallel
+	hasher *RootHasher
+	// wallets spreads submissions across several accounts (WALLET_POOL_KEYS)
+	wallets *WalletPool
+	// partials keeps uploads whose segments were not all accepted, to be
+	// retried for up to resumePasses rounds of node selection
+	partials     *PartialUploads
+	resumePasses int
+	// retry is how often node selection and segment transfers are tried
+	retry RetryPolicy
+	// pool caches health-checked node selections (NODE_POOL_TTL)
+	pool *NodePool
+	// submitGas averages the gas mined submissions used, for fee estimates
+	submitGas   submitGasAverage
+	sectorPrice sectorPrice
+	// account is the wallet's address; uploads are refused while it cannot
+	// pay for them, and its balance is reported low under lowBalance
+	account    common.Address
+	lowBalance *big.Int
 }
 
 type UploadResponse struct {
-	RootHash string `json:"root_hash"`
-	TxHash   string `json:"tx_hash"`
+	RootHash     string `json:"root_hash"`
+	TxHash       string `json:"tx_hash"`
+	Deduplicated bool   `json:"deduplicated,omitempty"`
+	RefCount     int    `json:"ref_count,omitempty"`
+	// Share is a short link to the file, when the upload asked for one
+	Share *LinkResponse `json:"share,omitempty"`
+	// Stages is the time in milliseconds spent in each pipeline stage
+	Stages map[string]float64 `json:"stage_ms,omitempty"`
+	// Transforms lists the transforms that rewrote the file before storing
+	Transforms []string `json:"transforms,omitempty"`
+	// ID is the file's public ID, when PUBLIC_ID_SCHEME is set
+	ID string `json:"id,omitempty"`
+	// Quarantine is set, with no root or transaction hash, when the upload
+	// policy held the upload for review
+	Quarantine *QuarantinedUpload `json:"quarantine,omitempty"`
+	// Encryption is how the file was encrypted, when it was; keep the key ID
+	// and nonce with the key
+	Encryption *EncryptionInfo `json:"encryption,omitempty"`
+	// Replicas is how many storage nodes this upload stored each segment
+	// on; it is not known for content that was already stored
+	Replicas uint `json:"replicas,omitempty"`
+
+	// newReference is set when the upload gave the tenant a reference it did
+	// not hold before, i.e. one that a rollback may remove again.
+	newReference bool
 }
 
 // @Summary Upload a file to 0G Storage
-// @Description Upload a file to 0G Storage network
+// @Description Upload a file to 0G Storage network. Content that is already stored is not uploaded again; the caller receives a reference to the existing object instead. The body is streamed to disk, so chunked requests without Content-Length work; files over MAX_UPLOAD_BYTES are rejected with 413.
 // @Accept multipart/form-data
 // @Produce json
 // @Param file formData file true "File to upload"
+// @Param replicas formData int false "Storage nodes to store each segment on (up to MAX_UPLOAD_REPLICAS; also accepted as a query parameter); sent before the file"
+// @Param share_ttl query string false "Also create a share link expiring after this duration (e.g. 72h, or 0 for no expiry)"
+// @Param share_single_use query bool false "Make the share link work only once"
+// @Param async query bool false "Answer 202 with a job ID right away and upload in the background; poll GET /jobs/{id} for the result"
+// @Param callback_url query string false "URL sent the upload.finalized or upload.failed event of this upload"
+// @Param X-Callback-Secret header string false "Secret the callback's X-Webhook-Signature is keyed with (default: WEBHOOK_SECRET)"
+// @Param encrypt query bool false "Encrypt the file with AES-256-GCM under the gateway's master key before storing it"
+// @Param X-Encryption-Key header string false "Encrypt the file under this key instead (32 bytes, base64); it must be sent again to download"
 // @Success 200 {object} UploadResponse
+// @Success 202 {object} AsyncUploadResponse
+// @Security ApiKeyAuth
 // @Router /upload [post]
 func (s *Server) handleUpload(c *gin.Context) {
-	file, err := c.FormFile("file")
+	if limit := s.maxFileBytesFor(tenantFrom(c)); limit > 0 && c.Request.ContentLength > limit {
+		respondError(c, uploadTooLarge(limit))
+		return
+	}
+	share, shareTTL, err := shareRequested(c.Query("share_ttl"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	callbacks, err := s.callbackRequested(c.Query("callback_url"), c.GetHeader("X-Callback-Secret"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	encryption, err := s.encryption.Requested(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No file provided"})
+		respondError(c, err)
+		return
+	}
+	if s.spool.Remote() {
+		s.handleUploadRemoteSpool(c, share, shareTTL, callbacks, encryption)
 		return
 	}
 
-	// Create temp file
-	tempFile := filepath.Join(os.TempDir(), file.Filename)
-	if err := c.SaveUploadedFile(file, tempFile); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+	part, fields, ok := filePart(c)
+	if !ok {
+		return
+	}
+	defer part.Close()
+	tuning, err := s.uploadTuning(c, fields)
+	if err != nil {
+		respondError(c, err)
 		return
 	}
-	defer os.Remove(tempFile)
 
-	// Upload to 0G Storage
-	txHash, rootHash, err := s.client.UploadFile(tempFile)
+	// Stage the file in the spool, counting its size as it streams in
+	timer := s.stages.NewTimer(c.Request.Context())
+	start := time.Now()
+	admission := s.admissionReader(part, tenantFrom(c), part.FileName())
+	body := newUploadLimitReader(admission, s.maxUploadBytes)
+	staged, err := s.spool.Stage(body, "upload-*")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if body.Exceeded() {
+			err = uploadTooLarge(s.maxUploadBytes)
+		}
+		if rejected := admission.Rejected(); rejected != nil {
+			err = rejected
+		}
+		respondError(c, err)
 		return
 	}
+	timer.Since(StageSpool, start)
 
-	c.JSON(http.StatusOK, UploadResponse{
-		RootHash: rootHash,
-		TxHash:   txHash,
-	})
+	s.respondUpload(c, uploadRequest{
+		Tenant:   tenantFrom(c),
+		Class:    requestClassFrom(c),
+		Tuning:   tuning,
+		KeyID:    c.GetString(keyIDContextKey),
+		Origin:   requestOriginFrom(c),
+		Flags:    featureFlagsFrom(c),
+		Timer:    timer,
+		Path:     staged.Path,
+		Filename: part.FileName(),
+		Size:     staged.Size(),
+
+		Callbacks:  callbacks,
+		Encryption: encryption,
+	}, share, shareTTL, staged.Release)
 }
 
 // @Summary Download a file from 0G Storage
 // @Description Download a file using its root hash
 // @Produce octet-stream
-// @Param root_hash path string true "Root hash of the file"
+// @Param root_hash path string true "Root hash or public ID of the file"
+// @Param node query string false "Storage node URL to download from (must be in STORAGE_NODE_ALLOWLIST); bypasses the cache"
+// @Param resume query string false "Resume token from an earlier partial response (also accepted as X-Resume-Token)"
+// @Param X-Encryption-Key header string false "Key the file was encrypted with on upload, for files encrypted with a client key"
+// @Param download query bool false "false serves the file inline instead of as an attachment"
+// @Param Range header string false "Byte range, e.g. bytes=0-1048575; a single range of an uncached file is fetched by segment"
 // @Success 200 {file} binary
+// @Success 206 {file} binary
+// @Security ApiKeyAuth
 // @Router /download/{root_hash} [get]
 func (s *Server) handleDownload(c *gin.Context) {
-	rootHash := c.Param("root_hash")
+	rootHash := s.resolveRef(c.Param("root_hash"))
 	if rootHash == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Root hash is required"})
 		return
 	}
 
-	tempFile := filepath.Join(os.TempDir(), rootHash)
-	defer os.Remove(tempFile)
+	if s.withheld(c, rootHash, false) || s.outsideDomain(c, rootHash) {
+		return
+	}
+	// Content under a root hash never changes, so a client holding it
+	// needs nothing fetched
+	if etagMatches(c.GetHeader("If-None-Match"), downloadETag(rootHash)) {
+		c.Header("ETag", downloadETag(rootHash))
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	if info := s.encryptionOf(tenantFrom(c), rootHash); info != nil {
+		s.serveDecrypted(c, rootHash, info)
+		return
+	}
+
+	if nodeURL := c.Query("node"); nodeURL != "" {
+		s.downloadFromNode(c, rootHash, nodeURL)
+		return
+	}
+
+	if s.streamable(c, rootHash) {
+		s.streamDownload(c, rootHash)
+		return
+	}
+	if s.rangeStreamable(c, rootHash) && s.streamRange(c, rootHash) {
+		return
+	}
 
-	if err := s.client.DownloadFile(rootHash, tempFile); err != nil {
+	obj, err := s.fetchObjectWith(requestClassFrom(c), transferTuningFrom(c), rootHash)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	defer obj.Release()
 
-	c.File(tempFile)
+	s.serveDownload(c, rootHash, obj.Path)
 }
 
 type Server struct {
-	client *StorageClient
+	client    *StorageClient
+	shadow    *Shadower
+	catalog   *Catalog
+	keys      *APIKeyStore
+	manifests *manifestCache
+	sites     *SiteRegistry
+	domains   *TenantDomains
+	links     *LinkStore
+	webhooks  *WebhookStore
+	kv        *KVClient
+	streams   *StreamLog
+	settings  *SettingsStore
+	cache     *DiskCache
+	prefetch  *Prefetcher
+	spool     *Spool
+	policy    *Policy
+	access    *AccessPolicy
+	resume    *ResumeTokens
+	sessions  *UploadSessions
+	multipart *MultipartStore
+	resumable *ResumableStore
+	search    *SearchIndex
+	audit     *AuditLog
+	snapshots *SnapshotStore
+	// mode is which half of the API this process serves
+	mode ServiceMode
+	// signer is set when downloads carry a gateway signature
+	signer *ResponseSigner
+	// transfers bounds the tuning clients may request per transfer
+	transfers TransferPolicy
+	// transforms picks the transforms each tenant's uploads go through
+	transforms TransformSettings
+	// impersonation holds support tokens admins mint to act as a tenant
+	impersonation *ImpersonationStore
+	metrics       *RouteMetrics
+	stages        *StageMetrics
+	shield        *OriginShield
+	inflight      *inflightUploads
+	jobs          *JobStore
+	// uploadWorkers holds a slot per async upload running
+	uploadWorkers chan struct{}
+	lifecycle     *LifecycleStore
+	locks         Locker
+
+	moderation *Moderator
+	abuse      *AbuseDesk
+	// quarantine holds uploads the policy quarantines for review
+	quarantine *Quarantine
+	// encryption holds the master key uploads may be encrypted with
+	encryption *EncryptionSettings
+	// meter counts usage for billing; nil unless a metering sink is set
+	meter *Meter
+	// peers are gateways that share their download caches with this one
+	peers *PeerCache
+	// verifyReports keeps the CSV reports of catalog verifications
+	verifyReports *VerifyReports
+
+	maxJSONUploadBytes int64
+	maxUploadBytes     int64
+	defaultQuota       int64
+	quotas             map[string]int64
+	dailyUploads       *DailyUploads
+	defaultDailyQuota  int64
+	dailyQuotas        map[string]int64
+	maxFileBytes       map[string]int64
+	keyLimits          *KeyRateLimiter
+	adminToken         string
+	classes            *RequestClasses
+	// batcher is set when uploads are grouped into shared transactions
+	batcher       *UploadBatcher
+	nodeAllowlist map[string]bool
+	// sponsored is set when keyless uploads paid by the gateway are offered
+	sponsored *SponsoredUploads
+	// flags gates behaviors being rolled out per tenant
+	flags *FeatureFlags
+	// network is the 0G deployment uploads go to
+	network NetworkProfile
+	// publicIDs makes the short IDs uploads get, when set
+	publicIDs *IDScheme
+	// history records every upload, unless METADATA_STORE is none
+	history UploadHistory
+
+	gcMu   sync.Mutex
+	lastGC *GCRun
+	// startedAt is when the process started, reported by GET /version
+	startedAt time.Time
+	// traceURLTemplate is TRACE_URL_TEMPLATE
+	traceURLTemplate string
+	// tracer exports request and upload spans, when an OTLP endpoint is set
+	tracer *Tracer
+	// urlAudienceName is SIGNED_URL_AUDIENCE
+	urlAudienceName string
+	// reservations holds quota for uploads not yet in the catalog
+	reservations quotaReservations
 }
 
 func NewStorageClient(ctx context.Context, privateKey string, useTurbo bool) (*StorageClient, error) {
-	web3Client := blockchain.MustNewWeb3(EvmRPC, privateKey)
-
 	indexerRPC := IndexerRPCStandard
 	if useTurbo {
 		indexerRPC = IndexerRPCTurbo
 	}
 
+	wallet, err := NewKeyWallet(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewStorageClientWithEndpoints(ctx, EvmRPC, indexerRPC, wallet)
+}
+
+// NewStorageClientWithEndpoints connects to an arbitrary 0G deployment,
+// paying for uploads from wallet.
+func NewStorageClientWithEndpoints(ctx context.Context, evmRPC, indexerRPC string, wallet Wallet) (*StorageClient, error) {
+	web3Client, err := newWeb3(evmRPC, wallet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create web3 client: %v", err)
+	}
+
 	indexerClient, err := indexer.NewClient(indexerRPC)
 	if err != nil {
 		web3Client.Close()
@@ -127,30 +383,259 @@ func NewStorageClient(ctx context.Context, privateKey string, useTurbo bool) (*S
 		web3Client:    web3Client,
 		indexerClient: indexerClient,
 		ctx:           ctx,
+		replicas:      DefaultReplicas,
+		finality:      transfer.FileFinalized,
+		timeout:       5 * time.Minute,
+		prober:        NewNodeProber(defaultNodeSlowLatency, nil),
+		integrity:     NewIntegrityMonitor(""),
+		hasher:        NewRootHasher(0, 0),
+		retry:         defaultNodeRetry,
+		account:       wallet.Address(),
 	}, nil
 }
 
+// NewStorageClientForProfile connects to the deployment a network profile
+// describes and applies its upload settings.
+func NewStorageClientForProfile(ctx context.Context, profile NetworkProfile, wallet Wallet) (*StorageClient, error) {
+	client, err := NewStorageClientWithEndpoints(ctx, profile.EvmRPC, profile.IndexerRPC, wallet)
+	if err != nil {
+		return nil, err
+	}
+	client.replicas = profile.Replicas
+	client.finality = profile.Finality
+	client.timeout = profile.Timeout
+	return client, nil
+}
+
 func (c *StorageClient) Close() {
 	if c.web3Client != nil {
 		c.web3Client.Close()
 	}
+	if c.wallets != nil {
+		c.wallets.Close()
+	}
+}
+
+// ComputeRoot returns the Merkle root 0G Storage will assign to the file,
+// without uploading it.
+func (c *StorageClient) ComputeRoot(filePath string) (string, error) {
+	root, err := c.hasher.Root(filePath)
+	if err != nil {
+		return "", err
+	}
+	return root.String(), nil
+}
+
+// ComputeRootData is ComputeRoot for content that is not a local file.
+func (c *StorageClient) ComputeRootData(data core.IterableData) (string, error) {
+	if data.Size() == 0 {
+		return "", fmt.Errorf("failed to open file: %v", core.ErrFileEmpty)
+	}
+	tree, err := core.MerkleTree(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute merkle root: %v", err)
+	}
+	return tree.Root().String(), nil
+}
+
+func (c *StorageClient) selectNodes() ([]*node.ZgsClient, error) {
+	return c.selectNodesFor(ClassInteractive)
+}
+
+// selectNodesFor asks the indexer for nodes, leaving out those probing found
+// down or, for interactive requests, slow. If the indexer cannot satisfy the
+// request without them, they are allowed after all. With a node pool the
+// pool's health-checked set is used instead.
+func (c *StorageClient) selectNodesFor(class RequestClass) ([]*node.ZgsClient, error) {
+	if c.pool != nil {
+		return c.pooledNodes(class, c.replicas)
+	}
+	return c.selectNodesExcluding(class, c.replicas, nil)
+}
+
+// selectNodesExcluding is selectNodesFor with enough nodes to store every
+// segment replicas times, that never picks the nodes in avoid, even when
+// that leaves the indexer unable to answer.
+func (c *StorageClient) selectNodesExcluding(class RequestClass, replicas uint, avoid []string) ([]*node.ZgsClient, error) {
+	excluded := append(c.prober.Excluded(class), avoid...)
+	nodes, err := c.indexerClient.SelectNodes(c.ctx, 1, replicas, excluded, "max")
+	if err != nil && len(excluded) > len(avoid) {
+		nodes, err = c.indexerClient.SelectNodes(c.ctx, 1, replicas, append([]string{}, avoid...), "max")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to select storage nodes: %v", err)
+	}
+	for _, n := range nodes {
+		c.prober.Add(n.URL())
+	}
+	c.prober.Rank(nodes, class)
+	slog.Debug("selected storage nodes", "class", class.String(), "replicas", replicas, "nodes", nodeURLs(nodes), "excluded", excluded)
+	return nodes, nil
+}
+
+func nodeURLs(nodes []*node.ZgsClient) []string {
+	urls := make([]string, len(nodes))
+	for i, n := range nodes {
+		urls[i] = n.URL()
+	}
+	return urls
+}
+
+// uploaderFor returns an uploader whose steps are traced under ctx's span.
+func (c *StorageClient) uploaderFor(ctx context.Context, web3Client *web3go.Client, nodes []*node.ZgsClient) (*transfer.Uploader, error) {
+	uploader, err := transfer.NewUploader(c.ctx, web3Client, nodes, sdkLogOptionsFor(spanFrom(ctx))...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create uploader: %v", err)
+	}
+	return uploader, nil
+}
+
+func (c *StorageClient) uploadOption() transfer.UploadOption {
+	return transfer.UploadOption{FinalityRequired: c.finality, ExpectedReplica: c.replicas}
+}
+
+// replicasFor is the replica count an upload with tuning is stored with.
+func (c *StorageClient) replicasFor(tuning TransferTuning) uint {
+	if tuning.Replicas > 0 {
+		return tuning.Replicas
+	}
+	return c.replicas
 }
 
 func (c *StorageClient) UploadFile(filePath string) (string, string, error) {
-	nodes, err := c.indexerClient.SelectNodes(c.ctx, 1, DefaultReplicas, []string{}, "max")
+	return c.UploadFileAs(ClassInteractive, filePath)
+}
+
+// UploadFileAs uploads with node selection suited to class.
+func (c *StorageClient) UploadFileAs(class RequestClass, filePath string) (string, string, error) {
+	return c.UploadFileWith(class, TransferTuning{}, nil, nil, filePath)
+}
+
+// UploadFileWith is UploadFileAs with a request's transfer tuning applied,
+// recording node selection and submission in timer. With a watch, progress
+// is reported while the file is uploaded.
+func (c *StorageClient) UploadFileWith(class RequestClass, tuning TransferTuning, timer *StageTimer, watch *TransferWatch, filePath string) (string, string, error) {
+	file, err := core.Open(filePath)
+	if err != nil {
+		return "", "", fmt.Errorf("upload failed: failed to open file: %v", err)
+	}
+	defer file.Close()
+	return c.UploadDataWith(class, tuning, timer, watch, file)
+}
+
+// UploadDataWith is UploadFileWith for content that is not a local file.
+func (c *StorageClient) UploadDataWith(class RequestClass, tuning TransferTuning, timer *StageTimer, watch *TransferWatch, data core.IterableData) (string, string, error) {
+	start := time.Now()
+	replicas := c.replicasFor(tuning)
+	selecting := timer.Begin(StageNodeSelect)
+	nodes, err := c.selectUploadNodes(class, replicas)
+	if err != nil {
+		selecting.End(err)
+		return "", "", err
+	}
+	selecting.SetAttr("replicas", replicas)
+	selecting.SetAttr("nodes", nodeURLs(nodes))
+	timer.Since(StageNodeSelect, start)
+	option := c.uploadOption()
+	option.TaskSize = tuning.TaskSegments
+	option.ExpectedReplica = replicas
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+
+	start = time.Now()
+	submitting := timer.Begin(StageSubmit)
+	ctx = withSpan(ctx, submitting)
+	var txHash, rootHash common.Hash
+	err = c.withUploader(ctx, nodes, func(uploader *transfer.Uploader, nonce *big.Int) (common.Hash, error) {
+		if tuning.Concurrency > 0 {
+			uploader = uploader.WithRoutines(tuning.Concurrency)
+		}
+		option.Nonce = nonce
+		var err error
+		if watch != nil {
+			txHash, rootHash, err = c.uploadWatched(ctx, uploader, nodes, watch, data, option)
+		} else {
+			txHash, rootHash, err = uploader.Upload(ctx, data, option)
+		}
+		return txHash, err
+	})
+	if err != nil {
+		err = fmt.Errorf("upload failed: %v", err)
+		submitting.End(err)
+		return "", "", err
+	}
+	submitting.SetAttr("tx_hash", txHash.String())
+	submitting.SetAttr("root_hash", rootHash.String())
+	timer.Since(StageSubmit, start)
+
+	return txHash.String(), rootHash.String(), nil
+}
+
+// BatchUploadFiles submits several files in one flow contract transaction and
+// returns its hash with the files' root hashes, in order.
+func (c *StorageClient) BatchUploadFiles(class RequestClass, filePaths []string) (string, []string, error) {
+	datas := make([]core.IterableData, 0, len(filePaths))
+	options := make([]transfer.UploadOption, 0, len(filePaths))
+	for _, path := range filePaths {
+		file, err := core.Open(path)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to open file: %v", err)
+		}
+		defer file.Close()
+		datas = append(datas, file)
+		options = append(options, c.uploadOption())
+	}
+
+	nodes, err := c.selectNodesFor(class)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to select storage nodes: %v", err)
+		return "", nil, err
 	}
 
-	uploader, err := transfer.NewUploader(c.ctx, c.web3Client, nodes)
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+
+	var txHash common.Hash
+	var rootHashes []common.Hash
+	err = c.withUploader(ctx, nodes, func(uploader *transfer.Uploader, nonce *big.Int) (common.Hash, error) {
+		var err error
+		txHash, rootHashes, err = uploader.BatchUpload(ctx, datas, transfer.BatchUploadOption{Nonce: nonce, DataOptions: options})
+		return txHash, err
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("batch upload failed: %v", err)
+	}
+
+	roots := make([]string, len(rootHashes))
+	for i, root := range rootHashes {
+		roots[i] = root.String()
+	}
+	return txHash.String(), roots, nil
+}
+
+// UploadData uploads a small in-memory payload such as a manifest.
+func (c *StorageClient) UploadData(data []byte) (string, string, error) {
+	payload, err := core.NewDataInMemory(data)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to prepare data: %v", err)
+	}
+
+	nodes, err := c.selectNodes()
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create uploader: %v", err)
+		return "", "", err
 	}
 
-	ctx, cancel := context.WithTimeout(c.ctx, 5*time.Minute)
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
 	defer cancel()
 
-	txHash, rootHash, err := uploader.UploadFile(ctx, filePath)
+	var txHash, rootHash common.Hash
+	err = c.withUploader(ctx, nodes, func(uploader *transfer.Uploader, nonce *big.Int) (common.Hash, error) {
+		option := c.uploadOption()
+		option.Nonce = nonce
+		var err error
+		txHash, rootHash, err = uploader.Upload(ctx, payload, option)
+		return txHash, err
+	})
 	if err != nil {
 		return "", "", fmt.Errorf("upload failed: %v", err)
 	}
@@ -158,31 +643,241 @@ func (c *StorageClient) UploadFile(filePath string) (string, string, error) {
 	return txHash.String(), rootHash.String(), nil
 }
 
+// FileInfo asks nodes in turn about rootHash and returns the first answer; nil
+// means none of them knows the file.
+func (c *StorageClient) FileInfo(ctx context.Context, nodes []*node.ZgsClient, rootHash string) (*node.FileInfo, error) {
+	var lastErr error
+	for _, n := range nodes {
+		info, err := n.GetFileInfo(ctx, common.HexToHash(rootHash), true)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if info != nil {
+			return info, nil
+		}
+	}
+	if lastErr != nil {
+		return nil, fmt.Errorf("failed to get file info: %v", lastErr)
+	}
+	return nil, nil
+}
+
+// Head returns the size of rootHash and up to its first n bytes, read from
+// the first chunks of its first segment rather than downloading the file.
+func (c *StorageClient) Head(ctx context.Context, nodes []*node.ZgsClient, rootHash string, n int64) ([]byte, int64, error) {
+	info, err := c.FileInfo(ctx, nodes, rootHash)
+	if err != nil {
+		return nil, 0, err
+	}
+	if info == nil {
+		return nil, 0, fmt.Errorf("no storage node has %s", rootHash)
+	}
+	size := int64(info.Tx.Size)
+	if size < n {
+		n = size
+	}
+	if n == 0 {
+		return nil, size, nil
+	}
+
+	chunks := uint64((n + chunkSize - 1) / chunkSize)
+	var lastErr error
+	for _, nd := range nodes {
+		data, err := nd.DownloadSegment(ctx, common.HexToHash(rootHash), 0, chunks)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if int64(len(data)) >= n {
+			// The last chunk is padded with zeros past the end of the file
+			return data[:n], size, nil
+		}
+	}
+	if lastErr != nil {
+		return nil, 0, fmt.Errorf("failed to download first segment: %v", lastErr)
+	}
+	return nil, 0, fmt.Errorf("no storage node returned data for %s", rootHash)
+}
+
+// TxBlock returns the block a transaction was mined in; ok is false while it
+// is still pending.
+func (c *StorageClient) TxBlock(txHash string) (block uint64, ok bool, err error) {
+	receipt, err := c.web3Client.Eth.TransactionReceipt(common.HexToHash(txHash))
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get receipt: %v", err)
+	}
+	if receipt == nil {
+		return 0, false, nil
+	}
+	c.submitGas.Observe(receipt.GasUsed)
+	return receipt.BlockNumber, true, nil
+}
+
 func (c *StorageClient) DownloadFile(rootHash, outputPath string) error {
-	nodes, err := c.indexerClient.SelectNodes(c.ctx, 1, DefaultReplicas, []string{}, "max")
+	return c.DownloadFileAs(ClassInteractive, rootHash, outputPath)
+}
+
+// DownloadFileAs downloads with node selection suited to class.
+func (c *StorageClient) DownloadFileAs(class RequestClass, rootHash, outputPath string) error {
+	return c.DownloadFileWith(class, TransferTuning{}, rootHash, outputPath)
+}
+
+// DownloadFileWith is DownloadFileAs with a request's transfer tuning applied.
+func (c *StorageClient) DownloadFileWith(class RequestClass, tuning TransferTuning, rootHash, outputPath string) error {
+	nodes, err := c.selectNodesFor(class)
+	if err != nil {
+		return err
+	}
+	return c.downloadFrom(nodes, tuning.Concurrency, rootHash, outputPath)
+}
+
+// DownloadFileFrom downloads from one storage node only, skipping the
+// indexer's node selection.
+func (c *StorageClient) DownloadFileFrom(nodeURL, rootHash, outputPath string) error {
+	n, err := node.NewZgsClient(nodeURL)
 	if err != nil {
-		return fmt.Errorf("failed to select storage nodes: %v", err)
+		return fmt.Errorf("failed to connect to node: %v", err)
 	}
+	defer n.Close()
+	return c.downloadFrom([]*node.ZgsClient{n}, 0, rootHash, outputPath)
+}
 
-	downloader, err := transfer.NewDownloader(nodes)
+// downloadFrom downloads with routines segments in parallel, or the SDK's
+// default when it is 0.
+func (c *StorageClient) downloadFrom(nodes []*node.ZgsClient, routines int, rootHash, outputPath string) error {
+	downloader, err := transfer.NewDownloader(nodes, sdkLogOptions...)
 	if err != nil {
 		return fmt.Errorf("failed to create downloader: %v", err)
 	}
+	if routines > 0 {
+		downloader = downloader.WithRoutines(routines)
+	}
 
 	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
 		return fmt.Errorf("failed to create output directory: %v", err)
 	}
 
-	ctx, cancel := context.WithTimeout(c.ctx, 5*time.Minute)
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
 	defer cancel()
 
 	if err := downloader.Download(ctx, rootHash, outputPath, true); err != nil {
-		return fmt.Errorf("download failed: %v", err)
+		// The SDK gives up on a node that fails; fetching each segment from
+		// whichever replica serves it can still succeed
+		log.Printf("⚠️  Download of %s failed (%v); fetching its segments from the other replicas", rootHash, err)
+		if err := c.downloadSegmented(nodes, routines, rootHash, outputPath); err != nil {
+			return fmt.Errorf("download failed: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// StreamFile downloads rootHash, size bytes long, segment by segment and
+// passes each to write in order as soon as it has been checked against the
+// root with its Merkle proof. Up to routines segments (default 4) are fetched
+// ahead while earlier ones are written.
+func (c *StorageClient) StreamFile(ctx context.Context, nodes []*node.ZgsClient, rootHash string, size int64, routines int, write func([]byte) error) error {
+	return c.StreamSegments(ctx, nodes, rootHash, size, 0, (size+segmentSize-1)/segmentSize, routines, write)
+}
+
+// StreamSegments is StreamFile for segments first up to (not including) end
+// only, for a range of the file.
+func (c *StorageClient) StreamSegments(ctx context.Context, nodes []*node.ZgsClient, rootHash string, size, first, end int64, routines int, write func([]byte) error) error {
+	if routines <= 0 {
+		routines = 4
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	type fetched struct {
+		data []byte
+		err  error
+	}
+	root := common.HexToHash(rootHash)
+	var pending []chan fetched
+	next := first
+	fetchNext := func() {
+		ch := make(chan fetched, 1)
+		go func(index int64) {
+			data, err := c.downloadSegment(ctx, nodes, root, index, size)
+			ch <- fetched{data, err}
+		}(next)
+		pending = append(pending, ch)
+		next++
 	}
 
+	for next < end && len(pending) < routines {
+		fetchNext()
+	}
+	for len(pending) > 0 {
+		result := <-pending[0]
+		pending = pending[1:]
+		if result.err != nil {
+			return result.err
+		}
+		if err := write(result.data); err != nil {
+			return err
+		}
+		if next < end {
+			fetchNext()
+		}
+	}
 	return nil
 }
 
+// downloadSegment fetches one segment from the first node that returns it
+// with a valid proof, without the padding past the end of the file. When
+// none does, all of them are asked again as the retry policy allows.
+func (c *StorageClient) downloadSegment(ctx context.Context, nodes []*node.ZgsClient, root common.Hash, index, size int64) ([]byte, error) {
+	want := size - index*segmentSize
+	if want > segmentSize {
+		want = segmentSize
+	}
+	var data []byte
+	err := c.retry.Do(ctx, func() error {
+		var err error
+		data, err = c.downloadSegmentOnce(ctx, nodes, root, index, size, want)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download segment %d: %v", index, err)
+	}
+	return data, nil
+}
+
+// downloadSegmentOnce asks each node in turn for the segment.
+func (c *StorageClient) downloadSegmentOnce(ctx context.Context, nodes []*node.ZgsClient, root common.Hash, index, size, want int64) ([]byte, error) {
+	var lastErr error
+	for _, n := range nodes {
+		segment, err := n.DownloadSegmentWithProof(ctx, root, uint64(index))
+		if err != nil {
+			c.nodeFailed(n, err)
+			lastErr = err
+			continue
+		}
+		if segment == nil || int64(len(segment.Data)) < want {
+			lastErr = fmt.Errorf("%s does not have it", n.URL())
+			continue
+		}
+		segmentRoot, numSegmentsPadded := core.PaddedSegmentRoot(uint64(index), segment.Data, size)
+		if err := segment.Proof.ValidateHash(root, segmentRoot, uint64(index), numSegmentsPadded); err != nil {
+			lastErr = fmt.Errorf("invalid proof from %s: %v", n.URL(), err)
+			c.integrity.Record(IntegrityFailure{
+				Node:        n.URL(),
+				RootHash:    root.Hex(),
+				Segment:     uint64(index),
+				SegmentRoot: segmentRoot.Hex(),
+				Bytes:       len(segment.Data),
+				Error:       err.Error(),
+			})
+			continue
+		}
+		return segment.Data[:want], nil
+	}
+	return nil, lastErr
+}
+
 func main() {
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
@@ -191,46 +886,612 @@ func main() {
 		log.Println("PRIVATE_KEY=your_private_key_here")
 	}
 
-	privateKey := os.Getenv("PRIVATE_KEY")
-	if privateKey == "" {
-		log.Fatal("❌ PRIVATE_KEY environment variable is required. Please add it to .env file")
+	cfg := LoadConfig()
+	flag.StringVar(&cfg.Network, "network", cfg.Network, "0G network profile: galileo-testnet, mainnet, devnet, custom or one from the network config")
+	flag.StringVar(&cfg.NetworkConfig, "network-config", cfg.NetworkConfig, "YAML file of network profiles")
+	flag.Parse()
+	if err := setupLogging(cfg.LogFormat, cfg.LogLevel); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrateCommand(cfg, flag.Args()[1:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+	if flag.Arg(0) == "verify" {
+		if err := runVerifyCommand(flag.Args()[1:]); err != nil {
+			log.Fatalf("Verification failed: %v", err)
+		}
+		return
+	}
+	wallet, err := NewWallet(cfg)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	log.Printf("🔑 Using %s wallet %s", cfg.WalletBackend, wallet.Address().Hex())
+
+	mode, err := parseServiceMode(cfg.ServiceMode)
+	if err != nil {
+		log.Fatalf("Invalid SERVICE_MODE: %v", err)
+	}
+	if mode == ModeDownload && cfg.CatalogPath == "" {
+		log.Fatal("❌ SERVICE_MODE=download needs the upload instances' catalog (set CATALOG_PATH or DATA_DIR)")
 	}
 
-	ctx := context.Background()
-	client, err := NewStorageClient(ctx, privateKey, true)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	network, err := LoadNetworkProfile(cfg)
+	if err != nil {
+		log.Fatalf("Invalid network configuration: %v", err)
+	}
+	client, err := NewStorageClientForProfile(ctx, network, wallet)
 	if err != nil {
 		log.Fatalf("Failed to initialize storage client: %v", err)
 	}
 	defer client.Close()
+	if err := checkChainID(client, &network); err != nil {
+		log.Fatalf("Invalid network configuration: %v", err)
+	}
+	log.Printf("🌐 Using %s network, chain %d (%s)", network.Name, network.ChainID, network.IndexerRPC)
+	client.lowBalance, err = parseTokens(cfg.WalletLowBalance)
+	if err != nil {
+		log.Fatalf("Invalid WALLET_LOW_BALANCE: %v", err)
+	}
+	if len(cfg.WalletPoolKeys) > 0 {
+		wallets := []Wallet{wallet}
+		for i, key := range cfg.WalletPoolKeys {
+			w, err := NewKeyWallet(key)
+			if err != nil {
+				log.Fatalf("Invalid key %d in WALLET_POOL_KEYS: %v", i+1, err)
+			}
+			wallets = append(wallets, w)
+		}
+		client.wallets, err = NewWalletPool(network.EvmRPC, wallets)
+		if err != nil {
+			log.Fatalf("Failed to set up the wallet pool: %v", err)
+		}
+		log.Printf("👛 Spreading uploads across %d wallets", len(wallets))
+	}
+	if cfg.UploadResumePasses > 0 {
+		client.partials, err = NewPartialUploads(cfg.DataPath("partial_uploads.json"))
+		if err != nil {
+			log.Fatalf("Failed to initialize upload resume: %v", err)
+		}
+		client.resumePasses = cfg.UploadResumePasses
+	}
+
+	if cfg.DataDir != "" {
+		if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+			log.Fatalf("Failed to create data directory: %v", err)
+		}
+	}
+	if err := prepareDataDir(cfg); err != nil {
+		log.Fatalf("Data directory is not ready: %v", err)
+	}
+
+	if flag.Arg(0) == "catalog-snapshot" {
+		if err := runSnapshotCommand(cfg, client); err != nil {
+			log.Fatalf("Failed to publish catalog snapshot: %v", err)
+		}
+		return
+	}
+
+	catalog, err := NewCatalog(cfg.CatalogPath)
+	if err != nil {
+		log.Fatalf("Failed to load catalog: %v", err)
+	}
+
+	links, err := NewLinkStore(cfg.DataPath("links.json"))
+	if err != nil {
+		log.Fatalf("Failed to load links: %v", err)
+	}
+	go links.RunFlusher(ctx, 30*time.Second)
+
+	sites, err := NewSiteRegistry(cfg.DataPath("sites.json"), cfg.Sites, cfg.SiteHosts)
+	if err != nil {
+		log.Fatalf("Failed to load sites: %v", err)
+	}
+
+	tracer := NewTracer(cfg)
+	if tracer != nil {
+		go tracer.Run(ctx, 5*time.Second)
+		log.Printf("🔭 Exporting traces to %s", tracer.url)
+	}
+
+	webhooks, err := NewWebhookStore(cfg.DataPath("webhooks.json"))
+	if err != nil {
+		log.Fatalf("Failed to load webhooks: %v", err)
+	}
+	if err := webhooks.Configure(cfg.WebhookURL, cfg.WebhookSecret); err != nil {
+		log.Fatalf("Failed to configure the global webhook: %v", err)
+	}
+
+	dailyUploads, err := NewDailyUploads(cfg.DataPath("daily_uploads.json"))
+	if err != nil {
+		log.Fatalf("Failed to load daily upload usage: %v", err)
+	}
+	sponsored, err := NewSponsoredUploads(cfg, cfg.DataPath("sponsored.json"))
+	if err != nil {
+		log.Fatalf("Failed to set up sponsored uploads: %v", err)
+	}
+
+	quarantine, err := NewQuarantine(cfg.DataPath("quarantine.json"), filepath.Join(cfg.SpoolDir, "quarantine"))
+	if err != nil {
+		log.Fatalf("Failed to load quarantined uploads: %v", err)
+	}
+
+	verifyReports, err := NewVerifyReports(filepath.Join(cfg.SpoolDir, "reports"))
+	if err != nil {
+		log.Fatalf("Failed to set up verification reports: %v", err)
+	}
+
+	encryption, err := NewEncryptionSettings(cfg.EncryptionMasterKey, cfg.EncryptUploads)
+	if err != nil {
+		log.Fatalf("Invalid encryption configuration: %v", err)
+	}
+
+	meter, err := NewMeter(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize metering: %v", err)
+	}
+
+	peers, err := NewPeerCache(cfg.PeerGateways, cfg.PeerSecret)
+	if err != nil {
+		log.Fatalf("Invalid peer gateway configuration: %v", err)
+	}
+	if peers != nil && len(cfg.PeerGateways) > 0 {
+		log.Printf("🤝 Sharing the download cache with %d peer gateways", len(cfg.PeerGateways))
+	}
+
+	lifecycle, err := NewLifecycleStore(cfg.DataPath("lifecycle.json"))
+	if err != nil {
+		log.Fatalf("Failed to load lifecycle rules: %v", err)
+	}
+
+	keys, err := NewAPIKeyStore(cfg.DataPath("api_keys.json"), cfg.APIKeys)
+	if err != nil {
+		log.Fatalf("Failed to load API keys: %v", err)
+	}
+
+	audit, err := NewAuditLog(cfg.DataPath("audit.log"))
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer audit.Close()
+
+	jobs := NewJobStore()
+	if err := jobs.Load(cfg.DataPath("jobs.json")); err != nil {
+		log.Fatalf("Failed to load jobs: %v", err)
+	}
+
+	moderation, err := NewModerator(cfg, cfg.DataPath("moderation.json"))
+	if err != nil {
+		log.Fatalf("Failed to load moderation records: %v", err)
+	}
+	abuse, err := NewAbuseDesk(cfg, cfg.DataPath("abuse.json"))
+	if err != nil {
+		log.Fatalf("Failed to load abuse reports: %v", err)
+	}
+
+	spool, err := NewSpool(cfg.SpoolDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize spool: %v", err)
+	}
+	if s3 := cfg.SpoolS3; s3.Bucket != "" {
+		remote, err := NewS3Client(s3.Endpoint, s3.Region, s3.Bucket, s3.AccessKey, s3.SecretKey)
+		if err != nil {
+			log.Fatalf("Failed to initialize S3 spool: %v", err)
+		}
+		spool.UseRemote(remote, s3.Prefix)
+		log.Printf("🪣 Staging uploads in s3://%s/%s", s3.Bucket, s3.Prefix)
+	}
+
+	if mode != ModeDownload && cfg.CatalogBootstrapRoot != "" && catalog.Empty() {
+		if err := bootstrapCatalog(client, spool, catalog, cfg.CatalogBootstrapRoot); err != nil {
+			log.Fatalf("Failed to bootstrap catalog: %v", err)
+		}
+	}
+
+	snapshots, err := NewSnapshotStore(cfg.DataPath("snapshots.json"))
+	if err != nil {
+		log.Fatalf("Failed to load catalog snapshots: %v", err)
+	}
+
+	var cache *DiskCache
+	if mode != ModeUpload && cfg.CacheDir != "" {
+		cache, err = NewDiskCache(cfg.CacheDir, cfg.CacheMaxBytes)
+		if err != nil {
+			log.Fatalf("Failed to initialize download cache: %v", err)
+		}
+		var cold ColdTier
+		switch {
+		case cfg.CacheColdS3.Bucket != "":
+			cold, err = NewS3ColdTier(cfg.CacheColdS3)
+		case cfg.CacheColdDir != "":
+			cold, err = NewDirColdTier(cfg.CacheColdDir)
+		}
+		if err == nil && cold != nil {
+			err = cache.UseColdTier(cold, cfg.CacheColdMaxBytes)
+		}
+		if err != nil {
+			log.Fatalf("Failed to initialize cold cache tier: %v", err)
+		}
+		if cold != nil {
+			log.Printf("🧊 Demoting evicted cache objects to %s", cold.Describe())
+		}
+	}
+
+	// Leases keep schedulers from running on more than one replica
+	var locks Locker = newLocalLocker(instanceID())
+	if cfg.RedisURL != "" {
+		redis, err := NewRedisClient(cfg.RedisURL)
+		if err != nil {
+			log.Fatalf("Failed to configure redis: %v", err)
+		}
+		locks = newRedisLocker(instanceID(), redis)
+	}
+
+	policy, err := LoadPolicy(cfg.UploadPolicy)
+	if err != nil {
+		log.Fatalf("Failed to load upload policy: %v", err)
+	}
+	access, err := LoadAccessPolicy(cfg.AccessPolicy)
+	if err != nil {
+		log.Fatalf("Failed to load access policy: %v", err)
+	}
+
+	publicIDs, err := LoadIDScheme(cfg.PublicIDScheme)
+	if err != nil {
+		log.Fatalf("Failed to load ID scheme: %v", err)
+	}
+
+	flags, err := NewFeatureFlags(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load feature flags: %v", err)
+	}
+
+	history, err := OpenUploadHistory(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open upload history: %v", err)
+	}
+	if history != nil {
+		defer history.Close()
+	}
+
+	uploadWorkers := cfg.AsyncUploadWorkers
+	if uploadWorkers < 1 {
+		uploadWorkers = 1
+	}
+
+	server := &Server{
+		client:    client,
+		catalog:   catalog,
+		keys:      keys,
+		manifests: newManifestCache(),
+		sites:     sites,
+		domains:   NewTenantDomains(cfg.TenantHosts, cfg.TenantHeaders),
+		links:     links,
+		webhooks:  webhooks,
+		cache:     cache,
+		spool:     spool,
+		policy:    policy,
+		access:    access,
+		resume:    NewResumeTokens(cfg.ResumeTokenSecret),
+		sessions:  NewUploadSessions(cfg.UploadSessionSecret),
+		multipart: NewMultipartStore(spool),
+		resumable: NewResumableStore(spool),
+		search:    NewSearchIndex(catalog),
+		audit:     audit,
+		snapshots: snapshots,
+		mode:      mode,
+
+		impersonation: NewImpersonationStore(),
+		metrics:       NewRouteMetrics(),
+		stages:        NewStageMetrics(),
+		shield:        NewOriginShield(cfg),
+		inflight:      newInflightUploads(),
+		jobs:          jobs,
+		uploadWorkers: make(chan struct{}, uploadWorkers),
+		lifecycle:     lifecycle,
+		locks:         locks,
 
-	server := &Server{client: client}
+		moderation: moderation,
+		abuse:      abuse,
+		quarantine: quarantine,
+		encryption: encryption,
+		meter:      meter,
+		peers:      peers,
+
+		verifyReports: verifyReports,
+
+		maxJSONUploadBytes: cfg.MaxJSONUploadBytes,
+		maxUploadBytes:     cfg.MaxUploadBytes,
+		flags:              flags,
+		network:            network,
+		publicIDs:          publicIDs,
+		history:            history,
+		adminToken:         cfg.AdminToken,
+		nodeAllowlist:      make(map[string]bool),
+		defaultQuota:       cfg.DefaultQuotaBytes,
+		quotas:             cfg.TenantQuotas,
+		dailyUploads:       dailyUploads,
+		sponsored:          sponsored,
+		defaultDailyQuota:  cfg.DailyUploadBytes,
+		dailyQuotas:        cfg.TenantDailyUploadBytes,
+		maxFileBytes:       cfg.TenantMaxFileBytes,
+		keyLimits:          NewKeyRateLimiter(cfg.KeyRateLimit, cfg.KeyRateBurst),
+		classes:            NewRequestClasses(cfg.TenantClasses, cfg.ClassLimits),
+		transfers: TransferPolicy{
+			MaxConcurrency:  cfg.MaxTransferConcurrency,
+			MaxTaskSegments: uint(cfg.MaxTaskSegments),
+			MaxReplicas:     uint(cfg.MaxUploadReplicas),
+		},
+		transforms: TransformSettings{
+			Default: cfg.UploadTransforms,
+			Tenants: cfg.TenantTransforms,
+		},
+		startedAt: time.Now(),
+
+		traceURLTemplate: cfg.TraceURLTemplate,
+		tracer:           tracer,
+		urlAudienceName:  cfg.SignedURLAudience,
+	}
+	for _, url := range cfg.NodeAllowlist {
+		server.nodeAllowlist[normalizeNodeURL(url)] = true
+	}
+	if mode != ModeDownload && cfg.UploadBatchWindow > 0 {
+		server.batcher = NewUploadBatcher(client, cfg.UploadBatchWindow, cfg.UploadBatchMax)
+		log.Printf("📦 Batching uploads arriving within %s (up to %d per transaction)", cfg.UploadBatchWindow, cfg.UploadBatchMax)
+	}
+	if cfg.SignResponses {
+		if cfg.ResponseSigningKey != "" {
+			server.signer, err = NewResponseSigner(cfg.ResponseSigningKey)
+		} else {
+			server.signer = NewWalletResponseSigner(wallet)
+		}
+		if err != nil {
+			log.Fatalf("Failed to configure response signing: %v", err)
+		}
+		log.Printf("🔏 Signing downloads as %s", server.signer.Address())
+	}
+	client.prober = NewNodeProber(cfg.NodeSlowLatency, append(cfg.StorageNodes, cfg.NodeAllowlist...))
+	client.integrity = NewIntegrityMonitor(cfg.IntegrityNotifyURL)
+	client.hasher = NewRootHasher(cfg.HashWorkers, cfg.HashReads)
+	client.retry = cfg.NodeRetry
+	client.pool = NewNodePool(cfg.NodePoolTTL, cfg.NodePoolMaxStale)
+
+	if cfg.ShadowEnabled() {
+		shadowWallet := wallet
+		if cfg.ShadowPrivateKey != "" {
+			if shadowWallet, err = NewKeyWallet(cfg.ShadowPrivateKey); err != nil {
+				log.Fatalf("Invalid SHADOW_PRIVATE_KEY: %v", err)
+			}
+		}
+		shadowClient, err := NewStorageClientWithEndpoints(ctx, cfg.ShadowEvmRPC, cfg.ShadowIndexerRPC, shadowWallet)
+		if err != nil {
+			log.Fatalf("Failed to initialize shadow storage client: %v", err)
+		}
+		server.shadow = NewShadower(shadowClient, spool, cfg.ShadowIndexerRPC, cfg.ShadowHistory, cfg.ShadowConcurrency)
+		defer server.shadow.Close()
+		log.Printf("🪞 Shadow mode enabled: mirroring uploads to %s", cfg.ShadowIndexerRPC)
+	}
+
+	if cfg.KVNodeRPC != "" {
+		kvClient, err := NewKVClient(client, cfg.KVNodeRPC)
+		if err != nil {
+			log.Fatalf("Failed to initialize KV client: %v", err)
+		}
+		defer kvClient.Close()
+		server.kv = kvClient
+		server.streams = NewStreamLog(kvClient)
+		server.settings = NewSettingsStore(kvClient, cfg.SettingsStream, cfg.SettingsCacheTTL)
+	}
+
+	server.prefetch = NewPrefetcher(cfg, server.cache)
+	if server.prefetch != nil {
+		go server.runPrefetch(ctx, cfg.PrefetchWorkers)
+		log.Printf("🔮 Prefetching the top %d results of listings into the cache", cfg.PrefetchOnList)
+	}
+	go server.runGC(ctx, cfg.GCInterval)
+	if meter != nil {
+		go meter.Run(ctx, cfg.MeteringInterval)
+		log.Printf("🧾 Exporting usage every %s as %s", cfg.MeteringInterval, cfg.MeteringFormat)
+	}
+	if cfg.NodeProbeInterval > 0 {
+		go client.prober.Run(ctx, cfg.NodeProbeInterval)
+	}
+	if client.pool != nil {
+		go client.pool.Run(ctx, client)
+		log.Printf("🧭 Caching storage node selections for %s", cfg.NodePoolTTL)
+	}
+	if mode == ModeDownload {
+		// The upload instances own the catalog and the jobs that change it
+		go catalog.Follow(ctx, cfg.CatalogReloadInterval)
+		log.Printf("🚦 Download-only mode: following the catalog at %s", cfg.CatalogPath)
+	} else {
+		go server.runLifecycle(ctx, cfg.LifecycleInterval)
+		go server.watchTransactions(ctx, cfg.TxWatchInterval, cfg.TxWatchTimeout)
+		if server.moderation != nil {
+			go server.runModeration(ctx)
+			log.Printf("🚩 Moderating image and text uploads via %s", cfg.ModerationURL)
+		}
+		if mode == ModeUpload {
+			log.Printf("🚦 Upload-only mode: download and content routes are not served")
+		}
+	}
 
 	// Initialize Gin router
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
+	// Uploads stream their multipart bodies to the spool; this only bounds
+	// what anything parsing a whole form may keep in memory
+	r.MaxMultipartMemory = cfg.MultipartMemoryBytes
+	// Client IPs drive rate limits and access rules, so forwarding headers
+	// only count when they come from a known proxy
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 	r.Use(gin.Recovery())
-	r.Use(gin.LoggerWithConfig(gin.LoggerConfig{
-		SkipPaths: []string{"/swagger/*"},
-	}))
+	r.Use(server.traceRequest)
+	r.Use(server.metrics.Middleware)
+	r.Use(server.logRequest)
 
 	// CORS middleware for CodeSandbox
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-		if c.Request.Method == "OPTIONS" {
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, HEAD, OPTIONS, PUT, PATCH, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, Upload-Offset, X-Callback-Secret, X-Feature-Flags, X-Encryption-Key, traceparent")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Location, Upload-Offset, Upload-Length, X-Feature-Flags, X-Trace-ID, X-Request-ID")
+		// OPTIONS /api/v1/upload answers with the upload constraints
+		if c.Request.Method == "OPTIONS" && c.FullPath() != "/api/v1/upload" {
 			c.AbortWithStatus(204)
 			return
 		}
 		c.Next()
 	})
 
+	r.Use(server.restrictToMode)
+	// Requests on a domain bound to a site never reach the API routes
+	if mode != ModeUpload {
+		if server.domains != nil {
+			r.Use(server.scopeTenantDomain)
+		}
+		r.Use(server.siteHostRouter)
+	}
+
 	v1 := r.Group("/api/v1")
+	v1.Use(server.authenticate, server.requireDomainTenant, server.meterRequest, server.auditRequest, server.authorizeRequest, server.limitKeyRate, server.classifyRequest, server.readTransferHints, server.resolveFeatureFlags)
 	{
 		v1.POST("/upload", server.handleUpload)
+		v1.POST("/upload/json", server.handleUploadJSON)
+		v1.POST("/upload/dir", server.handleUploadDirectory)
+		v1.POST("/multipart", server.handleInitiateMultipart)
+		v1.GET("/multipart/:id", server.handleGetMultipart)
+		v1.PUT("/multipart/:id/parts/:part_number", server.handleUploadPart)
+		v1.POST("/multipart/:id/complete", server.handleCompleteMultipart)
+		v1.DELETE("/multipart/:id", server.handleAbortMultipart)
+		v1.POST("/uploads", server.handleCreateResumable)
+		v1.GET("/uploads/:id", server.handleGetResumable)
+		v1.HEAD("/uploads/:id", server.handleGetResumable)
+		v1.PATCH("/uploads/:id", server.handleAppendResumable)
+		v1.POST("/uploads/:id/complete", server.handleCompleteResumable)
+		v1.DELETE("/uploads/:id", server.handleAbortResumable)
+		v1.POST("/policy/explain", server.handleExplainPolicy)
+		v1.POST("/estimate", server.handleEstimate)
+		v1.GET("/wallet", server.handleWalletStatus)
+		v1.POST("/upload-sessions", server.handleCreateUploadSession)
+		v1.POST("/upload-sessions/:id/upload", server.handleSessionUpload)
 		v1.GET("/download/:root_hash", server.handleDownload)
+		v1.GET("/download/dir/:manifest_root/*path", server.handleDownloadDirectoryFile)
+		v1.GET("/files", server.handleListFiles)
+		v1.GET("/files/history", server.handleUploadHistory)
+		v1.GET("/search", server.handleSearch)
+		v1.POST("/files/info", server.handleBulkFileInfo)
+		v1.GET("/files/:root_hash/status", server.handleFileStatus)
+		v1.DELETE("/files/:root_hash", server.handleDeleteFile)
+		v1.GET("/usage", server.handleUsage)
+		v1.GET("/quota", server.handleQuota)
+		v1.POST("/manifests", server.handleCreateManifest)
+		v1.POST("/publish", server.handlePublish)
+		v1.GET("/jobs", server.handleListJobs)
+		v1.GET("/jobs/:id", server.handleGetJob)
+		v1.GET("/jobs/:id/wait", server.handleWaitJob)
+		v1.GET("/jobs/:id/events", server.handleJobEvents)
+		v1.GET("/receipts/:tx_hash", server.handleReceipt)
+		v1.GET("/tx/:tx_hash", server.handleTxStatus)
+		v1.GET("/me", server.handleGetAccount)
+		v1.GET("/me/keys", server.handleListKeys)
+		v1.POST("/me/keys/rotate", server.handleRotateKey)
+		v1.DELETE("/me/keys/:id", server.handleRevokeKey)
+		v1.GET("/sites", server.handleListSites)
+		v1.PUT("/sites/:name", server.handleSetSite)
+		v1.POST("/links", server.handleCreateLink)
+		v1.POST("/zip", server.handleZip)
+		v1.GET("/webhooks", server.handleListWebhooks)
+		v1.POST("/webhooks", server.handleCreateWebhook)
+		v1.GET("/webhooks/:id", server.handleGetWebhook)
+		v1.PUT("/webhooks/:id", server.handleUpdateWebhook)
+		v1.DELETE("/webhooks/:id", server.handleDeleteWebhook)
+		v1.POST("/streams/:id/append", server.handleStreamAppend)
+		v1.GET("/streams/:id/entries", server.handleStreamEntries)
+		v1.PUT("/kv/:stream_id/:key", server.handleKVPut)
+		v1.GET("/kv/:stream_id/:key", server.handleKVGet)
+		v1.POST("/kv/:stream_id", server.handleKVBatch)
+		v1.GET("/apps/:app/settings/:key", server.handleGetSetting)
+		v1.PUT("/apps/:app/settings/:key", server.handlePutSetting)
+		v1.GET("/links/:id", server.handleGetLink)
+		v1.GET("/network", server.handleNetwork)
+	}
+
+	// Operator endpoints authenticate with ADMIN_TOKEN instead of API keys
+	admin := r.Group("/api/v1/admin", server.requireAdmin)
+	{
+		admin.GET("/gc", server.handleGCReport)
+		admin.POST("/gc", server.handleRunGC)
+		admin.GET("/metering", server.handleMeteringStatus)
+		admin.POST("/metering/export", server.handleExportMetering)
+		admin.GET("/metrics", server.handleMetricsSummary)
+		admin.GET("/nodes", server.handleNodeStats)
+		admin.GET("/shadow", server.handleShadowReport)
+		admin.GET("/wallets", server.handleWalletPool)
+		admin.GET("/uploads/partial", server.handleListPartialUploads)
+		admin.GET("/integrity", server.handleIntegrityReport)
+		admin.GET("/audit", server.handleAuditLog)
+		admin.GET("/catalog/snapshots", server.handleListSnapshots)
+		admin.POST("/catalog/snapshots", server.handlePublishSnapshot)
+		admin.POST("/catalog/backfill", server.handleBackfillMetadata)
+		admin.POST("/catalog/verify", server.handleVerifyCatalog)
+		admin.GET("/catalog/verify/:id/report", server.handleVerifyReport)
+		admin.GET("/jobs/:id", server.handleGetAdminJob)
+		admin.GET("/impersonate", server.handleListImpersonations)
+		admin.POST("/impersonate", server.handleImpersonate)
+		admin.DELETE("/impersonate/:id", server.handleRevokeImpersonation)
+		admin.POST("/cdn/purge", server.handleCDNPurge)
+		admin.GET("/lifecycle", server.handleListLifecycleRules)
+		admin.POST("/access/explain", server.handleExplainAccess)
+		admin.GET("/moderation", server.handleListModeration)
+		admin.POST("/moderation/:root_hash/release", server.handleReleaseModeration)
+		admin.GET("/quarantine", server.handleListQuarantine)
+		admin.POST("/quarantine/:id/release", server.handleReleaseQuarantine)
+		admin.DELETE("/quarantine/:id", server.handleRejectQuarantine)
+		admin.POST("/lifecycle", server.handleCreateLifecycleRule)
+		admin.POST("/lifecycle/run", server.handleRunLifecycle)
+		admin.PUT("/lifecycle/:id", server.handleUpdateLifecycleRule)
+		admin.DELETE("/lifecycle/:id", server.handleDeleteLifecycleRule)
+		admin.GET("/reports", server.handleListAbuseReports)
+		admin.POST("/reports/:id/block", server.handleBlockAbuseReport)
+		admin.POST("/reports/:id/dismiss", server.handleDismissAbuseReport)
+		admin.GET("/blocklist", server.handleListBlocklist)
+		admin.POST("/blocklist", server.handleBlock)
+		admin.DELETE("/blocklist/:root_hash", server.handleUnblock)
+	}
+	r.DELETE("/api/v1/cache/:root_hash", server.requireAdmin, server.handleEvictCache)
+
+	// Discovery document for SDKs; public like the Swagger docs
+	r.GET("/api/v1/.well-known/storage-gateway", server.handleWellKnown)
+	r.GET("/api/v1/version", server.handleVersion)
+	// Abuse reports come from third parties without API keys
+	r.POST("/api/v1/report", server.handleReportAbuse)
+	// Pre-flight for uploads; public so that browsers' CORS pre-flights work
+	r.OPTIONS("/api/v1/upload", server.handleUploadOptions)
+	// Keyless uploads paid from the gateway's wallet, within strict rules
+	if server.sponsored != nil {
+		r.GET("/api/v1/sponsored", server.handleSponsorship)
+		r.POST("/api/v1/sponsored/upload", server.sponsorRequest, server.meterRequest, server.auditRequest, server.classifyRequest, server.handleSponsoredUpload)
 	}
 
+	// Public content routes; on a tenant domain they count as the tenant's
+	public := r.Group("", server.onTenantDomain(server.meterRequest), server.onTenantDomain(server.limitKeyRate), server.onTenantDomain(server.classifyRequest))
+	public.GET("/gw/:root_hash/*path", server.requireOrigin, server.handleGateway)
+	r.GET("/peer/objects/:root_hash", server.handlePeerObject)
+	r.HEAD("/peer/objects/:root_hash", server.handlePeerObject)
+	public.GET("/sites/:name/*path", server.requireOrigin, server.handleSite)
+	public.GET("/l/:id", server.requireOrigin, server.handleFollowLink)
+
+	// Prometheus scrape endpoint; route names and volumes are operator data
+	r.GET("/metrics", server.requireAdmin, server.handleMetrics)
+
 	// Swagger documentation endpoint with custom config
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler, ginSwagger.URL("/swagger/doc.json")))
 
@@ -250,9 +1511,42 @@ func main() {
 		`)
 	})
 
-	port := ":8080"
+	port := ":" + cfg.Port
+	server.logBanner()
+	if cfg.ResourceProfile == ResourceLow {
+		log.Printf("🪶 Low-resource profile: %d async upload workers, %d MiB cache, forms spooled past %d KiB", cfg.AsyncUploadWorkers, cfg.CacheMaxBytes>>20, cfg.MultipartMemoryBytes>>10)
+		if cfg.MetadataDSN == ":memory:" {
+			log.Printf("⚠️  Upload history is kept in memory and lost on restart; set METADATA_DSN to keep it")
+		}
+	}
 	log.Printf("🚀 Server starting on http://localhost%s", port)
 	log.Printf("📚 API Documentation: http://localhost%s/swagger/index.html", port)
 	log.Printf("💡 Tip: Click 'Open in New Window' in the browser preview to use Swagger UI")
-	log.Fatal(r.Run(port))
+
+	srv := &http.Server{Addr: port, Handler: r.Handler()}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	server.shutdown(srv, sig, cfg.ShutdownDrainTimeout, cfg.DataPath("jobs.json"))
+	// Stop the background loops, then flush what they hold before exiting
+	cancel()
+	if err := links.Flush(); err != nil {
+		log.Printf("⚠️  Failed to persist link stats: %v", err)
+	}
+	if meter != nil {
+		if err := meter.Export(); err != nil {
+			log.Printf("⚠️  Failed to export metering: %v", err)
+		}
+	}
+	if tracer != nil {
+		if err := tracer.Flush(); err != nil {
+			log.Printf("⚠️  %v", err)
+		}
+	}
+	log.Printf("👋 Stopped")
 }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ManifestVersion = 1
+
+	// Anything larger is treated as a plain file rather than a manifest.
+	maxManifestSize = 16 << 20
+	// Manifests are immutable, so parsed copies can be kept indefinitely.
+	maxCachedManifests = 256
+)
+
+var errNotManifest = errors.New("object is not a manifest")
+
+// ManifestEntry points a relative path inside a directory at a file stored on 0G.
+type ManifestEntry struct {
+	RootHash    string `json:"root_hash"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// Manifest maps relative paths to root hashes. It is itself uploaded to 0G, so
+// a whole directory is addressed by the manifest's root hash.
+type Manifest struct {
+	Version int                      `json:"version"`
+	Files   map[string]ManifestEntry `json:"files"`
+}
+
+type CreateManifestResponse struct {
+	ManifestRoot string `json:"manifest_root"`
+	TxHash       string `json:"tx_hash"`
+	Files        int    `json:"files"`
+	// ID is the manifest's public ID, when PUBLIC_ID_SCHEME is set
+	ID string `json:"id,omitempty"`
+
+	newReference bool
+}
+
+func ParseManifest(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil || m.Version == 0 || m.Files == nil {
+		return nil, errNotManifest
+	}
+	return &m, nil
+}
+
+// normalizeManifestPath turns a request or archive path into the canonical
+// key used in Manifest.Files.
+func normalizeManifestPath(p string) (string, error) {
+	if strings.Contains(p, "\\") {
+		return "", fmt.Errorf("invalid path %q", p)
+	}
+	clean := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if clean == "" {
+		return "", fmt.Errorf("invalid path %q", p)
+	}
+	return clean, nil
+}
+
+// Validate normalizes every path and makes sure each entry has a root hash.
+func (m *Manifest) Validate() error {
+	if m.Version == 0 {
+		m.Version = ManifestVersion
+	}
+	if len(m.Files) == 0 {
+		return errors.New("manifest has no files")
+	}
+
+	files := make(map[string]ManifestEntry, len(m.Files))
+	for p, entry := range m.Files {
+		clean, err := normalizeManifestPath(p)
+		if err != nil {
+			return err
+		}
+		if entry.RootHash == "" {
+			return fmt.Errorf("missing root_hash for %q", p)
+		}
+		if _, dup := files[clean]; dup {
+			return fmt.Errorf("duplicate path %q", clean)
+		}
+		files[clean] = entry
+	}
+	m.Files = files
+	return nil
+}
+
+func (m *Manifest) Lookup(p string) (ManifestEntry, bool) {
+	entry, ok := m.Files[p]
+	return entry, ok
+}
+
+type manifestCache struct {
+	mu        sync.RWMutex
+	manifests map[string]*Manifest
+}
+
+func newManifestCache() *manifestCache {
+	return &manifestCache{manifests: make(map[string]*Manifest)}
+}
+
+func (mc *manifestCache) get(rootHash string) (*Manifest, bool) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	m, ok := mc.manifests[rootHash]
+	return m, ok
+}
+
+func (mc *manifestCache) put(rootHash string, m *Manifest) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if len(mc.manifests) >= maxCachedManifests {
+		for k := range mc.manifests {
+			delete(mc.manifests, k)
+			break
+		}
+	}
+	mc.manifests[rootHash] = m
+}
+
+// @Summary Create a directory manifest
+// @Description Uploads a manifest mapping relative paths to root hashes of files already stored on 0G. The returned manifest root can be browsed via /gw/{manifest_root}/{path} or published as a site.
+// @Accept json
+// @Produce json
+// @Param manifest body Manifest true "Manifest"
+// @Success 200 {object} CreateManifestResponse
+// @Security ApiKeyAuth
+// @Router /manifests [post]
+func (s *Server) handleCreateManifest(c *gin.Context) {
+	var m Manifest
+	if err := c.ShouldBindJSON(&m); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid manifest: " + err.Error()})
+		return
+	}
+	if err := m.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := s.storeManifest(tenantFrom(c), &m)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// storeManifest uploads a validated manifest and records it for tenant.
+func (s *Server) storeManifest(tenant string, m *Manifest) (CreateManifestResponse, error) {
+	// Fill in sizes we already know about
+	for p, entry := range m.Files {
+		if entry.Size == 0 {
+			if obj, ok := s.catalog.Object(entry.RootHash); ok {
+				entry.Size = obj.Size
+				m.Files[p] = entry
+			}
+		}
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return CreateManifestResponse{}, err
+	}
+
+	txHash, rootHash, err := s.client.UploadData(data)
+	if err != nil {
+		return CreateManifestResponse{}, err
+	}
+	s.manifests.put(rootHash, m)
+
+	isNew := !s.catalog.HasReference(tenant, rootHash)
+	if _, err := s.catalog.AddReference(FileRecord{
+		Tenant:    tenant,
+		RootHash:  rootHash,
+		TxHash:    txHash,
+		Filename:  "manifest.json",
+		Size:      int64(len(data)),
+		CreatedAt: time.Now(),
+	}); err != nil {
+		return CreateManifestResponse{}, err
+	}
+
+	return CreateManifestResponse{
+		ManifestRoot: rootHash,
+		TxHash:       txHash,
+		Files:        len(m.Files),
+		ID:           s.assignPublicID(rootHash),
+		newReference: isNew,
+	}, nil
+}
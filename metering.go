@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Formats usage is exported in
+const (
+	MeteringCSV = "csv"
+	// MeteringOpenMeter is a batch of CloudEvents, as OpenMeter ingests them
+	MeteringOpenMeter = "openmeter"
+)
+
+const (
+	meteringEventType   = "storage.usage"
+	meteringEventSource = "0g-storage-gateway"
+	// maxUnsentBatches bounds the windows kept for a sink that keeps failing
+	maxUnsentBatches = 168
+)
+
+// UsageRecord is one tenant's usage on one instance over one window.
+type UsageRecord struct {
+	Tenant      string    `json:"tenant"`
+	Instance    string    `json:"instance"`
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+	// Requests counts authenticated API requests
+	Requests int64 `json:"requests"`
+	// Uploads and StoredBytes count files stored, deduplicated ones included
+	Uploads     int64 `json:"uploads"`
+	StoredBytes int64 `json:"stored_bytes"`
+	// IngressBytes and EgressBytes are request and response bodies
+	IngressBytes int64 `json:"ingress_bytes"`
+	EgressBytes  int64 `json:"egress_bytes"`
+	// GasUsed is the tenant's share of the submissions mined in the window,
+	// split by bytes when a transaction carried several tenants' files
+	GasUsed uint64 `json:"gas_used"`
+	// FeeWei is the gas times its price, in wei, as a decimal string
+	FeeWei string `json:"fee_wei"`
+}
+
+// meteredSubmission is a file stored in a transaction that is not mined yet.
+type meteredSubmission struct {
+	Tenant string `json:"tenant"`
+	Size   int64  `json:"size"`
+}
+
+// meteringBatch is an exported window and the sinks it still has to reach.
+type meteringBatch struct {
+	ID      string        `json:"id"`
+	Records []UsageRecord `json:"records"`
+	Sinks   []string      `json:"sinks"`
+}
+
+type meterSnapshot struct {
+	WindowStart time.Time                      `json:"window_start"`
+	Usage       map[string]*UsageRecord        `json:"usage"`
+	Awaiting    map[string][]meteredSubmission `json:"awaiting"`
+	Unsent      []meteringBatch                `json:"unsent"`
+}
+
+// Meter counts what each tenant uses and exports it every interval to a
+// directory, an S3 bucket and/or a webhook, for external billing. Windows a
+// sink could not take are retried with the next export.
+type Meter struct {
+	format     string
+	instance   string
+	dir        string
+	s3         *S3Client
+	s3Prefix   string
+	webhookURL string
+	secret     string
+	http       *http.Client
+	path       string
+	// exporting keeps a manual export from racing the scheduled one
+	exporting sync.Mutex
+
+	mu        sync.Mutex
+	start     time.Time
+	usage     map[string]*UsageRecord
+	awaiting  map[string][]meteredSubmission
+	unsent    []meteringBatch
+	lastRun   time.Time
+	lastError string
+}
+
+// NewMeter returns nil unless a metering sink is configured.
+func NewMeter(cfg *Config) (*Meter, error) {
+	if cfg.MeteringDir == "" && cfg.MeteringS3.Bucket == "" && cfg.MeteringWebhookURL == "" {
+		return nil, nil
+	}
+	if cfg.MeteringFormat != MeteringCSV && cfg.MeteringFormat != MeteringOpenMeter {
+		return nil, fmt.Errorf("invalid METERING_FORMAT %q (expected csv or openmeter)", cfg.MeteringFormat)
+	}
+	m := &Meter{
+		format:     cfg.MeteringFormat,
+		instance:   instanceID(),
+		dir:        cfg.MeteringDir,
+		s3Prefix:   cfg.MeteringS3.Prefix,
+		webhookURL: cfg.MeteringWebhookURL,
+		secret:     cfg.MeteringWebhookSecret,
+		http:       &http.Client{Timeout: 30 * time.Second},
+		path:       cfg.DataPath("metering.json"),
+		start:      time.Now().UTC(),
+		usage:      make(map[string]*UsageRecord),
+		awaiting:   make(map[string][]meteredSubmission),
+	}
+	if m.dir != "" {
+		if err := os.MkdirAll(m.dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create metering directory: %v", err)
+		}
+	}
+	if s3 := cfg.MeteringS3; s3.Bucket != "" {
+		client, err := NewS3Client(s3.Endpoint, s3.Region, s3.Bucket, s3.AccessKey, s3.SecretKey)
+		if err != nil {
+			return nil, err
+		}
+		m.s3 = client
+	}
+
+	if m.path == "" {
+		return m, nil
+	}
+	var snap meterSnapshot
+	if err := readJSONFile(m.path, &snap); err != nil {
+		return nil, fmt.Errorf("failed to load metering state: %v", err)
+	}
+	if !snap.WindowStart.IsZero() {
+		m.start = snap.WindowStart
+	}
+	if snap.Usage != nil {
+		m.usage = snap.Usage
+	}
+	if snap.Awaiting != nil {
+		m.awaiting = snap.Awaiting
+	}
+	m.unsent = snap.Unsent
+	return m, nil
+}
+
+// sinks names the configured destinations.
+func (m *Meter) sinks() []string {
+	var sinks []string
+	if m.dir != "" {
+		sinks = append(sinks, "dir")
+	}
+	if m.s3 != nil {
+		sinks = append(sinks, "s3")
+	}
+	if m.webhookURL != "" {
+		sinks = append(sinks, "webhook")
+	}
+	return sinks
+}
+
+func (m *Meter) recordLocked(tenant string) *UsageRecord {
+	rec, ok := m.usage[tenant]
+	if !ok {
+		rec = &UsageRecord{Tenant: tenant, FeeWei: "0"}
+		m.usage[tenant] = rec
+	}
+	return rec
+}
+
+func (m *Meter) saveLocked() {
+	if m.path == "" {
+		return
+	}
+	snap := meterSnapshot{WindowStart: m.start, Usage: m.usage, Awaiting: m.awaiting, Unsent: m.unsent}
+	if err := writeJSONFile(m.path, snap); err != nil {
+		log.Printf("⚠️  Failed to save metering state: %v", err)
+	}
+}
+
+// Middleware counts an authenticated API request and its body sizes.
+func (m *Meter) Middleware(c *gin.Context) {
+	c.Next()
+	in := c.Request.ContentLength
+	if in < 0 {
+		in = 0
+	}
+	out := int64(c.Writer.Size())
+	if out < 0 {
+		out = 0
+	}
+	m.mu.Lock()
+	rec := m.recordLocked(tenantFrom(c))
+	rec.Requests++
+	rec.IngressBytes += in
+	rec.EgressBytes += out
+	m.mu.Unlock()
+}
+
+// Stored counts a file a tenant stored. txHash is the submission it went
+// out in, whose gas is charged once it is mined, or "" when the content was
+// already on 0G.
+func (m *Meter) Stored(tenant string, size int64, txHash string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec := m.recordLocked(tenant)
+	rec.Uploads++
+	rec.StoredBytes += size
+	if txHash != "" {
+		m.awaiting[txHash] = append(m.awaiting[txHash], meteredSubmission{Tenant: tenant, Size: size})
+		m.saveLocked()
+	}
+}
+
+// AwaitingTxs returns the submissions whose gas is not charged yet.
+func (m *Meter) AwaitingTxs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	txs := make([]string, 0, len(m.awaiting))
+	for txHash := range m.awaiting {
+		txs = append(txs, txHash)
+	}
+	return txs
+}
+
+// Mined charges the gas of a mined submission to the tenants whose files it
+// carried, in proportion to their size.
+func (m *Meter) Mined(txHash string, gasUsed, gasPrice uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	subs, ok := m.awaiting[txHash]
+	if !ok {
+		return
+	}
+	delete(m.awaiting, txHash)
+
+	var total int64
+	for _, sub := range subs {
+		total += sub.Size
+	}
+	gas := new(big.Int).SetUint64(gasUsed)
+	fee := new(big.Int).Mul(gas, new(big.Int).SetUint64(gasPrice))
+	for _, sub := range subs {
+		weight, of := big.NewInt(sub.Size), big.NewInt(total)
+		if total == 0 {
+			weight, of = big.NewInt(1), big.NewInt(int64(len(subs)))
+		}
+		rec := m.recordLocked(sub.Tenant)
+		rec.GasUsed += new(big.Int).Div(new(big.Int).Mul(gas, weight), of).Uint64()
+		share := new(big.Int).Div(new(big.Int).Mul(fee, weight), of)
+		current, ok := new(big.Int).SetString(rec.FeeWei, 10)
+		if !ok {
+			current = new(big.Int)
+		}
+		rec.FeeWei = current.Add(current, share).String()
+	}
+	m.saveLocked()
+}
+
+// Run exports usage every interval until ctx is done, and once more then.
+func (m *Meter) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.Export()
+		case <-ctx.Done():
+			m.Export()
+			return
+		}
+	}
+}
+
+// Export closes the current window and sends it, with any windows still
+// unsent, to every sink that has not taken them yet.
+func (m *Meter) Export() error {
+	m.exporting.Lock()
+	defer m.exporting.Unlock()
+
+	m.mu.Lock()
+	now := time.Now().UTC()
+	if len(m.usage) > 0 {
+		batch := meteringBatch{ID: m.start.Format("20060102T150405Z") + "-" + m.instance, Sinks: m.sinks()}
+		for _, rec := range m.usage {
+			rec.Instance = m.instance
+			rec.WindowStart = m.start
+			rec.WindowEnd = now
+			batch.Records = append(batch.Records, *rec)
+		}
+		sort.Slice(batch.Records, func(i, j int) bool { return batch.Records[i].Tenant < batch.Records[j].Tenant })
+		m.unsent = append(m.unsent, batch)
+		if drop := len(m.unsent) - maxUnsentBatches; drop > 0 {
+			log.Printf("⚠️  Dropping %d unsent metering windows", drop)
+			m.unsent = m.unsent[drop:]
+		}
+	}
+	m.start = now
+	m.usage = make(map[string]*UsageRecord)
+	pending := append([]meteringBatch(nil), m.unsent...)
+	m.saveLocked()
+	m.mu.Unlock()
+
+	var firstErr error
+	sent := make(map[string][]string)
+	for _, batch := range pending {
+		body, contentType, err := m.encode(batch)
+		if err != nil {
+			return err
+		}
+		for _, sink := range batch.Sinks {
+			if err := m.send(sink, batch, body, contentType); err != nil {
+				log.Printf("⚠️  Failed to export metering window %s to %s: %v", batch.ID, sink, err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			sent[batch.ID] = append(sent[batch.ID], sink)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var unsent []meteringBatch
+	for _, batch := range m.unsent {
+		batch.Sinks = withoutSinks(batch.Sinks, sent[batch.ID])
+		if len(batch.Sinks) > 0 {
+			unsent = append(unsent, batch)
+		}
+	}
+	m.unsent = unsent
+	m.lastRun = now
+	m.lastError = ""
+	if firstErr != nil {
+		m.lastError = firstErr.Error()
+	}
+	m.saveLocked()
+	return firstErr
+}
+
+// withoutSinks returns list minus the sinks in drop.
+func withoutSinks(list, drop []string) []string {
+	var kept []string
+	for _, v := range list {
+		found := false
+		for _, d := range drop {
+			if v == d {
+				found = true
+				break
+			}
+		}
+		if !found {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// encode renders a batch in the configured format.
+func (m *Meter) encode(batch meteringBatch) ([]byte, string, error) {
+	if m.format == MeteringOpenMeter {
+		events := make([]map[string]interface{}, 0, len(batch.Records))
+		for _, rec := range batch.Records {
+			events = append(events, map[string]interface{}{
+				"specversion":     "1.0",
+				"id":              batch.ID + "-" + rec.Tenant,
+				"source":          meteringEventSource,
+				"type":            meteringEventType,
+				"subject":         rec.Tenant,
+				"time":            rec.WindowEnd,
+				"datacontenttype": "application/json",
+				"data":            rec,
+			})
+		}
+		body, err := json.Marshal(events)
+		return body, "application/cloudevents-batch+json", err
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"tenant", "instance", "window_start", "window_end", "requests", "uploads", "stored_bytes", "ingress_bytes", "egress_bytes", "gas_used", "fee_wei"})
+	for _, rec := range batch.Records {
+		w.Write([]string{
+			rec.Tenant,
+			rec.Instance,
+			rec.WindowStart.Format(time.RFC3339),
+			rec.WindowEnd.Format(time.RFC3339),
+			strconv.FormatInt(rec.Requests, 10),
+			strconv.FormatInt(rec.Uploads, 10),
+			strconv.FormatInt(rec.StoredBytes, 10),
+			strconv.FormatInt(rec.IngressBytes, 10),
+			strconv.FormatInt(rec.EgressBytes, 10),
+			strconv.FormatUint(rec.GasUsed, 10),
+			rec.FeeWei,
+		})
+	}
+	w.Flush()
+	return buf.Bytes(), "text/csv", w.Error()
+}
+
+func (m *Meter) fileName(batch meteringBatch) string {
+	ext := ".csv"
+	if m.format == MeteringOpenMeter {
+		ext = ".json"
+	}
+	return "usage-" + batch.ID + ext
+}
+
+// send delivers an encoded batch to one sink.
+func (m *Meter) send(sink string, batch meteringBatch, body []byte, contentType string) error {
+	switch sink {
+	case "dir":
+		tmp := filepath.Join(m.dir, "."+m.fileName(batch)+".tmp")
+		if err := os.WriteFile(tmp, body, 0644); err != nil {
+			return err
+		}
+		return os.Rename(tmp, filepath.Join(m.dir, m.fileName(batch)))
+	case "s3":
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		_, err := m.s3.PutObject(ctx, m.s3Prefix+m.fileName(batch), bytes.NewReader(body))
+		return err
+	case "webhook":
+		req, err := http.NewRequest(http.MethodPost, m.webhookURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("X-Metering-Batch", batch.ID)
+		if m.secret != "" {
+			mac := hmac.New(sha256.New, []byte(m.secret))
+			mac.Write(body)
+			req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+		resp, err := m.http.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("endpoint returned %s", resp.Status)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown metering sink %q", sink)
+}
+
+type MeteringStatus struct {
+	Format      string    `json:"format"`
+	Sinks       []string  `json:"sinks"`
+	WindowStart time.Time `json:"window_start"`
+	// Current is usage so far in the open window
+	Current []UsageRecord `json:"current"`
+	// AwaitingTxs are submissions whose gas is charged once mined
+	AwaitingTxs int `json:"awaiting_txs"`
+	// UnsentWindows are closed windows a sink has not taken yet
+	UnsentWindows int        `json:"unsent_windows"`
+	LastExport    *time.Time `json:"last_export,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+func (m *Meter) Status() MeteringStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := MeteringStatus{
+		Format:        m.format,
+		Sinks:         m.sinks(),
+		WindowStart:   m.start,
+		Current:       []UsageRecord{},
+		AwaitingTxs:   len(m.awaiting),
+		UnsentWindows: len(m.unsent),
+		LastError:     m.lastError,
+	}
+	for _, rec := range m.usage {
+		status.Current = append(status.Current, *rec)
+	}
+	sort.Slice(status.Current, func(i, j int) bool { return status.Current[i].Tenant < status.Current[j].Tenant })
+	if !m.lastRun.IsZero() {
+		last := m.lastRun
+		status.LastExport = &last
+	}
+	return status
+}
+
+// meterRequest counts API requests when metering is configured.
+func (s *Server) meterRequest(c *gin.Context) {
+	if s.meter == nil {
+		c.Next()
+		return
+	}
+	s.meter.Middleware(c)
+}
+
+// @Summary Metering status
+// @Description Usage counted so far in the open metering window per tenant, the configured sinks, and windows still waiting to be exported
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Success 200 {object} MeteringStatus
+// @Failure 404 {object} map[string]string
+// @Router /admin/metering [get]
+func (s *Server) handleMeteringStatus(c *gin.Context) {
+	if s.meter == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Metering is not configured"})
+		return
+	}
+	c.JSON(http.StatusOK, s.meter.Status())
+}
+
+// @Summary Export usage now
+// @Description Closes the open metering window and exports it, with any unsent windows, to the configured sinks
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Success 200 {object} MeteringStatus
+// @Failure 404 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Router /admin/metering/export [post]
+func (s *Server) handleExportMetering(c *gin.Context) {
+	if s.meter == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Metering is not configured"})
+		return
+	}
+	if err := s.meter.Export(); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to export usage: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, s.meter.Status())
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// latencySamples is how many recent requests per route feed the percentiles.
+const latencySamples = 1024
+
+// latencyBuckets are the Prometheus histogram bounds in seconds.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type routeKey struct {
+	Method string
+	Route  string
+}
+
+type routeStats struct {
+	requests     uint64
+	clientErrors uint64
+	serverErrors uint64
+	totalSeconds float64
+	buckets      []uint64 // per bucket, not cumulative; the last one is +Inf
+
+	samples []float64 // ring of recent latencies in seconds
+	next    int
+}
+
+type RouteSummary struct {
+	Method       string  `json:"method"`
+	Route        string  `json:"route"`
+	Requests     uint64  `json:"requests"`
+	ClientErrors uint64  `json:"client_errors"`
+	ServerErrors uint64  `json:"server_errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	MeanMs       float64 `json:"mean_ms"`
+	P50Ms        float64 `json:"p50_ms"`
+	P90Ms        float64 `json:"p90_ms"`
+	P99Ms        float64 `json:"p99_ms"`
+}
+
+type MetricsSummary struct {
+	Since  time.Time      `json:"since"`
+	Routes []RouteSummary `json:"routes"`
+	// UploadStages breaks upload time down by pipeline stage
+	UploadStages []StageSummary `json:"upload_stages"`
+}
+
+// RouteMetrics counts requests, errors and latency per registered route.
+// Routes are keyed by their pattern (e.g. /api/v1/download/:root_hash) so
+// the number of series stays bounded.
+type RouteMetrics struct {
+	started time.Time
+
+	mu     sync.Mutex
+	routes map[routeKey]*routeStats
+}
+
+func NewRouteMetrics() *RouteMetrics {
+	return &RouteMetrics{
+		started: time.Now(),
+		routes:  make(map[routeKey]*routeStats),
+	}
+}
+
+// Middleware records every request once the handler chain has finished.
+func (m *RouteMetrics) Middleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	m.observe(routeKey{Method: c.Request.Method, Route: route}, c.Writer.Status(), time.Since(start))
+}
+
+func (m *RouteMetrics) observe(key routeKey, status int, elapsed time.Duration) {
+	seconds := elapsed.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.routes[key]
+	if !ok {
+		st = &routeStats{buckets: make([]uint64, len(latencyBuckets)+1)}
+		m.routes[key] = st
+	}
+
+	st.requests++
+	switch {
+	case status >= 500:
+		st.serverErrors++
+	case status >= 400:
+		st.clientErrors++
+	}
+	st.totalSeconds += seconds
+	st.buckets[sort.SearchFloat64s(latencyBuckets, seconds)]++
+
+	if len(st.samples) < latencySamples {
+		st.samples = append(st.samples, seconds)
+	} else {
+		st.samples[st.next] = seconds
+		st.next = (st.next + 1) % latencySamples
+	}
+}
+
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+// Summary lists routes by request count, busiest first.
+func (m *RouteMetrics) Summary() MetricsSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	routes := make([]RouteSummary, 0, len(m.routes))
+	for key, st := range m.routes {
+		sorted := append([]float64(nil), st.samples...)
+		sort.Float64s(sorted)
+		routes = append(routes, RouteSummary{
+			Method:       key.Method,
+			Route:        key.Route,
+			Requests:     st.requests,
+			ClientErrors: st.clientErrors,
+			ServerErrors: st.serverErrors,
+			ErrorRate:    float64(st.clientErrors+st.serverErrors) / float64(st.requests),
+			MeanMs:       st.totalSeconds / float64(st.requests) * 1000,
+			P50Ms:        percentile(sorted, 0.50) * 1000,
+			P90Ms:        percentile(sorted, 0.90) * 1000,
+			P99Ms:        percentile(sorted, 0.99) * 1000,
+		})
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Requests != routes[j].Requests {
+			return routes[i].Requests > routes[j].Requests
+		}
+		return routes[i].Route+routes[i].Method < routes[j].Route+routes[j].Method
+	})
+	return MetricsSummary{Since: m.started, Routes: routes}
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format.
+func (m *RouteMetrics) WritePrometheus(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]routeKey, 0, len(m.routes))
+	for key := range m.routes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Route != keys[j].Route {
+			return keys[i].Route < keys[j].Route
+		}
+		return keys[i].Method < keys[j].Method
+	})
+	labels := func(k routeKey) string {
+		return fmt.Sprintf("method=%q,route=%q", k.Method, k.Route)
+	}
+
+	fmt.Fprintln(w, "# HELP http_requests_total Requests handled, by route.")
+	fmt.Fprintln(w, "# TYPE http_requests_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "http_requests_total{%s} %d\n", labels(k), m.routes[k].requests)
+	}
+
+	fmt.Fprintln(w, "# HELP http_request_errors_total Requests answered with a 4xx or 5xx status, by route.")
+	fmt.Fprintln(w, "# TYPE http_request_errors_total counter")
+	for _, k := range keys {
+		st := m.routes[k]
+		fmt.Fprintf(w, "http_request_errors_total{%s,class=\"4xx\"} %d\n", labels(k), st.clientErrors)
+		fmt.Fprintf(w, "http_request_errors_total{%s,class=\"5xx\"} %d\n", labels(k), st.serverErrors)
+	}
+
+	fmt.Fprintln(w, "# HELP http_request_duration_seconds Request latency, by route.")
+	fmt.Fprintln(w, "# TYPE http_request_duration_seconds histogram")
+	for _, k := range keys {
+		st := m.routes[k]
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += st.buckets[i]
+			fmt.Fprintf(w, "http_request_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels(k), le, cumulative)
+		}
+		fmt.Fprintf(w, "http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels(k), st.requests)
+		fmt.Fprintf(w, "http_request_duration_seconds_sum{%s} %g\n", labels(k), st.totalSeconds)
+		fmt.Fprintf(w, "http_request_duration_seconds_count{%s} %d\n", labels(k), st.requests)
+	}
+}
+
+// handleMetrics serves GET /metrics for Prometheus scrapers.
+func (s *Server) handleMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	s.metrics.WritePrometheus(c.Writer)
+	s.stages.WritePrometheus(c.Writer)
+}
+
+// @Summary Per-route usage summary
+// @Description Request counts, error rates and latency percentiles (over the last 1024 requests) for every API route, busiest first, and the same percentiles for each upload pipeline stage
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Success 200 {object} MetricsSummary
+// @Router /admin/metrics [get]
+func (s *Server) handleMetricsSummary(c *gin.Context) {
+	summary := s.metrics.Summary()
+	summary.UploadStages = s.stages.Summary()
+	c.JSON(http.StatusOK, summary)
+}
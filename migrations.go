@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Migration moves the files under DATA_DIR from the previous schema version
+// to Version. Up must leave them readable by this release; it runs once per
+// data directory, in version order, before any store is loaded.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(cfg *Config) error
+}
+
+// migrations is the ordered history of schema changes to persisted state.
+// Append to it when a release changes a file's layout; never edit or reorder
+// entries that have shipped.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		// Data written before versioning already has this layout
+		Up: func(cfg *Config) error { return nil },
+	},
+}
+
+func latestSchemaVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+type AppliedMigration struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// SchemaState is what schema.json records about a data directory.
+type SchemaState struct {
+	Version int                `json:"version"`
+	Applied []AppliedMigration `json:"applied"`
+}
+
+func loadSchemaState(cfg *Config) (SchemaState, error) {
+	var state SchemaState
+	if err := readJSONFile(cfg.DataPath("schema.json"), &state); err != nil {
+		return SchemaState{}, fmt.Errorf("failed to read schema version: %v", err)
+	}
+	if state.Version > latestSchemaVersion() {
+		return state, fmt.Errorf("data directory is at schema version %d but this release only knows up to %d; it was written by a newer release", state.Version, latestSchemaVersion())
+	}
+	return state, nil
+}
+
+// pendingMigrations lists the migrations a data directory at version has not
+// had yet.
+func pendingMigrations(version int) []Migration {
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// migrate applies every pending migration to DATA_DIR, recording each in
+// schema.json as soon as it succeeds so an interrupted run resumes where it
+// stopped. Without a DATA_DIR nothing is persisted and there is nothing to do.
+func migrate(cfg *Config) ([]AppliedMigration, error) {
+	if cfg.DataDir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %v", err)
+	}
+	state, err := loadSchemaState(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []AppliedMigration
+	for _, m := range pendingMigrations(state.Version) {
+		if err := m.Up(cfg); err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Name, err)
+		}
+		record := AppliedMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}
+		state.Version = m.Version
+		state.Applied = append(state.Applied, record)
+		if err := writeJSONFile(cfg.DataPath("schema.json"), state); err != nil {
+			return applied, fmt.Errorf("failed to record migration %d: %v", m.Version, err)
+		}
+		applied = append(applied, record)
+	}
+	return applied, nil
+}
+
+// prepareDataDir brings DATA_DIR up to this release's schema at startup. With
+// AUTO_MIGRATE off, pending migrations stop startup instead of running.
+func prepareDataDir(cfg *Config) error {
+	if cfg.DataDir == "" {
+		return nil
+	}
+	if !cfg.AutoMigrate {
+		state, err := loadSchemaState(cfg)
+		if err != nil {
+			return err
+		}
+		if pending := pendingMigrations(state.Version); len(pending) > 0 {
+			return fmt.Errorf("data directory is at schema version %d and needs %d migration(s) to reach %d; run \"go run . migrate\"", state.Version, len(pending), latestSchemaVersion())
+		}
+		return nil
+	}
+
+	applied, err := migrate(cfg)
+	for _, m := range applied {
+		log.Printf("🗃️  Applied migration %d (%s)", m.Version, m.Name)
+	}
+	return err
+}
+
+// runMigrateCommand implements "migrate": apply pending migrations and exit,
+// or with "migrate status" only report the schema version.
+func runMigrateCommand(cfg *Config, args []string) error {
+	if cfg.DataDir == "" {
+		return fmt.Errorf("no data directory to migrate (set DATA_DIR)")
+	}
+	if len(args) > 0 && args[0] == "status" {
+		state, err := loadSchemaState(cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("schema version %d of %d\n", state.Version, latestSchemaVersion())
+		for _, m := range state.Applied {
+			fmt.Printf("  applied  %3d %s (%s)\n", m.Version, m.Name, m.AppliedAt.Format(time.RFC3339))
+		}
+		for _, m := range pendingMigrations(state.Version) {
+			fmt.Printf("  pending  %3d %s\n", m.Version, m.Name)
+		}
+		return nil
+	}
+
+	applied, err := migrate(cfg)
+	for _, m := range applied {
+		fmt.Printf("applied %d %s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Printf("schema is up to date at version %d\n", latestSchemaVersion())
+	}
+	return nil
+}
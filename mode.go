@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServiceMode selects which half of the API a process serves, so the write
+// and read paths can be deployed and scaled separately over one catalog.
+type ServiceMode string
+
+const (
+	ModeAll      ServiceMode = "all"
+	ModeUpload   ServiceMode = "upload"
+	ModeDownload ServiceMode = "download"
+)
+
+func parseServiceMode(raw string) (ServiceMode, error) {
+	switch mode := ServiceMode(strings.ToLower(strings.TrimSpace(raw))); mode {
+	case "", ModeAll:
+		return ModeAll, nil
+	case ModeUpload, ModeDownload:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown service mode %q (want all, upload or download)", raw)
+}
+
+// Routes that belong to the write path: they create content or change the
+// catalog. A download-only process does not serve them.
+var uploadRoutes = map[string]bool{
+	"POST /api/v1/upload":                              true,
+	"OPTIONS /api/v1/upload":                           true,
+	"POST /api/v1/upload/json":                         true,
+	"POST /api/v1/upload/dir":                          true,
+	"POST /api/v1/multipart":                           true,
+	"GET /api/v1/multipart/:id":                        true,
+	"PUT /api/v1/multipart/:id/parts/:part_number":     true,
+	"POST /api/v1/multipart/:id/complete":              true,
+	"DELETE /api/v1/multipart/:id":                     true,
+	"POST /api/v1/uploads":                             true,
+	"GET /api/v1/uploads/:id":                          true,
+	"HEAD /api/v1/uploads/:id":                         true,
+	"PATCH /api/v1/uploads/:id":                        true,
+	"POST /api/v1/uploads/:id/complete":                true,
+	"DELETE /api/v1/uploads/:id":                       true,
+	"POST /api/v1/estimate":                            true,
+	"POST /api/v1/upload-sessions":                     true,
+	"POST /api/v1/upload-sessions/:id/upload":          true,
+	"POST /api/v1/sponsored/upload":                    true,
+	"DELETE /api/v1/files/:root_hash":                  true,
+	"POST /api/v1/manifests":                           true,
+	"POST /api/v1/publish":                             true,
+	"GET /api/v1/jobs":                                 true,
+	"GET /api/v1/jobs/:id":                             true,
+	"GET /api/v1/jobs/:id/wait":                        true,
+	"GET /api/v1/jobs/:id/events":                      true,
+	"PUT /api/v1/sites/:name":                          true,
+	"POST /api/v1/streams/:id/append":                  true,
+	"PUT /api/v1/kv/:stream_id/:key":                   true,
+	"POST /api/v1/kv/:stream_id":                       true,
+	"PUT /api/v1/apps/:app/settings/:key":              true,
+	"POST /api/v1/admin/catalog/snapshots":             true,
+	"POST /api/v1/admin/catalog/backfill":              true,
+	"POST /api/v1/admin/lifecycle/run":                 true,
+	"POST /api/v1/admin/moderation/:root_hash/release": true,
+	"POST /api/v1/admin/quarantine/:id/release":        true,
+	"DELETE /api/v1/admin/quarantine/:id":              true,
+	"GET /api/v1/admin/wallets":                        true,
+	"GET /api/v1/admin/uploads/partial":                true,
+}
+
+// Routes that belong to the read path: they serve stored bytes. An
+// upload-only process does not serve them.
+var downloadRoutes = map[string]bool{
+	"GET /api/v1/download/:root_hash":               true,
+	"GET /api/v1/download/dir/:manifest_root/*path": true,
+	"POST /api/v1/zip":                              true,
+	"GET /gw/:root_hash/*path":                      true,
+	"GET /sites/:name/*path":                        true,
+	"GET /l/:id":                                    true,
+	"GET /peer/objects/:root_hash":                  true,
+	"HEAD /peer/objects/:root_hash":                 true,
+}
+
+// Serves reports whether a process in this mode handles route; routes in
+// neither set, such as account and admin endpoints, are served by both.
+func (m ServiceMode) Serves(method, route string) bool {
+	key := method + " " + route
+	switch m {
+	case ModeUpload:
+		return !downloadRoutes[key]
+	case ModeDownload:
+		return !uploadRoutes[key]
+	}
+	return true
+}
+
+// restrictToMode answers 404 for routes the process's mode leaves to the
+// other half of the deployment.
+func (s *Server) restrictToMode(c *gin.Context) {
+	if route := c.FullPath(); route != "" && !s.mode.Serves(c.Request.Method, route) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("Not served by this instance (%s-only mode)", s.mode),
+		})
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ModerationPending     = "pending"
+	ModerationAllowed     = "allowed"
+	ModerationFlagged     = "flagged"
+	ModerationQuarantined = "quarantined"
+	ModerationReleased    = "released"
+	ModerationError       = "error"
+
+	moderationQueueSize = 256
+	moderationAttempts  = 3
+)
+
+// ModerationRecord is the outcome of classifying one stored object.
+type ModerationRecord struct {
+	RootHash    string    `json:"root_hash"`
+	Tenant      string    `json:"tenant"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Status      string    `json:"status"`
+	Labels      []string  `json:"labels,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	SubmittedAt time.Time `json:"submitted_at"`
+	CheckedAt   time.Time `json:"checked_at,omitempty"`
+}
+
+// blocksGateway reports whether the object must not be served publicly.
+func (r *ModerationRecord) blocksGateway() bool {
+	return r.Status == ModerationFlagged || r.Status == ModerationQuarantined
+}
+
+// ModerationVerdict is what the classification endpoint answers with.
+type ModerationVerdict struct {
+	// Verdict is allow, flag or quarantine
+	Verdict string   `json:"verdict"`
+	Labels  []string `json:"labels"`
+	Reason  string   `json:"reason"`
+}
+
+// Moderator sends new image and text uploads to an external classifier in the
+// background. Flagged objects are withheld from the public gateway;
+// quarantined ones are also hidden from their owners' listings and downloads.
+// Operators are notified of both and can release them again.
+type Moderator struct {
+	url       string
+	token     string
+	notifyURL string
+	maxBytes  int64
+	http      *http.Client
+	queue     chan string
+
+	mu      sync.RWMutex
+	path    string
+	records map[string]*ModerationRecord
+}
+
+// NewModerator returns nil unless MODERATION_URL is configured.
+func NewModerator(cfg *Config, path string) (*Moderator, error) {
+	if cfg.ModerationURL == "" {
+		return nil, nil
+	}
+	m := &Moderator{
+		url:       cfg.ModerationURL,
+		token:     cfg.ModerationToken,
+		notifyURL: cfg.ModerationNotifyURL,
+		maxBytes:  cfg.ModerationMaxBytes,
+		http:      &http.Client{Timeout: time.Minute},
+		queue:     make(chan string, moderationQueueSize),
+		path:      path,
+		records:   make(map[string]*ModerationRecord),
+	}
+	if path == "" {
+		return m, nil
+	}
+
+	var records []*ModerationRecord
+	if err := readJSONFile(path, &records); err != nil {
+		return nil, fmt.Errorf("failed to load moderation records: %v", err)
+	}
+	for _, r := range records {
+		m.records[strings.ToLower(r.RootHash)] = r
+		if r.Status == ModerationPending {
+			// Interrupted by a restart: check again
+			select {
+			case m.queue <- r.RootHash:
+			default:
+			}
+		}
+	}
+	return m, nil
+}
+
+// moderatable reports whether the classifier handles a content type.
+func moderatable(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "text/")
+}
+
+// Submit queues a newly stored object for classification. Objects seen
+// before, types the classifier does not handle and oversized files are
+// skipped.
+func (m *Moderator) Submit(rec FileRecord) {
+	if !moderatable(rec.ContentType) || (m.maxBytes > 0 && rec.Size > m.maxBytes) {
+		return
+	}
+
+	rootHash := strings.ToLower(rec.RootHash)
+	m.mu.Lock()
+	if _, ok := m.records[rootHash]; ok {
+		m.mu.Unlock()
+		return
+	}
+	m.records[rootHash] = &ModerationRecord{
+		RootHash:    rootHash,
+		Tenant:      rec.Tenant,
+		Filename:    rec.Filename,
+		ContentType: rec.ContentType,
+		Status:      ModerationPending,
+		SubmittedAt: time.Now(),
+	}
+	if err := m.saveLocked(); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	m.mu.Unlock()
+
+	select {
+	case m.queue <- rootHash:
+	default:
+		// Stays pending and is picked up again on the next start
+		log.Printf("⚠️  Moderation queue full, deferring %s", rootHash)
+	}
+}
+
+// Get returns the moderation record of an object. Records are keyed by the
+// lowercase root hash, so any spelling of it finds them.
+func (m *Moderator) Get(rootHash string) (ModerationRecord, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r, ok := m.records[strings.ToLower(rootHash)]
+	if !ok {
+		return ModerationRecord{}, false
+	}
+	return *r, true
+}
+
+// List returns records with the given status (all when empty), newest first.
+func (m *Moderator) List(status string) []ModerationRecord {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	records := []ModerationRecord{}
+	for _, r := range m.records {
+		if status == "" || r.Status == status {
+			records = append(records, *r)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].SubmittedAt.After(records[j].SubmittedAt) })
+	return records
+}
+
+func (m *Moderator) update(rootHash string, fn func(r *ModerationRecord)) (ModerationRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.records[strings.ToLower(rootHash)]
+	if !ok {
+		return ModerationRecord{}, ErrNotFound
+	}
+	fn(r)
+	return *r, m.saveLocked()
+}
+
+func (m *Moderator) saveLocked() error {
+	if m.path == "" {
+		return nil
+	}
+	records := make([]*ModerationRecord, 0, len(m.records))
+	for _, r := range m.records {
+		records = append(records, r)
+	}
+	if err := writeJSONFile(m.path, records); err != nil {
+		return fmt.Errorf("failed to write moderation records: %v", err)
+	}
+	return nil
+}
+
+// classify sends the object's content to the classifier.
+func (m *Moderator) classify(ctx context.Context, rec ModerationRecord, localPath string) (ModerationVerdict, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return ModerationVerdict{}, err
+	}
+	defer f.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, f)
+	if err != nil {
+		return ModerationVerdict{}, fmt.Errorf("failed to build moderation request: %v", err)
+	}
+	req.Header.Set("Content-Type", rec.ContentType)
+	req.Header.Set("X-Root-Hash", rec.RootHash)
+	req.Header.Set("X-Filename", rec.Filename)
+	if m.token != "" {
+		req.Header.Set("Authorization", "Bearer "+m.token)
+	}
+
+	resp, err := m.http.Do(req)
+	if err != nil {
+		return ModerationVerdict{}, fmt.Errorf("moderation request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return ModerationVerdict{}, fmt.Errorf("moderation endpoint returned %s", resp.Status)
+	}
+
+	var verdict ModerationVerdict
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&verdict); err != nil {
+		return ModerationVerdict{}, fmt.Errorf("failed to decode moderation verdict: %v", err)
+	}
+	switch verdict.Verdict {
+	case "allow", "flag", "quarantine":
+		return verdict, nil
+	}
+	return ModerationVerdict{}, fmt.Errorf("unknown moderation verdict %q", verdict.Verdict)
+}
+
+// notify tells operators about a flagged or quarantined object.
+func (m *Moderator) notify(rec ModerationRecord) {
+	log.Printf("🚩 %s %s (%s): %s", rec.RootHash, rec.Status, rec.Filename, strings.Join(rec.Labels, ", "))
+	if m.notifyURL == "" {
+		return
+	}
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	resp, err := m.http.Post(m.notifyURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("⚠️  Failed to notify moderators about %s: %v", rec.RootHash, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("⚠️  Moderator notification for %s returned %s", rec.RootHash, resp.Status)
+	}
+}
+
+// runModeration works through the moderation queue until ctx is done.
+func (s *Server) runModeration(ctx context.Context) {
+	for {
+		select {
+		case rootHash := <-s.moderation.queue:
+			s.moderate(ctx, rootHash)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) moderate(ctx context.Context, rootHash string) {
+	rec, ok := s.moderation.Get(rootHash)
+	if !ok {
+		return
+	}
+
+	var verdict ModerationVerdict
+	var err error
+	for attempt := 1; attempt <= moderationAttempts; attempt++ {
+		verdict, err = s.classifyObject(ctx, rec)
+		if err == nil || attempt == moderationAttempts {
+			break
+		}
+		select {
+		case <-time.After(time.Duration(attempt) * 5 * time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	rec, saveErr := s.moderation.update(rootHash, func(r *ModerationRecord) {
+		r.CheckedAt = time.Now()
+		if err != nil {
+			r.Status = ModerationError
+			r.Error = err.Error()
+			return
+		}
+		r.Labels = verdict.Labels
+		r.Reason = verdict.Reason
+		r.Error = ""
+		switch verdict.Verdict {
+		case "flag":
+			r.Status = ModerationFlagged
+		case "quarantine":
+			r.Status = ModerationQuarantined
+		default:
+			r.Status = ModerationAllowed
+		}
+	})
+	if saveErr != nil {
+		log.Printf("⚠️  %v", saveErr)
+	}
+	if err != nil {
+		log.Printf("⚠️  Moderation of %s failed: %v", rootHash, err)
+		return
+	}
+
+	if rec.Status == ModerationQuarantined {
+		if _, err := s.catalog.SetHidden(rootHash, true, hiddenByModeration); err != nil {
+			log.Printf("⚠️  Failed to hide quarantined %s: %v", rootHash, err)
+		}
+	}
+	if rec.blocksGateway() {
+		if s.cache != nil {
+			s.cache.Remove(rootHash)
+		}
+		s.moderation.notify(rec)
+	}
+}
+
+func (s *Server) classifyObject(ctx context.Context, rec ModerationRecord) (ModerationVerdict, error) {
+	obj, err := s.fetchObjectAs(ClassBatch, rec.RootHash)
+	if err != nil {
+		return ModerationVerdict{}, err
+	}
+	defer obj.Release()
+	return s.moderation.classify(ctx, rec, obj.Path)
+}
+
+// Withheld reports whether rootHash must not be served. Flagged objects are
+// only withheld publicly; their owners can still download them.
+func (m *Moderator) Withheld(rootHash string, public bool) bool {
+	rec, ok := m.Get(rootHash)
+	if !ok {
+		return false
+	}
+	return rec.Status == ModerationQuarantined || (public && rec.Status == ModerationFlagged)
+}
+
+// withheld answers 451 and returns true when moderation or the blocklist
+// keeps rootHash from being served.
+func (s *Server) withheld(c *gin.Context, rootHash string, public bool) bool {
+	if s.blocked(c, rootHash) {
+		return true
+	}
+	if s.moderation == nil || !s.moderation.Withheld(rootHash, public) {
+		return false
+	}
+	noStore(c)
+	c.JSON(http.StatusUnavailableForLegalReasons, gin.H{"error": "Content withheld by moderation"})
+	return true
+}
+
+// @Summary List moderation records
+// @Description Outcomes of classifying uploaded images and text, newest first. status filters by pending, allowed, flagged, quarantined, released or error.
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Param status query string false "Status"
+// @Success 200 {array} ModerationRecord
+// @Router /admin/moderation [get]
+func (s *Server) handleListModeration(c *gin.Context) {
+	if s.moderation == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Moderation is not enabled (set MODERATION_URL)"})
+		return
+	}
+	c.JSON(http.StatusOK, s.moderation.List(c.Query("status")))
+}
+
+// @Summary Release a moderated object
+// @Description Serves a flagged or quarantined object again and unhides its catalog entries
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Param root_hash path string true "Root hash"
+// @Success 200 {object} ModerationRecord
+// @Router /admin/moderation/{root_hash}/release [post]
+func (s *Server) handleReleaseModeration(c *gin.Context) {
+	if s.moderation == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Moderation is not enabled (set MODERATION_URL)"})
+		return
+	}
+	rootHash := c.Param("root_hash")
+	var wasQuarantined bool
+	rec, err := s.moderation.update(rootHash, func(r *ModerationRecord) {
+		wasQuarantined = r.Status == ModerationQuarantined
+		r.Status = ModerationReleased
+	})
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Moderation record not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if wasQuarantined {
+		// Entries hidden for another reason, e.g. by a lifecycle rule, stay
+		// hidden
+		if _, err := s.catalog.SetHidden(rec.RootHash, false, hiddenByModeration); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, rec)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWithheldMatchesAnyCaseOfRootHash(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	quarantined := "0x" + strings.Repeat("ab", 32)
+	flagged := "0x" + strings.Repeat("cd", 32)
+	s := &Server{moderation: &Moderator{records: map[string]*ModerationRecord{
+		quarantined: {RootHash: quarantined, Status: ModerationQuarantined},
+		flagged:     {RootHash: flagged, Status: ModerationFlagged},
+	}}}
+
+	for _, tc := range []struct {
+		name   string
+		root   string
+		public bool
+		want   bool
+	}{
+		{"quarantined download", quarantined, false, true},
+		{"flagged on the gateway", flagged, true, true},
+		{"flagged owner download", flagged, false, false},
+	} {
+		upper := "0x" + strings.ToUpper(tc.root[2:])
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/gw/"+upper+"/", nil)
+
+		if got := s.withheld(c, upper, tc.public); got != tc.want {
+			t.Errorf("%s: withheld(%s) = %v, want %v", tc.name, upper, got, tc.want)
+		}
+		if tc.want && w.Code != http.StatusUnavailableForLegalReasons {
+			t.Errorf("%s: status %d, want 451", tc.name, w.Code)
+		}
+	}
+}
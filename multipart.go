@@ -0,0 +1,479 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxMultipartParts = 10000
+	// Multipart uploads not completed within this long are aborted by GC.
+	multipartExpiry = 24 * time.Hour
+)
+
+// MultipartPart is one received part of a multipart upload.
+type MultipartPart struct {
+	Number int       `json:"part_number"`
+	ETag   string    `json:"etag"`
+	Size   int64     `json:"size"`
+	At     time.Time `json:"uploaded_at"`
+
+	path string
+}
+
+// MultipartUpload is an object being assembled from parts that may arrive in
+// any order and in parallel.
+type MultipartUpload struct {
+	ID        string            `json:"upload_id"`
+	Tenant    string            `json:"tenant"`
+	Filename  string            `json:"filename"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Parts     []MultipartPart   `json:"parts"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// multipartSession holds the parts of an upload in the spool of the replica
+// that initiated it.
+type multipartSession struct {
+	MultipartUpload
+
+	mu        sync.Mutex
+	parts     map[int]*MultipartPart
+	completed bool
+}
+
+func (u *multipartSession) snapshot() MultipartUpload {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	out := u.MultipartUpload
+	out.Parts = []MultipartPart{}
+	for _, p := range u.parts {
+		out.Parts = append(out.Parts, *p)
+	}
+	sort.Slice(out.Parts, func(i, j int) bool { return out.Parts[i].Number < out.Parts[j].Number })
+	return out
+}
+
+// MultipartStore tracks multipart uploads in progress.
+type MultipartStore struct {
+	spool *Spool
+
+	mu      sync.Mutex
+	uploads map[string]*multipartSession
+}
+
+func NewMultipartStore(spool *Spool) *MultipartStore {
+	return &MultipartStore{spool: spool, uploads: make(map[string]*multipartSession)}
+}
+
+func (s *MultipartStore) Create(tenant, filename string, metadata map[string]string) (*multipartSession, error) {
+	id, err := randomHex(12)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	u := &multipartSession{
+		MultipartUpload: MultipartUpload{
+			ID:        id,
+			Tenant:    tenant,
+			Filename:  filename,
+			Metadata:  metadata,
+			CreatedAt: now,
+			ExpiresAt: now.Add(multipartExpiry),
+		},
+		parts: make(map[int]*MultipartPart),
+	}
+	s.mu.Lock()
+	s.uploads[id] = u
+	s.mu.Unlock()
+	return u, nil
+}
+
+func (s *MultipartStore) Get(tenant, id string) (*multipartSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[id]
+	if !ok || u.Tenant != tenant {
+		return nil, false
+	}
+	return u, true
+}
+
+// PutPart stores part number n from r, replacing an earlier upload of the
+// same part. With a limit, a part that would take the upload's parts past
+// limit bytes in total is refused.
+func (s *MultipartStore) PutPart(u *multipartSession, n int, r io.Reader, limit int64) (MultipartPart, error) {
+	u.mu.Lock()
+	others := u.sizeExceptLocked(n)
+	u.mu.Unlock()
+	if limit > 0 && others >= limit {
+		return MultipartPart{}, uploadTooLarge(limit)
+	}
+
+	sink, err := s.spool.Sink("part-*")
+	if err != nil {
+		return MultipartPart{}, err
+	}
+	hash := md5.New()
+	budget := limit
+	if limit > 0 {
+		budget = limit - others
+	}
+	body := newUploadLimitReader(r, budget)
+	if _, err := io.Copy(io.MultiWriter(sink, hash), body); err != nil {
+		sink.Abort()
+		if body.Exceeded() {
+			return MultipartPart{}, uploadTooLarge(limit)
+		}
+		return MultipartPart{}, fmt.Errorf("failed to store part: %v", err)
+	}
+	staged, err := sink.Commit()
+	if err != nil {
+		return MultipartPart{}, fmt.Errorf("failed to store part: %v", err)
+	}
+	part := &MultipartPart{Number: n, ETag: hex.EncodeToString(hash.Sum(nil)), Size: staged.Size(), At: time.Now(), path: staged.Path}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.completed {
+		s.spool.Release(part.path)
+		return MultipartPart{}, newAPIError(http.StatusConflict, "Upload is already completed or aborted")
+	}
+	// Parts sent in parallel are each checked against what was stored when
+	// they started, so check the total again
+	if limit > 0 && u.sizeExceptLocked(n)+part.Size > limit {
+		s.spool.Release(part.path)
+		return MultipartPart{}, uploadTooLarge(limit)
+	}
+	if old, ok := u.parts[n]; ok {
+		s.spool.Release(old.path)
+	}
+	u.parts[n] = part
+	return *part, nil
+}
+
+// sizeExceptLocked is the total size of the stored parts other than n.
+func (u *multipartSession) sizeExceptLocked(n int) int64 {
+	var total int64
+	for number, p := range u.parts {
+		if number != n {
+			total += p.Size
+		}
+	}
+	return total
+}
+
+// Assemble concatenates the listed parts, which must be in ascending order
+// and match the stored ETags, into one spool file. The upload is closed for
+// further parts; Finish must be called afterwards either way.
+func (s *MultipartStore) Assemble(u *multipartSession, want []CompletedPart) (string, int64, error) {
+	paths, claimed, err := u.claim(want)
+	if err != nil {
+		return "", 0, err
+	}
+	// The parts now belong to this call, which copies them without holding
+	// the upload's lock
+	defer func() {
+		for _, p := range claimed {
+			s.spool.Release(p.path)
+		}
+	}()
+
+	sink, err := s.spool.Sink("multipart-*")
+	if err != nil {
+		return "", 0, err
+	}
+	for _, path := range paths {
+		if _, err := appendFile(sink, path); err != nil {
+			sink.Abort()
+			return "", 0, fmt.Errorf("failed to assemble parts: %v", err)
+		}
+	}
+	out, err := sink.Commit()
+	if err != nil {
+		return "", 0, err
+	}
+	return out.Path, out.Size(), nil
+}
+
+// claim checks the parts to assemble against the stored ones and, when they
+// match, closes the upload and takes its parts out of it. It returns the
+// paths to concatenate and every part taken, listed or not.
+func (u *multipartSession) claim(want []CompletedPart) ([]string, map[int]*MultipartPart, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.completed {
+		return nil, nil, newAPIError(http.StatusConflict, "Upload is already completed or aborted")
+	}
+	if len(want) == 0 {
+		return nil, nil, newAPIError(http.StatusBadRequest, "At least one part is required")
+	}
+	var paths []string
+	for i, p := range want {
+		if i > 0 && p.PartNumber <= want[i-1].PartNumber {
+			return nil, nil, newAPIError(http.StatusBadRequest, "Parts must be listed in ascending order")
+		}
+		stored, ok := u.parts[p.PartNumber]
+		if !ok {
+			return nil, nil, newAPIError(http.StatusBadRequest, "Part %d was not uploaded", p.PartNumber)
+		}
+		if p.ETag != "" && p.ETag != stored.ETag {
+			return nil, nil, newAPIError(http.StatusBadRequest, "Part %d has ETag %s, not %s", p.PartNumber, stored.ETag, p.ETag)
+		}
+		paths = append(paths, stored.path)
+	}
+	u.completed = true
+	claimed := u.parts
+	u.parts = nil
+	return paths, claimed, nil
+}
+
+func appendFile(dst io.Writer, path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(dst, f)
+}
+
+// Finish forgets an upload and releases its parts.
+func (s *MultipartStore) Finish(u *multipartSession) {
+	s.mu.Lock()
+	delete(s.uploads, u.ID)
+	s.mu.Unlock()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.completed = true
+	for _, p := range u.parts {
+		s.spool.Release(p.path)
+	}
+	u.parts = nil
+}
+
+// Abort discards an upload that is not being completed. Once its parts are
+// claimed only the completing request releases them.
+func (s *MultipartStore) Abort(u *multipartSession) error {
+	u.mu.Lock()
+	if u.completed {
+		u.mu.Unlock()
+		return newAPIError(http.StatusConflict, "Upload is already being completed")
+	}
+	u.completed = true
+	u.mu.Unlock()
+	s.Finish(u)
+	return nil
+}
+
+// Expire aborts uploads past their expiry and returns how many there were.
+// Uploads being completed are left to their request.
+func (s *MultipartStore) Expire() int {
+	now := time.Now()
+	s.mu.Lock()
+	var expired []*multipartSession
+	for _, u := range s.uploads {
+		u.mu.Lock()
+		if !u.completed && now.After(u.ExpiresAt) {
+			expired = append(expired, u)
+		}
+		u.mu.Unlock()
+	}
+	s.mu.Unlock()
+
+	for _, u := range expired {
+		s.Finish(u)
+	}
+	return len(expired)
+}
+
+type InitiateMultipartRequest struct {
+	Filename string            `json:"filename" binding:"required"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+type CompletedPart struct {
+	PartNumber int    `json:"part_number"`
+	ETag       string `json:"etag"`
+}
+
+type CompleteMultipartRequest struct {
+	Parts []CompletedPart `json:"parts" binding:"required"`
+	// ShareTTL asks for a share link as on /upload
+	ShareTTL string `json:"share_ttl"`
+}
+
+// @Summary Start a multipart upload
+// @Description Opens an upload that is sent in parts (in any order, in parallel) and assembled into one object on completion. Parts must all go to the same server replica. Uploads not completed within 24 hours are aborted.
+// @Accept json
+// @Produce json
+// @Param request body InitiateMultipartRequest true "File name and metadata"
+// @Success 201 {object} MultipartUpload
+// @Security ApiKeyAuth
+// @Router /multipart [post]
+func (s *Server) handleInitiateMultipart(c *gin.Context) {
+	var req InitiateMultipartRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	u, err := s.multipart.Create(tenantFrom(c), req.Filename, req.Metadata)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, u.snapshot())
+}
+
+// @Summary Upload one part
+// @Description Stores the request body as part part_number (1 to 10000). Re-sending a part replaces it. The response ETag is the part's MD5. A part that would take the upload past MAX_UPLOAD_BYTES in total is refused with 413.
+// @Accept octet-stream
+// @Produce json
+// @Param id path string true "Upload ID"
+// @Param part_number path int true "Part number"
+// @Success 200 {object} MultipartPart
+// @Security ApiKeyAuth
+// @Router /multipart/{id}/parts/{part_number} [put]
+func (s *Server) handleUploadPart(c *gin.Context) {
+	u, ok := s.multipart.Get(tenantFrom(c), c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Multipart upload not found"})
+		return
+	}
+	n, err := strconv.Atoi(c.Param("part_number"))
+	if err != nil || n < 1 || n > maxMultipartParts {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("part_number must be between 1 and %d", maxMultipartParts)})
+		return
+	}
+
+	part, err := s.multipart.PutPart(u, n, c.Request.Body, s.maxUploadBytes)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Header("ETag", `"`+part.ETag+`"`)
+	c.JSON(http.StatusOK, part)
+}
+
+// @Summary Get a multipart upload
+// @Description Lists the parts received so far
+// @Produce json
+// @Param id path string true "Upload ID"
+// @Success 200 {object} MultipartUpload
+// @Security ApiKeyAuth
+// @Router /multipart/{id} [get]
+func (s *Server) handleGetMultipart(c *gin.Context) {
+	u, ok := s.multipart.Get(tenantFrom(c), c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Multipart upload not found"})
+		return
+	}
+	c.JSON(http.StatusOK, u.snapshot())
+}
+
+// @Summary Complete a multipart upload
+// @Description Assembles the listed parts, in ascending part order, into one file and uploads it to 0G. Parts not listed are discarded. ETags are checked when given.
+// @Accept json
+// @Produce json
+// @Param id path string true "Upload ID"
+// @Param request body CompleteMultipartRequest true "Parts to assemble"
+// @Success 200 {object} UploadResponse
+// @Security ApiKeyAuth
+// @Router /multipart/{id}/complete [post]
+func (s *Server) handleCompleteMultipart(c *gin.Context) {
+	u, ok := s.multipart.Get(tenantFrom(c), c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Multipart upload not found"})
+		return
+	}
+	var req CompleteMultipartRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	share, shareTTL, err := shareRequested(req.ShareTTL)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	encryption, err := s.encryption.Requested(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// Parts were spooled as they arrived; assembling them is what is left
+	timer := s.stages.NewTimer(c.Request.Context())
+	start := time.Now()
+	path, size, err := s.multipart.Assemble(u, req.Parts)
+	if err != nil {
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) {
+			s.multipart.Finish(u)
+		}
+		respondError(c, err)
+		return
+	}
+	defer s.spool.Release(path)
+	defer s.multipart.Finish(u)
+	timer.Since(StageSpool, start)
+
+	resp, err := s.storeUpload(uploadRequest{
+		Tenant:   u.Tenant,
+		Class:    requestClassFrom(c),
+		Tuning:   transferTuningFrom(c),
+		KeyID:    c.GetString(keyIDContextKey),
+		Origin:   requestOriginFrom(c),
+		Flags:    featureFlagsFrom(c),
+		Timer:    timer,
+		Path:     path,
+		Filename: u.Filename,
+		Size:     size,
+		Metadata: u.Metadata,
+
+		Encryption: encryption,
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	resp.Stages = timer.Millis()
+	if share {
+		s.shareUpload(c, &resp, shareTTL)
+	}
+	c.JSON(uploadStatus(resp), resp)
+}
+
+// @Summary Abort a multipart upload
+// @Description Discards the upload and every part received. An upload already being completed cannot be aborted.
+// @Param id path string true "Upload ID"
+// @Success 204
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /multipart/{id} [delete]
+func (s *Server) handleAbortMultipart(c *gin.Context) {
+	u, ok := s.multipart.Get(tenantFrom(c), c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Multipart upload not found"})
+		return
+	}
+	if err := s.multipart.Abort(u); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0glabs/0g-storage-client/transfer"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultNetwork is the profile used when none is named.
+const defaultNetwork = "galileo-testnet"
+
+// NetworkProfile bundles the endpoints and upload settings for one 0G
+// deployment.
+type NetworkProfile struct {
+	Name       string
+	EvmRPC     string
+	IndexerRPC string
+	// ChainID is the chain the EVM RPC must serve; 0 takes whatever it reports
+	ChainID uint64
+	// Replicas is how many storage nodes uploads are sent to
+	Replicas uint
+	// Finality is what an upload waits for before it is reported as done
+	Finality transfer.FinalityRequirement
+	// Timeout bounds a single upload or download
+	Timeout time.Duration
+}
+
+// devnetProfile targets a local 0G stack (chain, storage node and indexer),
+// as started by docker-compose.devnet.yml. Uploads return once the
+// transaction is packed instead of waiting for the file to be finalized, which
+// keeps integration runs fast.
+var devnetProfile = NetworkProfile{
+	Name:       "devnet",
+	EvmRPC:     "http://127.0.0.1:8545",
+	IndexerRPC: "http://127.0.0.1:12345",
+	Replicas:   1,
+	Finality:   transfer.TransactionPacked,
+	Timeout:    time.Minute,
+}
+
+// networkProfiles are the built-in profiles. custom has no endpoints: they
+// come from EVM_RPC and INDEXER_RPC or a network config file.
+var networkProfiles = map[string]NetworkProfile{
+	"galileo-testnet": {
+		Name:       "galileo-testnet",
+		EvmRPC:     EvmRPC,
+		IndexerRPC: IndexerRPCStandard,
+		ChainID:    16601,
+		Replicas:   DefaultReplicas,
+		Finality:   transfer.FileFinalized,
+		Timeout:    5 * time.Minute,
+	},
+	"mainnet": {
+		Name:       "mainnet",
+		EvmRPC:     "https://evmrpc.0g.ai",
+		IndexerRPC: "https://indexer-storage-turbo.0g.ai",
+		ChainID:    16661,
+		Replicas:   DefaultReplicas,
+		Finality:   transfer.FileFinalized,
+		Timeout:    5 * time.Minute,
+	},
+	"devnet": devnetProfile,
+	"custom": {
+		Name:     "custom",
+		Replicas: DefaultReplicas,
+		Finality: transfer.FileFinalized,
+		Timeout:  5 * time.Minute,
+	},
+}
+
+// networkAliases are older names of built-in profiles.
+var networkAliases = map[string]string{
+	"testnet": "galileo-testnet",
+}
+
+// networkConfigFile is the YAML file NETWORK_CONFIG points to: profiles to
+// add or to override fields of built-in ones, and optionally the one to use.
+//
+//	network: staging
+//	networks:
+//	  staging:
+//	    evm_rpc: https://rpc.staging.example
+//	    indexer_rpc: https://indexer.staging.example
+//	    chain_id: 16602
+//	    finality: packed
+type networkConfigFile struct {
+	Network  string                       `yaml:"network"`
+	Networks map[string]networkConfigItem `yaml:"networks"`
+}
+
+type networkConfigItem struct {
+	EvmRPC     string `yaml:"evm_rpc"`
+	IndexerRPC string `yaml:"indexer_rpc"`
+	ChainID    uint64 `yaml:"chain_id"`
+	Replicas   uint   `yaml:"replicas"`
+	// Finality is finalized or packed
+	Finality string `yaml:"finality"`
+	Timeout  string `yaml:"timeout"`
+}
+
+func parseFinality(s string) (transfer.FinalityRequirement, error) {
+	switch s {
+	case "finalized":
+		return transfer.FileFinalized, nil
+	case "packed":
+		return transfer.TransactionPacked, nil
+	}
+	return 0, fmt.Errorf("invalid finality %q (expected finalized or packed)", s)
+}
+
+// apply overrides the fields of p the item sets.
+func (item networkConfigItem) apply(p *NetworkProfile) error {
+	if item.EvmRPC != "" {
+		p.EvmRPC = item.EvmRPC
+	}
+	if item.IndexerRPC != "" {
+		p.IndexerRPC = item.IndexerRPC
+	}
+	if item.ChainID != 0 {
+		p.ChainID = item.ChainID
+	}
+	if item.Replicas != 0 {
+		p.Replicas = item.Replicas
+	}
+	if item.Finality != "" {
+		finality, err := parseFinality(item.Finality)
+		if err != nil {
+			return err
+		}
+		p.Finality = finality
+	}
+	if item.Timeout != "" {
+		timeout, err := time.ParseDuration(item.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout %q", item.Timeout)
+		}
+		p.Timeout = timeout
+	}
+	return nil
+}
+
+// loadNetworkConfig reads the profiles of a network config file on top of
+// the built-in ones. It returns the profile the file selects, if any.
+func loadNetworkConfig(path string, profiles map[string]NetworkProfile) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read network config: %v", err)
+	}
+	var file networkConfigFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return "", fmt.Errorf("failed to parse network config: %v", err)
+	}
+	for name, item := range file.Networks {
+		profile, ok := profiles[name]
+		if !ok {
+			profile = networkProfiles["custom"]
+			profile.Name = name
+		}
+		if err := item.apply(&profile); err != nil {
+			return "", fmt.Errorf("network %s: %v", name, err)
+		}
+		profiles[name] = profile
+	}
+	return file.Network, nil
+}
+
+// LoadNetworkProfile picks the profile named by --network or NETWORK, else
+// by the network config file, else galileo-testnet, and applies EVM_RPC,
+// INDEXER_RPC, CHAIN_ID, UPLOAD_REPLICAS and UPLOAD_FINALITY overrides on top
+// of it.
+func LoadNetworkProfile(cfg *Config) (NetworkProfile, error) {
+	profiles := make(map[string]NetworkProfile, len(networkProfiles))
+	for name, p := range networkProfiles {
+		profiles[name] = p
+	}
+	if cfg.UseTurbo {
+		p := profiles["galileo-testnet"]
+		p.IndexerRPC = IndexerRPCTurbo
+		profiles["galileo-testnet"] = p
+	}
+	name := cfg.Network
+	if cfg.NetworkConfig != "" {
+		selected, err := loadNetworkConfig(cfg.NetworkConfig, profiles)
+		if err != nil {
+			return NetworkProfile{}, err
+		}
+		if name == "" {
+			name = selected
+		}
+	}
+	if name == "" {
+		name = defaultNetwork
+	}
+	if alias, ok := networkAliases[name]; ok {
+		name = alias
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		known := make([]string, 0, len(profiles))
+		for n := range profiles {
+			known = append(known, n)
+		}
+		sort.Strings(known)
+		return NetworkProfile{}, fmt.Errorf("unknown network %q (expected one of %s)", name, strings.Join(known, ", "))
+	}
+
+	profile.EvmRPC = envString("EVM_RPC", profile.EvmRPC)
+	profile.IndexerRPC = envString("INDEXER_RPC", profile.IndexerRPC)
+	if raw := envString("CHAIN_ID", ""); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return NetworkProfile{}, fmt.Errorf("invalid CHAIN_ID %q", raw)
+		}
+		profile.ChainID = id
+	}
+	profile.Replicas = uint(envInt("UPLOAD_REPLICAS", int(profile.Replicas)))
+	if raw := strings.TrimSpace(os.Getenv("UPLOAD_FINALITY")); raw != "" {
+		finality, err := parseFinality(raw)
+		if err != nil {
+			return NetworkProfile{}, fmt.Errorf("UPLOAD_FINALITY: %v", err)
+		}
+		profile.Finality = finality
+	}
+	if profile.EvmRPC == "" || profile.IndexerRPC == "" {
+		return NetworkProfile{}, fmt.Errorf("network %s needs EVM_RPC and INDEXER_RPC", profile.Name)
+	}
+	return profile, nil
+}
+
+// checkChainID compares the chain the EVM RPC serves with the profile's,
+// and fills it in when the profile leaves it open. An RPC that cannot be
+// reached is only logged, as it may come up later.
+func checkChainID(client *StorageClient, profile *NetworkProfile) error {
+	id, err := client.web3Client.Eth.ChainId()
+	if err != nil || id == nil {
+		log.Printf("⚠️  Could not read the chain ID from %s: %v", profile.EvmRPC, err)
+		return nil
+	}
+	if profile.ChainID != 0 && *id != profile.ChainID {
+		return fmt.Errorf("%s serves chain %d, but network %s is chain %d", profile.EvmRPC, *id, profile.Name, profile.ChainID)
+	}
+	profile.ChainID = *id
+	return nil
+}
+
+type NetworkResponse struct {
+	Name string `json:"name"`
+	// ChainID is 0 when the EVM RPC could not be asked at startup
+	ChainID    uint64 `json:"chain_id"`
+	EvmRPC     string `json:"evm_rpc"`
+	IndexerRPC string `json:"indexer_rpc"`
+	Replicas   uint   `json:"replicas"`
+	// Finality is finalized or packed: what uploads wait for
+	Finality string `json:"finality"`
+	Timeout  string `json:"timeout"`
+}
+
+// finalityName is how a finality requirement is spelled in configuration.
+func finalityName(f transfer.FinalityRequirement) string {
+	if f == transfer.TransactionPacked {
+		return "packed"
+	}
+	return "finalized"
+}
+
+// @Summary Get the active network
+// @Description The 0G network this gateway writes to: its profile name, chain ID, EVM RPC and indexer endpoints, and the upload settings in effect
+// @Produce json
+// @Success 200 {object} NetworkResponse
+// @Security ApiKeyAuth
+// @Router /network [get]
+func (s *Server) handleNetwork(c *gin.Context) {
+	c.JSON(http.StatusOK, NetworkResponse{
+		Name:       s.network.Name,
+		ChainID:    s.network.ChainID,
+		EvmRPC:     s.network.EvmRPC,
+		IndexerRPC: s.network.IndexerRPC,
+		Replicas:   s.network.Replicas,
+		Finality:   finalityName(s.network.Finality),
+		Timeout:    s.network.Timeout.String(),
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/0glabs/0g-storage-client/node"
+)
+
+// NodePool keeps the storage nodes last selected for each request class and
+// replica count, so that requests do not wait on the indexer. A set is
+// served for ttl after it was selected and health-checked and is refreshed
+// in the background before then; one holding a node that has since failed
+// is selected again on the next request. When the indexer cannot answer, a
+// set up to maxStale old is served rather than failing the request.
+type NodePool struct {
+	ttl      time.Duration
+	maxStale time.Duration
+
+	mu   sync.Mutex
+	sets map[nodePoolKey]*nodeSet
+}
+
+type nodePoolKey struct {
+	class    RequestClass
+	replicas uint
+}
+
+type nodeSet struct {
+	nodes    []*node.ZgsClient
+	selected time.Time
+}
+
+// NewNodePool returns nil when ttl is 0, leaving every request to select
+// its own nodes.
+func NewNodePool(ttl, maxStale time.Duration) *NodePool {
+	if ttl <= 0 {
+		return nil
+	}
+	if maxStale < ttl {
+		maxStale = ttl
+	}
+	return &NodePool{ttl: ttl, maxStale: maxStale, sets: make(map[nodePoolKey]*nodeSet)}
+}
+
+// get returns a copy of the set for key if it is at most maxAge old and
+// none of its nodes is in excluded, or nil.
+func (p *NodePool) get(key nodePoolKey, maxAge time.Duration, excluded []string) []*node.ZgsClient {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	set, ok := p.sets[key]
+	if !ok || time.Since(set.selected) > maxAge {
+		return nil
+	}
+	for _, n := range set.nodes {
+		url := normalizeNodeURL(n.URL())
+		for _, ex := range excluded {
+			if ex == url {
+				return nil
+			}
+		}
+	}
+	return append([]*node.ZgsClient(nil), set.nodes...)
+}
+
+// Fresh returns the set for class and replicas while it is within the TTL
+// and all its nodes are healthy.
+func (p *NodePool) Fresh(class RequestClass, replicas uint, excluded []string) []*node.ZgsClient {
+	if p == nil {
+		return nil
+	}
+	return p.get(nodePoolKey{class, replicas}, p.ttl, excluded)
+}
+
+// Stale returns the set for class and replicas while it is at most maxStale
+// old, for when no fresh one can be selected.
+func (p *NodePool) Stale(class RequestClass, replicas uint) []*node.ZgsClient {
+	if p == nil {
+		return nil
+	}
+	return p.get(nodePoolKey{class, replicas}, p.maxStale, nil)
+}
+
+// Put records nodes as the set for class and replicas.
+func (p *NodePool) Put(class RequestClass, replicas uint, nodes []*node.ZgsClient) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sets[nodePoolKey{class, replicas}] = &nodeSet{
+		nodes:    append([]*node.ZgsClient(nil), nodes...),
+		selected: time.Now(),
+	}
+}
+
+// Invalidate drops every set holding url, so the next request selects
+// again.
+func (p *NodePool) Invalidate(url string) {
+	if p == nil {
+		return
+	}
+	url = normalizeNodeURL(url)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, set := range p.sets {
+		for _, n := range set.nodes {
+			if normalizeNodeURL(n.URL()) == url {
+				delete(p.sets, key)
+				break
+			}
+		}
+	}
+}
+
+func (p *NodePool) keys() []nodePoolKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := make([]nodePoolKey, 0, len(p.sets))
+	for key := range p.sets {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Run selects the interactive set up front, then refreshes every set in use
+// twice per TTL until ctx is done. A failed refresh keeps the old set, to be
+// served while it is within maxStale.
+func (p *NodePool) Run(ctx context.Context, c *StorageClient) {
+	if p == nil {
+		return
+	}
+	refresh := func(key nodePoolKey) {
+		nodes, err := c.selectHealthyNodes(key.class, key.replicas)
+		if err != nil {
+			log.Printf("⚠️  Failed to refresh %s storage nodes: %v", key.class, err)
+			return
+		}
+		p.Put(key.class, key.replicas, nodes)
+	}
+	refresh(nodePoolKey{ClassInteractive, c.replicas})
+
+	ticker := time.NewTicker(p.ttl / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, key := range p.keys() {
+				refresh(key)
+			}
+		}
+	}
+}
+
+// pooledNodes serves class from the node pool, selecting and checking a new
+// set when the pool has none that is fresh and healthy, and falling back to
+// a stale one when that fails.
+func (c *StorageClient) pooledNodes(class RequestClass, replicas uint) ([]*node.ZgsClient, error) {
+	if nodes := c.pool.Fresh(class, replicas, c.prober.Excluded(class)); nodes != nil {
+		c.prober.Rank(nodes, class)
+		return nodes, nil
+	}
+	nodes, err := c.selectHealthyNodes(class, replicas)
+	if err != nil {
+		if stale := c.pool.Stale(class, replicas); stale != nil {
+			log.Printf("⚠️  Using the last selected storage nodes: %v", err)
+			c.prober.Rank(stale, class)
+			return stale, nil
+		}
+		return nil, err
+	}
+	c.pool.Put(class, replicas, nodes)
+	return nodes, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func normalizeNodeURL(url string) string {
+	return strings.TrimRight(strings.TrimSpace(url), "/")
+}
+
+// downloadFromNode serves rootHash retrieved from one allowlisted storage
+// node. The cache is bypassed so the answer reflects that node alone, which
+// is the point when chasing availability differences between replicas.
+func (s *Server) downloadFromNode(c *gin.Context, rootHash, nodeURL string) {
+	nodeURL = normalizeNodeURL(nodeURL)
+	if !s.nodeAllowlist[nodeURL] {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Node is not in the allowlist"})
+		return
+	}
+
+	tempFile := s.spool.Path("download")
+	defer s.spool.Release(tempFile)
+	if err := s.client.DownloadFileFrom(nodeURL, rootHash, tempFile); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("X-Storage-Node", nodeURL)
+	s.signResponse(c, rootHash, tempFile, true)
+	c.File(tempFile)
+}
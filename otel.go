@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	zgcommon "github.com/0glabs/0g-storage-client/common"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+
+	// maxPendingSpans bounds what is held while the collector is slow or
+	// down; spans past it are dropped
+	maxPendingSpans = 8192
+	spanExportBatch = 512
+)
+
+// Tracer exports spans to an OpenTelemetry collector over OTLP/HTTP in its
+// JSON encoding. Spans use the request's trace ID, so X-Trace-ID and
+// TRACE_URL_TEMPLATE lead to the same trace. Exporting is best effort: a
+// batch the collector does not take is dropped.
+type Tracer struct {
+	url      string
+	headers  map[string]string
+	resource []otlpKeyValue
+	http     *http.Client
+
+	mu      sync.Mutex
+	pending []*Span
+	dropped int
+
+	exporting sync.Mutex
+}
+
+// NewTracer returns nil unless an OTLP endpoint is configured.
+func NewTracer(cfg *Config) *Tracer {
+	url := cfg.OTLPTracesEndpoint
+	if url == "" && cfg.OTLPEndpoint != "" {
+		url = strings.TrimSuffix(cfg.OTLPEndpoint, "/") + "/v1/traces"
+	}
+	if url == "" {
+		return nil
+	}
+	return &Tracer{
+		url:     url,
+		headers: cfg.OTLPHeaders,
+		resource: otlpAttributes(map[string]any{
+			"service.name":    cfg.OTelServiceName,
+			"service.version": version,
+		}),
+		http: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Start begins a span of a trace whose ID comes from the request; parentID
+// is the caller's span from its traceparent header, if any.
+func (t *Tracer) Start(traceID, parentID, name string) *Span {
+	if t == nil {
+		return nil
+	}
+	return &Span{tracer: t, traceID: traceID, id: newSpanID(), parentID: parentID, name: name, kind: spanKindServer, start: time.Now()}
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) >= maxPendingSpans {
+		t.dropped++
+		return
+	}
+	t.pending = append(t.pending, s)
+}
+
+// Run exports finished spans every interval until ctx is done.
+func (t *Tracer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.Flush(); err != nil {
+				log.Printf("⚠️  %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Flush exports every finished span.
+func (t *Tracer) Flush() error {
+	t.exporting.Lock()
+	defer t.exporting.Unlock()
+
+	t.mu.Lock()
+	spans, dropped := t.pending, t.dropped
+	t.pending, t.dropped = nil, 0
+	t.mu.Unlock()
+	if dropped > 0 {
+		log.Printf("⚠️  Dropped %d spans the collector could not keep up with", dropped)
+	}
+
+	var failed int
+	var lastErr error
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > spanExportBatch {
+			n = spanExportBatch
+		}
+		if err := t.export(spans[:n]); err != nil {
+			failed += n
+			lastErr = err
+		}
+		spans = spans[n:]
+	}
+	if lastErr != nil {
+		return fmt.Errorf("failed to export %d spans: %v", failed, lastErr)
+	}
+	return nil
+}
+
+func (t *Tracer) export(spans []*Span) error {
+	batch := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		batch[i] = s.otlp()
+	}
+	body, err := json.Marshal(otlpExport{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: t.resource},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "0g-storage-gateway", Version: version}, Spans: batch}},
+	}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := t.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector answered %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Span is one timed step of a trace. A nil span traces nothing, so code
+// can be instrumented whether tracing is on or not.
+type Span struct {
+	tracer   *Tracer
+	traceID  string
+	id       string
+	parentID string
+	name     string
+	kind     int
+	start    time.Time
+
+	mu     sync.Mutex
+	attrs  map[string]any
+	events []spanEvent
+	end    time.Time
+	err    error
+}
+
+type spanEvent struct {
+	name  string
+	at    time.Time
+	attrs map[string]any
+}
+
+// Start begins a child span.
+func (s *Span) Start(name string) *Span {
+	return s.StartAt(name, time.Now())
+}
+
+// StartAt begins a child span that started at start.
+func (s *Span) StartAt(name string, start time.Time) *Span {
+	if s == nil {
+		return nil
+	}
+	return &Span{tracer: s.tracer, traceID: s.traceID, id: newSpanID(), parentID: s.id, name: name, kind: spanKindInternal, start: start}
+}
+
+// Record adds a finished child span that ran from start to end.
+func (s *Span) Record(name string, start, end time.Time, attrs map[string]any) {
+	child := s.StartAt(name, start)
+	if child == nil {
+		return
+	}
+	child.attrs = attrs
+	child.EndAt(end, nil)
+}
+
+// SetAttr sets an attribute: a string, bool, integer, float or []string.
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = make(map[string]any)
+	}
+	s.attrs[key] = value
+}
+
+// AddEvent notes something that happened during the span.
+func (s *Span) AddEvent(name string, at time.Time, attrs map[string]any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, spanEvent{name: name, at: at, attrs: attrs})
+}
+
+// End finishes the span, failed when err is set, and queues it for export.
+// Only the first call counts.
+func (s *Span) End(err error) {
+	s.EndAt(time.Now(), err)
+}
+
+func (s *Span) EndAt(end time.Time, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end, s.err = end, err
+	s.mu.Unlock()
+	s.tracer.enqueue(s)
+}
+
+func (s *Span) otlp() otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	span := otlpSpan{
+		TraceID:           s.traceID,
+		SpanID:            s.id,
+		ParentSpanID:      s.parentID,
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Attributes:        otlpAttributes(s.attrs),
+	}
+	for _, e := range s.events {
+		span.Events = append(span.Events, otlpEvent{
+			TimeUnixNano: strconv.FormatInt(e.at.UnixNano(), 10),
+			Name:         e.name,
+			Attributes:   otlpAttributes(e.attrs),
+		})
+	}
+	if s.err != nil {
+		span.Status = otlpStatus{Code: 2, Message: s.err.Error()}
+	}
+	return span
+}
+
+func newSpanID() string {
+	id, _ := randomHex(8)
+	return id
+}
+
+type spanContextKey struct{}
+
+// withSpan makes span the parent of the spans started further down.
+func withSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+func spanFrom(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// sdkLogOptionsFor has the SDK trace its work under span. Each line it
+// logs at info marks the end of a step, which becomes a child span from
+// the previous line (merkle root calculated, transaction sent, file
+// uploaded and so on); debug lines, such as each batch of segments sent to
+// a node, are added as events. The lines are logged as configured too.
+func sdkLogOptionsFor(span *Span) []zgcommon.LogOption {
+	if span == nil {
+		return sdkLogOptions
+	}
+	hook := &sdkSpanHook{span: span, last: time.Now()}
+	if len(sdkLogOptions) > 0 {
+		hook.next = sdkLogOptions[0].Logger
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.SetLevel(logrus.DebugLevel)
+	logger.AddHook(hook)
+	return []zgcommon.LogOption{{Logger: logger, LogLevel: logrus.DebugLevel}}
+}
+
+type sdkSpanHook struct {
+	span *Span
+	next *logrus.Logger
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (h *sdkSpanHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *sdkSpanHook) Fire(entry *logrus.Entry) error {
+	attrs := make(map[string]any, len(entry.Data))
+	for k, v := range entry.Data {
+		attrs[k] = fmt.Sprint(v)
+	}
+	if entry.Level >= logrus.DebugLevel {
+		h.span.AddEvent(entry.Message, entry.Time, attrs)
+	} else {
+		h.mu.Lock()
+		start := h.last
+		h.last = entry.Time
+		h.mu.Unlock()
+		h.span.Record(entry.Message, start, entry.Time, attrs)
+	}
+	if h.next != nil && h.next.IsLevelEnabled(entry.Level) {
+		h.next.WithFields(entry.Data).WithTime(entry.Time).Log(entry.Level, entry.Message)
+	}
+	return nil
+}
+
+// The OTLP/JSON encoding of spans.
+
+type otlpExport struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+// otlpStatus codes are 0 unset, 1 ok and 2 error.
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string    `json:"stringValue,omitempty"`
+	BoolValue   *bool      `json:"boolValue,omitempty"`
+	IntValue    *string    `json:"intValue,omitempty"`
+	DoubleValue *float64   `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArray `json:"arrayValue,omitempty"`
+}
+
+type otlpArray struct {
+	Values []otlpValue `json:"values"`
+}
+
+func otlpAttributes(attrs map[string]any) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: otlpValueOf(v)})
+	}
+	return kvs
+}
+
+func otlpValueOf(v any) otlpValue {
+	switch v := v.(type) {
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpValue{IntValue: &s}
+	case uint:
+		s := strconv.FormatUint(uint64(v), 10)
+		return otlpValue{IntValue: &s}
+	case uint64:
+		s := strconv.FormatUint(v, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	case []string:
+		values := make([]otlpValue, len(v))
+		for i := range v {
+			values[i] = otlpValue{StringValue: &v[i]}
+		}
+		return otlpValue{ArrayValue: &otlpArray{Values: values}}
+	default:
+		s := fmt.Sprint(v)
+		return otlpValue{StringValue: &s}
+	}
+}
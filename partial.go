@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0glabs/0g-storage-client/core"
+	"github.com/0glabs/0g-storage-client/core/merkle"
+	"github.com/0glabs/0g-storage-client/node"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// partialUploadTTL is how long the segments of a partial upload can be
+	// retried; storage nodes give up on files that never complete
+	partialUploadTTL = 24 * time.Hour
+	// segmentUploadTimeout bounds sending one segment to one node
+	segmentUploadTimeout = time.Minute
+)
+
+// PartialUpload is an upload whose submission is on chain but some of whose
+// segments the storage nodes turned away, e.g. because a node was full or
+// no longer served the segment's shard.
+type PartialUpload struct {
+	RootHash string `json:"root_hash"`
+	// TxHash is empty when the submission could not be found in recent blocks
+	TxHash   string `json:"tx_hash"`
+	TxSeq    uint64 `json:"tx_seq"`
+	Size     int64  `json:"size"`
+	Segments uint64 `json:"segments"`
+	// Replicas is how many nodes must accept each segment
+	Replicas uint `json:"replicas,omitempty"`
+	// Stored lists the segments each node has accepted
+	Stored map[string][]uint64 `json:"stored"`
+	// Rejected are nodes that turned segments away; they are not picked again
+	Rejected  []string  `json:"rejected"`
+	Passes    int       `json:"passes"`
+	Missing   int       `json:"missing"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// missing returns the segments fewer than replicas nodes have accepted.
+func (p *PartialUpload) missing(replicas uint) []uint64 {
+	counts := make([]uint, p.Segments)
+	for _, segments := range p.Stored {
+		for _, i := range segments {
+			if i < p.Segments {
+				counts[i]++
+			}
+		}
+	}
+	var missing []uint64
+	for i, n := range counts {
+		if n < replicas {
+			missing = append(missing, uint64(i))
+		}
+	}
+	return missing
+}
+
+// replication is how many nodes the least stored segment is on.
+func (p *PartialUpload) replication() uint {
+	counts := make([]uint, p.Segments)
+	for _, segments := range p.Stored {
+		for _, i := range segments {
+			if i < p.Segments {
+				counts[i]++
+			}
+		}
+	}
+	var least uint
+	for i, n := range counts {
+		if i == 0 || n < least {
+			least = n
+		}
+	}
+	return least
+}
+
+func (p *PartialUpload) clone() *PartialUpload {
+	c := *p
+	c.Stored = make(map[string][]uint64, len(p.Stored))
+	for url, segments := range p.Stored {
+		c.Stored[url] = append([]uint64(nil), segments...)
+	}
+	c.Rejected = append([]string(nil), p.Rejected...)
+	return &c
+}
+
+// PartialUploads keeps partial uploads, persisted to path, so that an
+// upload of the same content carries on where the last one stopped.
+type PartialUploads struct {
+	mu      sync.Mutex
+	path    string
+	uploads map[string]*PartialUpload
+}
+
+func NewPartialUploads(path string) (*PartialUploads, error) {
+	store := &PartialUploads{path: path, uploads: make(map[string]*PartialUpload)}
+	if path == "" {
+		return store, nil
+	}
+	var uploads []*PartialUpload
+	if err := readJSONFile(path, &uploads); err != nil {
+		return nil, fmt.Errorf("failed to load partial uploads: %v", err)
+	}
+	for _, p := range uploads {
+		store.uploads[p.RootHash] = p
+	}
+	return store, nil
+}
+
+// Get returns a copy of the partial upload of rootHash, unless it is too
+// old to resume.
+func (s *PartialUploads) Get(rootHash string) (*PartialUpload, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.uploads[rootHash]
+	if !ok {
+		return nil, false
+	}
+	if time.Since(p.StartedAt) > partialUploadTTL {
+		delete(s.uploads, rootHash)
+		s.saveLocked()
+		return nil, false
+	}
+	return p.clone(), true
+}
+
+func (s *PartialUploads) Put(p *PartialUpload) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads[p.RootHash] = p.clone()
+	s.saveLocked()
+}
+
+func (s *PartialUploads) Delete(rootHash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.uploads[rootHash]; ok {
+		delete(s.uploads, rootHash)
+		s.saveLocked()
+	}
+}
+
+func (s *PartialUploads) List() []PartialUpload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]PartialUpload, 0, len(s.uploads))
+	for _, p := range s.uploads {
+		list = append(list, *p.clone())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.Before(list[j].StartedAt) })
+	return list
+}
+
+func (s *PartialUploads) saveLocked() {
+	if s.path == "" {
+		return
+	}
+	uploads := make([]*PartialUpload, 0, len(s.uploads))
+	for _, p := range s.uploads {
+		uploads = append(uploads, p)
+	}
+	if err := writeJSONFile(s.path, uploads); err != nil {
+		log.Printf("⚠️  Failed to write partial uploads: %v", err)
+	}
+}
+
+// UploadFileResumable is UploadFileWith for a file whose root is rootHash.
+// When the storage nodes turn some segments away after the submission is
+// on chain, the segments they accepted are kept and only the rest are
+// sent again, to newly selected nodes. An upload still incomplete after
+// that is remembered, and uploading the same content again carries on from
+// there without a new submission. It also returns how many nodes store each
+// segment.
+func (c *StorageClient) UploadFileResumable(class RequestClass, tuning TransferTuning, timer *StageTimer, watch *TransferWatch, rootHash, filePath string) (string, string, uint, error) {
+	replicas := c.replicasFor(tuning)
+	if c.partials == nil {
+		txHash, uploadedRoot, err := c.UploadFileWith(class, tuning, timer, watch, filePath)
+		return txHash, uploadedRoot, replicas, err
+	}
+	if p, ok := c.partials.Get(rootHash); ok {
+		log.Printf("🧩 Resuming upload of %s: %d of %d segments left", rootHash, p.Missing, p.Segments)
+		if p.Replicas < replicas {
+			p.Replicas = replicas
+		}
+		return c.resumeUpload(class, timer, p, filePath)
+	}
+
+	txHash, uploadedRoot, err := c.UploadFileWith(class, tuning, timer, watch, filePath)
+	if err == nil {
+		return txHash, uploadedRoot, replicas, nil
+	}
+	p, ok := c.startPartial(class, replicas, rootHash, filePath)
+	if !ok {
+		return "", "", 0, err
+	}
+	log.Printf("🧩 Upload of %s failed after its submission (%v); sending the segments nodes did not take again", rootHash, err)
+	return c.resumeUpload(class, timer, p, filePath)
+}
+
+// startPartial looks for the submission of an upload that failed. It
+// returns false when no storage node knows of it, so there is nothing to
+// resume.
+func (c *StorageClient) startPartial(class RequestClass, replicas uint, rootHash, filePath string) (*PartialUpload, bool) {
+	nodes, err := c.selectNodesExcluding(class, replicas, nil)
+	if err != nil {
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, segmentUploadTimeout)
+	defer cancel()
+	info, err := c.FileInfo(ctx, nodes, rootHash)
+	if err != nil || info == nil {
+		return nil, false
+	}
+	file, err := core.Open(filePath)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	p := &PartialUpload{
+		RootHash:  strings.ToLower(rootHash),
+		TxSeq:     info.Tx.Seq,
+		Size:      file.Size(),
+		Segments:  file.NumSegments(),
+		Replicas:  replicas,
+		Missing:   int(file.NumSegments()),
+		Stored:    make(map[string][]uint64),
+		StartedAt: time.Now(),
+	}
+	// The SDK does not say which transaction it sent when it fails
+	if status, err := nodes[0].GetStatus(ctx); err == nil {
+		if p.TxHash, err = c.SubmissionTx(status.NetworkIdentity.FlowContractAddress, p.TxSeq); err != nil {
+			log.Printf("⚠️  Failed to find the transaction of %s: %v", rootHash, err)
+		}
+	}
+	return p, true
+}
+
+// resumeUpload sends the segments of p that too few nodes have, for up to
+// c.resumePasses rounds of node selection. Each segment sent is traced
+// under timer's span.
+func (c *StorageClient) resumeUpload(class RequestClass, timer *StageTimer, p *PartialUpload, filePath string) (string, string, uint, error) {
+	file, err := core.Open(filePath)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+	tree, err := core.MerkleTree(file)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to compute merkle root: %v", err)
+	}
+	if !strings.EqualFold(tree.Root().Hex(), p.RootHash) {
+		return "", "", 0, fmt.Errorf("file does not match partial upload %s", p.RootHash)
+	}
+
+	if p.Replicas == 0 {
+		p.Replicas = c.replicas
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+	resuming := timer.Span().Start("upload.resume")
+	resuming.SetAttr("root_hash", p.RootHash)
+	ctx = withSpan(ctx, resuming)
+	missing := p.missing(p.Replicas)
+	for pass := 0; pass < c.resumePasses && len(missing) > 0; pass++ {
+		nodes, err := c.selectNodesExcluding(class, p.Replicas, p.Rejected)
+		if err != nil {
+			log.Printf("⚠️  No nodes to resume %s on: %v", p.RootHash, err)
+			break
+		}
+		c.uploadSegments(ctx, p, file, tree, nodes, missing)
+		missing = p.missing(p.Replicas)
+		p.Passes++
+		p.Missing = len(missing)
+		p.UpdatedAt = time.Now()
+		c.partials.Put(p)
+	}
+	resuming.SetAttr("passes", p.Passes)
+	if len(missing) > 0 {
+		err := fmt.Errorf("upload failed: %d of %d segments were not accepted by enough storage nodes; upload the file again to retry them", len(missing), p.Segments)
+		resuming.End(err)
+		return "", "", 0, err
+	}
+	resuming.End(nil)
+	c.partials.Delete(p.RootHash)
+	log.Printf("🧩 Completed upload of %s after %d passes", p.RootHash, p.Passes)
+	return p.TxHash, p.RootHash, p.replication(), nil
+}
+
+// uploadSegments sends each node the segments in missing that belong to
+// its shard, recording which it accepts. A node that turns a segment away
+// is not sent any more and is left out of later passes.
+func (c *StorageClient) uploadSegments(ctx context.Context, p *PartialUpload, file *core.File, tree *merkle.Tree, nodes []*node.ZgsClient, missing []uint64) {
+	root := common.HexToHash(p.RootHash)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, n := range nodes {
+		mu.Lock()
+		have := make(map[uint64]bool, len(p.Stored[n.URL()]))
+		for _, i := range p.Stored[n.URL()] {
+			have[i] = true
+		}
+		mu.Unlock()
+
+		wg.Add(1)
+		go func(n *node.ZgsClient) {
+			defer wg.Done()
+			accepted, err := c.uploadSegmentsTo(ctx, n, p, file, tree, root, missing, have)
+			mu.Lock()
+			defer mu.Unlock()
+			p.Stored[n.URL()] = append(p.Stored[n.URL()], accepted...)
+			if err != nil {
+				log.Printf("⚠️  %s turned segments of %s away: %v", n.URL(), p.RootHash, err)
+				p.Rejected = append(p.Rejected, n.URL())
+			}
+		}(n)
+	}
+	wg.Wait()
+}
+
+func (c *StorageClient) uploadSegmentsTo(ctx context.Context, n *node.ZgsClient, p *PartialUpload, file *core.File, tree *merkle.Tree, root common.Hash, missing []uint64, have map[uint64]bool) ([]uint64, error) {
+	shard, err := n.GetShardConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shard config: %v", err)
+	}
+	if shard.NumShard == 0 {
+		return nil, fmt.Errorf("invalid shard config")
+	}
+	var accepted []uint64
+	// A node that has the whole file has every segment of its shard
+	finalized := false
+	if info, err := n.GetFileInfo(ctx, root, true); err == nil && info != nil {
+		finalized = info.Finalized
+	}
+	for _, i := range missing {
+		if i%shard.NumShard != shard.ShardId || have[i] {
+			continue
+		}
+		if finalized {
+			accepted = append(accepted, i)
+			continue
+		}
+		segment, err := readSegmentWithProof(file, tree, root, i)
+		if err != nil {
+			return accepted, err
+		}
+		span := spanFrom(ctx).Start("segment.upload")
+		span.SetAttr("segment", i)
+		span.SetAttr("node", n.URL())
+		err = c.retry.Do(ctx, func() error {
+			segmentCtx, cancel := context.WithTimeout(ctx, segmentUploadTimeout)
+			defer cancel()
+			_, err := n.UploadSegmentByTxSeq(segmentCtx, segment, p.TxSeq)
+			return err
+		})
+		span.End(err)
+		if err != nil {
+			c.nodeFailed(n, err)
+			return accepted, fmt.Errorf("segment %d: %v", i, err)
+		}
+		accepted = append(accepted, i)
+	}
+	return accepted, nil
+}
+
+// readSegmentWithProof reads segment index of file the way the SDK sends
+// it: padded like the rest of the flow, with its proof against root.
+func readSegmentWithProof(file *core.File, tree *merkle.Tree, root common.Hash, index uint64) (node.SegmentWithProof, error) {
+	data, err := core.ReadAt(file, core.DefaultSegmentSize, int64(index)*core.DefaultSegmentSize, file.PaddedSize())
+	if err != nil {
+		return node.SegmentWithProof{}, fmt.Errorf("failed to read segment %d: %v", index, err)
+	}
+	proof := tree.ProofAt(int(index))
+	return node.SegmentWithProof{
+		Root:     root,
+		Data:     data,
+		Index:    index,
+		Proof:    proof,
+		FileSize: uint64(file.Size()),
+	}, nil
+}
+
+// @Summary Partial uploads
+// @Description Uploads whose submission is on chain but whose segments were not all accepted by the storage nodes: how many segments are still missing, which nodes took which, and which turned segments away. Uploading the same content again resumes them.
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Success 200 {array} PartialUpload
+// @Failure 404 {object} map[string]string
+// @Router /admin/uploads/partial [get]
+func (s *Server) handleListPartialUploads(c *gin.Context) {
+	if s.client.partials == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload resume is disabled"})
+		return
+	}
+	c.JSON(http.StatusOK, s.client.partials.List())
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	peerObjectPath      = "/peer/objects/"
+	peerTimestampHeader = "X-Peer-Timestamp"
+	peerSignatureHeader = "X-Peer-Signature"
+	// peerClockSkew is how far a signed request's timestamp may be off
+	peerClockSkew = 5 * time.Minute
+	// peerHeaderTimeout bounds how long a peer may take to say whether it
+	// has an object, so a slow peer costs little more than a miss
+	peerHeaderTimeout = 2 * time.Second
+)
+
+// signPeerRequest is the signature peers expect on a request for path at
+// unix time ts: an HMAC-SHA256 of the method, path and time under the
+// fleet's shared secret.
+func signPeerRequest(secret, method, path string, ts int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%d", method, path, ts)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type PeerStats struct {
+	Peers []string `json:"peers"`
+	// Hits and Misses count this gateway's lookups at its peers
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Errors int64 `json:"errors"`
+	// Served counts objects this gateway served to its peers
+	Served int64 `json:"served"`
+}
+
+// PeerCache lets a fleet of gateways act as one cache: on a miss, the peers'
+// caches are asked before the storage nodes. Peers only answer from their
+// own cache, so a miss never travels further, and what they return is
+// checked against the root hash before it is used.
+type PeerCache struct {
+	peers  []string
+	secret string
+	http   *http.Client
+
+	mu     sync.Mutex
+	hits   int64
+	misses int64
+	errors int64
+	served int64
+}
+
+// NewPeerCache returns nil unless PEER_SECRET is set. Without PEER_GATEWAYS
+// the gateway only serves its peers.
+func NewPeerCache(peers []string, secret string) (*PeerCache, error) {
+	if secret == "" {
+		if len(peers) > 0 {
+			return nil, fmt.Errorf("PEER_GATEWAYS needs PEER_SECRET")
+		}
+		return nil, nil
+	}
+	for i, peer := range peers {
+		peers[i] = strings.TrimSuffix(peer, "/")
+	}
+	return &PeerCache{
+		peers:  peers,
+		secret: secret,
+		http: &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: peerHeaderTimeout,
+		}},
+	}, nil
+}
+
+func (p *PeerCache) count(counter *int64) {
+	p.mu.Lock()
+	*counter++
+	p.mu.Unlock()
+}
+
+func (p *PeerCache) request(ctx context.Context, method, peer, rootHash string) (*http.Response, error) {
+	path := peerObjectPath + rootHash
+	req, err := http.NewRequestWithContext(ctx, method, peer+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set(peerTimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(peerSignatureHeader, signPeerRequest(p.secret, method, path, ts))
+	return p.http.Do(req)
+}
+
+// Has reports whether some peer has rootHash cached.
+func (p *PeerCache) Has(ctx context.Context, rootHash string) bool {
+	for _, peer := range p.peers {
+		resp, err := p.request(ctx, http.MethodHead, peer, rootHash)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch copies rootHash from the first peer that has it to dst, and checks
+// it against the root hash with verify. It reports whether a peer had it.
+func (p *PeerCache) Fetch(ctx context.Context, rootHash, dst string, verify func(path string) (string, error)) bool {
+	for _, peer := range p.peers {
+		err := p.fetchFrom(ctx, peer, rootHash, dst)
+		if err == errPeerMiss {
+			continue
+		}
+		if err == nil {
+			var root string
+			root, err = verify(dst)
+			if err == nil && !strings.EqualFold(root, rootHash) {
+				err = fmt.Errorf("returned content with root %s", root)
+			}
+		}
+		if err != nil {
+			p.count(&p.errors)
+			log.Printf("⚠️  Peer %s failed to provide %s: %v", peer, rootHash, err)
+			continue
+		}
+		p.count(&p.hits)
+		return true
+	}
+	p.count(&p.misses)
+	return false
+}
+
+var errPeerMiss = errors.New("not cached")
+
+func (p *PeerCache) fetchFrom(ctx context.Context, peer, rootHash, dst string) error {
+	resp, err := p.request(ctx, http.MethodGet, peer, rootHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errPeerMiss
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("returned %s", resp.Status)
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Authorized reports whether a request carries a fresh signature made with
+// the fleet's secret.
+func (p *PeerCache) Authorized(r *http.Request) bool {
+	ts, err := strconv.ParseInt(r.Header.Get(peerTimestampHeader), 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > peerClockSkew || skew < -peerClockSkew {
+		return false
+	}
+	want := signPeerRequest(p.secret, r.Method, r.URL.Path, ts)
+	return hmac.Equal([]byte(want), []byte(r.Header.Get(peerSignatureHeader)))
+}
+
+func (p *PeerCache) Stats() PeerStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := p.peers
+	if peers == nil {
+		peers = []string{}
+	}
+	return PeerStats{Peers: peers, Hits: p.hits, Misses: p.misses, Errors: p.errors, Served: p.served}
+}
+
+// fetchFromPeers tries the peers for rootHash and, if one has it, returns
+// it as fetchObject would.
+func (s *Server) fetchFromPeers(ctx context.Context, rootHash string) (*Staged, bool) {
+	if s.peers == nil || len(s.peers.peers) == 0 {
+		return nil, false
+	}
+	tempFile := s.spool.Path("peer")
+	if !s.peers.Fetch(ctx, rootHash, tempFile, s.client.ComputeRoot) {
+		s.spool.Release(tempFile)
+		return nil, false
+	}
+	return s.keepInCache(rootHash, s.spool.staged(tempFile, 0)), true
+}
+
+// handlePeerObject serves an object from this gateway's cache to a peer.
+// It never fetches from the storage nodes: a miss is a 404.
+func (s *Server) handlePeerObject(c *gin.Context) {
+	if s.peers == nil || !s.peers.Authorized(c.Request) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	rootHash := strings.ToLower(c.Param("root_hash"))
+	if s.cache == nil || !isRootHash(rootHash) || s.abuse.Blocked(rootHash) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not cached"})
+		return
+	}
+	path, release, ok := s.cache.Get(rootHash)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not cached"})
+		return
+	}
+	defer release()
+	if c.Request.Method == http.MethodGet {
+		s.peers.count(&s.peers.served)
+	}
+	c.File(path)
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// sniffLen is how many leading bytes a content type is detected from
+	sniffLen = 512
+
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+	// PolicyQuarantine admits an upload but holds it until an admin releases
+	// it
+	PolicyQuarantine = "quarantine"
+)
+
+func validPolicyAction(action string) bool {
+	return action == PolicyAllow || action == PolicyDeny || action == PolicyQuarantine
+}
+
+// PolicyRule matches uploads on every condition it sets; unset conditions
+// match anything. Rules are evaluated in order and the first match decides.
+type PolicyRule struct {
+	Name       string   `json:"name"`
+	Action     string   `json:"action"`
+	Tenants    []string `json:"tenants,omitempty"`
+	Extensions []string `json:"extensions,omitempty"`
+	MIMETypes  []string `json:"mime_types,omitempty"`
+	MinSize    int64    `json:"min_size,omitempty"`
+	MaxSize    int64    `json:"max_size,omitempty"`
+	Reason     string   `json:"reason,omitempty"`
+}
+
+type Policy struct {
+	DefaultAction string       `json:"default_action"`
+	Rules         []PolicyRule `json:"rules"`
+}
+
+// UploadCandidate is what admission knows about an upload. ContentType comes
+// from the file's leading bytes, not from what the client claims.
+type UploadCandidate struct {
+	Tenant      string `json:"tenant"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	// The request the upload came with, for the access policy
+	KeyID  string `json:"key_id,omitempty"`
+	IP     string `json:"ip,omitempty"`
+	Route  string `json:"route,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Method string `json:"method,omitempty"`
+}
+
+type RuleTrace struct {
+	Rule     string `json:"rule"`
+	Matched  bool   `json:"matched"`
+	Mismatch string `json:"mismatch,omitempty"`
+	// Error is why the rule's expression could not be evaluated
+	Error string `json:"error,omitempty"`
+}
+
+type PolicyDecision struct {
+	Allowed bool `json:"allowed"`
+	// Quarantined is set when an allowed upload is held for review
+	Quarantined bool        `json:"quarantined,omitempty"`
+	Rule        string      `json:"rule,omitempty"`
+	Reason      string      `json:"reason,omitempty"`
+	Trace       []RuleTrace `json:"trace,omitempty"`
+}
+
+// LoadPolicy reads rules from a JSON file, or from inline JSON when the value
+// starts with "{". An empty source allows everything.
+func LoadPolicy(source string) (*Policy, error) {
+	p := &Policy{DefaultAction: PolicyAllow}
+	if source == "" {
+		return p, nil
+	}
+
+	data := []byte(source)
+	if !strings.HasPrefix(strings.TrimSpace(source), "{") {
+		var err error
+		if data, err = os.ReadFile(source); err != nil {
+			return nil, fmt.Errorf("failed to read policy: %v", err)
+		}
+	}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %v", err)
+	}
+	return p, p.validate()
+}
+
+func (p *Policy) validate() error {
+	if p.DefaultAction == "" {
+		p.DefaultAction = PolicyAllow
+	}
+	if !validPolicyAction(p.DefaultAction) {
+		return fmt.Errorf("invalid default_action %q", p.DefaultAction)
+	}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if !validPolicyAction(r.Action) {
+			return fmt.Errorf("rule %d: invalid action %q", i, r.Action)
+		}
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		for j, ext := range r.Extensions {
+			r.Extensions[j] = strings.ToLower("." + strings.TrimPrefix(ext, "."))
+		}
+	}
+	return nil
+}
+
+func matchMIME(pattern, contentType string) bool {
+	pattern = strings.ToLower(pattern)
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(contentType, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == contentType
+}
+
+func containsFold(list []string, v string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// mismatch returns why the rule does not apply, or "" when it matches.
+func (r *PolicyRule) mismatch(c UploadCandidate) string {
+	if why := r.typeMismatch(c); why != "" {
+		return why
+	}
+	if r.MinSize > 0 && c.Size < r.MinSize {
+		return fmt.Sprintf("size %d below %d", c.Size, r.MinSize)
+	}
+	if r.MaxSize > 0 && c.Size > r.MaxSize {
+		return fmt.Sprintf("size %d above %d", c.Size, r.MaxSize)
+	}
+	return ""
+}
+
+// typeMismatch is mismatch on everything but the size, which is all that is
+// known of an upload still being received.
+func (r *PolicyRule) typeMismatch(c UploadCandidate) string {
+	if len(r.Tenants) > 0 && !containsFold(r.Tenants, c.Tenant) {
+		return "tenant " + c.Tenant
+	}
+	if len(r.Extensions) > 0 && !containsFold(r.Extensions, strings.ToLower(filepath.Ext(c.Filename))) {
+		return "extension " + filepath.Ext(c.Filename)
+	}
+	if len(r.MIMETypes) > 0 {
+		matched := false
+		for _, m := range r.MIMETypes {
+			if matchMIME(m, c.ContentType) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return "mime type " + c.ContentType
+		}
+	}
+	return ""
+}
+
+// Evaluate applies the first matching rule, or the default action.
+func (p *Policy) Evaluate(c UploadCandidate, withTrace bool) PolicyDecision {
+	c.ContentType = strings.ToLower(strings.TrimSpace(strings.Split(c.ContentType, ";")[0]))
+
+	var decision PolicyDecision
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		why := r.mismatch(c)
+		if withTrace {
+			decision.Trace = append(decision.Trace, RuleTrace{Rule: r.Name, Matched: why == "", Mismatch: why})
+		}
+		if why == "" {
+			decision.Allowed = r.Action != PolicyDeny
+			decision.Quarantined = r.Action == PolicyQuarantine
+			decision.Rule = r.Name
+			decision.Reason = r.Reason
+			return decision
+		}
+	}
+	decision.Allowed = p.DefaultAction != PolicyDeny
+	decision.Quarantined = p.DefaultAction == PolicyQuarantine
+	decision.Rule = "default"
+	return decision
+}
+
+// EarlyDecision is Evaluate for an upload whose size is not known yet. ok is
+// false when the decision depends on the size: a rule that sets one could
+// match before any rule that decides regardless.
+func (p *Policy) EarlyDecision(c UploadCandidate) (decision PolicyDecision, ok bool) {
+	c.ContentType = strings.ToLower(strings.TrimSpace(strings.Split(c.ContentType, ";")[0]))
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.typeMismatch(c) != "" {
+			continue
+		}
+		if r.MinSize > 0 || r.MaxSize > 0 {
+			return PolicyDecision{}, false
+		}
+		return p.Evaluate(c, false), true
+	}
+	return p.Evaluate(c, false), true
+}
+
+// sniffContentType detects a file's MIME type from its leading bytes.
+func sniffContentType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, sniffLen)
+	n, _ := f.Read(buf)
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// admit rejects uploads the policy denies. The decision tells whether an
+// admitted upload is to be quarantined.
+func (s *Server) admit(c UploadCandidate) (PolicyDecision, error) {
+	decision := s.policy.Evaluate(c, false)
+	if decision.Allowed {
+		return decision, nil
+	}
+	return decision, policyRejection(decision)
+}
+
+func policyRejection(decision PolicyDecision) error {
+	msg := fmt.Sprintf("Upload rejected by policy rule %q", decision.Rule)
+	if decision.Reason != "" {
+		msg += ": " + decision.Reason
+	}
+	return newAPIError(http.StatusForbidden, "%s", msg)
+}
+
+// sniffingReader hands the first sniffLen bytes of an upload to check as soon
+// as they arrive, and fails the read when check refuses them, so an upload
+// is cut off without receiving the rest.
+type sniffingReader struct {
+	r        io.Reader
+	check    func(head []byte) error
+	head     []byte
+	checked  bool
+	rejected error
+}
+
+func (r *sniffingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if r.checked {
+		return n, err
+	}
+	if room := sniffLen - len(r.head); n < room {
+		r.head = append(r.head, p[:n]...)
+	} else {
+		r.head = append(r.head, p[:room]...)
+	}
+	if len(r.head) < sniffLen && err == nil {
+		return n, err
+	}
+	r.checked = true
+	if r.rejected = r.check(r.head); r.rejected != nil {
+		return n, r.rejected
+	}
+	r.head = nil
+	return n, err
+}
+
+// Rejected returns check's error once it refused the upload. Callers check it
+// rather than the error, which staging may have wrapped.
+func (r *sniffingReader) Rejected() error {
+	return r.rejected
+}
+
+// admissionReader wraps the body of an upload so that the policy refuses it
+// on its name and sniffed type before the rest is received. Only decisions no
+// size could change are taken this early; the others wait for the staged file.
+func (s *Server) admissionReader(r io.Reader, tenant, filename string) *sniffingReader {
+	return &sniffingReader{r: r, check: func(head []byte) error {
+		decision, ok := s.policy.EarlyDecision(UploadCandidate{
+			Tenant:      tenant,
+			Filename:    filename,
+			ContentType: http.DetectContentType(head),
+		})
+		if !ok || decision.Allowed {
+			return nil
+		}
+		return policyRejection(decision)
+	}}
+}
+
+// @Summary Explain an upload policy decision
+// @Description Dry-run of upload admission: reports which rule would match a hypothetical upload and why the others did not. tenant defaults to the caller.
+// @Accept json
+// @Produce json
+// @Param candidate body UploadCandidate true "Hypothetical upload"
+// @Success 200 {object} PolicyDecision
+// @Security ApiKeyAuth
+// @Router /policy/explain [post]
+func (s *Server) handleExplainPolicy(c *gin.Context) {
+	var candidate UploadCandidate
+	if err := c.ShouldBindJSON(&candidate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if candidate.Tenant == "" {
+		candidate.Tenant = tenantFrom(c)
+	}
+	c.JSON(http.StatusOK, s.policy.Evaluate(candidate, true))
+}
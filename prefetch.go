@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Prefetcher warms the download cache with the top results of file
+// listings and searches, so that a browsing UI opening one of them is
+// served from disk. The queue is bounded: hints arriving while it is full
+// are dropped, as are files over maxBytes and ones already cached or
+// queued.
+type Prefetcher struct {
+	top      int
+	maxBytes int64
+	queue    chan string
+
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+// NewPrefetcher returns nil unless PREFETCH_ON_LIST is set and there is a
+// download cache to fill.
+func NewPrefetcher(cfg *Config, cache *DiskCache) *Prefetcher {
+	if cfg.PrefetchOnList <= 0 || cache == nil {
+		return nil
+	}
+	return &Prefetcher{
+		top:      cfg.PrefetchOnList,
+		maxBytes: cfg.PrefetchMaxBytes,
+		queue:    make(chan string, cfg.PrefetchQueue),
+		pending:  make(map[string]bool),
+	}
+}
+
+// hint queues the first files of a listing that are not cached yet.
+func (s *Server) hint(files []FileRecord) {
+	p := s.prefetch
+	if p == nil {
+		return
+	}
+	if len(files) > p.top {
+		files = files[:p.top]
+	}
+	for _, rec := range files {
+		root := strings.ToLower(rec.RootHash)
+		if rec.Hidden || s.abuse.Blocked(root) || (p.maxBytes > 0 && rec.Size > p.maxBytes) {
+			continue
+		}
+		if _, cached := s.cache.LastAccess(root); cached {
+			continue
+		}
+		p.mu.Lock()
+		if p.pending[root] {
+			p.mu.Unlock()
+			continue
+		}
+		select {
+		case p.queue <- root:
+			p.pending[root] = true
+		default:
+			// A full queue means the hints are arriving faster than they
+			// can be fetched; the rest of this listing is not worth it
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+	}
+}
+
+// runPrefetch fetches hinted files into the cache with workers running
+// concurrently, as batch work, until ctx is done.
+func (s *Server) runPrefetch(ctx context.Context, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	p := s.prefetch
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case root := <-p.queue:
+					s.prefetchObject(root)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Server) prefetchObject(rootHash string) {
+	defer func() {
+		s.prefetch.mu.Lock()
+		delete(s.prefetch.pending, rootHash)
+		s.prefetch.mu.Unlock()
+	}()
+	obj, err := s.fetchObjectAs(ClassBatch, rootHash)
+	if err != nil {
+		log.Printf("⚠️  Failed to prefetch %s: %v", rootHash, err)
+		return
+	}
+	obj.Release()
+}
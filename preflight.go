@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UploadConstraints is what OPTIONS /upload tells a client before it sends
+// a file, so one that would be rejected is not sent at all.
+type UploadConstraints struct {
+	// Tenant is set when the request carried a valid API key; the limits
+	// are then that tenant's
+	Tenant string `json:"tenant,omitempty"`
+	// MaxFileBytes is the largest file accepted (0 is unlimited)
+	MaxFileBytes       int64 `json:"max_file_bytes"`
+	MaxJSONUploadBytes int64 `json:"max_json_upload_bytes"`
+	// DefaultReplicas and MaxReplicas bound the replicas an upload may ask
+	// for
+	DefaultReplicas uint `json:"default_replicas"`
+	MaxReplicas     uint `json:"max_replicas"`
+	// DailyRemainingBytes is what the tenant may still upload today, when
+	// its daily uploads are limited
+	DailyRemainingBytes *int64        `json:"daily_remaining_bytes,omitempty"`
+	AcceptedTypes       AcceptedTypes `json:"accepted_types"`
+	Queue               QueueEstimate `json:"queue"`
+}
+
+// AcceptedTypes is the upload policy as it applies to the tenant: rules
+// are tried in order, and the first that matches a file decides.
+type AcceptedTypes struct {
+	DefaultAction string       `json:"default_action"`
+	Rules         []PolicyRule `json:"rules"`
+}
+
+// QueueEstimate describes the async upload queue, with a rough estimate of
+// how long a new upload waits for a worker.
+type QueueEstimate struct {
+	Workers int `json:"workers"`
+	Running int `json:"running"`
+	Queued  int `json:"queued"`
+	// WaitSeconds is from the mean time uploads have taken so far; 0 when a
+	// worker is free or nothing has been uploaded yet
+	WaitSeconds float64 `json:"wait_seconds"`
+}
+
+// acceptedTypes returns the policy rules that can apply to tenant, or all
+// of them when the tenant is not known.
+func (s *Server) acceptedTypes(tenant string) AcceptedTypes {
+	types := AcceptedTypes{DefaultAction: s.policy.DefaultAction, Rules: []PolicyRule{}}
+	for _, r := range s.policy.Rules {
+		if tenant != "" && len(r.Tenants) > 0 && !containsFold(r.Tenants, tenant) {
+			continue
+		}
+		r.Tenants = nil
+		types.Rules = append(types.Rules, r)
+	}
+	return types
+}
+
+// queueEstimate reports the async upload queue. A new upload waits for
+// the jobs ahead of it to go through the workers in waves, each about as
+// long as an upload takes on average.
+func (s *Server) queueEstimate() QueueEstimate {
+	q := QueueEstimate{
+		Workers: cap(s.uploadWorkers),
+		Running: len(s.uploadWorkers),
+		Queued:  s.jobs.Count("upload", JobQueued),
+	}
+	ahead := q.Running + q.Queued - q.Workers + 1
+	if ahead <= 0 {
+		return q
+	}
+	var meanMs float64
+	for _, st := range s.stages.Summary() {
+		// Spooling happens before an upload is queued
+		if st.Stage != string(StageSpool) {
+			meanMs += st.MeanMs
+		}
+	}
+	waves := math.Ceil(float64(ahead) / float64(q.Workers))
+	q.WaitSeconds = math.Round(waves*meanMs) / 1000
+	return q
+}
+
+// @Summary Upload constraints
+// @Description Pre-flight check before an upload: the largest file accepted, the upload policy deciding which types are accepted, today's remaining upload quota and the current async upload queue with an estimated wait. It needs no API key, but with one the limits are the caller's tenant's. Browsers' CORS pre-flights get the same answer.
+// @Produce json
+// @Success 200 {object} UploadConstraints
+// @Router /upload [options]
+func (s *Server) handleUploadOptions(c *gin.Context) {
+	tenant := ""
+	if key, ok := s.keys.Lookup(apiKeyFromRequest(c)); ok {
+		tenant = key.Tenant
+	} else if !s.keys.Enabled() {
+		tenant = DefaultTenant
+	}
+
+	constraints := UploadConstraints{
+		Tenant:             tenant,
+		MaxFileBytes:       s.maxUploadBytes,
+		MaxJSONUploadBytes: s.maxJSONUploadBytes,
+		DefaultReplicas:    s.network.Replicas,
+		MaxReplicas:        s.network.Replicas,
+		AcceptedTypes:      s.acceptedTypes(tenant),
+		Queue:              s.queueEstimate(),
+	}
+	if s.transfers.MaxReplicas > constraints.MaxReplicas {
+		constraints.MaxReplicas = s.transfers.MaxReplicas
+	}
+	if tenant != "" {
+		constraints.MaxFileBytes = s.maxFileBytesFor(tenant)
+		if limit := s.dailyQuotaFor(tenant); limit > 0 {
+			remaining := limit - s.dailyUploads.Used(tenant)
+			if remaining < 0 {
+				remaining = 0
+			}
+			constraints.DailyRemainingBytes = &remaining
+		}
+	}
+	c.Header("Allow", "POST, OPTIONS")
+	c.JSON(http.StatusOK, constraints)
+}
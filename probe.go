@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0glabs/0g-storage-client/node"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Weight of the newest sample in the latency average
+	probeEWMAWeight = 0.3
+	probeTimeout    = 5 * time.Second
+	// Consecutive failed probes after which a node is considered down
+	probeMaxFailures = 3
+
+	defaultNodeSlowLatency = 500 * time.Millisecond
+)
+
+// NodeStats is what probing has learned about one storage node.
+type NodeStats struct {
+	URL string `json:"url"`
+	// LatencyMs is an exponentially weighted moving average of status call
+	// round trips
+	LatencyMs float64   `json:"latency_ms"`
+	Samples   int       `json:"samples"`
+	Failures  int       `json:"consecutive_failures"`
+	LastProbe time.Time `json:"last_probe,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	Slow      bool      `json:"slow"`
+	Down      bool      `json:"down"`
+}
+
+// NodeProber periodically times a status call against every known storage
+// node. Nodes are learned from configuration and from the indexer's
+// selections.
+type NodeProber struct {
+	slowAfter time.Duration
+
+	mu    sync.Mutex
+	nodes map[string]*NodeStats
+}
+
+func NewNodeProber(slowAfter time.Duration, urls []string) *NodeProber {
+	p := &NodeProber{slowAfter: slowAfter, nodes: make(map[string]*NodeStats)}
+	p.Add(urls...)
+	return p
+}
+
+// Add registers nodes to probe.
+func (p *NodeProber) Add(urls ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, url := range urls {
+		url = normalizeNodeURL(url)
+		if url == "" {
+			continue
+		}
+		if _, ok := p.nodes[url]; !ok {
+			p.nodes[url] = &NodeStats{URL: url}
+		}
+	}
+}
+
+// Observe folds one probe result into a node's statistics.
+func (p *NodeProber) Observe(url string, took time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st, ok := p.nodes[url]
+	if !ok {
+		return
+	}
+	st.LastProbe = time.Now()
+	if err != nil {
+		st.Failures++
+		st.LastError = err.Error()
+		return
+	}
+	ms := float64(took) / float64(time.Millisecond)
+	if st.Samples == 0 {
+		st.LatencyMs = ms
+	} else {
+		st.LatencyMs = probeEWMAWeight*ms + (1-probeEWMAWeight)*st.LatencyMs
+	}
+	st.Samples++
+	st.Failures = 0
+	st.LastError = ""
+}
+
+func (p *NodeProber) statsLocked(st *NodeStats) NodeStats {
+	out := *st
+	out.Down = st.Failures >= probeMaxFailures
+	out.Slow = st.Samples > 0 && st.LatencyMs > float64(p.slowAfter)/float64(time.Millisecond)
+	return out
+}
+
+// Stats lists every known node, fastest first.
+func (p *NodeProber) Stats() []NodeStats {
+	p.mu.Lock()
+	stats := make([]NodeStats, 0, len(p.nodes))
+	for _, st := range p.nodes {
+		stats = append(stats, p.statsLocked(st))
+	}
+	p.mu.Unlock()
+	sort.Slice(stats, func(i, j int) bool { return lessLatency(stats[i], stats[j]) })
+	return stats
+}
+
+// lessLatency orders healthy nodes by latency, then unprobed ones, then down
+// ones.
+func lessLatency(a, b NodeStats) bool {
+	if a.Down != b.Down {
+		return !a.Down
+	}
+	if (a.Samples == 0) != (b.Samples == 0) {
+		return a.Samples > 0
+	}
+	return a.LatencyMs < b.LatencyMs
+}
+
+// Excluded returns the nodes class should not be sent to: down nodes for
+// everyone and, for interactive requests, slow ones too.
+func (p *NodeProber) Excluded(class RequestClass) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	excluded := []string{}
+	for url, st := range p.nodes {
+		stats := p.statsLocked(st)
+		if stats.Down || (class == ClassInteractive && stats.Slow) {
+			excluded = append(excluded, url)
+		}
+	}
+	return excluded
+}
+
+// Rank orders selected nodes for class: fastest first for interactive
+// requests, slowest healthy node first for batch work so that the fast
+// nodes stay free for clients.
+func (p *NodeProber) Rank(nodes []*node.ZgsClient, class RequestClass) {
+	p.mu.Lock()
+	stats := make(map[*node.ZgsClient]NodeStats, len(nodes))
+	for _, n := range nodes {
+		url := normalizeNodeURL(n.URL())
+		if st, ok := p.nodes[url]; ok {
+			stats[n] = p.statsLocked(st)
+		} else {
+			stats[n] = NodeStats{URL: url}
+		}
+	}
+	p.mu.Unlock()
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := stats[nodes[i]], stats[nodes[j]]
+		if class == ClassBatch && !a.Down && !b.Down && a.Samples > 0 && b.Samples > 0 {
+			return a.LatencyMs > b.LatencyMs
+		}
+		return lessLatency(a, b)
+	})
+}
+
+// Probe times a status call against every known node concurrently.
+func (p *NodeProber) Probe(ctx context.Context) {
+	p.mu.Lock()
+	urls := make([]string, 0, len(p.nodes))
+	for url := range p.nodes {
+		urls = append(urls, url)
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, url := range urls {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			took, err := probeNode(ctx, url)
+			p.Observe(url, took, err)
+		}(url)
+	}
+	wg.Wait()
+}
+
+func probeNode(ctx context.Context, url string) (time.Duration, error) {
+	client, err := node.NewZgsClient(url)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	start := time.Now()
+	_, err = client.GetStatus(ctx)
+	return time.Since(start), err
+}
+
+// Run probes every interval until ctx is done.
+func (p *NodeProber) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	p.Probe(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.Probe(ctx)
+			for _, st := range p.Stats() {
+				if st.Down && st.Failures == probeMaxFailures {
+					log.Printf("⚠️  Storage node %s is not responding: %s", st.URL, st.LastError)
+				}
+			}
+		}
+	}
+}
+
+// @Summary Storage node latency
+// @Description Lists the storage nodes being probed with their average status call latency, fastest first. Slow nodes (over NODE_SLOW_LATENCY) are left to background work and down nodes are avoided.
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Success 200 {array} NodeStats
+// @Router /admin/nodes [get]
+func (s *Server) handleNodeStats(c *gin.Context) {
+	c.JSON(http.StatusOK, s.client.prober.Stats())
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	ResourceStandard = "standard"
+	// ResourceLow suits free-tier and sandbox containers such as CodeSandbox,
+	// with a fraction of a CPU and a few hundred MB of memory
+	ResourceLow = "low"
+)
+
+// ResourceProfile holds the defaults of the settings that size the gateway's
+// worker pools, caches and buffers. Each can still be set on its own.
+type ResourceProfile struct {
+	Name string
+
+	AsyncUploadWorkers int
+	InteractiveWorkers int
+	BatchWorkers       int
+
+	MaxTransferConcurrency int
+	MaxTaskSegments        int
+	HashWorkers            int
+	HashReads              int
+
+	CacheMaxBytes int64
+	// MultipartMemoryBytes is how much of a form is buffered before it
+	// spills to the spool
+	MultipartMemoryBytes int64
+	GCInterval           time.Duration
+	// InMemoryMetadata keeps the SQLite upload history in memory, unless
+	// METADATA_DSN says otherwise
+	InMemoryMetadata bool
+}
+
+var resourceProfiles = map[string]ResourceProfile{
+	ResourceStandard: {
+		Name:                   ResourceStandard,
+		AsyncUploadWorkers:     4,
+		InteractiveWorkers:     64,
+		BatchWorkers:           4,
+		MaxTransferConcurrency: 16,
+		MaxTaskSegments:        64,
+		HashReads:              defaultHashReads,
+		CacheMaxBytes:          1 << 30,
+		MultipartMemoryBytes:   8 << 20,
+		GCInterval:             10 * time.Minute,
+	},
+	ResourceLow: {
+		Name:                   ResourceLow,
+		AsyncUploadWorkers:     1,
+		InteractiveWorkers:     8,
+		BatchWorkers:           1,
+		MaxTransferConcurrency: 4,
+		MaxTaskSegments:        16,
+		HashWorkers:            1,
+		HashReads:              1,
+		CacheMaxBytes:          64 << 20,
+		MultipartMemoryBytes:   256 << 10,
+		GCInterval:             2 * time.Minute,
+		InMemoryMetadata:       true,
+	},
+}
+
+// resourceProfile returns the profile RESOURCE_PROFILE names, falling back
+// to the standard one.
+func resourceProfile(name string) ResourceProfile {
+	if p, ok := resourceProfiles[strings.ToLower(name)]; ok {
+		return p
+	}
+	log.Printf("⚠️  Unknown RESOURCE_PROFILE %q (want standard or low), using standard", name)
+	return resourceProfiles[ResourceStandard]
+}
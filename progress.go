@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0glabs/0g-storage-client/core"
+	"github.com/0glabs/0g-storage-client/node"
+	"github.com/0glabs/0g-storage-client/transfer"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// transferPollInterval is how often storage nodes are asked how much of an
+// upload they have while it is being watched.
+const transferPollInterval = time.Second
+
+// TransferProgress is how far an upload has got inside the SDK. The SDK has
+// no progress callbacks, so it is pieced together from how far it has read
+// the file and from what the storage nodes report about it.
+type TransferProgress struct {
+	Bytes int64 `json:"bytes"`
+	// BytesSegmented is how much of the file the SDK has split into segments
+	BytesSegmented int64  `json:"bytes_segmented"`
+	Segments       uint64 `json:"segments"`
+	// TxSubmitted is set once a storage node has seen the submission on chain
+	TxSubmitted bool                   `json:"tx_submitted"`
+	TxSeq       uint64                 `json:"tx_seq,omitempty"`
+	Nodes       []NodeTransferProgress `json:"nodes"`
+	// Finalized is set once every node has the whole file
+	Finalized bool `json:"finalized"`
+}
+
+type NodeTransferProgress struct {
+	URL              string `json:"url"`
+	SegmentsUploaded uint64 `json:"segments_uploaded"`
+	Finalized        bool   `json:"finalized"`
+}
+
+// SegmentsUploaded is the number of segments every node has; the upload is
+// only as far along as its slowest replica.
+func (p TransferProgress) SegmentsUploaded() uint64 {
+	if len(p.Nodes) == 0 {
+		return 0
+	}
+	least := p.Nodes[0].SegmentsUploaded
+	for _, n := range p.Nodes[1:] {
+		if n.SegmentsUploaded < least {
+			least = n.SegmentsUploaded
+		}
+	}
+	return least
+}
+
+// TransferWatch asks for an upload's progress to be reported while the SDK
+// works on it.
+type TransferWatch struct {
+	RootHash string
+	Report   func(TransferProgress)
+}
+
+// progressData records the furthest offset the SDK has read a file to. The
+// mark is shared by the fragments a large file is split into.
+type progressData struct {
+	core.IterableData
+	read *atomic.Int64
+}
+
+func (d progressData) Read(buf []byte, offset int64) (int, error) {
+	n, err := d.IterableData.Read(buf, offset)
+	end := d.Offset() + offset + int64(n)
+	for {
+		cur := d.read.Load()
+		if end <= cur || d.read.CompareAndSwap(cur, end) {
+			break
+		}
+	}
+	return n, err
+}
+
+func (d progressData) Split(fragmentSize int64) []core.IterableData {
+	parts := d.IterableData.Split(fragmentSize)
+	for i := range parts {
+		parts[i] = progressData{IterableData: parts[i], read: d.read}
+	}
+	return parts
+}
+
+// uploadWatched uploads content like uploader.Upload, reporting its
+// progress to watch until the upload returns.
+func (c *StorageClient) uploadWatched(ctx context.Context, uploader *transfer.Uploader, nodes []*node.ZgsClient, watch *TransferWatch, content core.IterableData, option transfer.UploadOption) (common.Hash, common.Hash, error) {
+	data := progressData{IterableData: content, read: new(atomic.Int64)}
+	watchCtx, stop := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		watchTransfer(watchCtx, nodes, data, watch)
+	}()
+	defer func() {
+		stop()
+		wg.Wait()
+	}()
+	return uploader.Upload(ctx, data, option)
+}
+
+// watchTransfer reports an upload's progress whenever it changes, polling
+// the nodes it goes to until ctx is done.
+func watchTransfer(ctx context.Context, nodes []*node.ZgsClient, data progressData, watch *TransferWatch) {
+	ticker := time.NewTicker(transferPollInterval)
+	defer ticker.Stop()
+	root := common.HexToHash(watch.RootHash)
+	var last TransferProgress
+	for {
+		p := TransferProgress{
+			Bytes:          data.Size(),
+			BytesSegmented: data.read.Load(),
+			Segments:       data.NumSegments(),
+			Nodes:          make([]NodeTransferProgress, 0, len(nodes)),
+			Finalized:      len(nodes) > 0,
+		}
+		if p.BytesSegmented > p.Bytes {
+			p.BytesSegmented = p.Bytes
+		}
+		for _, n := range nodes {
+			np := NodeTransferProgress{URL: n.URL()}
+			// Not known to a node until the submission is on chain
+			if info, err := n.GetFileInfo(ctx, root, true); err == nil && info != nil {
+				p.TxSubmitted = true
+				p.TxSeq = info.Tx.Seq
+				np.SegmentsUploaded = info.UploadedSegNum
+				np.Finalized = info.Finalized
+				if info.Finalized {
+					np.SegmentsUploaded = p.Segments
+				}
+			}
+			p.Finalized = p.Finalized && np.Finalized
+			p.Nodes = append(p.Nodes, np)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if !reflect.DeepEqual(p, last) {
+			watch.Report(p)
+			last = p
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxPublishFiles = 1000
+
+type publishMember struct {
+	Path      string
+	LocalPath string
+	Filename  string
+	Size      int64
+}
+
+type PublishResponse struct {
+	JobID        string `json:"job_id"`
+	Files        int    `json:"files"`
+	BaseManifest string `json:"base_manifest,omitempty"`
+}
+
+// ManifestDiff lists how a published manifest differs from its base.
+type ManifestDiff struct {
+	Base      string   `json:"base"`
+	Added     []string `json:"added"`
+	Changed   []string `json:"changed"`
+	Unchanged []string `json:"unchanged"`
+	Removed   []string `json:"removed"`
+}
+
+// PublishResult is the result of a succeeded publish job.
+type PublishResult struct {
+	ManifestRoot string        `json:"manifest_root"`
+	TxHash       string        `json:"tx_hash"`
+	Files        int           `json:"files"`
+	Site         *Site         `json:"site,omitempty"`
+	Diff         *ManifestDiff `json:"diff,omitempty"`
+}
+
+// publishPlan is what a publish job works from.
+type publishPlan struct {
+	Tenant   string
+	SiteName string
+	Members  []publishMember
+	// BaseRoot is the previous version of the directory. Members whose
+	// content is unchanged reuse its entries instead of being uploaded, and
+	// Keep carries entries over without the client sending them again.
+	BaseRoot string
+	Keep     []string
+	// Timer accumulates the stages of every upload, starting with the
+	// members spooled by the request
+	Timer *StageTimer
+	// KeyID and Origin are the publish request's, for the access policy
+	KeyID  string
+	Origin requestOrigin
+}
+
+// runPublish uploads every changed member, then the manifest, then points the
+// site at it. If any step fails, the catalog references created along the way
+// are removed again and the site keeps serving what it served before. Content
+// already submitted on chain stays there, but nothing of the bundle goes live,
+// and the members' upload.finalized events are only sent once it does.
+func (s *Server) runPublish(ctx context.Context, job *JobHandle, plan publishPlan) (result interface{}, err error) {
+	tenant, members := plan.Tenant, plan.Members
+	var created []string
+	var events []WebhookEvent
+	defer func() {
+		job.RecordStages(plan.Timer)
+		for _, m := range members {
+			s.spool.Release(m.LocalPath)
+		}
+		if err == nil {
+			for _, event := range events {
+				s.webhooks.Publish(event)
+			}
+			return
+		}
+		for _, root := range created {
+			if _, rmErr := s.catalog.RemoveReference(tenant, root); rmErr != nil && rmErr != ErrNotFound {
+				log.Printf("⚠️  Publish rollback of %s failed: %v", root, rmErr)
+			}
+		}
+	}()
+
+	manifest := &Manifest{Version: ManifestVersion, Files: make(map[string]ManifestEntry, len(members))}
+	var base *Manifest
+	var diff *ManifestDiff
+	if plan.BaseRoot != "" {
+		m, raw, err := s.loadManifest(plan.BaseRoot)
+		if err == errNotManifest {
+			raw.Release()
+			return nil, fmt.Errorf("base %s is not a directory manifest", plan.BaseRoot)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load base manifest: %v", err)
+		}
+		base = m
+		diff = &ManifestDiff{Base: plan.BaseRoot, Added: []string{}, Changed: []string{}, Unchanged: []string{}, Removed: []string{}}
+
+		for _, p := range plan.Keep {
+			entry, ok := base.Lookup(p)
+			if !ok {
+				return nil, fmt.Errorf("%q is not in the base manifest", p)
+			}
+			if err := s.reusePublishEntry(tenant, p, entry, &created); err != nil {
+				return nil, err
+			}
+			manifest.Files[p] = entry
+			diff.Unchanged = append(diff.Unchanged, p)
+		}
+	}
+
+	for i, m := range members {
+		if base != nil {
+			if prev, ok := base.Lookup(m.Path); ok {
+				root, err := s.client.ComputeRoot(m.LocalPath)
+				if err != nil {
+					return nil, fmt.Errorf("failed to hash %s: %v", m.Path, err)
+				}
+				if root == prev.RootHash {
+					if err := s.reusePublishEntry(tenant, m.Path, prev, &created); err != nil {
+						return nil, err
+					}
+					manifest.Files[m.Path] = prev
+					diff.Unchanged = append(diff.Unchanged, m.Path)
+					job.Note("unchanged %s (%d/%d)", m.Path, i+1, len(members))
+					continue
+				}
+				diff.Changed = append(diff.Changed, m.Path)
+			} else {
+				diff.Added = append(diff.Added, m.Path)
+			}
+		}
+
+		resp, err := s.storeUpload(uploadRequest{
+			Tenant:   tenant,
+			Class:    ClassBatch,
+			KeyID:    plan.KeyID,
+			Origin:   plan.Origin,
+			Timer:    plan.Timer,
+			Path:     m.LocalPath,
+			Filename: m.Filename,
+			Size:     m.Size,
+			Bundled:  true,
+			Events:   &events,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload %s: %v", m.Path, err)
+		}
+		if resp.newReference {
+			created = append(created, resp.RootHash)
+		}
+		job.Track(resp.RootHash, resp.TxHash)
+		manifest.Files[m.Path] = ManifestEntry{RootHash: resp.RootHash, Size: m.Size}
+		job.Note("uploaded %s (%d/%d)", m.Path, i+1, len(members))
+	}
+
+	if diff != nil {
+		for p := range base.Files {
+			if _, ok := manifest.Files[p]; !ok {
+				diff.Removed = append(diff.Removed, p)
+			}
+		}
+		sort.Strings(diff.Removed)
+	}
+
+	stored, err := s.storeManifest(tenant, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload manifest: %v", err)
+	}
+	if stored.newReference {
+		created = append(created, stored.ManifestRoot)
+	}
+	job.Track(stored.ManifestRoot, stored.TxHash)
+	job.Note("uploaded manifest %s", stored.ManifestRoot)
+
+	res := PublishResult{
+		ManifestRoot: stored.ManifestRoot,
+		TxHash:       stored.TxHash,
+		Files:        stored.Files,
+		Diff:         diff,
+	}
+	if plan.SiteName != "" {
+		site, err := s.sites.Set(plan.SiteName, stored.ManifestRoot, tenant)
+		if err == errSiteNotOwned {
+			return nil, fmt.Errorf("site %q is owned by another tenant or configured on the server", plan.SiteName)
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.purgeSite(plan.SiteName)
+		res.Site = &site
+	}
+	return res, nil
+}
+
+// reusePublishEntry makes sure tenant references the content of an entry
+// carried over from the base manifest, which it may have deleted since or
+// never uploaded itself. A reference it adds is appended to created, to be
+// rolled back with the rest.
+func (s *Server) reusePublishEntry(tenant, p string, entry ManifestEntry, created *[]string) error {
+	if s.catalog.HasReference(tenant, entry.RootHash) {
+		return nil
+	}
+	obj, ok := s.catalog.Object(entry.RootHash)
+	if !ok {
+		return fmt.Errorf("%s of the base manifest is no longer stored", p)
+	}
+	release, err := s.reserveQuota(tenant, entry.RootHash, obj.Size)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if _, err := s.catalog.AddReference(FileRecord{
+		Tenant:    tenant,
+		RootHash:  entry.RootHash,
+		TxHash:    obj.TxHash,
+		Filename:  path.Base(p),
+		Size:      obj.Size,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		return fmt.Errorf("failed to reference %s: %v", p, err)
+	}
+	*created = append(*created, entry.RootHash)
+	return nil
+}
+
+// @Summary Publish a bundle of files atomically
+// @Description Uploads every file as a background job and, only if all of them succeed, uploads a manifest for the bundle and points the optional site at it. On failure the catalog is rolled back and the site is left untouched. Paths default to the file names; send one paths value per file to place them in directories.
+// @Description When a base manifest is given (by default the site's current one; it must be one the caller has stored), files whose content matches the base are not uploaded again and keep paths are carried over from the base without being sent. The job result lists added, changed, unchanged and removed paths.
+// @Accept multipart/form-data
+// @Produce json
+// @Param files formData file true "Files of the bundle (repeat the field)"
+// @Param paths formData []string false "Relative path of each file, in the same order" collectionFormat(multi)
+// @Param site formData string false "Site to point at the new manifest"
+// @Param base formData string false "Previous manifest root to diff against"
+// @Param keep formData []string false "Paths to carry over unchanged from the base" collectionFormat(multi)
+// @Success 202 {object} PublishResponse
+// @Security ApiKeyAuth
+// @Router /publish [post]
+func (s *Server) handlePublish(c *gin.Context) {
+	timer := s.stages.NewTimer(c.Request.Context())
+	start := time.Now()
+	form, err := s.readStreamedForm(c, maxPublishFiles)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	// Once the job starts it owns the published files; anything else sent
+	// is dropped here
+	started := false
+	defer func() {
+		if started {
+			delete(form.Files, "files")
+		}
+		form.Release(s.spool)
+	}()
+
+	timer.Since(StageSpool, start)
+	files := form.Files["files"]
+	paths := form.Values["paths"]
+	keep := form.Values["keep"]
+	if len(files) == 0 && len(keep) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No files provided"})
+		return
+	}
+	if len(files)+len(keep) > maxPublishFiles {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A bundle has at most %d files, counting keep paths", maxPublishFiles)})
+		return
+	}
+	if len(paths) > 0 && len(paths) != len(files) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "paths must have one value per file"})
+		return
+	}
+
+	tenant := tenantFrom(c)
+	siteName := firstValue(form.Values["site"])
+	baseRoot := firstValue(form.Values["base"])
+	if siteName != "" {
+		if !s.sites.CanSet(siteName, tenant) {
+			respondError(c, errSiteNotOwned)
+			return
+		}
+		site, ok := s.sites.Get(siteName)
+		if ok && baseRoot == "" {
+			baseRoot = site.ManifestRoot
+		}
+	}
+	if len(keep) > 0 && baseRoot == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "keep requires a base manifest"})
+		return
+	}
+	// Publishing on top of a manifest copies its entries, so it must be one
+	// the tenant could read anyway
+	baseRoot = strings.ToLower(baseRoot)
+	if baseRoot != "" && !s.catalog.HasReference(tenant, baseRoot) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Base manifest not found"})
+		return
+	}
+	for i, p := range keep {
+		clean, err := normalizeManifestPath(p)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		keep[i] = clean
+	}
+
+	members := make([]publishMember, 0, len(files))
+	seen := make(map[string]bool, len(files)+len(keep))
+	for _, p := range keep {
+		if seen[p] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("duplicate keep path %q", p)})
+			return
+		}
+		seen[p] = true
+	}
+	for i, file := range files {
+		name := file.Filename
+		if len(paths) > 0 {
+			name = paths[i]
+		}
+		p, err := normalizeManifestPath(name)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if seen[p] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("duplicate path %q", p)})
+			return
+		}
+		seen[p] = true
+		members = append(members, publishMember{Path: p, LocalPath: file.LocalPath, Filename: file.Filename, Size: file.Size})
+	}
+
+	keyID, origin := c.GetString(keyIDContextKey), requestOriginFrom(c)
+	job, err := s.jobs.Start(tenant, "publish", traceFrom(c), func(ctx context.Context, job *JobHandle) (interface{}, error) {
+		return s.runPublish(ctx, job, publishPlan{
+			Tenant:   tenant,
+			SiteName: siteName,
+			Members:  members,
+			BaseRoot: baseRoot,
+			Keep:     keep,
+			Timer:    timer,
+			KeyID:    keyID,
+			Origin:   origin,
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	started = true
+
+	c.JSON(http.StatusAccepted, PublishResponse{JobID: job.ID, Files: len(members), BaseManifest: baseRoot})
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	QuarantineHeld      = "held"
+	QuarantineReleasing = "releasing"
+	QuarantineReleased  = "released"
+	QuarantineRejected  = "rejected"
+)
+
+// QuarantinedUpload is an upload the policy held for review. It is kept,
+// spooled but not on 0G, until an admin releases or rejects it.
+type QuarantinedUpload struct {
+	ID          string            `json:"id"`
+	Tenant      string            `json:"tenant"`
+	KeyID       string            `json:"key_id,omitempty"`
+	Filename    string            `json:"filename"`
+	ContentType string            `json:"content_type"`
+	Size        int64             `json:"size"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	// Rule is the policy rule that quarantined the upload
+	Rule   string `json:"rule"`
+	Reason string `json:"reason,omitempty"`
+	Status string `json:"status"`
+	// Callbacks are told the outcome; they are never shown, as they carry
+	// their secrets
+	Callbacks []Webhook `json:"callbacks,omitempty"`
+	// Encrypted uploads are encrypted with the master key on release
+	Encrypted bool `json:"encrypted,omitempty"`
+	// RootHash and TxHash are set once the upload is released
+	RootHash string `json:"root_hash,omitempty"`
+	TxHash   string `json:"tx_hash,omitempty"`
+	// Error is why the last release failed, or why the upload was rejected
+	Error     string     `json:"error,omitempty"`
+	HeldAt    time.Time  `json:"held_at"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+}
+
+// public is the upload as shown to tenants and admins.
+func (u QuarantinedUpload) public() QuarantinedUpload {
+	u.Callbacks = nil
+	return u
+}
+
+// Quarantine holds uploads the policy quarantines. Their records are
+// persisted to path, when set; the files are kept in dir, which the spool's
+// sweeps leave alone.
+type Quarantine struct {
+	path string
+	dir  string
+
+	mu      sync.Mutex
+	uploads map[string]*QuarantinedUpload
+}
+
+func NewQuarantine(path, dir string) (*Quarantine, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create quarantine directory: %v", err)
+	}
+	q := &Quarantine{path: path, dir: dir, uploads: make(map[string]*QuarantinedUpload)}
+	if path == "" {
+		return q, nil
+	}
+	var uploads []*QuarantinedUpload
+	if err := readJSONFile(path, &uploads); err != nil {
+		return nil, fmt.Errorf("failed to load quarantine: %v", err)
+	}
+	for _, u := range uploads {
+		// A release interrupted by a restart is held again
+		if u.Status == QuarantineReleasing {
+			u.Status = QuarantineHeld
+		}
+		q.uploads[u.ID] = u
+	}
+	return q, nil
+}
+
+// filePath is where the held copy of upload id is kept.
+func (q *Quarantine) filePath(id string) string {
+	return filepath.Join(q.dir, id)
+}
+
+// Hold keeps a copy of the staged file at src, which its caller goes on to
+// release, and records the upload as held.
+func (q *Quarantine) Hold(u QuarantinedUpload, src string) (QuarantinedUpload, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return QuarantinedUpload{}, err
+	}
+	if err := copyFile(src, q.filePath(id)); err != nil {
+		return QuarantinedUpload{}, fmt.Errorf("failed to quarantine upload: %v", err)
+	}
+	u.ID = id
+	u.Status = QuarantineHeld
+	u.HeldAt = time.Now()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.uploads[id] = &u
+	if err := q.saveLocked(); err != nil {
+		delete(q.uploads, id)
+		os.Remove(q.filePath(id))
+		return QuarantinedUpload{}, err
+	}
+	return u, nil
+}
+
+// List returns uploads with the given status, or all of them, newest first.
+func (q *Quarantine) List(status string) []QuarantinedUpload {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := []QuarantinedUpload{}
+	for _, u := range q.uploads {
+		if status == "" || u.Status == status {
+			out = append(out, u.public())
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].HeldAt.After(out[j].HeldAt) })
+	return out
+}
+
+// heldLocked returns upload id if it is still awaiting a decision.
+func (q *Quarantine) heldLocked(id string) (*QuarantinedUpload, error) {
+	u, ok := q.uploads[id]
+	if !ok {
+		return nil, newAPIError(http.StatusNotFound, "Quarantined upload not found")
+	}
+	if u.Status != QuarantineHeld {
+		return nil, newAPIError(http.StatusConflict, "Upload is already %s", u.Status)
+	}
+	return u, nil
+}
+
+// Begin marks a held upload as being released, so it is released only once.
+func (q *Quarantine) Begin(id string) (QuarantinedUpload, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u, err := q.heldLocked(id)
+	if err != nil {
+		return QuarantinedUpload{}, err
+	}
+	u.Status = QuarantineReleasing
+	return *u, nil
+}
+
+// Finish records the outcome of a release. A failed release leaves the upload
+// held, to be released again.
+func (q *Quarantine) Finish(id string, resp UploadResponse, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u, ok := q.uploads[id]
+	if !ok {
+		return
+	}
+	if err != nil {
+		u.Status = QuarantineHeld
+		u.Error = err.Error()
+	} else {
+		now := time.Now()
+		u.Status = QuarantineReleased
+		u.RootHash, u.TxHash, u.Error = resp.RootHash, resp.TxHash, ""
+		u.DecidedAt = &now
+		u.Callbacks = nil
+		os.Remove(q.filePath(id))
+	}
+	if err := q.saveLocked(); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+}
+
+// Reject discards a held upload. The returned record still has its
+// callbacks, to be told.
+func (q *Quarantine) Reject(id, reason string) (QuarantinedUpload, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u, err := q.heldLocked(id)
+	if err != nil {
+		return QuarantinedUpload{}, err
+	}
+	rejected := *u
+	now := time.Now()
+	u.Status = QuarantineRejected
+	u.Error = reason
+	u.DecidedAt = &now
+	u.Callbacks = nil
+	os.Remove(q.filePath(id))
+	if err := q.saveLocked(); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	rejected.Status, rejected.Error, rejected.DecidedAt = u.Status, u.Error, u.DecidedAt
+	return rejected, nil
+}
+
+func (q *Quarantine) saveLocked() error {
+	if q.path == "" {
+		return nil
+	}
+	uploads := make([]*QuarantinedUpload, 0, len(q.uploads))
+	for _, u := range q.uploads {
+		uploads = append(uploads, u)
+	}
+	if err := writeJSONFile(q.path, uploads); err != nil {
+		return fmt.Errorf("failed to save quarantine: %v", err)
+	}
+	return nil
+}
+
+// holdUpload quarantines a staged upload the policy held and tells the
+// tenant's webhooks and the request's callbacks. Uploads encrypted with a
+// client key are rejected instead, as the key would have to be kept.
+func (s *Server) holdUpload(req uploadRequest, contentType string, decision PolicyDecision) (UploadResponse, error) {
+	if req.Encryption != nil && req.Encryption.Source == EncryptionKeyClient {
+		return UploadResponse{}, newAPIError(http.StatusForbidden, "Upload held by policy rule %q; uploads encrypted with a client key cannot be quarantined", decision.Rule)
+	}
+	held, err := s.quarantine.Hold(QuarantinedUpload{
+		Tenant:      req.Tenant,
+		KeyID:       req.KeyID,
+		Filename:    req.Filename,
+		ContentType: contentType,
+		Size:        req.Size,
+		Metadata:    req.Metadata,
+		Rule:        decision.Rule,
+		Reason:      decision.Reason,
+		Callbacks:   req.Callbacks,
+		Encrypted:   req.Encryption != nil,
+	}, req.Path)
+	if err != nil {
+		return UploadResponse{}, err
+	}
+	log.Printf("🚧 Upload %s (%s) of %s held by policy rule %q", held.ID, req.Filename, req.Tenant, decision.Rule)
+	s.webhooks.Publish(WebhookEvent{
+		Type:         EventUploadQuarantined,
+		Tenant:       req.Tenant,
+		Filename:     req.Filename,
+		Size:         req.Size,
+		Status:       UploadStatusQuarantined,
+		QuarantineID: held.ID,
+		Metadata:     req.Metadata,
+	}, req.Callbacks...)
+	public := held.public()
+	return UploadResponse{Quarantine: &public}, nil
+}
+
+// uploadStatus is 202 for an upload held for review, which is not stored
+// yet, and 200 otherwise.
+func uploadStatus(resp UploadResponse) int {
+	if resp.Quarantine != nil {
+		return http.StatusAccepted
+	}
+	return http.StatusOK
+}
+
+type QuarantineReleaseResponse struct {
+	JobID  string            `json:"job_id"`
+	Upload QuarantinedUpload `json:"upload"`
+}
+
+// @Summary List quarantined uploads
+// @Description Uploads an upload policy rule with action quarantine held for review, newest first, with the rule that held them. Held uploads are spooled but not on 0G until released.
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Param status query string false "held, releasing, released or rejected"
+// @Success 200 {array} QuarantinedUpload
+// @Router /admin/quarantine [get]
+func (s *Server) handleListQuarantine(c *gin.Context) {
+	c.JSON(http.StatusOK, s.quarantine.List(c.Query("status")))
+}
+
+// @Summary Release a quarantined upload
+// @Description Starts a job that submits a held upload to 0G as its tenant, with the tenant's transforms and quotas applied, and notifies its callbacks and the tenant's webhooks of the outcome. Poll the job with GET /admin/jobs/{id}. A failed release leaves the upload held.
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Param id path string true "Quarantine ID"
+// @Success 202 {object} QuarantineReleaseResponse
+// @Router /admin/quarantine/{id}/release [post]
+func (s *Server) handleReleaseQuarantine(c *gin.Context) {
+	id := c.Param("id")
+	held, err := s.quarantine.Begin(id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	job, err := s.jobs.Start(adminJobOwner, "quarantine_release", traceFrom(c), func(ctx context.Context, job *JobHandle) (interface{}, error) {
+		var encryption *UploadKey
+		if held.Encrypted {
+			encryption = &UploadKey{Source: EncryptionKeyMaster}
+		}
+		resp, err := s.storeUpload(uploadRequest{
+			Tenant:    held.Tenant,
+			Class:     ClassBatch,
+			KeyID:     held.KeyID,
+			Path:      s.quarantine.filePath(id),
+			Filename:  held.Filename,
+			Size:      held.Size,
+			Metadata:  held.Metadata,
+			Callbacks: held.Callbacks,
+			Released:  true,
+
+			Encryption: encryption,
+		})
+		s.quarantine.Finish(id, resp, err)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("🚧 Quarantined upload %s released as %s", id, resp.RootHash)
+		job.Track(resp.RootHash, resp.TxHash)
+		return resp, nil
+	})
+	if err != nil {
+		s.quarantine.Finish(id, UploadResponse{}, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, QuarantineReleaseResponse{JobID: job.ID, Upload: held.public()})
+}
+
+// @Summary Reject a quarantined upload
+// @Description Discards a held upload without storing it and sends its callbacks and the tenant's webhooks an upload.failed event with status rejected
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Param id path string true "Quarantine ID"
+// @Param reason query string false "Reason given to the uploader"
+// @Success 200 {object} QuarantinedUpload
+// @Router /admin/quarantine/{id} [delete]
+func (s *Server) handleRejectQuarantine(c *gin.Context) {
+	reason := c.DefaultQuery("reason", "Rejected on review")
+	rejected, err := s.quarantine.Reject(c.Param("id"), reason)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	s.webhooks.Publish(WebhookEvent{
+		Type:         EventUploadFailed,
+		Tenant:       rejected.Tenant,
+		Filename:     rejected.Filename,
+		Size:         rejected.Size,
+		Status:       UploadStatusRejected,
+		QuarantineID: rejected.ID,
+		Error:        reason,
+		Metadata:     rejected.Metadata,
+	}, rejected.Callbacks...)
+	c.JSON(http.StatusOK, rejected.public())
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DailyUploads counts the bytes each tenant uploads per UTC day, against
+// the daily upload quota. It is persisted to path, when set, so restarts do
+// not reset the count.
+type DailyUploads struct {
+	mu      sync.Mutex
+	path    string
+	day     string
+	tenants map[string]int64
+}
+
+type dailyUploadsSnapshot struct {
+	Day     string           `json:"day"`
+	Tenants map[string]int64 `json:"tenants"`
+}
+
+func NewDailyUploads(path string) (*DailyUploads, error) {
+	d := &DailyUploads{path: path, tenants: make(map[string]int64)}
+	if path == "" {
+		return d, nil
+	}
+	var snap dailyUploadsSnapshot
+	if err := readJSONFile(path, &snap); err != nil {
+		return nil, fmt.Errorf("failed to load daily upload usage: %v", err)
+	}
+	if snap.Tenants != nil {
+		d.day, d.tenants = snap.Day, snap.Tenants
+	}
+	return d, nil
+}
+
+func utcDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// untilTomorrow is how long until the daily counts reset.
+func untilTomorrow(now time.Time) time.Duration {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC).Sub(now)
+}
+
+// rollLocked starts a new day's counts once the day has changed.
+func (d *DailyUploads) rollLocked() {
+	if today := utcDay(time.Now()); d.day != today {
+		d.day = today
+		d.tenants = make(map[string]int64)
+	}
+}
+
+// Used returns the bytes tenant has uploaded today.
+func (d *DailyUploads) Used(tenant string) int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rollLocked()
+	return d.tenants[tenant]
+}
+
+// Reserve counts size bytes against tenant's limit for today (0 is
+// unlimited), or fails with 429 until the counts reset if that would exceed
+// it. An upload that then fails gives the bytes back with Release.
+func (d *DailyUploads) Reserve(tenant string, size, limit int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rollLocked()
+	used := d.tenants[tenant]
+	if limit > 0 && used+size > limit {
+		return &apiError{
+			Status:     http.StatusTooManyRequests,
+			Message:    fmt.Sprintf("Daily upload quota exceeded: %d of %d bytes used today", used, limit),
+			RetryAfter: untilTomorrow(time.Now()),
+		}
+	}
+	d.tenants[tenant] = used + size
+	d.saveLocked()
+	return nil
+}
+
+// Release gives back bytes reserved today for an upload that failed.
+func (d *DailyUploads) Release(tenant string, size int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rollLocked()
+	if d.tenants[tenant] -= size; d.tenants[tenant] <= 0 {
+		delete(d.tenants, tenant)
+	}
+	d.saveLocked()
+}
+
+// saveLocked persists the counts. A failure is only logged: the count in
+// memory stays right until the next restart.
+func (d *DailyUploads) saveLocked() {
+	if d.path == "" {
+		return
+	}
+	if err := writeJSONFile(d.path, dailyUploadsSnapshot{Day: d.day, Tenants: d.tenants}); err != nil {
+		log.Printf("⚠️  Failed to write daily upload usage: %v", err)
+	}
+}
+
+func (s *Server) dailyQuotaFor(tenant string) int64 {
+	if q, ok := s.dailyQuotas[tenant]; ok {
+		return q
+	}
+	return s.defaultDailyQuota
+}
+
+// maxFileBytesFor is the largest file tenant may upload: its own limit, when
+// lower than MAX_UPLOAD_BYTES, otherwise MAX_UPLOAD_BYTES (0 is unlimited).
+func (s *Server) maxFileBytesFor(tenant string) int64 {
+	limit := s.maxUploadBytes
+	if own, ok := s.maxFileBytes[tenant]; ok && own > 0 && (limit == 0 || own < limit) {
+		limit = own
+	}
+	return limit
+}
+
+// DailyQuota is a tenant's upload allowance for the current UTC day. Bytes
+// is 0 when unlimited.
+type DailyQuota struct {
+	Bytes     int64     `json:"bytes"`
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining,omitempty"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+// RateLimitStatus is the request rate limit of the caller's API key or IP.
+type RateLimitStatus struct {
+	PerSecond int `json:"per_second"`
+	Burst     int `json:"burst"`
+	Remaining int `json:"remaining"`
+}
+
+type QuotaResponse struct {
+	Tenant  string     `json:"tenant"`
+	Storage Quota      `json:"storage"`
+	Daily   DailyQuota `json:"daily_uploads"`
+	// MaxFileBytes is the largest file the caller may upload (0 is unlimited)
+	MaxFileBytes int64 `json:"max_file_bytes"`
+	// RateLimit is set when requests are rate limited per key or IP
+	RateLimit *RateLimitStatus `json:"rate_limit,omitempty"`
+}
+
+// @Summary Get the caller's quotas
+// @Description The caller's storage quota, today's upload quota (resetting at midnight UTC), largest allowed file and, when requests are rate limited per API key or IP, the state of its limit
+// @Produce json
+// @Success 200 {object} QuotaResponse
+// @Security ApiKeyAuth
+// @Router /quota [get]
+func (s *Server) handleQuota(c *gin.Context) {
+	tenant := tenantFrom(c)
+	now := time.Now()
+	daily := DailyQuota{
+		Bytes:    s.dailyQuotaFor(tenant),
+		Used:     s.dailyUploads.Used(tenant),
+		ResetsAt: now.Add(untilTomorrow(now)).UTC(),
+	}
+	if daily.Bytes > 0 && daily.Used < daily.Bytes {
+		daily.Remaining = daily.Bytes - daily.Used
+	}
+	resp := QuotaResponse{
+		Tenant:       tenant,
+		Storage:      s.quota(tenant),
+		Daily:        daily,
+		MaxFileBytes: s.maxFileBytesFor(tenant),
+	}
+	if s.keyLimits != nil {
+		st := s.keyLimits.Peek(rateLimitKey(c))
+		resp.RateLimit = &RateLimitStatus{PerSecond: int(s.keyLimits.rate), Burst: st.Limit, Remaining: st.Remaining}
+	}
+	c.JSON(http.StatusOK, resp)
+}
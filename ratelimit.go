@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How often buckets that have refilled completely are dropped
+const rateLimitSweepInterval = time.Minute
+
+// KeyRateLimiter gives every API key, or client IP for requests without
+// one, its own token bucket.
+type KeyRateLimiter struct {
+	rate  float64
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// NewKeyRateLimiter returns nil when rate is 0, leaving requests unlimited.
+func NewKeyRateLimiter(rate, burst int) *KeyRateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+	return newKeyRateLimiter(float64(rate), burst)
+}
+
+// NewHourlyRateLimiter allows every key perHour requests an hour, all at
+// once if it likes. It returns nil when perHour is 0.
+func NewHourlyRateLimiter(perHour int) *KeyRateLimiter {
+	if perHour <= 0 {
+		return nil
+	}
+	return newKeyRateLimiter(float64(perHour)/3600, perHour)
+}
+
+func newKeyRateLimiter(rate float64, burst int) *KeyRateLimiter {
+	return &KeyRateLimiter{rate: rate, burst: burst, buckets: make(map[string]*tokenBucket), lastSweep: time.Now()}
+}
+
+func (l *KeyRateLimiter) bucket(key string) *tokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Since(l.lastSweep) > rateLimitSweepInterval {
+		// A full bucket is the same as a new one, so it can go
+		for k, b := range l.buckets {
+			if st := b.Peek(); st.Remaining >= st.Limit {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = time.Now()
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newTokenBucket(l.rate, l.burst)
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// Take consumes a token from key's bucket if one is available.
+func (l *KeyRateLimiter) Take(key string) bucketState {
+	return l.bucket(key).Take()
+}
+
+// Peek reports key's bucket without taking a token.
+func (l *KeyRateLimiter) Peek(key string) bucketState {
+	return l.bucket(key).Peek()
+}
+
+// rateLimitKey identifies who a request counts against: its API key, or its
+// client IP when it was made without one.
+func rateLimitKey(c *gin.Context) string {
+	if id := c.GetString(keyIDContextKey); id != "" {
+		return "key:" + id
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// limitKeyRate answers 429 with Retry-After once the caller's API key or IP
+// has used up its token bucket, and reports the limit in X-RateLimit-Limit,
+// -Remaining and -Reset.
+func (s *Server) limitKeyRate(c *gin.Context) {
+	if s.keyLimits == nil {
+		c.Next()
+		return
+	}
+	st := s.keyLimits.Take(rateLimitKey(c))
+	c.Header("X-RateLimit-Limit", strconv.Itoa(st.Limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(st.Remaining))
+	c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(st.Reset)))
+	if st.Wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(ceilSeconds(st.Wait)))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+		return
+	}
+	c.Next()
+}
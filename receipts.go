@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Receipt is everything known about an upload, found from its transaction.
+type Receipt struct {
+	TxHash   string        `json:"tx_hash"`
+	RootHash string        `json:"root_hash"`
+	Object   *StoredObject `json:"object,omitempty"`
+	File     *FileRecord   `json:"file,omitempty"`
+	Jobs     []Job         `json:"jobs"`
+	// Files lists every file of the transaction the caller references; a
+	// batched submission stores several. RootHash, Object and File are the
+	// first of them.
+	Files []ReceiptFile `json:"files"`
+}
+
+// ReceiptFile is one file stored by a receipt's transaction.
+type ReceiptFile struct {
+	RootHash string       `json:"root_hash"`
+	Object   StoredObject `json:"object"`
+	File     FileRecord   `json:"file"`
+}
+
+// @Summary Look up an upload by transaction hash
+// @Description Maps a submission transaction back to its root hashes (several for a batched submission), the caller's file records and metadata, and the history of the jobs that produced it. Only uploads the caller references or produced are visible.
+// @Produce json
+// @Param tx_hash path string true "Transaction hash"
+// @Param fields query string false "Comma-separated fields to return"
+// @Param envelope query bool false "Wrap the response in {data, count}"
+// @Success 200 {object} Receipt
+// @Security ApiKeyAuth
+// @Router /receipts/{tx_hash} [get]
+func (s *Server) handleReceipt(c *gin.Context) {
+	tenant := tenantFrom(c)
+	txHash := c.Param("tx_hash")
+
+	receipt := Receipt{TxHash: txHash, Jobs: s.jobs.WithRef(tenant, txHash), Files: []ReceiptFile{}}
+	if receipt.Jobs == nil {
+		receipt.Jobs = []Job{}
+	}
+	for _, obj := range s.catalog.ObjectsByTx(txHash) {
+		if rec, ok := s.catalog.Reference(tenant, obj.RootHash); ok {
+			receipt.Files = append(receipt.Files, ReceiptFile{RootHash: obj.RootHash, Object: obj, File: rec})
+		}
+	}
+	if len(receipt.Files) > 0 {
+		first := receipt.Files[0]
+		receipt.RootHash = first.RootHash
+		receipt.Object = &first.Object
+		receipt.File = &first.File
+	}
+
+	if receipt.File == nil && len(receipt.Jobs) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No upload found for this transaction"})
+		return
+	}
+	respondSelected(c, http.StatusOK, receipt)
+}
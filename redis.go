@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errRedisNil is returned for a RESP null reply (e.g. SET NX that lost).
+var errRedisNil = errors.New("redis: nil")
+
+// RedisClient speaks just enough RESP for coordination between replicas. It
+// keeps a single connection and serializes commands over it.
+type RedisClient struct {
+	addr     string
+	password string
+	db       int
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// NewRedisClient parses redis://[:password@]host:port[/db].
+func NewRedisClient(rawURL string) (*RedisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("invalid redis URL %q", rawURL)
+	}
+	c := &RedisClient{addr: u.Host}
+	if !strings.Contains(c.addr, ":") {
+		c.addr += ":6379"
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+	return c, nil
+}
+
+func (c *RedisClient) connectLocked(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to redis: %v", err)
+	}
+	c.conn = conn
+	c.rd = bufio.NewReader(conn)
+
+	if c.password != "" {
+		if _, err := c.roundTripLocked(ctx, "AUTH", c.password); err != nil {
+			c.closeLocked()
+			return err
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.roundTripLocked(ctx, "SELECT", strconv.Itoa(c.db)); err != nil {
+			c.closeLocked()
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *RedisClient) closeLocked() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// Do runs one command and returns its reply: string, int64, []interface{} or
+// errRedisNil.
+func (c *RedisClient) Do(ctx context.Context, args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.connectLocked(ctx); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.roundTripLocked(ctx, args...)
+	var netErr net.Error
+	if err != nil && (errors.As(err, &netErr) || err == io.EOF) {
+		// Broken connection: reconnect on the next command
+		c.closeLocked()
+	}
+	return reply, err
+}
+
+func (c *RedisClient) roundTripLocked(ctx context.Context, args ...string) (interface{}, error) {
+	deadline := time.Now().Add(10 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.conn.SetDeadline(deadline)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return c.readReplyLocked()
+}
+
+func (c *RedisClient) readReplyLocked() (interface{}, error) {
+	line, err := c.rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.rd, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			item, err := c.readReplyLocked()
+			if err != nil && err != errRedisNil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
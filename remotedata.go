@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/0glabs/0g-storage-client/core"
+)
+
+const (
+	// remoteBlockSize is how much of a staged object one ranged GET reads.
+	// The SDK reads a segment at a time, far too little for a request each.
+	remoteBlockSize = 4 << 20
+	// remoteBlocks is how many blocks are kept, enough for the uploader's
+	// routines to work on neighbouring segments without fetching them twice
+	remoteBlocks = 8
+	// remoteReadTimeout bounds each ranged GET
+	remoteReadTimeout = 2 * time.Minute
+)
+
+// remoteData is an object staged in the remote spool, read by the SDK with
+// ranged GETs instead of from a local copy. At most remoteBlocks blocks of it
+// are held in memory, whatever its size.
+type remoteData struct {
+	blocks     *remoteBlockCache
+	offset     int64
+	size       int64
+	paddedSize uint64
+}
+
+func newRemoteData(client *S3Client, key string, size int64) *remoteData {
+	return &remoteData{
+		blocks:     &remoteBlockCache{client: client, key: key, size: size, data: make(map[int64][]byte)},
+		size:       size,
+		paddedSize: core.IteratorPaddedSize(size, true),
+	}
+}
+
+func (d *remoteData) NumChunks() uint64 {
+	return core.NumSplits(d.size, core.DefaultChunkSize)
+}
+
+func (d *remoteData) NumSegments() uint64 {
+	return core.NumSplits(d.size, core.DefaultSegmentSize)
+}
+
+func (d *remoteData) Size() int64 {
+	return d.size
+}
+
+func (d *remoteData) PaddedSize() uint64 {
+	return d.paddedSize
+}
+
+func (d *remoteData) Offset() int64 {
+	return d.offset
+}
+
+// Read fills buf from offset, reading less at the end of the data like a
+// file does.
+func (d *remoteData) Read(buf []byte, offset int64) (int, error) {
+	if offset >= d.size {
+		return 0, nil
+	}
+	if rest := d.size - offset; int64(len(buf)) > rest {
+		buf = buf[:rest]
+	}
+	n := 0
+	for n < len(buf) {
+		pos := d.offset + offset + int64(n)
+		block, err := d.blocks.get(pos / remoteBlockSize)
+		if err != nil {
+			return 0, err
+		}
+		n += copy(buf[n:], block[pos%remoteBlockSize:])
+	}
+	return n, nil
+}
+
+func (d *remoteData) Split(fragmentSize int64) []core.IterableData {
+	var fragments []core.IterableData
+	for offset := d.offset; offset < d.offset+d.size; offset += fragmentSize {
+		size := min(d.offset+d.size-offset, fragmentSize)
+		fragments = append(fragments, &remoteData{
+			blocks:     d.blocks,
+			offset:     offset,
+			size:       size,
+			paddedSize: core.IteratorPaddedSize(size, true),
+		})
+	}
+	return fragments
+}
+
+// remoteBlockCache holds the most recently read blocks of a staged object,
+// shared by the fragments it is split into.
+type remoteBlockCache struct {
+	client *S3Client
+	key    string
+	size   int64
+
+	mu    sync.Mutex
+	data  map[int64][]byte
+	order []int64
+}
+
+func (c *remoteBlockCache) get(index int64) ([]byte, error) {
+	c.mu.Lock()
+	block, ok := c.data[index]
+	c.mu.Unlock()
+	if ok {
+		return block, nil
+	}
+
+	// Fetched without the lock, so routines reading other blocks don't wait
+	start := index * remoteBlockSize
+	length := min(int64(remoteBlockSize), c.size-start)
+	ctx, cancel := context.WithTimeout(context.Background(), remoteReadTimeout)
+	defer cancel()
+	body, err := c.client.GetObjectRange(ctx, c.key, start, length)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	block = make([]byte, length)
+	if _, err := io.ReadFull(body, block); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.data[index]; !ok {
+		if len(c.order) == remoteBlocks {
+			delete(c.data, c.order[0])
+			c.order = c.order[1:]
+		}
+		c.data[index] = block
+		c.order = append(c.order, index)
+	}
+	return block, nil
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	uploadOffsetHeader = "Upload-Offset"
+	uploadLengthHeader = "Upload-Length"
+	// Resumable uploads not completed within this long are aborted by GC.
+	resumableExpiry = 24 * time.Hour
+)
+
+// ResumableUpload is a file sent as a sequence of appended chunks, tus
+// style: a client that loses its connection asks for the offset the server
+// has and carries on from there.
+type ResumableUpload struct {
+	ID       string            `json:"upload_id"`
+	Tenant   string            `json:"tenant"`
+	Filename string            `json:"filename"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Length is the declared total size, 0 when not known up front
+	Length    int64     `json:"length,omitempty"`
+	Offset    int64     `json:"offset"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// resumableSession keeps the bytes received so far in one spool file on the
+// replica that created the upload.
+type resumableSession struct {
+	ResumableUpload
+
+	mu        sync.Mutex
+	path      string
+	appending bool
+	completed bool
+}
+
+func (u *resumableSession) snapshot() ResumableUpload {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.ResumableUpload
+}
+
+// ResumableStore tracks resumable uploads in progress.
+type ResumableStore struct {
+	spool *Spool
+
+	mu      sync.Mutex
+	uploads map[string]*resumableSession
+}
+
+func NewResumableStore(spool *Spool) *ResumableStore {
+	return &ResumableStore{spool: spool, uploads: make(map[string]*resumableSession)}
+}
+
+func (s *ResumableStore) Create(tenant, filename string, length int64, metadata map[string]string) (*resumableSession, error) {
+	id, err := randomHex(12)
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.spool.Create("resumable-*")
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	now := time.Now()
+	u := &resumableSession{
+		ResumableUpload: ResumableUpload{
+			ID:        id,
+			Tenant:    tenant,
+			Filename:  filename,
+			Metadata:  metadata,
+			Length:    length,
+			CreatedAt: now,
+			UpdatedAt: now,
+			ExpiresAt: now.Add(resumableExpiry),
+		},
+		path: f.Name(),
+	}
+	s.mu.Lock()
+	s.uploads[id] = u
+	s.mu.Unlock()
+	return u, nil
+}
+
+func (s *ResumableStore) Get(tenant, id string) (*resumableSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[id]
+	if !ok || u.Tenant != tenant {
+		return nil, false
+	}
+	return u, true
+}
+
+// Append writes r at offset, which must be where the upload currently ends,
+// and returns the new offset. Bytes received before the client goes away
+// are kept, so it can resume from the offset reported afterwards. limit caps
+// the total size (0 is unlimited).
+func (s *ResumableStore) Append(u *resumableSession, offset int64, r io.Reader, limit int64) (int64, error) {
+	u.mu.Lock()
+	switch {
+	case u.completed:
+		u.mu.Unlock()
+		return 0, newAPIError(http.StatusConflict, "Upload is already completed or aborted")
+	case u.appending:
+		u.mu.Unlock()
+		return 0, newAPIError(http.StatusConflict, "Another chunk is being appended")
+	case offset != u.Offset:
+		current := u.Offset
+		u.mu.Unlock()
+		return current, newAPIError(http.StatusConflict, "Offset %d does not match the %d bytes received", offset, current)
+	}
+	u.appending = true
+	u.mu.Unlock()
+
+	start := offset
+	written, err := s.appendChunk(u, start, r, limit)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.appending = false
+	u.Offset = start + written
+	u.UpdatedAt = time.Now()
+	u.ExpiresAt = u.UpdatedAt.Add(resumableExpiry)
+	return u.Offset, err
+}
+
+// appendChunk copies r to the end of the upload's file. A chunk that would
+// take the upload past its declared length or the size limit is refused as a
+// whole.
+func (s *ResumableStore) appendChunk(u *resumableSession, offset int64, r io.Reader, limit int64) (int64, error) {
+	f, err := os.OpenFile(u.path, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if err := f.Truncate(offset); err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	max := int64(-1)
+	var tooLong error
+	if u.Length > 0 {
+		max, tooLong = u.Length-offset, newAPIError(http.StatusBadRequest, "Chunk runs past the declared length of %d bytes", u.Length)
+	}
+	if limit > 0 && (max < 0 || limit-offset < max) {
+		max, tooLong = limit-offset, uploadTooLarge(limit)
+	}
+	if max >= 0 {
+		r = io.LimitReader(r, max+1)
+	}
+
+	written, err := io.Copy(f, r)
+	if max >= 0 && written > max {
+		f.Truncate(offset)
+		return 0, tooLong
+	}
+	if err != nil {
+		// Keep what arrived; the client resumes from the new offset
+		return written, fmt.Errorf("chunk interrupted after %d bytes: %v", written, err)
+	}
+	return written, nil
+}
+
+// Close stops the upload taking chunks, for completion. Finish must be called
+// afterwards either way.
+func (s *ResumableStore) Close(u *resumableSession) (string, int64, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	switch {
+	case u.completed:
+		return "", 0, newAPIError(http.StatusConflict, "Upload is already completed or aborted")
+	case u.appending:
+		return "", 0, newAPIError(http.StatusConflict, "A chunk is still being appended")
+	case u.Offset == 0:
+		return "", 0, newAPIError(http.StatusBadRequest, "Nothing has been uploaded")
+	case u.Length > 0 && u.Offset != u.Length:
+		return "", 0, newAPIError(http.StatusConflict, "Only %d of %d bytes have been received", u.Offset, u.Length)
+	}
+	u.completed = true
+	return u.path, u.Offset, nil
+}
+
+// Finish forgets an upload and releases what it received.
+func (s *ResumableStore) Finish(u *resumableSession) {
+	s.mu.Lock()
+	delete(s.uploads, u.ID)
+	s.mu.Unlock()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.completed = true
+	s.spool.Release(u.path)
+}
+
+// Abort discards an upload that is not being completed. Once Close has
+// succeeded only the completing request releases it, so its file is not
+// removed while it is stored.
+func (s *ResumableStore) Abort(u *resumableSession) error {
+	u.mu.Lock()
+	if u.completed {
+		u.mu.Unlock()
+		return newAPIError(http.StatusConflict, "Upload is already being completed")
+	}
+	u.completed = true
+	u.mu.Unlock()
+	s.Finish(u)
+	return nil
+}
+
+// Expire aborts uploads that have not received a chunk for a day and returns
+// how many there were. Uploads being completed are left to their request.
+func (s *ResumableStore) Expire() int {
+	now := time.Now()
+	s.mu.Lock()
+	var expired []*resumableSession
+	for _, u := range s.uploads {
+		u.mu.Lock()
+		if !u.appending && !u.completed && now.After(u.ExpiresAt) {
+			expired = append(expired, u)
+		}
+		u.mu.Unlock()
+	}
+	s.mu.Unlock()
+
+	for _, u := range expired {
+		s.Finish(u)
+	}
+	return len(expired)
+}
+
+type CreateResumableRequest struct {
+	Filename string `json:"filename" binding:"required"`
+	// Length is the total size in bytes, if known; completion then requires
+	// exactly that many
+	Length   int64             `json:"length"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+type CompleteResumableRequest struct {
+	// ShareTTL asks for a share link as on /upload
+	ShareTTL string `json:"share_ttl"`
+}
+
+// @Summary Start a resumable upload
+// @Description Opens an upload that is sent as chunks appended with PATCH /uploads/{id}. A client that loses its connection reads Upload-Offset from GET or HEAD /uploads/{id} and resumes from there. The Location header points at the upload. Chunks must all go to the same server replica; uploads that receive nothing for 24 hours are aborted.
+// @Accept json
+// @Produce json
+// @Param request body CreateResumableRequest true "File name, optional total length and metadata"
+// @Success 201 {object} ResumableUpload
+// @Security ApiKeyAuth
+// @Router /uploads [post]
+func (s *Server) handleCreateResumable(c *gin.Context) {
+	var req CreateResumableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Length < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "length must not be negative"})
+		return
+	}
+	if s.maxUploadBytes > 0 && req.Length > s.maxUploadBytes {
+		respondError(c, uploadTooLarge(s.maxUploadBytes))
+		return
+	}
+
+	u, err := s.resumable.Create(tenantFrom(c), req.Filename, req.Length, req.Metadata)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Location", "/api/v1/uploads/"+u.ID)
+	c.Header(uploadOffsetHeader, "0")
+	c.JSON(http.StatusCreated, u.snapshot())
+}
+
+// @Summary Get a resumable upload
+// @Description Reports how many bytes have been received, also as the Upload-Offset header (HEAD returns only the headers)
+// @Produce json
+// @Param id path string true "Upload ID"
+// @Success 200 {object} ResumableUpload
+// @Security ApiKeyAuth
+// @Router /uploads/{id} [get]
+func (s *Server) handleGetResumable(c *gin.Context) {
+	u, ok := s.resumable.Get(tenantFrom(c), c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+	snap := u.snapshot()
+	c.Header("Cache-Control", "no-store")
+	c.Header(uploadOffsetHeader, strconv.FormatInt(snap.Offset, 10))
+	if snap.Length > 0 {
+		c.Header(uploadLengthHeader, strconv.FormatInt(snap.Length, 10))
+	}
+	c.JSON(http.StatusOK, snap)
+}
+
+// @Summary Append a chunk
+// @Description Appends the request body at Upload-Offset, which must equal the bytes received so far; a mismatch answers 409 with the current Upload-Offset. If the connection drops mid-chunk, the bytes that arrived are kept. Answers 204 with the new Upload-Offset.
+// @Accept octet-stream
+// @Param id path string true "Upload ID"
+// @Param Upload-Offset header int true "Offset the chunk starts at"
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /uploads/{id} [patch]
+func (s *Server) handleAppendResumable(c *gin.Context) {
+	u, ok := s.resumable.Get(tenantFrom(c), c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset header is required"})
+		return
+	}
+
+	next, err := s.resumable.Append(u, offset, c.Request.Body, s.maxUploadBytes)
+	c.Header(uploadOffsetHeader, strconv.FormatInt(next, 10))
+	if err != nil {
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) {
+			err = newAPIError(http.StatusBadRequest, "%v", err)
+		}
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Complete a resumable upload
+// @Description Uploads the received bytes to 0G as one file. When a length was declared, all of it must have arrived.
+// @Accept json
+// @Produce json
+// @Param id path string true "Upload ID"
+// @Param request body CompleteResumableRequest false "Share link options"
+// @Success 200 {object} UploadResponse
+// @Security ApiKeyAuth
+// @Router /uploads/{id}/complete [post]
+func (s *Server) handleCompleteResumable(c *gin.Context) {
+	u, ok := s.resumable.Get(tenantFrom(c), c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+	var req CompleteResumableRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	share, shareTTL, err := shareRequested(req.ShareTTL)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	encryption, err := s.encryption.Requested(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	path, size, err := s.resumable.Close(u)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	defer s.resumable.Finish(u)
+
+	timer := s.stages.NewTimer(c.Request.Context())
+	resp, err := s.storeUpload(uploadRequest{
+		Tenant:   u.Tenant,
+		Class:    requestClassFrom(c),
+		Tuning:   transferTuningFrom(c),
+		KeyID:    c.GetString(keyIDContextKey),
+		Origin:   requestOriginFrom(c),
+		Flags:    featureFlagsFrom(c),
+		Timer:    timer,
+		Path:     path,
+		Filename: u.Filename,
+		Size:     size,
+		Metadata: u.Metadata,
+
+		Encryption: encryption,
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	resp.Stages = timer.Millis()
+	if share {
+		s.shareUpload(c, &resp, shareTTL)
+	}
+	c.JSON(uploadStatus(resp), resp)
+}
+
+// @Summary Abort a resumable upload
+// @Description Discards the upload and everything received. An upload already being completed cannot be aborted.
+// @Param id path string true "Upload ID"
+// @Success 204
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /uploads/{id} [delete]
+func (s *Server) handleAbortResumable(c *gin.Context) {
+	u, ok := s.resumable.Get(tenantFrom(c), c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+	if err := s.resumable.Abort(u); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// 0G verifies content in segments of this many bytes
+	segmentSize = 256 << 10
+	// Segments are made of chunks of this many bytes
+	chunkSize = 256
+
+	resumeTokenTTL = 24 * time.Hour
+)
+
+// resumeState is what a resumption token vouches for: the first Offset bytes
+// of RootHash were delivered, so a download can continue from there.
+type resumeState struct {
+	RootHash  string `json:"r"`
+	Offset    int64  `json:"o"`
+	Size      int64  `json:"s"`
+	ExpiresAt int64  `json:"e"`
+}
+
+// ResumeTokens signs download resumption tokens. Replicas sharing the secret
+// accept each other's tokens.
+type ResumeTokens struct {
+	secret []byte
+}
+
+// NewResumeTokens uses secret, or a random one that only this process knows.
+func NewResumeTokens(secret string) *ResumeTokens {
+	if secret != "" {
+		return &ResumeTokens{secret: []byte(secret)}
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	log.Printf("⚠️  RESUME_TOKEN_SECRET is not set: download resumption tokens only work on this replica")
+	return &ResumeTokens{secret: key}
+}
+
+func (t *ResumeTokens) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Issue returns a token for continuing rootHash from offset.
+func (t *ResumeTokens) Issue(rootHash string, offset, size int64) string {
+	payload, _ := json.Marshal(resumeState{
+		RootHash:  rootHash,
+		Offset:    offset,
+		Size:      size,
+		ExpiresAt: time.Now().Add(resumeTokenTTL).Unix(),
+	})
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(t.sign(payload))
+}
+
+// Parse verifies a token and returns the state it carries.
+func (t *ResumeTokens) Parse(token string) (resumeState, error) {
+	enc := base64.RawURLEncoding
+	payloadPart, sigPart, ok := strings.Cut(token, ".")
+	if !ok {
+		return resumeState{}, fmt.Errorf("malformed resume token")
+	}
+	payload, err := enc.DecodeString(payloadPart)
+	if err != nil {
+		return resumeState{}, fmt.Errorf("malformed resume token")
+	}
+	sig, err := enc.DecodeString(sigPart)
+	if err != nil || !hmac.Equal(sig, t.sign(payload)) {
+		return resumeState{}, fmt.Errorf("invalid resume token")
+	}
+
+	var st resumeState
+	if err := json.Unmarshal(payload, &st); err != nil {
+		return resumeState{}, fmt.Errorf("malformed resume token")
+	}
+	if time.Now().Unix() > st.ExpiresAt {
+		return resumeState{}, fmt.Errorf("resume token expired")
+	}
+	return st, nil
+}
+
+// requestedRange parses a single "bytes=" range against size and returns the
+// last byte it covers. ok is false for anything http.ServeContent would not
+// answer with a single 206 part.
+func requestedRange(header string, size int64) (start, end int64, ok bool) {
+	if !strings.HasPrefix(header, "bytes=") {
+		return 0, 0, false
+	}
+	spec := strings.TrimPrefix(header, "bytes=")
+	if strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+	var err error
+	switch {
+	case first == "":
+		// Suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	default:
+		if start, err = strconv.ParseInt(first, 10, 64); err != nil || start >= size {
+			return 0, 0, false
+		}
+		end = size - 1
+		if last != "" {
+			if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+				return 0, 0, false
+			}
+			if end >= size {
+				end = size - 1
+			}
+		}
+		return start, end, true
+	}
+}
+
+// serveDownload writes a downloaded object with range support. A resume
+// token (?resume= or X-Resume-Token) continues where an earlier response left
+// off, on any replica; every partial response that stops short of the end
+// carries X-Resume-Token for the next request, aligned to the start of the
+// segment it stopped in.
+func (s *Server) serveDownload(c *gin.Context, rootHash, localPath string) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	size := info.Size()
+
+	token := c.Query("resume")
+	if token == "" {
+		token = c.GetHeader("X-Resume-Token")
+	}
+	if token != "" {
+		st, err := s.resume.Parse(token)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !strings.EqualFold(st.RootHash, rootHash) || st.Size != size {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Resume token does not belong to this file"})
+			return
+		}
+		if st.Offset >= size {
+			c.Status(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		c.Request.Header.Set("Range", fmt.Sprintf("bytes=%d-", st.Offset))
+		c.Request.Header.Del("If-Range")
+	}
+
+	if _, end, ok := requestedRange(c.Request.Header.Get("Range"), size); ok && end+1 < size {
+		next := (end + 1) / segmentSize * segmentSize
+		c.Header("X-Resume-Token", s.resume.Issue(rootHash, next, size))
+	}
+	c.Header("Accept-Ranges", "bytes")
+	// ServeContent answers If-None-Match and If-Range against it
+	c.Header("ETag", downloadETag(rootHash))
+	s.describeDownload(c, rootHash)
+	s.signResponse(c, rootHash, localPath, true)
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, f)
+}
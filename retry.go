@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/0glabs/0g-storage-client/node"
+)
+
+// RetryPolicy is how often, and how far apart, a step against the storage
+// nodes is tried before it counts as failed: Attempts tries in all, the
+// first retry after Backoff and each further one after twice the last
+// wait, up to MaxBackoff.
+type RetryPolicy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+var defaultNodeRetry = RetryPolicy{Attempts: 3, Backoff: 500 * time.Millisecond, MaxBackoff: 10 * time.Second}
+
+// Do runs fn until it succeeds, the attempts are used up or ctx is done,
+// and returns fn's last error.
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	wait := p.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || attempt >= p.Attempts {
+			return err
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		if wait *= 2; p.MaxBackoff > 0 && wait > p.MaxBackoff {
+			wait = p.MaxBackoff
+		}
+	}
+}
+
+// nodeFailed counts a failed transfer against a node, so that after a few
+// node selection leaves it out like a node that fails its probes. Pooled
+// node sets holding it are selected again.
+func (c *StorageClient) nodeFailed(n *node.ZgsClient, err error) {
+	c.prober.Observe(normalizeNodeURL(n.URL()), 0, err)
+	c.pool.Invalidate(n.URL())
+}
+
+// selectUploadNodes picks the nodes for an upload, from the node pool when
+// there is one.
+func (c *StorageClient) selectUploadNodes(class RequestClass, replicas uint) ([]*node.ZgsClient, error) {
+	if c.pool != nil {
+		return c.pooledNodes(class, replicas)
+	}
+	return c.selectHealthyNodes(class, replicas)
+}
+
+// selectHealthyNodes is selectNodesExcluding where each node picked must
+// answer for its shard, and nodes that do not are replaced by selecting
+// again without them, up to the retry policy's attempts. A flaky node then
+// costs a second selection rather than the whole upload.
+func (c *StorageClient) selectHealthyNodes(class RequestClass, replicas uint) ([]*node.ZgsClient, error) {
+	var avoid []string
+	var nodes []*node.ZgsClient
+	err := c.retry.Do(c.ctx, func() error {
+		var err error
+		if nodes, err = c.selectNodesExcluding(class, replicas, avoid); err != nil {
+			return err
+		}
+		var failed []string
+		for _, n := range nodes {
+			ctx, cancel := context.WithTimeout(c.ctx, probeTimeout)
+			_, err := n.GetShardConfig(ctx)
+			cancel()
+			if err != nil {
+				c.nodeFailed(n, err)
+				failed = append(failed, n.URL())
+			}
+		}
+		if len(failed) == 0 {
+			return nil
+		}
+		log.Printf("⚠️  Storage nodes %v did not answer; selecting others", failed)
+		avoid = append(avoid, failed...)
+		return fmt.Errorf("storage nodes %v did not answer", failed)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// downloadSegmented downloads rootHash into outputPath segment by segment,
+// each from the first of nodes that serves it with a valid proof. It is
+// the fallback when the SDK's download fails: one node that does not
+// answer then only means its segments come from another replica.
+func (c *StorageClient) downloadSegmented(nodes []*node.ZgsClient, routines int, rootHash, outputPath string) error {
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+	info, err := c.FileInfo(ctx, nodes, rootHash)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return fmt.Errorf("file not found on storage nodes")
+	}
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %v", err)
+	}
+	err = c.StreamFile(ctx, nodes, rootHash, int64(info.Tx.Size), routines, func(data []byte) error {
+		_, err := out.Write(data)
+		return err
+	})
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3PartSize is the multipart part size used when the length of a body is
+// not known up front. S3 requires at least 5 MiB for all but the last part.
+const s3PartSize = 8 << 20
+
+// S3Client is a minimal client for S3-compatible object storage (AWS, MinIO,
+// R2, ...) using path-style URLs and Signature Version 4.
+type S3Client struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	http      *http.Client
+}
+
+func NewS3Client(endpoint, region, bucket, accessKey, secretKey string) (*S3Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	return &S3Client{
+		endpoint:  u,
+		region:    region,
+		bucket:    bucket,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		http:      &http.Client{},
+	}, nil
+}
+
+// awsEscape percent-encodes everything but unreserved characters, as SigV4
+// canonical requests require.
+func awsEscape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch >= 'A' && ch <= 'Z', ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~', ch == '/' && keepSlash:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func (c *S3Client) newRequest(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Request, error) {
+	escapedPath := awsEscape(strings.TrimSuffix(c.endpoint.Path, "/")+"/"+c.bucket+"/"+key, true)
+
+	var queryParts []string
+	for k, vs := range query {
+		for _, v := range vs {
+			queryParts = append(queryParts, awsEscape(k, false)+"="+awsEscape(v, false))
+		}
+	}
+	sort.Strings(queryParts)
+	rawQuery := strings.Join(queryParts, "&")
+
+	target := c.endpoint.Scheme + "://" + c.endpoint.Host + escapedPath + queryPrefix(rawQuery)
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+
+	payloadHash := sha256.Sum256(body)
+	payloadHex := hex.EncodeToString(payloadHash[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHex)
+	signV4(req, escapedPath, rawQuery, payloadHex, "s3", c.region, c.accessKey, c.secretKey)
+	return req, nil
+}
+
+// signV4 signs req for service with Signature Version 4, covering the host
+// and every X-Amz-* header already set on it.
+func signV4(req *http.Request, escapedPath, rawQuery, payloadHex, service, region, accessKey, secretKey string) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{req.Method, escapedPath, rawQuery, canonicalHeaders.String(), signedHeaders, payloadHex}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func queryPrefix(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	return "?" + rawQuery
+}
+
+func (c *S3Client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("S3 %s %s returned %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+func (c *S3Client) call(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, key, query, body)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req)
+}
+
+// PutObject streams r to key and returns the number of bytes written. Bodies
+// larger than one part go through a multipart upload, so the length does not
+// have to be known in advance and at most one part is held in memory.
+func (c *S3Client) PutObject(ctx context.Context, key string, r io.Reader) (int64, error) {
+	buf := make([]byte, s3PartSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		resp, err := c.call(ctx, http.MethodPut, key, nil, buf[:n])
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return int64(n), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.call(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return 0, err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start multipart upload: %v", err)
+	}
+
+	type part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var parts []part
+	var total int64
+	abort := func() {
+		if resp, err := c.call(context.Background(), http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadID}}, nil); err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	for chunk := buf[:n]; len(chunk) > 0; {
+		number := len(parts) + 1
+		resp, err := c.call(ctx, http.MethodPut, key, url.Values{
+			"partNumber": {fmt.Sprint(number)},
+			"uploadId":   {initiated.UploadID},
+		}, chunk)
+		if err != nil {
+			abort()
+			return 0, err
+		}
+		resp.Body.Close()
+		parts = append(parts, part{PartNumber: number, ETag: resp.Header.Get("ETag")})
+		total += int64(len(chunk))
+
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			abort()
+			return 0, err
+		}
+		chunk = buf[:n]
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		abort()
+		return 0, err
+	}
+	resp, err = c.call(ctx, http.MethodPost, key, url.Values{"uploadId": {initiated.UploadID}}, body)
+	if err != nil {
+		abort()
+		return 0, err
+	}
+	resp.Body.Close()
+	return total, nil
+}
+
+// GetObject opens key for reading. The caller must close the body.
+func (c *S3Client) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := c.call(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// GetObjectRange reads length bytes of key starting at offset. The Range
+// header is left out of the signature, which only covers X-Amz-* headers.
+func (c *S3Client) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	// A server ignoring the range sends the whole object from the start
+	if resp.StatusCode != http.StatusPartialContent && offset > 0 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("S3 GET %s ignored the requested range", req.URL.Path)
+	}
+	return resp.Body, nil
+}
+
+func (c *S3Client) DeleteObject(ctx context.Context, key string) error {
+	resp, err := c.call(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// S3Object is one entry of a bucket listing.
+type S3Object struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+}
+
+// ListObjects returns every object whose key starts with prefix, following
+// continuation tokens until the listing is complete.
+func (c *S3Client) ListObjects(ctx context.Context, prefix string) ([]S3Object, error) {
+	var objects []S3Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := c.call(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents              []S3Object `xml:"Contents"`
+			IsTruncated           bool       `xml:"IsTruncated"`
+			NextContinuationToken string     `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse bucket listing: %v", err)
+		}
+		objects = append(objects, page.Contents...)
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+
+	// BM25 parameters
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Searchable fields and how much a match in each counts. Tags and the
+// description are read from the metadata keys of the same name; other
+// metadata values are indexed as one field.
+var searchFields = []struct {
+	Name   string
+	Weight float64
+}{
+	{"filename", 3},
+	{"tags", 2},
+	{"description", 1.5},
+	{"metadata", 1},
+}
+
+func searchFieldText(rec *FileRecord, field string) string {
+	switch field {
+	case "filename":
+		return rec.Filename
+	case "tags", "description":
+		return rec.Metadata[field]
+	}
+	var values []string
+	for k, v := range rec.Metadata {
+		if k != "tags" && k != "description" {
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+	return strings.Join(values, " ")
+}
+
+// tokenize lowercases text and splits it into runs of letters and digits, so
+// "Q3_report-final.pdf" yields q3, report, final and pdf.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+type posting struct {
+	doc   int
+	field int
+	tf    int
+}
+
+// tenantIndex is an inverted index over one tenant's visible files.
+type tenantIndex struct {
+	rev      uint64
+	docs     []FileRecord
+	postings map[string][]posting
+	terms    []string // sorted, for prefix matching
+	// Average token count per field, for length normalization
+	avgLen []float64
+	length [][]int // doc -> field -> token count
+}
+
+func buildTenantIndex(rev uint64, files []FileRecord) *tenantIndex {
+	idx := &tenantIndex{
+		rev:      rev,
+		docs:     files,
+		postings: make(map[string][]posting),
+		avgLen:   make([]float64, len(searchFields)),
+		length:   make([][]int, len(files)),
+	}
+	for d := range files {
+		idx.length[d] = make([]int, len(searchFields))
+		for f, field := range searchFields {
+			tokens := tokenize(searchFieldText(&files[d], field.Name))
+			idx.length[d][f] = len(tokens)
+			idx.avgLen[f] += float64(len(tokens))
+
+			counts := make(map[string]int)
+			for _, t := range tokens {
+				counts[t]++
+			}
+			for t, n := range counts {
+				idx.postings[t] = append(idx.postings[t], posting{doc: d, field: f, tf: n})
+			}
+		}
+	}
+	for f := range idx.avgLen {
+		if len(files) > 0 {
+			idx.avgLen[f] /= float64(len(files))
+		}
+	}
+	for t := range idx.postings {
+		idx.terms = append(idx.terms, t)
+	}
+	sort.Strings(idx.terms)
+	return idx
+}
+
+// expand returns the indexed terms a query term matches: itself, plus every
+// term it is a prefix of when prefix is set.
+func (idx *tenantIndex) expand(term string, prefix bool) []string {
+	if !prefix {
+		if _, ok := idx.postings[term]; ok {
+			return []string{term}
+		}
+		return nil
+	}
+	var matches []string
+	for i := sort.SearchStrings(idx.terms, term); i < len(idx.terms) && strings.HasPrefix(idx.terms[i], term); i++ {
+		matches = append(matches, idx.terms[i])
+	}
+	return matches
+}
+
+// SearchHit is a file matching a search, with the fields it matched in and
+// their text with matching words wrapped in <mark></mark>.
+type SearchHit struct {
+	File       FileRecord        `json:"file"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+type SearchResponse struct {
+	Query string      `json:"query"`
+	Total int         `json:"total"`
+	Hits  []SearchHit `json:"hits"`
+}
+
+// search ranks the files matching every query term with BM25 over the
+// weighted fields. The last term also matches as a prefix, for search as
+// you type.
+func (idx *tenantIndex) search(query string) []SearchHit {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return []SearchHit{}
+	}
+
+	n := float64(len(idx.docs))
+	scores := make(map[int]float64)
+	matchedTerms := make(map[int]map[string]bool)
+	for i, term := range terms {
+		expanded := idx.expand(term, i == len(terms)-1 && len(term) > 1)
+		termScores := make(map[int]float64)
+		for _, t := range expanded {
+			list := idx.postings[t]
+			docFreq := make(map[int]bool)
+			for _, p := range list {
+				docFreq[p.doc] = true
+			}
+			idf := math.Log(1 + (n-float64(len(docFreq))+0.5)/(float64(len(docFreq))+0.5))
+			for _, p := range list {
+				tf := float64(p.tf)
+				norm := 1 - bm25B
+				if avg := idx.avgLen[p.field]; avg > 0 {
+					norm += bm25B * float64(idx.length[p.doc][p.field]) / avg
+				}
+				termScores[p.doc] += searchFields[p.field].Weight * idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+				if matchedTerms[p.doc] == nil {
+					matchedTerms[p.doc] = make(map[string]bool)
+				}
+				matchedTerms[p.doc][t] = true
+			}
+		}
+		// Every term must match
+		if i == 0 {
+			scores = termScores
+			continue
+		}
+		for doc := range scores {
+			if s, ok := termScores[doc]; ok {
+				scores[doc] += s
+			} else {
+				delete(scores, doc)
+			}
+		}
+	}
+
+	hits := make([]SearchHit, 0, len(scores))
+	for doc, score := range scores {
+		rec := idx.docs[doc]
+		hits = append(hits, SearchHit{
+			File:       rec,
+			Score:      math.Round(score*1000) / 1000,
+			Highlights: highlight(&rec, matchedTerms[doc]),
+		})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].File.CreatedAt.After(hits[j].File.CreatedAt)
+	})
+	return hits
+}
+
+// highlight marks the words of each field that matched.
+func highlight(rec *FileRecord, matched map[string]bool) map[string]string {
+	highlights := make(map[string]string)
+	for _, field := range searchFields {
+		text := searchFieldText(rec, field.Name)
+		var b strings.Builder
+		found := false
+		word := -1
+		flush := func(end int) {
+			if word < 0 {
+				return
+			}
+			w := text[word:end]
+			if matched[strings.ToLower(w)] {
+				b.WriteString("<mark>" + w + "</mark>")
+				found = true
+			} else {
+				b.WriteString(w)
+			}
+			word = -1
+		}
+		for i, r := range text {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				if word < 0 {
+					word = i
+				}
+				continue
+			}
+			flush(i)
+			b.WriteRune(r)
+		}
+		flush(len(text))
+		if found {
+			highlights[field.Name] = b.String()
+		}
+	}
+	return highlights
+}
+
+// SearchIndex keeps a full-text index per tenant over the catalog and
+// rebuilds a tenant's index on first search after the catalog changed.
+type SearchIndex struct {
+	catalog *Catalog
+
+	mu      sync.Mutex
+	tenants map[string]*tenantIndex
+}
+
+func NewSearchIndex(catalog *Catalog) *SearchIndex {
+	return &SearchIndex{catalog: catalog, tenants: make(map[string]*tenantIndex)}
+}
+
+func (s *SearchIndex) Search(tenant, query string) []SearchHit {
+	rev := s.catalog.Revision()
+	s.mu.Lock()
+	idx, ok := s.tenants[tenant]
+	if !ok || idx.rev != rev {
+		idx = buildTenantIndex(rev, s.catalog.Files(tenant))
+		s.tenants[tenant] = idx
+	}
+	s.mu.Unlock()
+	return idx.search(query)
+}
+
+// @Summary Search files
+// @Description Full-text search over the caller's filenames, tags, descriptions (the tags and description metadata keys) and other metadata values. Every word must match; the last one also matches as a prefix. Results are ranked with BM25, weighting filename matches highest, and carry highlights with matching words in <mark></mark>.
+// @Produce json
+// @Param q query string true "Search words"
+// @Param limit query int false "Maximum hits (default 20, at most 100)"
+// @Param offset query int false "Hits to skip"
+// @Success 200 {object} SearchResponse
+// @Security ApiKeyAuth
+// @Router /search [get]
+func (s *Server) handleSearch(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultSearchLimit)))
+	if err != nil || limit < 1 || limit > maxSearchLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative number"})
+		return
+	}
+
+	hits := s.search.Search(tenantFrom(c), query)
+	resp := SearchResponse{Query: query, Total: len(hits), Hits: []SearchHit{}}
+	if offset < len(hits) {
+		end := offset + limit
+		if end > len(hits) {
+			end = len(hits)
+		}
+		resp.Hits = hits[offset:end]
+	}
+	if s.prefetch != nil {
+		files := make([]FileRecord, len(resp.Hits))
+		for i, hit := range resp.Hits {
+			files[i] = hit.File
+		}
+		s.hint(files)
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+// App names and setting keys become part of a KV key, so they are kept to a
+// plain alphabet.
+var settingName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+type settingEntry struct {
+	value   []byte
+	etag    string
+	fetched time.Time
+}
+
+// SettingsStore keeps small per-app settings in a 0G KV stream, under
+// "<tenant>/<app>/<key>", so each tenant has its own namespace of apps.
+// Values read are cached for ttl; values written are cached right away,
+// which also covers the KV node's sync lag.
+type SettingsStore struct {
+	kv     *KVClient
+	stream common.Hash
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	cache map[string]settingEntry
+	// locks serialize writes to a key within this process
+	locks map[string]*sync.Mutex
+}
+
+func NewSettingsStore(kv *KVClient, stream string, ttl time.Duration) *SettingsStore {
+	return &SettingsStore{
+		kv:     kv,
+		stream: StreamID(stream),
+		ttl:    ttl,
+		cache:  make(map[string]settingEntry),
+		locks:  make(map[string]*sync.Mutex),
+	}
+}
+
+func (s *SettingsStore) keyLock(k string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, ok := s.locks[k]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.locks[k] = lock
+	}
+	return lock
+}
+
+func settingKey(tenant, app, key string) string {
+	return tenant + "/" + app + "/" + key
+}
+
+// settingETag is a strong ETag of a value; "" for a setting that is not set.
+func settingETag(value []byte) string {
+	if value == nil {
+		return ""
+	}
+	return `"` + hex.EncodeToString(sha256Sum(value))[:32] + `"`
+}
+
+// Get returns a setting's value and ETag; value is nil when it is not set.
+func (s *SettingsStore) Get(ctx context.Context, tenant, app, key string) ([]byte, string, error) {
+	k := settingKey(tenant, app, key)
+	s.mu.Lock()
+	entry, ok := s.cache[k]
+	s.mu.Unlock()
+	if ok && time.Since(entry.fetched) < s.ttl {
+		return entry.value, entry.etag, nil
+	}
+
+	value, err := s.kv.Get(ctx, s.stream, []byte(k))
+	if err != nil {
+		return nil, "", err
+	}
+	entry = settingEntry{value: value, etag: settingETag(value), fetched: time.Now()}
+	s.mu.Lock()
+	s.cache[k] = entry
+	s.mu.Unlock()
+	return entry.value, entry.etag, nil
+}
+
+// Put writes a setting unless ifMatch is given and no longer matches its
+// ETag, and returns the new ETag and transaction hash. An empty value clears
+// the setting.
+func (s *SettingsStore) Put(ctx context.Context, tenant, app, key string, value []byte, ifMatch string) (string, string, error) {
+	k := settingKey(tenant, app, key)
+	lock := s.keyLock(k)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if ifMatch != "" {
+		_, etag, err := s.Get(ctx, tenant, app, key)
+		if err != nil {
+			return "", "", err
+		}
+		if !etagMatches(ifMatch, etag) {
+			return "", "", newAPIError(http.StatusPreconditionFailed, "Setting %s of %s has changed", key, app)
+		}
+	}
+
+	txHash, err := s.kv.Set(ctx, s.stream, []KVPair{{Key: []byte(k), Value: value}})
+	if err != nil {
+		return "", "", err
+	}
+	if len(value) == 0 {
+		value = nil
+	}
+	entry := settingEntry{value: value, etag: settingETag(value), fetched: time.Now()}
+	s.mu.Lock()
+	s.cache[k] = entry
+	s.mu.Unlock()
+	return entry.etag, txHash, nil
+}
+
+type SettingWriteResponse struct {
+	App    string `json:"app"`
+	Key    string `json:"key"`
+	ETag   string `json:"etag,omitempty"`
+	TxHash string `json:"tx_hash"`
+}
+
+func settingParams(c *gin.Context) (app, key string, ok bool) {
+	app, key = c.Param("app"), c.Param("key")
+	if !settingName.MatchString(app) || !settingName.MatchString(key) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "App names and keys take 1 to 128 letters, digits, '.', '_' or '-'"})
+		return "", "", false
+	}
+	return app, key, true
+}
+
+// @Summary Read an app setting
+// @Description Returns a setting of one of the caller's apps, stored in 0G KV, with its ETag. JSON values are served as application/json, anything else as octet-stream. Send If-None-Match to get 304 while it is unchanged. Reads are cached for SETTINGS_CACHE_TTL.
+// @Produce json,octet-stream
+// @Param app path string true "App name"
+// @Param key path string true "Setting key"
+// @Param If-None-Match header string false "ETag of the value already held"
+// @Success 200 {file} binary
+// @Success 304
+// @Failure 404 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /apps/{app}/settings/{key} [get]
+func (s *Server) handleGetSetting(c *gin.Context) {
+	if s.settings == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "KV is not configured (set KV_NODE_RPC)"})
+		return
+	}
+	app, key, ok := settingParams(c)
+	if !ok {
+		return
+	}
+
+	value, etag, err := s.settings.Get(c.Request.Context(), tenantFrom(c), app, key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if value == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Setting not found"})
+		return
+	}
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, no-cache")
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	contentType := "application/octet-stream"
+	if json.Valid(value) {
+		contentType = "application/json"
+	}
+	c.Data(http.StatusOK, contentType, value)
+}
+
+// @Summary Write an app setting
+// @Description Stores the raw request body (max 64 KiB) as a setting of one of the caller's apps in 0G KV, in one transaction; an empty body clears it. Send If-Match with the ETag last read to write only if nobody changed it since, otherwise 412. Settings of one tenant are never visible to another.
+// @Accept json,octet-stream
+// @Produce json
+// @Param app path string true "App name"
+// @Param key path string true "Setting key"
+// @Param If-Match header string false "ETag the setting must still have"
+// @Success 200 {object} SettingWriteResponse
+// @Failure 412 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /apps/{app}/settings/{key} [put]
+func (s *Server) handlePutSetting(c *gin.Context) {
+	if s.settings == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "KV is not configured (set KV_NODE_RPC)"})
+		return
+	}
+	app, key, ok := settingParams(c)
+	if !ok {
+		return
+	}
+
+	value, err := io.ReadAll(io.LimitReader(c.Request.Body, maxKVValueSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
+	if len(value) > maxKVValueSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Settings are limited to %d bytes", maxKVValueSize)})
+		return
+	}
+
+	etag, txHash, err := s.settings.Put(c.Request.Context(), tenantFrom(c), app, key, value, c.GetHeader("If-Match"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if etag != "" {
+		c.Header("ETag", etag)
+	}
+	c.JSON(http.StatusOK, SettingWriteResponse{
+		App:    app,
+		Key:    key,
+		ETag:   etag,
+		TxHash: txHash,
+	})
+}
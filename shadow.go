@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ShadowResult records how a mirrored upload on the secondary network
+// compared with the primary one.
+type ShadowResult struct {
+	Filename       string    `json:"filename"`
+	PrimaryRoot    string    `json:"primary_root"`
+	PrimaryTx      string    `json:"primary_tx"`
+	ShadowRoot     string    `json:"shadow_root,omitempty"`
+	ShadowTx       string    `json:"shadow_tx,omitempty"`
+	Match          bool      `json:"match"`
+	Error          string    `json:"error,omitempty"`
+	PrimaryElapsed string    `json:"primary_elapsed"`
+	ShadowElapsed  string    `json:"shadow_elapsed"`
+	CompletedAt    time.Time `json:"completed_at"`
+}
+
+type ShadowSummary struct {
+	Enabled    bool           `json:"enabled"`
+	IndexerRPC string         `json:"indexer_rpc,omitempty"`
+	Total      int            `json:"total"`
+	Matched    int            `json:"matched"`
+	Mismatched int            `json:"mismatched"`
+	Failed     int            `json:"failed"`
+	Dropped    int            `json:"dropped"`
+	Recent     []ShadowResult `json:"recent"`
+}
+
+// Shadower mirrors uploads to a secondary network in the background and keeps
+// the most recent comparisons for inspection.
+type Shadower struct {
+	client     *StorageClient
+	spool      *Spool
+	indexerRPC string
+	history    int
+	// slots holds a token for each mirrored upload in flight
+	slots chan struct{}
+
+	mu         sync.Mutex
+	results    []ShadowResult
+	total      int
+	matched    int
+	mismatched int
+	failed     int
+	dropped    int
+}
+
+func NewShadower(client *StorageClient, spool *Spool, indexerRPC string, history, concurrency int) *Shadower {
+	if history <= 0 {
+		history = 100
+	}
+	if concurrency <= 0 {
+		concurrency = 2
+	}
+	return &Shadower{client: client, spool: spool, indexerRPC: indexerRPC, history: history, slots: make(chan struct{}, concurrency)}
+}
+
+// Mirror uploads a private copy of filePath to the secondary network and
+// compares the result with the primary upload. The caller remains free to
+// delete filePath as soon as Mirror returns. When as many mirrored uploads
+// as allowed are in flight, the file is not mirrored: a slow secondary
+// network must not pile up goroutines and staged copies.
+func (s *Shadower) Mirror(filePath, filename, primaryRoot, primaryTx string, primaryElapsed time.Duration) error {
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		s.mu.Lock()
+		s.dropped++
+		s.mu.Unlock()
+		return fmt.Errorf("%d shadow uploads already in flight", cap(s.slots))
+	}
+	copyPath, err := s.stage(filePath)
+	if err != nil {
+		<-s.slots
+		return err
+	}
+
+	go func() {
+		defer func() { <-s.slots }()
+		defer s.spool.Release(copyPath)
+
+		start := time.Now()
+		txHash, rootHash, err := s.client.UploadFile(copyPath)
+		result := ShadowResult{
+			Filename:       filename,
+			PrimaryRoot:    primaryRoot,
+			PrimaryTx:      primaryTx,
+			ShadowRoot:     rootHash,
+			ShadowTx:       txHash,
+			Match:          err == nil && rootHash == primaryRoot,
+			PrimaryElapsed: primaryElapsed.String(),
+			ShadowElapsed:  time.Since(start).String(),
+			CompletedAt:    time.Now(),
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		if !result.Match {
+			log.Printf("⚠️  Shadow upload of %s diverged: primary=%s shadow=%s err=%s", filename, primaryRoot, rootHash, result.Error)
+		}
+		s.record(result)
+	}()
+
+	return nil
+}
+
+// stage gives the background upload its own copy of the file in the spool,
+// preferring a hard link so large uploads are not duplicated on disk. The
+// copy is tracked like any spool file until the upload releases it.
+func (s *Shadower) stage(filePath string) (string, error) {
+	copyPath := s.spool.Path("shadow")
+	if err := os.Link(filePath, copyPath); err == nil {
+		return copyPath, nil
+	}
+
+	src, err := os.Open(filePath)
+	if err != nil {
+		s.spool.Release(copyPath)
+		return "", fmt.Errorf("failed to open file for shadow upload: %v", err)
+	}
+	defer src.Close()
+
+	dst, err := os.Create(copyPath)
+	if err != nil {
+		s.spool.Release(copyPath)
+		return "", fmt.Errorf("failed to stage shadow upload: %v", err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		s.spool.Release(copyPath)
+		return "", fmt.Errorf("failed to stage shadow upload: %v", err)
+	}
+	if err := dst.Close(); err != nil {
+		s.spool.Release(copyPath)
+		return "", fmt.Errorf("failed to stage shadow upload: %v", err)
+	}
+	return copyPath, nil
+}
+
+func (s *Shadower) record(result ShadowResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.total++
+	switch {
+	case result.Error != "":
+		s.failed++
+	case result.Match:
+		s.matched++
+	default:
+		s.mismatched++
+	}
+
+	s.results = append(s.results, result)
+	if len(s.results) > s.history {
+		s.results = s.results[len(s.results)-s.history:]
+	}
+}
+
+func (s *Shadower) Summary() ShadowSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recent := make([]ShadowResult, len(s.results))
+	copy(recent, s.results)
+	return ShadowSummary{
+		Enabled:    true,
+		IndexerRPC: s.indexerRPC,
+		Total:      s.total,
+		Matched:    s.matched,
+		Mismatched: s.mismatched,
+		Failed:     s.failed,
+		Dropped:    s.dropped,
+		Recent:     recent,
+	}
+}
+
+func (s *Shadower) Close() {
+	s.client.Close()
+}
+
+// @Summary Shadow upload comparison report
+// @Description Summarizes uploads mirrored to the secondary network configured via SHADOW_INDEXER_RPC
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Success 200 {object} ShadowSummary
+// @Router /admin/shadow [get]
+func (s *Server) handleShadowReport(c *gin.Context) {
+	if s.shadow == nil {
+		c.JSON(http.StatusOK, ShadowSummary{Enabled: false, Recent: []ShadowResult{}})
+		return
+	}
+	c.JSON(http.StatusOK, s.shadow.Summary())
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// shutdown stops the gateway taking requests and waits up to timeout for the
+// requests and background jobs (async uploads among them) already running.
+// Jobs still unfinished then are saved to jobsPath as failed.
+func (s *Server) shutdown(srv *http.Server, sig os.Signal, timeout time.Duration, jobsPath string) {
+	log.Printf("🛑 %s: no longer accepting requests, draining for up to %s", sig, timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("⚠️  Requests still running after %s: %v", timeout, err)
+	}
+	if n := s.jobs.Drain(ctx); n > 0 {
+		log.Printf("⚠️  %d jobs still running after %s; recording them as interrupted", n, timeout)
+	}
+	if err := s.jobs.Save(jobsPath); err != nil {
+		log.Printf("⚠️  Failed to save jobs: %v", err)
+	}
+	log.Printf("🛑 Drained in %s", time.Since(start).Round(time.Millisecond))
+}
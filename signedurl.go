@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// signedURLClockSkew is how far ahead of this replica's clock a token's
+	// issue time may be, for replicas whose clocks differ a little
+	signedURLClockSkew = time.Minute
+	// singleUseLeaseTTL is how long a single-use link without an expiry is
+	// remembered as used across replicas; the link store keeps it for good
+	singleUseLeaseTTL = 30 * 24 * time.Hour
+)
+
+// URLClaims are embedded in every signed URL the gateway issues: when it
+// was issued, a random nonce making it unique, and the audience (the
+// gateway host) it is meant for. They keep a captured URL from being used
+// somewhere, or for longer, than it was issued for.
+type URLClaims struct {
+	IssuedAt int64  `json:"iat"`
+	Nonce    string `json:"n"`
+	Audience string `json:"aud,omitempty"`
+}
+
+func newURLClaims(audience string) (URLClaims, error) {
+	nonce, err := randomHex(12)
+	if err != nil {
+		return URLClaims{}, err
+	}
+	return URLClaims{IssuedAt: time.Now().Unix(), Nonce: nonce, Audience: audience}, nil
+}
+
+// Check validates the claims of a token expiring at expiresAt (unix
+// seconds), which may live at most maxTTL, presented to audience. what
+// names the token in errors.
+func (cl URLClaims) Check(what string, expiresAt int64, maxTTL time.Duration, audience string) error {
+	now := time.Now()
+	if cl.Nonce == "" || cl.IssuedAt == 0 {
+		return fmt.Errorf("%s carries no issue time or nonce", what)
+	}
+	if time.Unix(cl.IssuedAt, 0).After(now.Add(signedURLClockSkew)) {
+		return fmt.Errorf("%s is not valid yet", what)
+	}
+	if expiresAt-cl.IssuedAt > int64(maxTTL/time.Second) {
+		return fmt.Errorf("%s is valid for longer than allowed", what)
+	}
+	if now.Unix() > expiresAt {
+		return fmt.Errorf("%s expired", what)
+	}
+	if cl.Audience != "" && cl.Audience != audience {
+		return fmt.Errorf("%s was issued for another gateway", what)
+	}
+	return nil
+}
+
+// urlAudience is the audience signed URLs issued or presented in a request
+// are for: SIGNED_URL_AUDIENCE, or else the host the request was made to.
+func (s *Server) urlAudience(c *gin.Context) string {
+	if s.urlAudienceName != "" {
+		return s.urlAudienceName
+	}
+	return c.Request.Host
+}
+
+// spendOnce records that name was used, across replicas when they share a
+// lock backend, until until. It fails with 410 when name was used before.
+func (s *Server) spendOnce(ctx context.Context, name string, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	_, ok, err := s.locks.TryAcquire(ctx, "used/"+name, ttl)
+	if err != nil {
+		return fmt.Errorf("failed to check for reuse: %v", err)
+	}
+	if !ok {
+		return newAPIError(http.StatusGone, "This link was already used")
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResponseSigner signs served content with an Ethereum key, so a client or
+// cache holding a response can prove which gateway served those bytes of
+// which object, and when. Signatures follow EIP-191 (personal_sign), so any
+// Ethereum library can recover the signer's address from them.
+type ResponseSigner struct {
+	wallet  Wallet
+	address string
+}
+
+func NewResponseSigner(privateKey string) (*ResponseSigner, error) {
+	wallet, err := NewKeyWallet(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %v", err)
+	}
+	return NewWalletResponseSigner(wallet), nil
+}
+
+// NewWalletResponseSigner signs with the gateway's wallet. Remote and
+// hardware wallets are asked for every signature, so a local
+// RESPONSE_SIGNING_KEY is usually the better choice for them.
+func NewWalletResponseSigner(wallet Wallet) *ResponseSigner {
+	return &ResponseSigner{wallet: wallet, address: wallet.Address().Hex()}
+}
+
+func (s *ResponseSigner) Address() string {
+	return s.address
+}
+
+// signedContent is the statement a signature covers, one field per line:
+//
+//	0g-gateway-response
+//	root=<root hash>
+//	range=bytes <first>-<last>/<size>
+//	ts=<unix seconds>
+func signedContent(rootHash string, start, end, size int64, at time.Time) string {
+	return fmt.Sprintf("0g-gateway-response\nroot=%s\nrange=bytes %d-%d/%d\nts=%d",
+		strings.ToLower(rootHash), start, end, size, at.Unix())
+}
+
+// Sign returns the 65-byte signature over message as hex, with v as 27 or 28
+// the way ecrecover expects it.
+func (s *ResponseSigner) Sign(message string) (string, error) {
+	sig, err := s.wallet.SignMessage([]byte(message))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign response: %v", err)
+	}
+	return "0x" + hex.EncodeToString(sig), nil
+}
+
+// signResponse adds the signature headers for serving localPath as rootHash.
+// The signed range is the one a single-range request will be answered with,
+// and the whole object otherwise; ranged is false when the response ignores
+// Range (e.g. because it is compressed on the fly).
+func (s *Server) signResponse(c *gin.Context, rootHash, localPath string, ranged bool) {
+	if s.signer == nil {
+		return
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return
+	}
+	s.signRange(c, rootHash, info.Size(), ranged)
+}
+
+// signRange is signResponse for an object of size bytes that is not on disk.
+func (s *Server) signRange(c *gin.Context, rootHash string, size int64, ranged bool) {
+	if s.signer == nil {
+		return
+	}
+	start, end := int64(0), size-1
+	if ranged {
+		if first, last, ok := requestedRange(c.GetHeader("Range"), size); ok {
+			start, end = first, last
+		}
+	}
+	message := signedContent(rootHash, start, end, size, time.Now())
+	sig, err := s.signer.Sign(message)
+	if err != nil {
+		return
+	}
+	c.Header("X-Gateway-Signer", s.signer.Address())
+	// The statement with its newlines as "; ", so it fits in a header
+	c.Header("X-Gateway-Signed", strings.ReplaceAll(message, "\n", "; "))
+	c.Header("X-Gateway-Signature", sig)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Site is a named pointer to a directory manifest served as a static website.
+type Site struct {
+	Name         string    `json:"name"`
+	ManifestRoot string    `json:"manifest_root"`
+	Owner        string    `json:"owner,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type SetSiteRequest struct {
+	ManifestRoot string `json:"manifest_root" binding:"required"`
+}
+
+// SiteRegistry holds site name pointers and the hostnames that map to them.
+// Sites created through the API are persisted to path; sites configured at
+// startup are not, and cannot be changed through the API.
+type SiteRegistry struct {
+	mu    sync.RWMutex
+	path  string
+	sites map[string]*Site
+	hosts map[string]string // hostname -> site name
+}
+
+func NewSiteRegistry(path string, sites, hosts map[string]string) (*SiteRegistry, error) {
+	reg := &SiteRegistry{
+		path:  path,
+		sites: make(map[string]*Site),
+		hosts: make(map[string]string),
+	}
+	if path != "" {
+		var saved []*Site
+		if err := readJSONFile(path, &saved); err != nil {
+			return nil, fmt.Errorf("failed to load sites: %v", err)
+		}
+		for _, site := range saved {
+			reg.sites[site.Name] = site
+		}
+	}
+	// Configured sites win over a saved one of the same name
+	for name, root := range sites {
+		reg.sites[name] = &Site{Name: name, ManifestRoot: root, UpdatedAt: time.Now()}
+	}
+	for host, name := range hosts {
+		reg.hosts[strings.ToLower(host)] = name
+	}
+	return reg, nil
+}
+
+func (r *SiteRegistry) Get(name string) (Site, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	site, ok := r.sites[name]
+	if !ok {
+		return Site{}, false
+	}
+	return *site, true
+}
+
+// errSiteNotOwned refuses changes to a site the tenant does not own,
+// including the ownerless sites configured at startup.
+var errSiteNotOwned = newAPIError(http.StatusForbidden, "Site is owned by another tenant or configured on the server")
+
+// Set points name at a manifest. The first tenant to claim a name owns it
+// and only that tenant may update it afterwards.
+func (r *SiteRegistry) Set(name, manifestRoot, tenant string) (Site, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	site, ok := r.sites[name]
+	if ok && site.Owner != tenant {
+		return *site, errSiteNotOwned
+	}
+	if !ok {
+		site = &Site{Name: name, Owner: tenant}
+		r.sites[name] = site
+	}
+	site.ManifestRoot = manifestRoot
+	site.UpdatedAt = time.Now()
+	return *site, r.saveLocked()
+}
+
+// CanSet reports whether tenant may point name at a manifest.
+func (r *SiteRegistry) CanSet(name, tenant string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	site, ok := r.sites[name]
+	return !ok || site.Owner == tenant
+}
+
+// saveLocked persists the sites created through the API.
+func (r *SiteRegistry) saveLocked() error {
+	if r.path == "" {
+		return nil
+	}
+	sites := make([]*Site, 0, len(r.sites))
+	for _, site := range r.sites {
+		if site.Owner != "" {
+			sites = append(sites, site)
+		}
+	}
+	if err := writeJSONFile(r.path, sites); err != nil {
+		return fmt.Errorf("failed to write sites: %v", err)
+	}
+	return nil
+}
+
+// List returns the sites tenant owns and the ones configured on the server.
+func (r *SiteRegistry) List(tenant string) []Site {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sites := make([]Site, 0, len(r.sites))
+	for _, site := range r.sites {
+		if site.Owner == "" || site.Owner == tenant {
+			sites = append(sites, *site)
+		}
+	}
+	sort.Slice(sites, func(i, j int) bool { return sites[i].Name < sites[j].Name })
+	return sites
+}
+
+// SiteForHost returns the site name bound to a request Host header.
+func (r *SiteRegistry) SiteForHost(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	name, ok := r.hosts[strings.ToLower(host)]
+	return name, ok
+}
+
+func (s *Server) serveSite(c *gin.Context, name, requestPath string) {
+	site, ok := s.sites.Get(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found"})
+		return
+	}
+	if ownerOffDomain(c, site.Owner) {
+		return
+	}
+
+	// A site only changes when it is repointed
+	lastModified := site.UpdatedAt.UTC().Truncate(time.Second)
+	c.Header("Last-Modified", lastModified.Format(http.TimeFormat))
+	if c.GetHeader("If-None-Match") == "" {
+		if since, err := http.ParseTime(c.GetHeader("If-Modified-Since")); err == nil && !lastModified.After(since) {
+			c.Header("Cache-Control", siteCacheControl)
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+	if s.shield != nil {
+		s.shield.tagSite(c, name)
+	}
+
+	m, raw, err := s.loadManifest(site.ManifestRoot)
+	if err == errNotManifest {
+		raw.Release()
+		noStore(c)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Site does not point at a directory manifest"})
+		return
+	}
+	if err != nil {
+		noStore(c)
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.serveManifestPath(c, m, requestPath, siteCacheControl)
+}
+
+// siteHostRouter serves whole requests from a site when the Host header is
+// bound to one via SITE_HOSTS, so sites can be hosted on their own domains.
+func (s *Server) siteHostRouter(c *gin.Context) {
+	name, ok := s.sites.SiteForHost(c.Request.Host)
+	if !ok {
+		c.Next()
+		return
+	}
+	if s.shield != nil && !s.shield.Allowed(c.Request) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Requests must come through the CDN"})
+		return
+	}
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		c.AbortWithStatus(http.StatusMethodNotAllowed)
+		return
+	}
+	s.serveSite(c, name, c.Request.URL.Path)
+	c.Abort()
+}
+
+// handleSite serves /sites/{name}/{path} from the manifest the site points to.
+func (s *Server) handleSite(c *gin.Context) {
+	s.serveSite(c, c.Param("name"), c.Param("path"))
+}
+
+// @Summary List hosted sites
+// @Description Lists the caller's sites and those configured on the server
+// @Produce json
+// @Param fields query string false "Comma-separated fields to return"
+// @Param envelope query bool false "Wrap the response in {data, count}"
+// @Success 200 {array} Site
+// @Security ApiKeyAuth
+// @Router /sites [get]
+func (s *Server) handleListSites(c *gin.Context) {
+	respondSelected(c, http.StatusOK, s.sites.List(tenantFrom(c)))
+}
+
+// @Summary Publish a site
+// @Description Points a site name at a directory manifest, given by root hash or public ID, that the caller uploaded or references. The first tenant to claim a name owns it; sites configured on the server cannot be changed.
+// @Accept json
+// @Produce json
+// @Param name path string true "Site name"
+// @Param request body SetSiteRequest true "Manifest to publish"
+// @Success 200 {object} Site
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /sites/{name} [put]
+func (s *Server) handleSetSite(c *gin.Context) {
+	var req SetSiteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tenant := tenantFrom(c)
+	manifestRoot := strings.ToLower(s.resolveRef(req.ManifestRoot))
+	if !isRootHash(manifestRoot) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "manifest_root must be a root hash or public ID"})
+		return
+	}
+	// As with a publish base, a site may only serve a manifest the tenant
+	// could read anyway
+	if !s.catalog.HasReference(tenant, manifestRoot) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Manifest not found"})
+		return
+	}
+
+	site, err := s.sites.Set(c.Param("name"), manifestRoot, tenant)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	s.purgeSite(site.Name)
+	c.JSON(http.StatusOK, site)
+}
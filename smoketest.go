@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// smokeTest runs the verify command's checks against one gateway. Each
+// check prints a PASS or FAIL line; later checks that depend on a failed
+// one are skipped.
+type smokeTest struct {
+	base   string
+	apiKey string
+	client *http.Client
+	failed int
+}
+
+// runVerifyCommand checks a live gateway end to end, the way a client
+// would use it: it uploads a tiny random file with a share link, reads the
+// file's status until it is finalized, downloads it and compares hashes,
+// and follows the share link. It fails when any check did.
+func runVerifyCommand(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	apiKey := fs.String("api-key", os.Getenv("API_KEY"), "API key to upload with (default $API_KEY)")
+	timeout := fs.Duration("timeout", 5*time.Minute, "how long the upload may take to be finalized")
+	size := fs.Int("size", 1024, "size in bytes of the test file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: verify [-api-key key] [-timeout 5m] [-size 1024] <gateway url>")
+	}
+	base := strings.TrimSuffix(fs.Arg(0), "/")
+	if u, err := url.Parse(base); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid gateway URL %q", fs.Arg(0))
+	}
+
+	t := &smokeTest{
+		base:   base,
+		apiKey: *apiKey,
+		// Redirects are checked by hand, to see where share links lead
+		client: &http.Client{
+			Timeout: *timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	fmt.Printf("verifying %s\n", base)
+	t.run(*size, *timeout)
+	if t.failed > 0 {
+		return fmt.Errorf("%d check(s) failed", t.failed)
+	}
+	fmt.Println("all checks passed")
+	return nil
+}
+
+func (t *smokeTest) run(size int, timeout time.Duration) {
+	var version VersionInfo
+	t.check("version", func() (string, error) {
+		if err := t.getJSON("/api/v1/version", &version); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s (%s)", version.Version, version.Mode), nil
+	})
+
+	data := make([]byte, size)
+	rand.Read(data)
+	sum := sha256.Sum256(data)
+	want := hex.EncodeToString(sum[:])
+
+	var upload UploadResponse
+	if !t.check("upload", func() (string, error) {
+		if err := t.upload(data, &upload); err != nil {
+			return "", err
+		}
+		if upload.Quarantine != nil {
+			return "", fmt.Errorf("the upload policy quarantined the test file")
+		}
+		if upload.RootHash == "" {
+			return "", fmt.Errorf("no root hash in the response")
+		}
+		return fmt.Sprintf("%d bytes as %s (tx %s)", size, upload.RootHash, upload.TxHash), nil
+	}) {
+		return
+	}
+
+	if !t.check("info", func() (string, error) {
+		deadline := time.Now().Add(timeout)
+		for {
+			var status FileStatus
+			if err := t.getJSON("/api/v1/files/"+url.PathEscape(upload.RootHash)+"/status", &status); err != nil {
+				return "", err
+			}
+			if status.Finalized {
+				if status.Size != 0 && status.Size != uint64(size) {
+					return "", fmt.Errorf("size is %d, want %d", status.Size, size)
+				}
+				return fmt.Sprintf("finalized, tx seq %d", status.TxSeq), nil
+			}
+			if time.Now().After(deadline) {
+				return "", fmt.Errorf("not finalized after %v", timeout)
+			}
+			time.Sleep(5 * time.Second)
+		}
+	}) {
+		return
+	}
+
+	t.check("download", func() (string, error) {
+		body, _, err := t.get("/api/v1/download/" + url.PathEscape(upload.RootHash))
+		if err != nil {
+			return "", err
+		}
+		return compareHash(body, want)
+	})
+
+	t.check("share link", func() (string, error) {
+		if upload.Share == nil {
+			return "", fmt.Errorf("the upload returned no share link")
+		}
+		body, location, err := t.get(upload.Share.ShortURL)
+		if err != nil {
+			return "", err
+		}
+		if location == "" {
+			return "", fmt.Errorf("%s did not redirect", upload.Share.ShortURL)
+		}
+		for hops := 0; location != ""; hops++ {
+			if hops == 5 {
+				return "", fmt.Errorf("too many redirects from %s", upload.Share.ShortURL)
+			}
+			if body, location, err = t.get(location); err != nil {
+				return "", err
+			}
+		}
+		return compareHash(body, want)
+	})
+}
+
+// check runs one named check and prints its result, reporting whether it
+// passed.
+func (t *smokeTest) check(name string, fn func() (string, error)) bool {
+	start := time.Now()
+	detail, err := fn()
+	took := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		t.failed++
+		fmt.Printf("FAIL  %-10s %v (%v)\n", name, err, took)
+		return false
+	}
+	fmt.Printf("PASS  %-10s %s (%v)\n", name, detail, took)
+	return true
+}
+
+func compareHash(body []byte, want string) (string, error) {
+	sum := sha256.Sum256(body)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return "", fmt.Errorf("sha256 %s does not match the uploaded %s", got, want)
+	}
+	return "sha256 " + want + " matches", nil
+}
+
+func (t *smokeTest) upload(data []byte, out *UploadResponse) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "verify.bin")
+	if err != nil {
+		return err
+	}
+	part.Write(data)
+	form.Close()
+
+	req, err := http.NewRequest(http.MethodPost, t.base+"/api/v1/upload?share_ttl=1h", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	raw, _, err := t.do(req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, out)
+}
+
+func (t *smokeTest) getJSON(path string, out interface{}) error {
+	raw, _, err := t.get(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// get fetches path, relative to the gateway unless it is a full URL. A
+// redirect is not followed; its location is returned instead.
+func (t *smokeTest) get(path string) ([]byte, string, error) {
+	if !strings.Contains(path, "://") {
+		path = t.base + path
+	}
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return t.do(req)
+}
+
+func (t *smokeTest) do(req *http.Request) ([]byte, string, error) {
+	if t.apiKey != "" {
+		req.Header.Set("X-API-Key", t.apiKey)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read response: %v", err)
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		location, err := resp.Location()
+		if err != nil {
+			return nil, "", fmt.Errorf("%s answered %d without a location", req.URL.Path, resp.StatusCode)
+		}
+		return nil, location.String(), nil
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, "", fmt.Errorf("%s answered %d: %s", req.URL.Path, resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+	return raw, "", nil
+}
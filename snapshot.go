@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const catalogSnapshotFormat = "0g-starter-catalog/1"
+
+// CatalogSnapshotFile is the document uploaded to 0G: the whole catalog, so a
+// fresh deployment can rebuild its index from the network.
+type CatalogSnapshotFile struct {
+	Format    string         `json:"format"`
+	CreatedAt time.Time      `json:"created_at"`
+	Objects   []StoredObject `json:"objects"`
+	Refs      []FileRecord   `json:"refs"`
+}
+
+// CatalogSnapshot records a snapshot published to 0G.
+type CatalogSnapshot struct {
+	RootHash  string    `json:"root_hash"`
+	TxHash    string    `json:"tx_hash"`
+	Objects   int       `json:"objects"`
+	Refs      int       `json:"refs"`
+	Bytes     int       `json:"bytes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SnapshotStore remembers the snapshots published from this deployment,
+// newest last.
+type SnapshotStore struct {
+	mu        sync.Mutex
+	path      string
+	snapshots []CatalogSnapshot
+}
+
+func NewSnapshotStore(path string) (*SnapshotStore, error) {
+	store := &SnapshotStore{path: path}
+	if path == "" {
+		return store, nil
+	}
+	if err := readJSONFile(path, &store.snapshots); err != nil {
+		return nil, fmt.Errorf("failed to load catalog snapshots: %v", err)
+	}
+	return store, nil
+}
+
+func (s *SnapshotStore) Add(snap CatalogSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshots = append(s.snapshots, snap)
+	if s.path == "" {
+		return nil
+	}
+	if err := writeJSONFile(s.path, s.snapshots); err != nil {
+		return fmt.Errorf("failed to write catalog snapshots: %v", err)
+	}
+	return nil
+}
+
+// List returns the recorded snapshots, newest first.
+func (s *SnapshotStore) List() []CatalogSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]CatalogSnapshot, 0, len(s.snapshots))
+	for i := len(s.snapshots) - 1; i >= 0; i-- {
+		list = append(list, s.snapshots[i])
+	}
+	return list
+}
+
+// publishCatalogSnapshot serializes the catalog, uploads it to 0G and records
+// its root hash.
+func publishCatalogSnapshot(client *StorageClient, catalog *Catalog, store *SnapshotStore) (CatalogSnapshot, error) {
+	objects, refs := catalog.Export()
+	file := CatalogSnapshotFile{
+		Format:    catalogSnapshotFormat,
+		CreatedAt: time.Now(),
+		Objects:   objects,
+		Refs:      refs,
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		return CatalogSnapshot{}, fmt.Errorf("failed to encode catalog snapshot: %v", err)
+	}
+
+	txHash, rootHash, err := client.UploadData(data)
+	if err != nil {
+		return CatalogSnapshot{}, fmt.Errorf("failed to upload catalog snapshot: %v", err)
+	}
+	snap := CatalogSnapshot{
+		RootHash:  rootHash,
+		TxHash:    txHash,
+		Objects:   len(objects),
+		Refs:      len(refs),
+		Bytes:     len(data),
+		CreatedAt: file.CreatedAt,
+	}
+	log.Printf("📚 Published catalog snapshot %s (%d objects, %d references)", rootHash, snap.Objects, snap.Refs)
+	if err := store.Add(snap); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	return snap, nil
+}
+
+// bootstrapCatalog fills an empty catalog from a snapshot on 0G.
+func bootstrapCatalog(client *StorageClient, spool *Spool, catalog *Catalog, rootHash string) error {
+	tempFile := spool.Path("snapshot")
+	defer spool.Release(tempFile)
+	if err := client.DownloadFile(rootHash, tempFile); err != nil {
+		return fmt.Errorf("failed to download catalog snapshot: %v", err)
+	}
+	data, err := os.ReadFile(tempFile)
+	if err != nil {
+		return err
+	}
+
+	var file CatalogSnapshotFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse catalog snapshot: %v", err)
+	}
+	if file.Format != catalogSnapshotFormat {
+		return fmt.Errorf("%s is not a catalog snapshot (format %q)", rootHash, file.Format)
+	}
+	if err := catalog.Import(file.Objects, file.Refs); err != nil {
+		return fmt.Errorf("failed to import catalog snapshot: %v", err)
+	}
+	log.Printf("📚 Bootstrapped catalog from snapshot %s taken %s (%d objects, %d references)",
+		rootHash, file.CreatedAt.Format(time.RFC3339), len(file.Objects), len(file.Refs))
+	return nil
+}
+
+// runSnapshotCommand implements "catalog-snapshot": publish a snapshot of the
+// persisted catalog and print its root hash, without starting the server.
+func runSnapshotCommand(cfg *Config, client *StorageClient) error {
+	if cfg.CatalogPath == "" {
+		return fmt.Errorf("no persisted catalog to snapshot (set CATALOG_PATH or DATA_DIR)")
+	}
+	catalog, err := NewCatalog(cfg.CatalogPath)
+	if err != nil {
+		return err
+	}
+	store, err := NewSnapshotStore(cfg.DataPath("snapshots.json"))
+	if err != nil {
+		return err
+	}
+	snap, err := publishCatalogSnapshot(client, catalog, store)
+	if err != nil {
+		return err
+	}
+	fmt.Println(snap.RootHash)
+	return nil
+}
+
+// @Summary Publish a catalog snapshot
+// @Description Serializes the whole catalog, uploads it to 0G and records its root hash. Start a fresh deployment with CATALOG_BOOTSTRAP_ROOT set to that hash to rebuild its catalog from the network.
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Success 201 {object} CatalogSnapshot
+// @Router /admin/catalog/snapshots [post]
+func (s *Server) handlePublishSnapshot(c *gin.Context) {
+	snap, err := publishCatalogSnapshot(s.client, s.catalog, s.snapshots)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, snap)
+}
+
+// @Summary List catalog snapshots
+// @Description Snapshots published from this deployment, newest first
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Success 200 {array} CatalogSnapshot
+// @Router /admin/catalog/snapshots [get]
+func (s *Server) handleListSnapshots(c *gin.Context) {
+	c.JSON(http.StatusOK, s.snapshots.List())
+}
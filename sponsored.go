@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SponsoredUploads lets anyone upload small files without an API key, paid
+// for from the gateway's wallet. Strict rules keep a public route from
+// draining it: a size cap, a set of allowed content types, an hourly limit
+// per client IP and a budget of 0G that sponsored uploads may spend per UTC
+// day. The day's spending is persisted to path, when set.
+type SponsoredUploads struct {
+	tenant   string
+	maxBytes int64
+	types    []string
+	limiter  *KeyRateLimiter
+	perHour  int
+	budget   *big.Int
+
+	mu    sync.Mutex
+	path  string
+	day   string
+	spent *big.Int
+}
+
+type sponsoredSnapshot struct {
+	Day      string `json:"day"`
+	SpentWei string `json:"spent_wei"`
+}
+
+// NewSponsoredUploads returns nil unless SPONSORED_UPLOADS is on.
+func NewSponsoredUploads(cfg *Config, path string) (*SponsoredUploads, error) {
+	if !cfg.SponsoredUploads {
+		return nil, nil
+	}
+	budget, err := parseTokens(cfg.SponsoredDailyBudget)
+	if err != nil {
+		return nil, fmt.Errorf("SPONSORED_DAILY_BUDGET: %v", err)
+	}
+	if cfg.SponsoredMaxBytes <= 0 {
+		return nil, fmt.Errorf("SPONSORED_MAX_BYTES must be positive")
+	}
+	sp := &SponsoredUploads{
+		tenant:   cfg.SponsoredTenant,
+		maxBytes: cfg.SponsoredMaxBytes,
+		types:    cfg.SponsoredTypes,
+		limiter:  NewHourlyRateLimiter(cfg.SponsoredRateLimit),
+		perHour:  cfg.SponsoredRateLimit,
+		budget:   budget,
+		path:     path,
+		spent:    new(big.Int),
+	}
+	if path == "" {
+		return sp, nil
+	}
+	var snap sponsoredSnapshot
+	if err := readJSONFile(path, &snap); err != nil {
+		return nil, fmt.Errorf("failed to load sponsored spending: %v", err)
+	}
+	if spent, ok := new(big.Int).SetString(snap.SpentWei, 10); ok {
+		sp.day, sp.spent = snap.Day, spent
+	}
+	return sp, nil
+}
+
+// Allows reports whether files of contentType may be sponsored.
+func (sp *SponsoredUploads) Allows(contentType string) bool {
+	for _, pattern := range sp.types {
+		if matchMIME(pattern, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// rollLocked starts a new day's budget once the day has changed.
+func (sp *SponsoredUploads) rollLocked() {
+	if today := utcDay(time.Now()); sp.day != today {
+		sp.day = today
+		sp.spent = new(big.Int)
+	}
+}
+
+// Reserve counts cost against today's budget, or fails with 429 until the
+// budget resets if that would exceed it. Uploads that end up costing
+// nothing give the reservation back with Release.
+func (sp *SponsoredUploads) Reserve(cost *big.Int) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.rollLocked()
+	if new(big.Int).Add(sp.spent, cost).Cmp(sp.budget) > 0 {
+		return &apiError{
+			Status:     http.StatusTooManyRequests,
+			Message:    "Today's budget for sponsored uploads is spent; try again tomorrow or upload with an API key",
+			RetryAfter: untilTomorrow(time.Now()),
+		}
+	}
+	sp.spent.Add(sp.spent, cost)
+	sp.saveLocked()
+	return nil
+}
+
+// Release gives back cost reserved today.
+func (sp *SponsoredUploads) Release(cost *big.Int) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.rollLocked()
+	if sp.spent.Sub(sp.spent, cost); sp.spent.Sign() < 0 {
+		sp.spent.SetInt64(0)
+	}
+	sp.saveLocked()
+}
+
+// Remaining is what is left of today's budget.
+func (sp *SponsoredUploads) Remaining() *big.Int {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.rollLocked()
+	left := new(big.Int).Sub(sp.budget, sp.spent)
+	if left.Sign() < 0 {
+		left.SetInt64(0)
+	}
+	return left
+}
+
+// saveLocked persists the day's spending. A failure is only logged: the
+// count in memory stays right until the next restart.
+func (sp *SponsoredUploads) saveLocked() {
+	if sp.path == "" {
+		return
+	}
+	if err := writeJSONFile(sp.path, sponsoredSnapshot{Day: sp.day, SpentWei: sp.spent.String()}); err != nil {
+		log.Printf("⚠️  Failed to write sponsored spending: %v", err)
+	}
+}
+
+// sponsorRequest makes the request the sponsored tenant's, so it is metered
+// and audited like any other.
+func (s *Server) sponsorRequest(c *gin.Context) {
+	c.Set(tenantContextKey, s.sponsored.tenant)
+	c.Next()
+}
+
+// SponsorshipStatus describes the rules sponsored uploads follow.
+type SponsorshipStatus struct {
+	MaxBytes int64    `json:"max_bytes"`
+	Types    []string `json:"types"`
+	// PerHour is how many uploads one client IP may make an hour; 0 is
+	// unlimited
+	PerHour            int    `json:"per_hour"`
+	DailyBudgetWei     string `json:"daily_budget_wei"`
+	DailyBudget        string `json:"daily_budget"`
+	RemainingBudgetWei string `json:"remaining_budget_wei"`
+	RemainingBudget    string `json:"remaining_budget"`
+	// ResetsAt is when the daily budget starts over
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+func (sp *SponsoredUploads) Status() SponsorshipStatus {
+	left := sp.Remaining()
+	now := time.Now()
+	return SponsorshipStatus{
+		MaxBytes:           sp.maxBytes,
+		Types:              sp.types,
+		PerHour:            sp.perHour,
+		DailyBudgetWei:     sp.budget.String(),
+		DailyBudget:        formatTokens(sp.budget),
+		RemainingBudgetWei: left.String(),
+		RemainingBudget:    formatTokens(left),
+		ResetsAt:           now.Add(untilTomorrow(now)).UTC().Truncate(time.Second),
+	}
+}
+
+type SponsoredUploadResponse struct {
+	UploadResponse
+	// CostWei is what the upload was estimated to cost the sponsor; it is
+	// 0 for content that was already stored
+	CostWei string `json:"cost_wei"`
+	Cost    string `json:"cost"`
+}
+
+// @Summary Sponsored upload rules
+// @Description Whether this gateway offers free uploads paid for from its wallet, and under which rules: the largest file, the allowed content types, uploads per hour per client IP, and the daily budget with what is left of it today. It needs no API key.
+// @Produce json
+// @Success 200 {object} SponsorshipStatus
+// @Router /sponsored [get]
+func (s *Server) handleSponsorship(c *gin.Context) {
+	c.JSON(http.StatusOK, s.sponsored.Status())
+}
+
+// @Summary Upload a file for free
+// @Description Community upload route that needs no API key: the gateway pays for the upload from its own wallet. Files over the sponsored size cap get 413 and content types outside the allowed ones 415. Each client IP may upload a limited number of files an hour, and all sponsored uploads share a daily budget of 0G; either being used up answers 429 with Retry-After. Content that is already stored costs nothing and does not count against the budget. Files are owned by the sponsored tenant (SPONSORED_TENANT).
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "File to upload"
+// @Success 200 {object} SponsoredUploadResponse
+// @Failure 413 {object} map[string]string
+// @Failure 415 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /sponsored/upload [post]
+func (s *Server) handleSponsoredUpload(c *gin.Context) {
+	sp := s.sponsored
+	if sp.limiter != nil {
+		st := sp.limiter.Take("ip:" + c.ClientIP())
+		c.Header("X-RateLimit-Limit", strconv.Itoa(st.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(st.Remaining))
+		if st.Wait > 0 {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(st.Wait)))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": fmt.Sprintf("Sponsored uploads are limited to %d an hour", sp.perHour)})
+			return
+		}
+	}
+	if c.Request.ContentLength > sp.maxBytes {
+		respondError(c, uploadTooLarge(sp.maxBytes))
+		return
+	}
+
+	part, _, ok := filePart(c)
+	if !ok {
+		return
+	}
+	defer part.Close()
+	timer := s.stages.NewTimer(c.Request.Context())
+	start := time.Now()
+	body := newUploadLimitReader(part, sp.maxBytes)
+	staged, err := s.spool.Stage(body, "sponsored-*")
+	if err != nil {
+		if body.Exceeded() {
+			err = uploadTooLarge(sp.maxBytes)
+		}
+		respondError(c, err)
+		return
+	}
+	defer staged.Release()
+	timer.Since(StageSpool, start)
+	size := staged.Size()
+
+	contentType, err := sniffContentType(staged.Path)
+	if err != nil {
+		respondError(c, fmt.Errorf("failed to inspect upload: %v", err))
+		return
+	}
+	if !sp.Allows(contentType) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": fmt.Sprintf("Sponsored uploads take %s files, not %s", strings.Join(sp.types, ", "), contentType)})
+		return
+	}
+
+	// Without a price nothing stops the budget being overspent
+	est, err := s.client.EstimateFee(c.Request.Context(), size)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Sponsored uploads are paused while the upload cost cannot be estimated: " + err.Error()})
+		return
+	}
+	cost, _ := new(big.Int).SetString(est.TotalWei, 10)
+	if err := sp.Reserve(cost); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	resp, err := s.storeUpload(uploadRequest{
+		Tenant:   sp.tenant,
+		Class:    requestClassFrom(c),
+		Timer:    timer,
+		Path:     staged.Path,
+		Filename: part.FileName(),
+		Size:     size,
+		Origin:   requestOriginFrom(c),
+		Log:      requestLogger(c),
+	})
+	if err != nil || resp.Deduplicated || resp.Quarantine != nil {
+		sp.Release(cost)
+		cost = new(big.Int)
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	resp.Stages = timer.Millis()
+	log.Printf("🎁 Sponsored upload %s (%d bytes) cost %s 0G", resp.RootHash, size, formatTokens(cost))
+	c.JSON(uploadStatus(resp), SponsoredUploadResponse{
+		UploadResponse: resp,
+		CostWei:        cost.String(),
+		Cost:           formatTokens(cost),
+	})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/0glabs/0g-storage-client/core"
+)
+
+// Files younger than this are never treated as orphans.
+const orphanGracePeriod = time.Hour
+
+type SpoolStats struct {
+	Dir      string `json:"dir"`
+	Files    int    `json:"files"`
+	Bytes    int64  `json:"bytes"`
+	Active   int    `json:"active"`
+	Orphaned int    `json:"orphaned"`
+
+	RemoteBucket string `json:"remote_bucket,omitempty"`
+	RemoteActive int    `json:"remote_active,omitempty"`
+}
+
+// Spool is the local staging area for files moving to and from 0G. It tracks
+// which files are in use so leftovers from crashed or abandoned requests can
+// be told apart and collected.
+type Spool struct {
+	dir string
+
+	// Incoming bodies are staged in an S3 bucket instead when remote is set;
+	// only what the uploader currently works on is kept on local disk.
+	remote       *S3Client
+	remotePrefix string
+
+	mu           sync.Mutex
+	active       map[string]bool
+	remoteActive map[string]bool
+}
+
+// defaultSpoolDir is where the spool lives unless SPOOL_DIR says otherwise.
+// It is the only place the gateway touches the system temp directory.
+func defaultSpoolDir() string {
+	return filepath.Join(os.TempDir(), "0g-spool")
+}
+
+func NewSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %v", err)
+	}
+	return &Spool{dir: dir, active: make(map[string]bool), remoteActive: make(map[string]bool)}, nil
+}
+
+// UseRemote stages incoming bodies in an S3 bucket under prefix.
+func (s *Spool) UseRemote(client *S3Client, prefix string) {
+	s.remote = client
+	s.remotePrefix = prefix
+}
+
+// Remote reports whether incoming bodies are staged in S3.
+func (s *Spool) Remote() bool {
+	return s.remote != nil
+}
+
+// StageRemote streams r into the S3 staging bucket and returns the object key
+// and size. Call DiscardRemote with the key when done.
+func (s *Spool) StageRemote(ctx context.Context, r io.Reader, prefix string) (string, int64, error) {
+	suffix, err := randomHex(8)
+	if err != nil {
+		return "", 0, err
+	}
+	key := fmt.Sprintf("%s%s-%d-%s", s.remotePrefix, prefix, time.Now().UnixNano(), suffix)
+	s.mu.Lock()
+	s.remoteActive[key] = true
+	s.mu.Unlock()
+
+	size, err := s.remote.PutObject(ctx, key, r)
+	if err != nil {
+		s.DiscardRemote(key)
+		return "", 0, fmt.Errorf("failed to stage upload: %v", err)
+	}
+	return key, size, nil
+}
+
+// RemoteData reads a staged object of size bytes in place, for hashing and
+// uploading it without a local copy.
+func (s *Spool) RemoteData(key string, size int64) core.IterableData {
+	return newRemoteData(s.remote, key, size)
+}
+
+// FetchRemote copies a staged object into a local spool file, for the steps
+// that need one. Call Release with the returned path when done.
+func (s *Spool) FetchRemote(ctx context.Context, key string) (string, error) {
+	body, err := s.remote.GetObject(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch staged upload: %v", err)
+	}
+	defer body.Close()
+
+	f, err := s.Create("remote-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		s.Release(f.Name())
+		return "", fmt.Errorf("failed to fetch staged upload: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		s.Release(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// DiscardRemote deletes a staged object and stops tracking it.
+func (s *Spool) DiscardRemote(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := s.remote.DeleteObject(ctx, key); err != nil {
+		// The bucket's own lifecycle policy is the backstop for leftovers
+		log.Printf("⚠️  Failed to delete staged object %s: %v", key, err)
+	}
+	s.mu.Lock()
+	delete(s.remoteActive, key)
+	s.mu.Unlock()
+}
+
+// Create opens a new spool file. Call Release with its name when done.
+func (s *Spool) Create(pattern string) (*os.File, error) {
+	f, err := os.CreateTemp(s.dir, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %v", err)
+	}
+	s.mu.Lock()
+	s.active[f.Name()] = true
+	s.mu.Unlock()
+	return f, nil
+}
+
+// Path reserves a spool path without creating the file, for APIs that insist
+// on creating their output themselves.
+func (s *Spool) Path(prefix string) string {
+	path := filepath.Join(s.dir, fmt.Sprintf("%s-%d", prefix, time.Now().UnixNano()))
+	s.mu.Lock()
+	s.active[path] = true
+	s.mu.Unlock()
+	return path
+}
+
+// Release deletes a spool file and stops tracking it.
+func (s *Spool) Release(path string) {
+	os.Remove(path)
+	s.mu.Lock()
+	delete(s.active, path)
+	s.mu.Unlock()
+}
+
+func (s *Spool) isOrphan(path string, info os.FileInfo) bool {
+	return !s.active[path] && time.Since(info.ModTime()) > orphanGracePeriod
+}
+
+func (s *Spool) Stats() SpoolStats {
+	stats := SpoolStats{Dir: s.dir}
+	files, _ := os.ReadDir(s.dir)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats.Active = len(s.active)
+	if s.remote != nil {
+		stats.RemoteBucket = s.remote.bucket
+		stats.RemoteActive = len(s.remoteActive)
+	}
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil || info.IsDir() {
+			continue
+		}
+		stats.Files++
+		stats.Bytes += info.Size()
+		if s.isOrphan(filepath.Join(s.dir, f.Name()), info) {
+			stats.Orphaned++
+		}
+	}
+	return stats
+}
+
+// Sweep deletes orphaned spool files and returns how many bytes were freed.
+func (s *Spool) Sweep() (removed int, freed int64) {
+	files, _ := os.ReadDir(s.dir)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil || info.IsDir() {
+			continue
+		}
+		path := filepath.Join(s.dir, f.Name())
+		if s.isOrphan(path, info) {
+			if os.Remove(path) == nil {
+				removed++
+				freed += info.Size()
+			}
+		}
+	}
+	return removed, freed
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// UploadStage is one step of the upload pipeline.
+type UploadStage string
+
+const (
+	// StageSpool is receiving the body into the spool
+	StageSpool UploadStage = "spool"
+	// StageTransform is running the tenant's upload transforms
+	StageTransform UploadStage = "transform"
+	// StageHash is computing the Merkle root
+	StageHash UploadStage = "hash"
+	// StageNodeSelect is asking the indexer for storage nodes
+	StageNodeSelect UploadStage = "node_select"
+	// StageSubmit is the SDK's upload call: submitting the transaction,
+	// waiting for it to be confirmed, uploading segments and waiting for the
+	// file to be finalized happen inside it and cannot be told apart
+	StageSubmit UploadStage = "submit"
+	// StageFinalize is recording the upload in the catalog and notifying
+	StageFinalize UploadStage = "finalize"
+)
+
+var uploadStages = []UploadStage{StageSpool, StageTransform, StageHash, StageNodeSelect, StageSubmit, StageFinalize}
+
+// StageTimer collects the stage durations of one upload, or of all uploads
+// of a job, and feeds each into the aggregate metrics as it is recorded.
+// When the request is traced, each stage is also a span under the
+// request's. A nil timer records nothing.
+type StageTimer struct {
+	metrics *StageMetrics
+	span    *Span
+
+	mu     sync.Mutex
+	stages map[UploadStage]time.Duration
+	open   map[UploadStage]*Span
+}
+
+// NewTimer times the stages of the upload whose request ctx belongs to.
+func (m *StageMetrics) NewTimer(ctx context.Context) *StageTimer {
+	return &StageTimer{metrics: m, span: spanFrom(ctx), stages: make(map[UploadStage]time.Duration)}
+}
+
+// Span is the span stages are traced under, or nil.
+func (t *StageTimer) Span() *Span {
+	if t == nil {
+		return nil
+	}
+	return t.span
+}
+
+// Begin starts stage's span now, for work inside the stage to be traced
+// under; Since ends it. A stage that fails ends its span itself.
+func (t *StageTimer) Begin(stage UploadStage) *Span {
+	span := t.Span().Start("upload." + string(stage))
+	if span == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.open == nil {
+		t.open = make(map[UploadStage]*Span)
+	}
+	t.open[stage] = span
+	return span
+}
+
+// Since records that stage ran from start until now.
+func (t *StageTimer) Since(stage UploadStage, start time.Time) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	elapsed := now.Sub(start)
+	t.mu.Lock()
+	t.stages[stage] += elapsed
+	span := t.open[stage]
+	delete(t.open, stage)
+	t.mu.Unlock()
+	if t.metrics != nil {
+		t.metrics.observe(stage, elapsed)
+	}
+	if span != nil {
+		span.EndAt(now, nil)
+	} else {
+		t.span.Record("upload."+string(stage), start, now, nil)
+	}
+}
+
+// Millis returns the recorded stages in milliseconds.
+func (t *StageTimer) Millis() map[string]float64 {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.stages) == 0 {
+		return nil
+	}
+	ms := make(map[string]float64, len(t.stages))
+	for stage, d := range t.stages {
+		ms[string(stage)] = float64(d.Microseconds()) / 1000
+	}
+	return ms
+}
+
+type stageStats struct {
+	count        uint64
+	totalSeconds float64
+	buckets      []uint64 // per bucket, not cumulative; the last one is +Inf
+
+	samples []float64 // ring of recent durations in seconds
+	next    int
+}
+
+type StageSummary struct {
+	Stage  string  `json:"stage"`
+	Count  uint64  `json:"count"`
+	MeanMs float64 `json:"mean_ms"`
+	P50Ms  float64 `json:"p50_ms"`
+	P90Ms  float64 `json:"p90_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+}
+
+// StageMetrics aggregates upload stage durations across all uploads, using
+// the same buckets and sample window as the route metrics.
+type StageMetrics struct {
+	mu     sync.Mutex
+	stages map[UploadStage]*stageStats
+}
+
+func NewStageMetrics() *StageMetrics {
+	return &StageMetrics{stages: make(map[UploadStage]*stageStats)}
+}
+
+func (m *StageMetrics) observe(stage UploadStage, elapsed time.Duration) {
+	seconds := elapsed.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.stages[stage]
+	if !ok {
+		st = &stageStats{buckets: make([]uint64, len(latencyBuckets)+1)}
+		m.stages[stage] = st
+	}
+	st.count++
+	st.totalSeconds += seconds
+	st.buckets[sort.SearchFloat64s(latencyBuckets, seconds)]++
+	if len(st.samples) < latencySamples {
+		st.samples = append(st.samples, seconds)
+	} else {
+		st.samples[st.next] = seconds
+		st.next = (st.next + 1) % latencySamples
+	}
+}
+
+// Summary lists the stages that ran, in pipeline order.
+func (m *StageMetrics) Summary() []StageSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	summary := []StageSummary{}
+	for _, stage := range uploadStages {
+		st, ok := m.stages[stage]
+		if !ok {
+			continue
+		}
+		sorted := append([]float64(nil), st.samples...)
+		sort.Float64s(sorted)
+		summary = append(summary, StageSummary{
+			Stage:  string(stage),
+			Count:  st.count,
+			MeanMs: st.totalSeconds / float64(st.count) * 1000,
+			P50Ms:  percentile(sorted, 0.50) * 1000,
+			P90Ms:  percentile(sorted, 0.90) * 1000,
+			P99Ms:  percentile(sorted, 0.99) * 1000,
+		})
+	}
+	return summary
+}
+
+// WritePrometheus writes the stage histograms in the Prometheus text
+// exposition format.
+func (m *StageMetrics) WritePrometheus(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP upload_stage_duration_seconds Time spent in each upload pipeline stage.")
+	fmt.Fprintln(w, "# TYPE upload_stage_duration_seconds histogram")
+	for _, stage := range uploadStages {
+		st, ok := m.stages[stage]
+		if !ok {
+			continue
+		}
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += st.buckets[i]
+			fmt.Fprintf(w, "upload_stage_duration_seconds_bucket{stage=%q,le=\"%g\"} %d\n", stage, le, cumulative)
+		}
+		fmt.Fprintf(w, "upload_stage_duration_seconds_bucket{stage=%q,le=\"+Inf\"} %d\n", stage, st.count)
+		fmt.Fprintf(w, "upload_stage_duration_seconds_sum{stage=%q} %g\n", stage, st.totalSeconds)
+		fmt.Fprintf(w, "upload_stage_duration_seconds_count{stage=%q} %d\n", stage, st.count)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// Content moves through the gateway as Sources and Sinks. A Sink receives
+// bytes into the spool and, once committed, becomes a Staged file; a Staged
+// file is a Source the next step reads from. Transforms, encryption and
+// decryption are each a step from one Source to a new Staged file (see
+// Spool.Through), and a Sink can tee a stream into the download cache, so
+// the features compose without any of them handling temp files itself.
+
+// Source is content that can be read from the start, as often as needed.
+type Source interface {
+	Open() (io.ReadCloser, error)
+	Size() int64
+}
+
+// Sink receives content. Commit closes it and returns what was written as
+// a Staged file; Abort throws it away. Exactly one of them must be called.
+type Sink interface {
+	io.Writer
+	Commit() (*Staged, error)
+	Abort()
+}
+
+// Staged is content held in a local file, in the spool or the download
+// cache. The SDK and the hasher work on files, so everything headed for
+// 0G, or fetched from it, is staged on the way.
+type Staged struct {
+	Path    string
+	size    int64
+	release func()
+}
+
+func (f *Staged) Open() (io.ReadCloser, error) {
+	return os.Open(f.Path)
+}
+
+// Size is the file's size, read from disk when it was not recorded.
+func (f *Staged) Size() int64 {
+	if f.size == 0 {
+		if info, err := os.Stat(f.Path); err == nil {
+			f.size = info.Size()
+		}
+	}
+	return f.size
+}
+
+// Release hands the file back; it must not be used afterwards. Files in
+// the download cache stay there.
+func (f *Staged) Release() {
+	if f.release != nil {
+		f.release()
+	}
+}
+
+// spoolSink writes to a new spool file.
+type spoolSink struct {
+	spool *Spool
+	f     *os.File
+	n     int64
+}
+
+// Sink opens a new spool file to write content into.
+func (s *Spool) Sink(pattern string) (Sink, error) {
+	f, err := s.Create(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &spoolSink{spool: s, f: f}, nil
+}
+
+func (w *spoolSink) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *spoolSink) Commit() (*Staged, error) {
+	path := w.f.Name()
+	if err := w.f.Close(); err != nil {
+		w.spool.Release(path)
+		return nil, err
+	}
+	return w.spool.staged(path, w.n), nil
+}
+
+func (w *spoolSink) Abort() {
+	w.f.Close()
+	w.spool.Release(w.f.Name())
+}
+
+// staged hands out a spool file that is released back to the spool.
+func (s *Spool) staged(path string, size int64) *Staged {
+	return &Staged{Path: path, size: size, release: func() { s.Release(path) }}
+}
+
+// Stage copies r into a new spool file.
+func (s *Spool) Stage(r io.Reader, pattern string) (*Staged, error) {
+	sink, err := s.Sink(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(sink, r); err != nil {
+		sink.Abort()
+		return nil, fmt.Errorf("failed to save file: %v", err)
+	}
+	staged, err := sink.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("failed to save file: %v", err)
+	}
+	return staged, nil
+}
+
+// Through stages what fn writes while reading src, buffered on both sides.
+// An error from fn is returned as it is.
+func (s *Spool) Through(src Source, pattern string, fn func(r io.Reader, w io.Writer) error) (*Staged, error) {
+	in, err := src.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	sink, err := s.Sink(pattern)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(sink)
+	err = fn(bufio.NewReader(in), w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		sink.Abort()
+		return nil, err
+	}
+	return sink.Commit()
+}
+
+// cacheSink is a Sink whose content is kept in the download cache as the
+// object rootHash once committed.
+type cacheSink struct {
+	Sink
+	server   *Server
+	rootHash string
+}
+
+// cacheSink returns a Sink that keeps what it receives as rootHash in the
+// download cache, or nil without a cache.
+func (s *Server) cacheSink(rootHash string) Sink {
+	if s.cache == nil {
+		return nil
+	}
+	sink, err := s.spool.Sink("download-*")
+	if err != nil {
+		log.Printf("⚠️  Streaming %s without caching it: %v", rootHash, err)
+		return nil
+	}
+	return &cacheSink{Sink: sink, server: s, rootHash: rootHash}
+}
+
+func (w *cacheSink) Commit() (*Staged, error) {
+	f, err := w.Sink.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return w.server.keepInCache(w.rootHash, f), nil
+}
+
+// keepInCache moves a staged object into the download cache and returns
+// it there, pinned until released, or returns f as it is without a cache,
+// when the object does not fit in it or when caching fails.
+func (s *Server) keepInCache(rootHash string, f *Staged) *Staged {
+	if s.cache == nil {
+		return f
+	}
+	cached, release, err := s.cache.Put(rootHash, f.Path)
+	if err != nil {
+		if err != errTooLargeToCache {
+			log.Printf("⚠️  %v", err)
+		}
+		return f
+	}
+	size := f.size
+	f.Release()
+	return &Staged{Path: cached, size: size, release: release}
+}
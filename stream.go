@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamable reports whether a download can go straight from the storage
+// nodes to the client: the whole file is wanted and it is not cached here or
+// by a peer, so there is nothing to seek in and nothing local to serve from.
+func (s *Server) streamable(c *gin.Context, rootHash string) bool {
+	if !s.featureEnabled(c, FlagStreamingDownload) || c.GetHeader("Range") != "" || c.Query("resume") != "" || c.GetHeader("X-Resume-Token") != "" {
+		return false
+	}
+	return !s.heldNearby(c, rootHash)
+}
+
+// heldNearby reports whether rootHash is in the download cache or a peer's,
+// which serve it faster than the storage nodes.
+func (s *Server) heldNearby(c *gin.Context, rootHash string) bool {
+	if s.cache != nil {
+		if _, cached := s.cache.LastAccess(rootHash); cached {
+			return true
+		}
+	}
+	return s.peers != nil && len(s.peers.peers) > 0 && s.peers.Has(c.Request.Context(), rootHash)
+}
+
+// describeDownload sets the Content-Type and Content-Disposition of a
+// download from the content type and filename the caller uploaded rootHash
+// with, leaving headers the route has set alone. The file is sent as an
+// attachment, or with ?download=false inline, for a browser to render.
+func (s *Server) describeDownload(c *gin.Context, rootHash string) {
+	h := c.Writer.Header()
+	rec, ok := s.catalog.Reference(tenantFrom(c), rootHash)
+	if ok && rec.ContentType != "" && h.Get("Content-Type") == "" {
+		h.Set("Content-Type", rec.ContentType)
+	}
+	if h.Get("Content-Disposition") == "" {
+		setContentDisposition(c, rec.Filename)
+	}
+}
+
+// setContentDisposition names the file a download is saved as; filename may
+// be empty. Names outside ASCII are encoded as RFC 2231 requires.
+func setContentDisposition(c *gin.Context, filename string) {
+	disposition := "attachment"
+	if c.Query("download") == "false" {
+		disposition = "inline"
+	}
+	params := map[string]string{}
+	if name := path.Base(filename); filename != "" && name != "/" && name != "." {
+		params["filename"] = name
+	}
+	if value := mime.FormatMediaType(disposition, params); value != "" {
+		disposition = value
+	}
+	c.Header("Content-Disposition", disposition)
+}
+
+// rangeStreamable reports whether a Range request can be answered from just
+// the segments it covers. Resume tokens and If-Range go through the whole
+// file, as do files held nearby.
+func (s *Server) rangeStreamable(c *gin.Context, rootHash string) bool {
+	if c.GetHeader("Range") == "" || c.GetHeader("If-Range") != "" || c.Query("resume") != "" || c.GetHeader("X-Resume-Token") != "" {
+		return false
+	}
+	return !s.heldNearby(c, rootHash)
+}
+
+// streamRange answers a single-range request with 206, downloading only the
+// segments the range falls in and trimming the first and last of them.
+// handled is false, with nothing written, for ranges it leaves to the whole
+// file download: several ranges, or ones the file cannot satisfy.
+func (s *Server) streamRange(c *gin.Context, rootHash string) (handled bool) {
+	nodes, err := s.client.selectNodesFor(requestClassFrom(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return true
+	}
+	info, err := s.client.FileInfo(c.Request.Context(), nodes, rootHash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return true
+	}
+	if info == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found on storage nodes"})
+		return true
+	}
+	size := int64(info.Tx.Size)
+	if obj, ok := s.catalog.Object(rootHash); ok && obj.Size > 0 && obj.Size != size {
+		log.Printf("⚠️  Storage nodes report %d bytes for %s, catalog has %d", size, rootHash, obj.Size)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Storage nodes report the wrong size for this file"})
+		return true
+	}
+	start, end, ok := requestedRange(c.GetHeader("Range"), size)
+	if !ok {
+		return false
+	}
+
+	started := false
+	written := int64(0)
+	begin := func(first []byte) {
+		s.describeDownload(c, rootHash)
+		if c.Writer.Header().Get("Content-Type") == "" {
+			contentType := "application/octet-stream"
+			if start == 0 {
+				contentType = http.DetectContentType(first)
+			}
+			c.Header("Content-Type", contentType)
+		}
+		if end+1 < size {
+			next := (end + 1) / segmentSize * segmentSize
+			c.Header("X-Resume-Token", s.resume.Issue(rootHash, next, size))
+		}
+		c.Header("Content-Length", strconv.FormatInt(end-start+1, 10))
+		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		c.Header("Accept-Ranges", "bytes")
+		c.Header("ETag", downloadETag(rootHash))
+		s.signRange(c, rootHash, size, true)
+		c.Status(http.StatusPartialContent)
+		c.Writer.WriteHeaderNow()
+		started = true
+	}
+
+	first, last := start/segmentSize, end/segmentSize
+	index := first
+	err = s.client.StreamSegments(c.Request.Context(), nodes, rootHash, size, first, last+1, transferTuningFrom(c).Concurrency, func(data []byte) error {
+		offset := index * segmentSize
+		index++
+		lo, hi := int64(0), int64(len(data))
+		if start > offset {
+			lo = start - offset
+		}
+		if end+1-offset < hi {
+			hi = end + 1 - offset
+		}
+		if lo > hi {
+			return fmt.Errorf("storage nodes returned a short segment")
+		}
+		if !started {
+			begin(data[lo:hi])
+		}
+		written += hi - lo
+		if _, err := c.Writer.Write(data[lo:hi]); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err == nil && written != end-start+1 {
+		err = fmt.Errorf("storage nodes returned %d of the %d bytes of the range", written, end-start+1)
+	}
+	if err == nil {
+		return true
+	}
+	if !started {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return true
+	}
+	if c.Request.Context().Err() != context.Canceled {
+		log.Printf("⚠️  Streaming a range of %s stopped: %v", rootHash, err)
+	}
+	c.Abort()
+	return true
+}
+
+// streamDownload serves rootHash by writing each verified segment to the
+// response as it arrives, instead of downloading the whole file to disk
+// first. With a download cache the bytes are also written to the spool and
+// cached once complete. The response carries the file's Content-Length, so
+// a failure after the first byte, which can only cut the response short,
+// is noticed by clients and proxies as a truncated body.
+func (s *Server) streamDownload(c *gin.Context, rootHash string) {
+	nodes, err := s.client.selectNodesFor(requestClassFrom(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	info, err := s.client.FileInfo(c.Request.Context(), nodes, rootHash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if info == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found on storage nodes"})
+		return
+	}
+	size := int64(info.Tx.Size)
+	// The root hash commits to the size, so a node and the catalog can only
+	// disagree about it if one of them is wrong
+	if obj, ok := s.catalog.Object(rootHash); ok && obj.Size > 0 && obj.Size != size {
+		log.Printf("⚠️  Storage nodes report %d bytes for %s, catalog has %d", size, rootHash, obj.Size)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Storage nodes report the wrong size for this file"})
+		return
+	}
+
+	tee := s.cacheSink(rootHash)
+
+	started := false
+	written := int64(0)
+	start := func(first []byte) {
+		s.describeDownload(c, rootHash)
+		if c.Writer.Header().Get("Content-Type") == "" {
+			c.Header("Content-Type", http.DetectContentType(first))
+		}
+		c.Header("Content-Length", strconv.FormatInt(size, 10))
+		c.Header("Accept-Ranges", "bytes")
+		c.Header("ETag", downloadETag(rootHash))
+		s.signRange(c, rootHash, size, false)
+		c.Status(http.StatusOK)
+		c.Writer.WriteHeaderNow()
+		started = true
+	}
+	if size == 0 {
+		start(nil)
+	}
+	err = s.client.StreamFile(c.Request.Context(), nodes, rootHash, size, transferTuningFrom(c).Concurrency, func(data []byte) error {
+		if !started {
+			start(data)
+		}
+		if written+int64(len(data)) > size {
+			return fmt.Errorf("storage nodes returned more than the %d bytes of the file", size)
+		}
+		written += int64(len(data))
+		if tee != nil {
+			if _, err := tee.Write(data); err != nil {
+				log.Printf("⚠️  Streaming %s without caching it: %v", rootHash, err)
+				tee.Abort()
+				tee = nil
+			}
+		}
+		if _, err := c.Writer.Write(data); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err == nil && written != size {
+		err = fmt.Errorf("storage nodes returned %d of the %d bytes of the file", written, size)
+	}
+
+	if tee != nil {
+		if err != nil {
+			tee.Abort()
+		} else if cached, err := tee.Commit(); err == nil {
+			cached.Release()
+		}
+	}
+	if err == nil {
+		return
+	}
+	if !started {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Request.Context().Err() == context.Canceled {
+		return
+	}
+	log.Printf("⚠️  Streaming %s stopped: %v", rootHash, err)
+	// Closing the connection short of the Content-Length tells the client
+	// the body is incomplete
+	c.Abort()
+}
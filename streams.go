@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxStreamEntrySize  = 64 << 10
+	defaultEntriesLimit = 100
+	maxEntriesLimit     = 1000
+)
+
+// Each tenant has its own log in a stream, under keys prefixed with its name,
+// so tenants picking the same stream name never see each other's entries.
+func streamHeadKey(tenant string) []byte {
+	return []byte(tenant + "/head")
+}
+
+func streamEntryKey(tenant string, index uint64) []byte {
+	prefix := tenant + "/entry:"
+	key := make([]byte, len(prefix)+8)
+	copy(key, prefix)
+	binary.BigEndian.PutUint64(key[len(prefix):], index)
+	return key
+}
+
+// streamLogKey identifies a tenant's log in a stream.
+type streamLogKey struct {
+	stream common.Hash
+	tenant string
+}
+
+type StreamEntry struct {
+	Index      uint64          `json:"index"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	DataBase64 string          `json:"data_base64,omitempty"`
+}
+
+type AppendResponse struct {
+	StreamID string `json:"stream_id"`
+	Index    uint64 `json:"index"`
+	TxHash   string `json:"tx_hash"`
+}
+
+type EntriesResponse struct {
+	StreamID string        `json:"stream_id"`
+	Head     uint64        `json:"head"`
+	Entries  []StreamEntry `json:"entries"`
+}
+
+// StreamLog implements append-only logs on a KV stream: a tenant's entries
+// live under "<tenant>/entry:<index>" and "<tenant>/head" holds the number of
+// them. An entry and the new head are written in the same transaction.
+// Appends are serialized per log within this process; the locally known head
+// covers KV node sync lag.
+type StreamLog struct {
+	kv *KVClient
+
+	mu    sync.Mutex
+	locks map[streamLogKey]*sync.Mutex
+	heads map[streamLogKey]uint64
+}
+
+func NewStreamLog(kv *KVClient) *StreamLog {
+	return &StreamLog{
+		kv:    kv,
+		locks: make(map[streamLogKey]*sync.Mutex),
+		heads: make(map[streamLogKey]uint64),
+	}
+}
+
+func (l *StreamLog) streamLock(k streamLogKey) *sync.Mutex {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lock, ok := l.locks[k]
+	if !ok {
+		lock = &sync.Mutex{}
+		l.locks[k] = lock
+	}
+	return lock
+}
+
+// Head returns the number of entries in tenant's log in the stream.
+func (l *StreamLog) Head(ctx context.Context, id common.Hash, tenant string) (uint64, error) {
+	raw, err := l.kv.Get(ctx, id, streamHeadKey(tenant))
+	if err != nil {
+		return 0, err
+	}
+	var head uint64
+	if len(raw) == 8 {
+		head = binary.BigEndian.Uint64(raw)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if local := l.heads[streamLogKey{id, tenant}]; local > head {
+		head = local
+	}
+	return head, nil
+}
+
+func (l *StreamLog) Append(ctx context.Context, id common.Hash, tenant string, data []byte) (uint64, string, error) {
+	k := streamLogKey{id, tenant}
+	lock := l.streamLock(k)
+	lock.Lock()
+	defer lock.Unlock()
+
+	index, err := l.Head(ctx, id, tenant)
+	if err != nil {
+		return 0, "", err
+	}
+
+	head := make([]byte, 8)
+	binary.BigEndian.PutUint64(head, index+1)
+	txHash, err := l.kv.Set(ctx, id, []KVPair{
+		{Key: streamEntryKey(tenant, index), Value: data},
+		{Key: streamHeadKey(tenant), Value: head},
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	l.mu.Lock()
+	l.heads[k] = index + 1
+	l.mu.Unlock()
+	return index, txHash, nil
+}
+
+func (l *StreamLog) Entries(ctx context.Context, id common.Hash, tenant string, from uint64, limit int) ([]StreamEntry, uint64, error) {
+	head, err := l.Head(ctx, id, tenant)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries := []StreamEntry{}
+	for i := from; i < head && len(entries) < limit; i++ {
+		data, err := l.kv.Get(ctx, id, streamEntryKey(tenant, i))
+		if err != nil {
+			return nil, 0, err
+		}
+		if data == nil {
+			// Written but not yet synced by the KV node
+			break
+		}
+		entry := StreamEntry{Index: i}
+		if json.Valid(data) {
+			entry.Data = data
+		} else {
+			entry.DataBase64 = base64.StdEncoding.EncodeToString(data)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, head, nil
+}
+
+// @Summary Append an entry to a log stream
+// @Description Appends the raw request body (max 64 KiB) to an append-only log backed by 0G KV. The stream ID is either a 32-byte hex KV stream ID or a name that is hashed into one. Each tenant has its own log in a stream.
+// @Accept octet-stream
+// @Produce json
+// @Param id path string true "Stream ID or name"
+// @Success 200 {object} AppendResponse
+// @Security ApiKeyAuth
+// @Router /streams/{id}/append [post]
+func (s *Server) handleStreamAppend(c *gin.Context) {
+	if s.streams == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "KV is not configured (set KV_NODE_RPC)"})
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxStreamEntrySize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
+	if len(data) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Entry is empty"})
+		return
+	}
+	if len(data) > maxStreamEntrySize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Entries are limited to %d bytes", maxStreamEntrySize)})
+		return
+	}
+
+	id := StreamID(c.Param("id"))
+	index, txHash, err := s.streams.Append(c.Request.Context(), id, tenantFrom(c), data)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, AppendResponse{
+		StreamID: id.Hex(),
+		Index:    index,
+		TxHash:   txHash,
+	})
+}
+
+// @Summary Read entries from a log stream
+// @Description Returns entries starting at index from. JSON entries are returned inline as data, anything else as data_base64.
+// @Produce json
+// @Param id path string true "Stream ID or name"
+// @Param from query int false "First entry index" default(0)
+// @Param limit query int false "Maximum number of entries" default(100)
+// @Success 200 {object} EntriesResponse
+// @Security ApiKeyAuth
+// @Router /streams/{id}/entries [get]
+func (s *Server) handleStreamEntries(c *gin.Context) {
+	if s.streams == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "KV is not configured (set KV_NODE_RPC)"})
+		return
+	}
+
+	from, err := strconv.ParseUint(c.DefaultQuery("from", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be a non-negative integer"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultEntriesLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+		return
+	}
+	if limit > maxEntriesLimit {
+		limit = maxEntriesLimit
+	}
+
+	id := StreamID(c.Param("id"))
+	entries, head, err := s.streams.Entries(c.Request.Context(), id, tenantFrom(c), from, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, EntriesResponse{
+		StreamID: id.Hex(),
+		Head:     head,
+		Entries:  entries,
+	})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	traceContextKey = "trace"
+	traceIDHeader   = "X-Trace-ID"
+	// requestIDHeader repeats the trace ID, which is also the request ID
+	// in the logs
+	requestIDHeader = "X-Request-ID"
+)
+
+// TraceRef identifies the trace of the request that produced an error or
+// started a job, so whoever reports a failure can quote it.
+type TraceRef struct {
+	ID string `json:"trace_id,omitempty"`
+	// URL opens the trace in the operator's tracing UI, with
+	// TRACE_URL_TEMPLATE set
+	URL string `json:"trace_url,omitempty"`
+}
+
+// traceRequest gives every request a trace ID, continuing the trace of a W3C
+// traceparent header when there is one, and returns it in X-Trace-ID. JSON
+// error responses carry it as trace_id (and trace_url). With a tracer, the
+// request is also exported as a span, the parent of those its work starts.
+func (s *Server) traceRequest(c *gin.Context) {
+	id, parent, ok := parseTraceparent(c.GetHeader("traceparent"))
+	if !ok {
+		var err error
+		if id, err = randomHex(16); err != nil {
+			c.Next()
+			return
+		}
+	}
+	trace := TraceRef{ID: id}
+	if s.traceURLTemplate != "" {
+		trace.URL = strings.ReplaceAll(s.traceURLTemplate, "{trace_id}", id)
+	}
+	c.Set(traceContextKey, trace)
+	c.Header(traceIDHeader, id)
+	c.Header(requestIDHeader, id)
+	c.Writer = &traceErrorWriter{ResponseWriter: c.Writer, trace: trace}
+
+	span := s.tracer.Start(id, parent, c.Request.Method+" "+c.FullPath())
+	if span == nil {
+		c.Next()
+		return
+	}
+	c.Request = c.Request.WithContext(withSpan(c.Request.Context(), span))
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttr("http.request.method", c.Request.Method)
+	span.SetAttr("http.route", c.FullPath())
+	span.SetAttr("url.path", c.Request.URL.Path)
+	span.SetAttr("http.response.status_code", status)
+	span.SetAttr("client.address", c.ClientIP())
+	if tenant := c.GetString(tenantContextKey); tenant != "" {
+		span.SetAttr("tenant", tenant)
+	}
+	var err error
+	if status >= 500 {
+		err = fmt.Errorf("%d %s", status, http.StatusText(status))
+	}
+	span.End(err)
+}
+
+func traceFrom(c *gin.Context) TraceRef {
+	value, _ := c.Get(traceContextKey)
+	trace, _ := value.(TraceRef)
+	return trace
+}
+
+// parseTraceparent returns the trace and parent span IDs of a traceparent
+// header ("00-<trace id>-<parent id>-<flags>"). A malformed parent is left
+// out rather than failing the trace.
+func parseTraceparent(header string) (traceID, parentID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 {
+		return "", "", false
+	}
+	id := strings.ToLower(parts[1])
+	if _, err := hex.DecodeString(id); err != nil || id == strings.Repeat("0", 32) {
+		return "", "", false
+	}
+	parent := strings.ToLower(parts[2])
+	if _, err := hex.DecodeString(parent); err != nil || len(parent) != 16 || parent == strings.Repeat("0", 16) {
+		parent = ""
+	}
+	return id, parent, true
+}
+
+// traceErrorWriter adds the trace to JSON error bodies as they are written.
+type traceErrorWriter struct {
+	gin.ResponseWriter
+	trace TraceRef
+}
+
+func (w *traceErrorWriter) Write(b []byte) (int, error) {
+	if w.Status() < 400 || w.Size() > 0 || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		return w.ResponseWriter.Write(b)
+	}
+	tagged, ok := withTrace(b, w.trace)
+	if !ok {
+		return w.ResponseWriter.Write(b)
+	}
+	if _, err := w.ResponseWriter.Write(tagged); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// withTrace appends the trace's fields to a JSON object.
+func withTrace(body []byte, trace TraceRef) ([]byte, bool) {
+	body = bytes.TrimRight(body, "\n")
+	if len(body) < 2 || body[0] != '{' || body[len(body)-1] != '}' || bytes.Contains(body, []byte(`"trace_id"`)) {
+		return nil, false
+	}
+	fields, err := json.Marshal(trace)
+	if err != nil {
+		return nil, false
+	}
+	out := make([]byte, 0, len(body)+len(fields))
+	out = append(out, body[:len(body)-1]...)
+	if len(bytes.TrimSpace(body[1:len(body)-1])) > 0 {
+		out = append(out, ',')
+	}
+	out = append(out, fields[1:]...)
+	return out, true
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// Transform rewrites uploads of some MIME types before they are hashed and
+// stored, e.g. to drop metadata a tenant does not want published.
+type Transform struct {
+	Name string
+	// Types are MIME types, or "type/*" for a whole family
+	Types []string
+	Apply func(r io.Reader, w io.Writer) error
+}
+
+func (t Transform) Matches(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, pattern := range t.Types {
+		if strings.HasSuffix(pattern, "/*") {
+			if strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if mediaType == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// transforms is the registry of transforms tenants can enable by name.
+var transforms = map[string]Transform{
+	"strip_exif": {
+		Name:  "strip_exif",
+		Types: []string{"image/jpeg", "image/png"},
+		Apply: stripImageMetadata,
+	},
+	"normalize_newlines": {
+		Name:  "normalize_newlines",
+		Types: []string{"text/*"},
+		Apply: normalizeNewlines,
+	},
+}
+
+// parseTransformList reads transform names separated by "+" or ",",
+// skipping unknown ones. "none" yields an empty list.
+func parseTransformList(raw string) []string {
+	names := []string{}
+	for _, name := range strings.FieldsFunc(raw, func(r rune) bool { return r == '+' || r == ',' }) {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "none" {
+			continue
+		}
+		if _, ok := transforms[name]; !ok {
+			log.Printf("⚠️  Unknown upload transform %q", name)
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// parseTenantTransforms reads "tenant=name+name" pairs separated by commas.
+func parseTenantTransforms(raw string) map[string][]string {
+	tenants := make(map[string][]string)
+	for tenant, v := range parseKeyValueList(raw) {
+		tenants[tenant] = parseTransformList(v)
+	}
+	return tenants
+}
+
+// TransformSettings picks the transforms each tenant's uploads go through.
+type TransformSettings struct {
+	Default []string
+	Tenants map[string][]string
+}
+
+func (t TransformSettings) For(tenant string) []string {
+	if names, ok := t.Tenants[tenant]; ok {
+		return names
+	}
+	return t.Default
+}
+
+// matchingTransforms returns the tenant's transforms that apply to
+// contentType, in the order they run.
+func (s *Server) matchingTransforms(tenant, contentType string) []Transform {
+	var matched []Transform
+	for _, name := range s.transforms.For(tenant) {
+		if t := transforms[name]; t.Matches(contentType) {
+			matched = append(matched, t)
+		}
+	}
+	return matched
+}
+
+// transformUpload runs the tenant's transforms that match contentType over
+// the staged file. When any applies, the result is a new spool file the
+// caller releases; otherwise path is returned unchanged with applied empty.
+func (s *Server) transformUpload(tenant, contentType, path string) (out string, size int64, applied []string, err error) {
+	matched := s.matchingTransforms(tenant, contentType)
+	if len(matched) == 0 {
+		return path, 0, nil, nil
+	}
+
+	src := path
+	for _, t := range matched {
+		next, n, err := s.applyTransform(t, src)
+		if src != path {
+			s.spool.Release(src)
+		}
+		if err != nil {
+			return "", 0, nil, fmt.Errorf("failed to apply %s: %v", t.Name, err)
+		}
+		src, size = next, n
+		applied = append(applied, t.Name)
+	}
+	return src, size, applied, nil
+}
+
+func (s *Server) applyTransform(t Transform, path string) (string, int64, error) {
+	out, err := s.spool.Through(&Staged{Path: path}, "transform-*", t.Apply)
+	if err != nil {
+		return "", 0, err
+	}
+	return out.Path, out.Size(), nil
+}
+
+// normalizeNewlines turns CRLF and lone CR line endings into LF.
+func normalizeNewlines(r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return bw.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		if b == '\r' {
+			b = '\n'
+			if next, err := br.Peek(1); err == nil && next[0] == '\n' {
+				br.ReadByte()
+			}
+		}
+		if err := bw.WriteByte(b); err != nil {
+			return err
+		}
+	}
+}
+
+var (
+	jpegSignature = []byte{0xFF, 0xD8}
+	pngSignature  = []byte("\x89PNG\r\n\x1a\n")
+	// iTXt chunks holding XMP start with this keyword
+	pngXMPKeyword = []byte("XML:com.adobe.xmp\x00")
+)
+
+// stripImageMetadata removes EXIF (and XMP) metadata from JPEG and PNG
+// images, leaving the image data itself byte for byte as it was.
+func stripImageMetadata(r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(8)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	switch {
+	case bytes.HasPrefix(head, pngSignature):
+		return stripPNGMetadata(br, w)
+	case bytes.HasPrefix(head, jpegSignature):
+		return stripJPEGMetadata(br, w)
+	}
+	// Not what the sniffed type claimed; leave it alone
+	_, err = io.Copy(w, br)
+	return err
+}
+
+// stripJPEGMetadata drops APP1 segments carrying EXIF or XMP. Everything
+// from the start of scan on is copied unchanged.
+func stripJPEGMetadata(r *bufio.Reader, w io.Writer) error {
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(r, soi); err != nil {
+		return err
+	}
+	if _, err := w.Write(soi); err != nil {
+		return err
+	}
+	for {
+		marker, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if marker != 0xFF {
+			return errors.New("malformed JPEG segment")
+		}
+		kind, err := r.ReadByte()
+		for err == nil && kind == 0xFF { // fill bytes
+			kind, err = r.ReadByte()
+		}
+		if err != nil {
+			return err
+		}
+		// Markers without a length
+		if kind == 0xD9 || kind == 0x01 || (kind >= 0xD0 && kind <= 0xD7) {
+			if _, err := w.Write([]byte{0xFF, kind}); err != nil {
+				return err
+			}
+			if kind == 0xD9 {
+				_, err := io.Copy(w, r)
+				return err
+			}
+			continue
+		}
+
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return err
+		}
+		if length < 2 {
+			return errors.New("malformed JPEG segment length")
+		}
+		payload := make([]byte, length-2)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return err
+		}
+		if kind == 0xE1 && (bytes.HasPrefix(payload, []byte("Exif\x00")) || bytes.HasPrefix(payload, []byte("http://ns.adobe.com/xap/1.0/\x00"))) {
+			continue
+		}
+		header := []byte{0xFF, kind, byte(length >> 8), byte(length)}
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		if _, err := w.Write(payload); err != nil {
+			return err
+		}
+		if kind == 0xDA { // start of scan: the rest is image data
+			_, err := io.Copy(w, r)
+			return err
+		}
+	}
+}
+
+// stripPNGMetadata drops eXIf chunks and XMP stored in iTXt chunks.
+func stripPNGMetadata(r *bufio.Reader, w io.Writer) error {
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return err
+	}
+	if _, err := w.Write(sig); err != nil {
+		return err
+	}
+	for {
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// Data and CRC
+		length := int64(binary.BigEndian.Uint32(header[:4])) + 4
+		kind := string(header[4:8])
+		drop := kind == "eXIf"
+		if kind == "iTXt" {
+			prefix, _ := r.Peek(len(pngXMPKeyword))
+			drop = bytes.Equal(prefix, pngXMPKeyword)
+		}
+		if drop {
+			if _, err := io.CopyN(io.Discard, r, length); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, r, length); err != nil {
+			return err
+		}
+		if kind == "IEND" {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+	"github.com/openweb3/web3go/types"
+)
+
+// Status of a transaction on chain
+const (
+	TxPending = "pending"
+	TxSuccess = "success"
+	TxFailed  = "failed"
+)
+
+// submitEventTopics identify the flow contract's Submit event. The
+// submission struct it carries changed shape between contract versions, so
+// both signatures are recognised; the fields decoded here precede it.
+var submitEventTopics = []common.Hash{
+	crypto.Keccak256Hash([]byte("Submit(address,bytes32,uint256,uint256,uint256,(uint256,bytes,(bytes32,uint256)[]))")),
+	crypto.Keccak256Hash([]byte("Submit(address,bytes32,uint256,uint256,uint256,((uint256,bytes,(bytes32,uint256)[]),address))")),
+}
+
+// SubmitEvent is a storage submission logged by the flow contract.
+type SubmitEvent struct {
+	Contract string `json:"contract"`
+	LogIndex uint   `json:"log_index"`
+	Sender   string `json:"sender"`
+	// Identity is the submission's digest as the contract records it
+	Identity        string `json:"identity"`
+	SubmissionIndex uint64 `json:"submission_index"`
+	// StartPos and Length place the data in the flow, in 256-byte sectors
+	StartPos uint64 `json:"start_pos"`
+	Length   uint64 `json:"length"`
+}
+
+// TxStatus is a transaction's receipt with its storage submissions decoded.
+type TxStatus struct {
+	TxHash string `json:"tx_hash"`
+	// Status is pending, success or failed
+	Status        string `json:"status"`
+	BlockNumber   uint64 `json:"block_number,omitempty"`
+	BlockHash     string `json:"block_hash,omitempty"`
+	Confirmations uint64 `json:"confirmations,omitempty"`
+	From          string `json:"from,omitempty"`
+	To            string `json:"to,omitempty"`
+	GasUsed       uint64 `json:"gas_used,omitempty"`
+	// EffectiveGasPrice is in wei
+	EffectiveGasPrice uint64 `json:"effective_gas_price,omitempty"`
+	// Error is the reason a failed transaction reverted, when the node gives one
+	Error       string        `json:"error,omitempty"`
+	Submissions []SubmitEvent `json:"submissions"`
+	// RootHash is set when the transaction stored a file the caller references
+	RootHash string `json:"root_hash,omitempty"`
+	// RootHashes lists every such file; a batched submission stores several
+	RootHashes []string `json:"root_hashes,omitempty"`
+}
+
+// TxStatus looks a transaction up on chain. ok is false when the node does
+// not know it at all.
+func (c *StorageClient) TxStatus(txHash string) (status TxStatus, ok bool, err error) {
+	hash := common.HexToHash(txHash)
+	status = TxStatus{TxHash: hash.Hex(), Submissions: []SubmitEvent{}}
+
+	receipt, err := c.web3Client.Eth.TransactionReceipt(hash)
+	if err != nil {
+		return status, false, fmt.Errorf("failed to get receipt: %v", err)
+	}
+	if receipt == nil {
+		tx, err := c.web3Client.Eth.TransactionByHash(hash)
+		if err != nil {
+			return status, false, fmt.Errorf("failed to get transaction: %v", err)
+		}
+		if tx == nil {
+			return status, false, nil
+		}
+		status.Status = TxPending
+		status.From = tx.From.Hex()
+		if tx.To != nil {
+			status.To = tx.To.Hex()
+		}
+		return status, true, nil
+	}
+
+	status.Status = TxSuccess
+	if receipt.Status != nil && *receipt.Status != 1 {
+		status.Status = TxFailed
+	}
+	if receipt.TxExecErrorMsg != nil {
+		status.Error = *receipt.TxExecErrorMsg
+	}
+	status.BlockNumber = receipt.BlockNumber
+	status.BlockHash = receipt.BlockHash.Hex()
+	status.From = receipt.From.Hex()
+	if receipt.To != nil {
+		status.To = receipt.To.Hex()
+	}
+	status.GasUsed = receipt.GasUsed
+	status.EffectiveGasPrice = receipt.EffectiveGasPrice
+	if latest, err := c.web3Client.Eth.BlockNumber(); err == nil && latest != nil && latest.Uint64() >= receipt.BlockNumber {
+		status.Confirmations = latest.Uint64() - receipt.BlockNumber + 1
+	}
+
+	for _, l := range receipt.Logs {
+		if event, ok := decodeSubmitEvent(l); ok {
+			status.Submissions = append(status.Submissions, event)
+		}
+	}
+	return status, true, nil
+}
+
+// decodeSubmitEvent decodes l if it is a flow contract Submit event.
+func decodeSubmitEvent(l *types.Log) (SubmitEvent, bool) {
+	if len(l.Topics) != 3 || len(l.Data) < 3*32 || !isSubmitTopic(l.Topics[0]) {
+		return SubmitEvent{}, false
+	}
+	word := func(i int) uint64 {
+		return new(big.Int).SetBytes(l.Data[i*32 : (i+1)*32]).Uint64()
+	}
+	return SubmitEvent{
+		Contract:        l.Address.Hex(),
+		LogIndex:        l.Index,
+		Sender:          common.BytesToAddress(l.Topics[1].Bytes()).Hex(),
+		Identity:        l.Topics[2].Hex(),
+		SubmissionIndex: word(0),
+		StartPos:        word(1),
+		Length:          word(2),
+	}, true
+}
+
+const (
+	// submissionSearchBlocks is how far back SubmissionTx looks, and
+	// submissionSearchStep how many blocks it asks for the logs of at once
+	submissionSearchBlocks = 10000
+	submissionSearchStep   = 1000
+)
+
+// SubmissionTx finds the transaction that made submission seq on the flow
+// contract at flow, searching the logs of recent blocks. Storage nodes know
+// a file by its submission, not by the transaction that made it.
+func (c *StorageClient) SubmissionTx(flow common.Address, seq uint64) (string, error) {
+	latest, err := c.web3Client.Eth.BlockNumber()
+	if err != nil || latest == nil {
+		return "", fmt.Errorf("failed to get block number: %v", err)
+	}
+	end := latest.Uint64()
+	for searched := uint64(0); searched < submissionSearchBlocks && searched <= latest.Uint64(); searched += submissionSearchStep {
+		start := uint64(0)
+		if end >= submissionSearchStep {
+			start = end - submissionSearchStep + 1
+		}
+		from, to := types.BlockNumber(start), types.BlockNumber(end)
+		logs, err := c.web3Client.Eth.Logs(types.FilterQuery{
+			FromBlock: &from,
+			ToBlock:   &to,
+			Addresses: []common.Address{flow},
+			Topics:    [][]common.Hash{submitEventTopics},
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to get logs: %v", err)
+		}
+		for i := range logs {
+			if event, ok := decodeSubmitEvent(&logs[i]); ok && event.SubmissionIndex == seq {
+				return logs[i].TxHash.Hex(), nil
+			}
+		}
+		if start == 0 {
+			break
+		}
+		end = start - 1
+	}
+	return "", fmt.Errorf("submission %d is not in the last %d blocks", seq, submissionSearchBlocks)
+}
+
+func isSubmitTopic(topic common.Hash) bool {
+	for _, t := range submitEventTopics {
+		if topic == t {
+			return true
+		}
+	}
+	return false
+}
+
+// @Summary Get a transaction's on-chain status
+// @Description Looks the transaction up on the 0G chain: whether it is pending, succeeded or failed, its block, confirmations and gas used, and the flow contract Submit events it logged, decoded, so an upload can be verified without a block explorer. The root hash is included when the transaction stored a file the caller references.
+// @Produce json
+// @Param tx_hash path string true "Transaction hash"
+// @Success 200 {object} TxStatus
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /tx/{tx_hash} [get]
+func (s *Server) handleTxStatus(c *gin.Context) {
+	txHash := strings.ToLower(c.Param("tx_hash"))
+	// Transaction hashes have the same shape as root hashes
+	if !isRootHash(txHash) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction hash"})
+		return
+	}
+
+	status, ok, err := s.client.TxStatus(txHash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		return
+	}
+	for _, obj := range s.catalog.ObjectsByTx(txHash) {
+		if _, referenced := s.catalog.Reference(tenantFrom(c), obj.RootHash); referenced {
+			status.RootHashes = append(status.RootHashes, obj.RootHash)
+		}
+	}
+	if len(status.RootHashes) > 0 {
+		status.RootHash = status.RootHashes[0]
+	}
+	c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// watchTransactions records the block each submission was mined in, and with
+// metering charges the gas it used, every interval until ctx is done. Every
+// replica watches its own submissions, as they are in its own catalog.
+func (s *Server) watchTransactions(ctx context.Context, interval, timeout time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.checkTransactions(timeout)
+			if s.meter != nil {
+				s.chargeGas()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkTransactions records the blocks of the submissions mined since the
+// last check. Those still not mined timeout after they were stored are
+// marked failed and no longer looked up.
+func (s *Server) checkTransactions(timeout time.Duration) {
+	blocks := make(map[string]uint64)
+	var failed []string
+	for _, tx := range s.catalog.UnminedTxs() {
+		block, ok, err := s.client.TxBlock(tx.TxHash)
+		if err == nil && ok {
+			blocks[tx.TxHash] = block
+			continue
+		}
+		if timeout > 0 && time.Since(tx.Since) > timeout {
+			log.Printf("⚠️  Giving up on transaction %s: not mined within %s", tx.TxHash, timeout)
+			failed = append(failed, tx.TxHash)
+			continue
+		}
+		if err != nil {
+			log.Printf("⚠️  Failed to check transaction %s: %v", tx.TxHash, err)
+		}
+	}
+	if err := s.catalog.SetBlocks(blocks, failed); err != nil {
+		log.Printf("⚠️  Failed to record transaction blocks: %v", err)
+	}
+}
+
+// chargeGas meters the gas of the submissions that have been mined since the
+// last check. Lookups that fail are retried on the next one.
+func (s *Server) chargeGas() {
+	for _, txHash := range s.meter.AwaitingTxs() {
+		status, ok, err := s.client.TxStatus(txHash)
+		if err != nil {
+			log.Printf("⚠️  Failed to read gas used by %s: %v", txHash, err)
+			continue
+		}
+		if ok && status.Status != TxPending {
+			s.meter.Mined(txHash, status.GasUsed, status.EffectiveGasPrice)
+		}
+	}
+}
@@ -0,0 +1,789 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/0glabs/0g-storage-client/core"
+	"github.com/gin-gonic/gin"
+)
+
+// uploadRequest describes a file staged on local disk, or in the remote
+// spool, and ready for 0G.
+type uploadRequest struct {
+	Tenant string
+	Class  RequestClass
+	Tuning TransferTuning
+	// Timer, when set, records the time spent in each stage
+	Timer    *StageTimer
+	Path     string
+	Filename string
+	Size     int64
+	Metadata map[string]string
+	// Progress, when set, is told about each stage as it starts
+	Progress func(UploadStage)
+	// Transfer, when set, is told how far the file has got while it is
+	// uploaded to the storage nodes. Batched uploads do not report it.
+	Transfer func(TransferProgress)
+	// Callbacks are notified of the outcome along with the tenant's webhooks
+	Callbacks []Webhook
+	// KeyID is the API key the upload was made with, kept in the history
+	KeyID string
+	// Origin is the request the upload came with, for the access policy
+	Origin requestOrigin
+	// Flags are the request's feature flags; nil means the defaults
+	Flags FlagSet
+	// Bundled is set for the files of a directory, which cannot be held one
+	// by one: a quarantine rule rejects them instead
+	Bundled bool
+	// Released is set for a quarantined upload an admin has released
+	Released bool
+	// Encryption, when set, is the key the file is encrypted with before it
+	// is hashed and stored
+	Encryption *UploadKey
+	// Log, when set, logs the upload's lifecycle with the request's ID
+	Log *slog.Logger
+	// Events, when set, collects the upload.finalized event instead of it
+	// being published, for a caller that may still roll the upload back
+	Events *[]WebhookEvent
+	// Remote, when set, is the file staged in the remote spool. It is hashed
+	// and uploaded with ranged reads; Path stays empty until a step that
+	// needs a local file fetches it.
+	Remote *remoteUpload
+}
+
+// remoteUpload is an upload staged in the remote spool.
+type remoteUpload struct {
+	Data core.IterableData
+	// Fetch copies the object to a local spool file once; later calls
+	// return the same path
+	Fetch func() (string, error)
+}
+
+// local fetches a remotely staged file, for the steps that need it on disk.
+func (r *uploadRequest) local() error {
+	if r.Path != "" || r.Remote == nil {
+		return nil
+	}
+	path, err := r.Remote.Fetch()
+	if err != nil {
+		return err
+	}
+	r.Path = path
+	return nil
+}
+
+// sniff returns the content type of the staged file.
+func (r uploadRequest) sniff() (string, error) {
+	if r.Path != "" || r.Remote == nil {
+		return sniffContentType(r.Path)
+	}
+	buf := make([]byte, sniffLen)
+	n, err := r.Remote.Data.Read(buf, 0)
+	if err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+func (r uploadRequest) logger() *slog.Logger {
+	if r.Log != nil {
+		return r.Log
+	}
+	return slog.Default()
+}
+
+func (r uploadRequest) reached(stage UploadStage) {
+	r.logger().Debug("upload stage", "stage", string(stage), "tenant", r.Tenant, "filename", r.Filename, "size", r.Size)
+	if r.Progress != nil {
+		r.Progress(stage)
+	}
+}
+
+// storeUpload puts a staged file on 0G for a tenant, after the tenant's
+// transforms, once the upload policy admits it. Content that is already
+// stored, or currently being submitted by another request, only gains a
+// reference for the tenant instead of a second upload. Uploads the policy
+// quarantines are held instead, until an admin releases them.
+// The tenant's webhooks and the request's callbacks are notified of the
+// outcome.
+func (s *Server) storeUpload(req uploadRequest) (resp UploadResponse, err error) {
+	started := time.Now()
+	contentType, err := req.sniff()
+	if err != nil {
+		return UploadResponse{}, fmt.Errorf("failed to inspect upload: %v", err)
+	}
+	defer func() {
+		if err == nil && resp.Quarantine == nil {
+			resp.ID = s.assignPublicID(resp.RootHash)
+			s.recordUpload(req, contentType, started, resp)
+		}
+	}()
+
+	if limit := s.maxFileBytesFor(req.Tenant); limit > 0 && req.Size > limit {
+		return UploadResponse{}, uploadTooLarge(limit)
+	}
+
+	// Transforms run first, so policy, quota and the root hash all see the
+	// content that is actually stored. A held upload keeps the original,
+	// which is transformed again on release.
+	staged := req
+	req.reached(StageTransform)
+	start := time.Now()
+	if len(s.matchingTransforms(req.Tenant, contentType)) > 0 {
+		if err := req.local(); err != nil {
+			return UploadResponse{}, err
+		}
+	}
+	transformed, size, applied, err := s.transformUpload(req.Tenant, contentType, req.Path)
+	if err != nil {
+		return UploadResponse{}, err
+	}
+	if len(applied) > 0 {
+		defer s.spool.Release(transformed)
+		req.Path, req.Size = transformed, size
+		req.Timer.Since(StageTransform, start)
+		defer func() {
+			if err == nil {
+				resp.Transforms = applied
+			}
+		}()
+	}
+	candidate := UploadCandidate{
+		Tenant:      req.Tenant,
+		Filename:    req.Filename,
+		ContentType: contentType,
+		Size:        req.Size,
+		KeyID:       req.KeyID,
+		IP:          req.Origin.IP,
+		Route:       req.Origin.Route,
+		Path:        req.Origin.Path,
+		Method:      req.Origin.Method,
+	}
+	decision, err := s.admit(candidate)
+	if err != nil {
+		return UploadResponse{}, err
+	}
+	if err := s.authorizeUpload(candidate); err != nil {
+		return UploadResponse{}, err
+	}
+	if decision.Quarantined && !req.Released {
+		if req.Bundled {
+			return UploadResponse{}, newAPIError(http.StatusForbidden, "Upload held by policy rule %q; files of a directory cannot be quarantined", decision.Rule)
+		}
+		if err := staged.local(); err != nil {
+			return UploadResponse{}, err
+		}
+		return s.holdUpload(staged, contentType, decision)
+	}
+
+	// Policy saw the plaintext; 0G only ever sees the ciphertext
+	var encryption *EncryptionInfo
+	if req.Encryption != nil {
+		if err := req.local(); err != nil {
+			return UploadResponse{}, err
+		}
+		var encrypted string
+		encrypted, req.Size, encryption, err = s.encryptUpload(req.Path, req.Encryption)
+		if err != nil {
+			return UploadResponse{}, err
+		}
+		defer s.spool.Release(encrypted)
+		req.Path = encrypted
+		defer func() {
+			if err == nil {
+				resp.Encryption = encryption
+			}
+		}()
+	}
+
+	req.reached(StageHash)
+	start = time.Now()
+	var rootHash string
+	if req.Path != "" {
+		rootHash, err = s.client.ComputeRoot(req.Path)
+	} else {
+		rootHash, err = s.client.ComputeRootData(req.Remote.Data)
+	}
+	if err != nil {
+		return UploadResponse{}, err
+	}
+	req.Timer.Since(StageHash, start)
+
+	releaseQuota, err := s.reserveQuota(req.Tenant, rootHash, req.Size)
+	if err != nil {
+		return UploadResponse{}, err
+	}
+	defer releaseQuota()
+	if err := s.dailyUploads.Reserve(req.Tenant, req.Size, s.dailyQuotaFor(req.Tenant)); err != nil {
+		return UploadResponse{}, err
+	}
+	defer func() {
+		if err != nil {
+			s.dailyUploads.Release(req.Tenant, req.Size)
+		}
+	}()
+
+	record := FileRecord{
+		Tenant:      req.Tenant,
+		RootHash:    rootHash,
+		Filename:    req.Filename,
+		ContentType: contentType,
+		Size:        req.Size,
+		Metadata:    req.Metadata,
+		Encryption:  encryption,
+	}
+
+	// Identical content is already on 0G: just reference it for this tenant.
+	// Content whose submission was given up on is sent again.
+	if existing, ok := s.catalog.Object(rootHash); ok && !existing.TxFailed {
+		req.reached(StageFinalize)
+		defer req.Timer.Since(StageFinalize, time.Now())
+		return s.referenceUpload(req, record, existing.TxHash)
+	}
+
+	if err := s.client.EnsureFunds(s.client.ctx, req.Size); err != nil {
+		return UploadResponse{}, err
+	}
+
+	// Upload to 0G Storage, unless the same content is already on its way
+	upload, shared, finish := s.inflight.Do(rootHash, func() inflightResult {
+		req.reached(StageSubmit)
+		// Tuned uploads go out on their own, as a batch shares one transfer,
+		// and so do remote ones, which a batch would have to fetch
+		if s.batcher != nil && req.Tuning.IsZero() && req.Path != "" && s.flags.On(req.Flags, FlagBatching) {
+			defer req.Timer.Since(StageSubmit, time.Now())
+			return s.batcher.Upload(req.Class, req.Path)
+		}
+		var watch *TransferWatch
+		if req.Transfer != nil {
+			watch = &TransferWatch{RootHash: rootHash, Report: req.Transfer}
+		}
+		start := time.Now()
+		if req.Path == "" {
+			// Not resumable: resuming reads segments from a local file
+			txHash, uploadedRoot, err := s.client.UploadDataWith(req.Class, req.Tuning, req.Timer, watch, req.Remote.Data)
+			return inflightResult{TxHash: txHash, RootHash: uploadedRoot, Replicas: s.client.replicasFor(req.Tuning), Elapsed: time.Since(start), Err: err}
+		}
+		txHash, uploadedRoot, replicas, err := s.client.UploadFileResumable(req.Class, req.Tuning, req.Timer, watch, rootHash, req.Path)
+		return inflightResult{TxHash: txHash, RootHash: uploadedRoot, Replicas: replicas, Elapsed: time.Since(start), Err: err}
+	})
+	// Uploads of the same content wait until this one is in the catalog
+	defer finish()
+	if upload.Err != nil {
+		req.logger().Warn("upload failed", "tenant", req.Tenant, "root_hash", rootHash, "size", req.Size, "error", upload.Err.Error())
+		s.webhooks.Publish(WebhookEvent{
+			Type:     EventUploadFailed,
+			Tenant:   req.Tenant,
+			RootHash: rootHash,
+			Filename: req.Filename,
+			Size:     req.Size,
+			Status:   UploadStatusFailed,
+			Error:    upload.Err.Error(),
+			Metadata: req.Metadata,
+		}, req.Callbacks...)
+		return UploadResponse{}, upload.Err
+	}
+	req.reached(StageFinalize)
+	start = time.Now()
+	defer req.Timer.Since(StageFinalize, start)
+	if shared {
+		log.Printf("🔁 Upload of %s joined an in-flight submission", rootHash)
+		record.RootHash = upload.RootHash
+		resp, err := s.referenceUpload(req, record, upload.TxHash)
+		resp.Replicas = upload.Replicas
+		return resp, err
+	}
+	rootHash, txHash := upload.RootHash, upload.TxHash
+	req.logger().Info("upload stored", "tenant", req.Tenant, "root_hash", rootHash, "tx_hash", txHash, "size", req.Size, "replicas", upload.Replicas, "elapsed_ms", upload.Elapsed.Milliseconds())
+
+	if s.shadow != nil {
+		if err := req.local(); err != nil {
+			log.Printf("⚠️  Shadow upload skipped: %v", err)
+		} else if err := s.shadow.Mirror(req.Path, req.Filename, rootHash, txHash, upload.Elapsed); err != nil {
+			log.Printf("⚠️  Shadow upload skipped: %v", err)
+		}
+	}
+
+	record.RootHash = rootHash
+	record.TxHash = txHash
+	record.CreatedAt = time.Now()
+	isNew := !s.catalog.HasReference(record.Tenant, record.RootHash)
+	obj, err := s.catalog.AddReference(record)
+	if err != nil {
+		return UploadResponse{}, fmt.Errorf("failed to record upload %s in catalog: %v", rootHash, err)
+	}
+	if s.meter != nil {
+		s.meter.Stored(record.Tenant, record.Size, txHash)
+	}
+	// A classifier could make nothing of ciphertext
+	if s.moderation != nil && encryption == nil {
+		s.moderation.Submit(record)
+	}
+	s.notifyFinalized(req, WebhookEvent{
+		Type:     EventUploadFinalized,
+		Tenant:   req.Tenant,
+		RootHash: rootHash,
+		TxHash:   txHash,
+		Filename: req.Filename,
+		Size:     req.Size,
+		Status:   UploadStatusFinalized,
+		Metadata: req.Metadata,
+	})
+
+	return UploadResponse{
+		RootHash:     rootHash,
+		TxHash:       txHash,
+		RefCount:     obj.RefCount,
+		Replicas:     upload.Replicas,
+		newReference: isNew,
+	}, nil
+}
+
+// notifyFinalized publishes the event of a stored upload, or holds it in the
+// request's Events.
+func (s *Server) notifyFinalized(req uploadRequest, event WebhookEvent) {
+	if req.Events != nil {
+		*req.Events = append(*req.Events, event)
+		return
+	}
+	s.webhooks.Publish(event, req.Callbacks...)
+}
+
+// referenceUpload records a tenant's reference to content that is already on
+// 0G under txHash.
+func (s *Server) referenceUpload(req uploadRequest, record FileRecord, txHash string) (UploadResponse, error) {
+	record.TxHash = txHash
+	record.CreatedAt = time.Now()
+	isNew := !s.catalog.HasReference(record.Tenant, record.RootHash)
+	obj, err := s.catalog.AddReference(record)
+	if err != nil {
+		return UploadResponse{}, err
+	}
+	if s.meter != nil {
+		s.meter.Stored(record.Tenant, record.Size, "")
+	}
+	s.notifyFinalized(req, WebhookEvent{
+		Type:         EventUploadFinalized,
+		Tenant:       record.Tenant,
+		RootHash:     record.RootHash,
+		TxHash:       obj.TxHash,
+		Filename:     record.Filename,
+		Size:         record.Size,
+		Status:       UploadStatusFinalized,
+		Deduplicated: true,
+		Metadata:     record.Metadata,
+	})
+	return UploadResponse{
+		RootHash:     record.RootHash,
+		TxHash:       obj.TxHash,
+		Deduplicated: true,
+		RefCount:     obj.RefCount,
+		newReference: isNew,
+	}, nil
+}
+
+// uploadLimitReader counts the bytes of an upload of unknown length and fails
+// the read that takes it past limit (0 is unlimited), so an oversized chunked
+// body is cut off as soon as it crosses the limit rather than after it has
+// been spooled in full.
+type uploadLimitReader struct {
+	r        io.Reader
+	limit    int64
+	read     int64
+	exceeded bool
+}
+
+func newUploadLimitReader(r io.Reader, limit int64) *uploadLimitReader {
+	return &uploadLimitReader{r: r, limit: limit}
+}
+
+func (l *uploadLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.limit > 0 && l.read > l.limit {
+		l.exceeded = true
+		return n, uploadTooLarge(l.limit)
+	}
+	return n, err
+}
+
+// Exceeded reports whether reading stopped at the limit. Callers check it
+// rather than the error, which staging may have wrapped.
+func (l *uploadLimitReader) Exceeded() bool {
+	return l.exceeded
+}
+
+func uploadTooLarge(limit int64) error {
+	return newAPIError(http.StatusRequestEntityTooLarge, "Upload exceeds the %d byte limit", limit)
+}
+
+// filePart finds the "file" part of a multipart upload without buffering the
+// body, answering the request itself when there is none. The value fields
+// sent before the file are returned with it.
+func filePart(c *gin.Context) (*multipart.Part, map[string]string, bool) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a multipart/form-data body"})
+		return nil, nil, false
+	}
+	fields := make(map[string]string)
+	var fieldBytes int
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No file provided"})
+			return nil, nil, false
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid multipart body: " + err.Error()})
+			return nil, nil, false
+		}
+		if part.FormName() == "file" && part.FileName() != "" {
+			return part, fields, true
+		}
+		if part.FileName() == "" && fieldBytes < maxFormValuesBytes {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormValueBytes))
+			if err == nil {
+				fields[part.FormName()] = string(value)
+				fieldBytes += len(value)
+			}
+		}
+		part.Close()
+	}
+}
+
+// Limits on the value (non-file) fields of a streamed multipart form
+const (
+	maxFormValueBytes  = 64 << 10
+	maxFormValuesBytes = 1 << 20
+)
+
+func firstValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// streamedFile is a file part of a multipart form, staged in the spool.
+type streamedFile struct {
+	Filename  string
+	LocalPath string
+	Size      int64
+}
+
+// streamedForm is a multipart form read part by part: file parts went
+// straight to the spool and only the (small) value fields are in memory, so
+// a form with multi-gigabyte files costs no more RAM than a small one.
+type streamedForm struct {
+	Values map[string][]string
+	Files  map[string][]streamedFile
+}
+
+// Release removes the staged files.
+func (f *streamedForm) Release(spool *Spool) {
+	for _, files := range f.Files {
+		for _, file := range files {
+			spool.Release(file.LocalPath)
+		}
+	}
+}
+
+// readStreamedForm reads a multipart form, staging at most maxFiles files.
+// Value fields are limited to maxFormValueBytes each and maxFormValuesBytes
+// together, files to MAX_UPLOAD_BYTES each.
+func (s *Server) readStreamedForm(c *gin.Context, maxFiles int) (*streamedForm, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, "Expected a multipart/form-data body")
+	}
+
+	form := &streamedForm{Values: make(map[string][]string), Files: make(map[string][]streamedFile)}
+	files := 0
+	var valueBytes int64
+	fail := func(err error) (*streamedForm, error) {
+		form.Release(s.spool)
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			return fail(newAPIError(http.StatusBadRequest, "Invalid multipart form: %v", err))
+		}
+		name := part.FormName()
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormValueBytes+1))
+			part.Close()
+			if err != nil {
+				return fail(newAPIError(http.StatusBadRequest, "Invalid multipart form: %v", err))
+			}
+			if len(value) > maxFormValueBytes {
+				return fail(newAPIError(http.StatusRequestEntityTooLarge, "Form field %q exceeds %d bytes", name, maxFormValueBytes))
+			}
+			if valueBytes += int64(len(value)); valueBytes > maxFormValuesBytes {
+				return fail(newAPIError(http.StatusRequestEntityTooLarge, "Form fields exceed %d bytes in total", maxFormValuesBytes))
+			}
+			form.Values[name] = append(form.Values[name], string(value))
+			continue
+		}
+
+		if files++; files > maxFiles {
+			part.Close()
+			return fail(newAPIError(http.StatusBadRequest, "At most %d files can be sent at once", maxFiles))
+		}
+		admission := s.admissionReader(part, tenantFrom(c), part.FileName())
+		body := newUploadLimitReader(admission, s.maxUploadBytes)
+		staged, err := s.spool.Stage(body, "form-*")
+		part.Close()
+		if err != nil {
+			if body.Exceeded() {
+				err = uploadTooLarge(s.maxUploadBytes)
+			}
+			if rejected := admission.Rejected(); rejected != nil {
+				err = rejected
+			}
+			return fail(err)
+		}
+		form.Files[name] = append(form.Files[name], streamedFile{Filename: part.FileName(), LocalPath: staged.Path, Size: staged.Size()})
+	}
+}
+
+// handleUploadRemoteSpool is handleUpload for an S3 staging area: the file
+// part is streamed straight from the request into the bucket without touching
+// local disk, and only copied back while it is hashed and uploaded to 0G.
+func (s *Server) handleUploadRemoteSpool(c *gin.Context, share bool, shareTTL time.Duration, callbacks []Webhook, encryption *UploadKey) {
+	part, fields, ok := filePart(c)
+	if !ok {
+		return
+	}
+	defer part.Close()
+	tuning, err := s.uploadTuning(c, fields)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	timer := s.stages.NewTimer(c.Request.Context())
+	start := time.Now()
+	admission := s.admissionReader(part, tenantFrom(c), part.FileName())
+	body := newUploadLimitReader(admission, s.maxUploadBytes)
+	key, size, err := s.spool.StageRemote(ctx, body, "upload")
+	if err != nil {
+		if body.Exceeded() {
+			err = uploadTooLarge(s.maxUploadBytes)
+		}
+		if rejected := admission.Rejected(); rejected != nil {
+			err = rejected
+		}
+		respondError(c, err)
+		return
+	}
+
+	timer.Since(StageSpool, start)
+
+	// The object is read in place; it is only copied to local disk for
+	// transforms, encryption, quarantine and shadow uploads
+	var fetching sync.Mutex
+	var localPath string
+	remote := &remoteUpload{
+		Data: s.spool.RemoteData(key, size),
+		Fetch: func() (string, error) {
+			fetching.Lock()
+			defer fetching.Unlock()
+			if localPath == "" {
+				path, err := s.spool.FetchRemote(context.Background(), key)
+				if err != nil {
+					return "", err
+				}
+				localPath = path
+			}
+			return localPath, nil
+		},
+	}
+
+	s.respondUpload(c, uploadRequest{
+		Tenant:   tenantFrom(c),
+		Class:    requestClassFrom(c),
+		Tuning:   tuning,
+		KeyID:    c.GetString(keyIDContextKey),
+		Origin:   requestOriginFrom(c),
+		Flags:    featureFlagsFrom(c),
+		Timer:    timer,
+		Remote:   remote,
+		Filename: part.FileName(),
+		Size:     size,
+
+		Callbacks:  callbacks,
+		Encryption: encryption,
+	}, share, shareTTL, func() {
+		fetching.Lock()
+		defer fetching.Unlock()
+		if localPath != "" {
+			s.spool.Release(localPath)
+		}
+		s.spool.DiscardRemote(key)
+	})
+}
+
+type JSONUploadRequest struct {
+	Filename      string            `json:"filename" binding:"required"`
+	ContentBase64 string            `json:"content_base64" binding:"required"`
+	Metadata      map[string]string `json:"metadata"`
+	// ShareTTL asks for a share link expiring after this duration, e.g.
+	// "72h"; "0" creates one that does not expire
+	ShareTTL string `json:"share_ttl"`
+	// CallbackURL is sent the upload's upload.finalized or upload.failed
+	// event, signed with CallbackSecret (default: WEBHOOK_SECRET)
+	CallbackURL    string `json:"callback_url"`
+	CallbackSecret string `json:"callback_secret"`
+}
+
+// shareRequested reads a share_ttl value: whether a share link was asked for
+// and its lifetime, "0" meaning no expiry.
+func shareRequested(raw string) (bool, time.Duration, error) {
+	if raw == "" {
+		return false, 0, nil
+	}
+	if raw == "0" {
+		return true, 0, nil
+	}
+	ttl, err := parseShareTTL(raw, "share_ttl")
+	return err == nil, ttl, err
+}
+
+// uploadTuning is the request's transfer tuning with the replicas the
+// upload asked for, as a form field or a query parameter.
+func (s *Server) uploadTuning(c *gin.Context, fields map[string]string) (TransferTuning, error) {
+	tuning := transferTuningFrom(c)
+	raw, ok := fields["replicas"]
+	if !ok {
+		raw = c.Query("replicas")
+	}
+	replicas, err := s.transfers.Replicas(raw, s.network.Replicas)
+	if err != nil {
+		return TransferTuning{}, err
+	}
+	tuning.Replicas = replicas
+	return tuning, nil
+}
+
+// callbackRequested returns the callback an upload asked to be notified at,
+// if any.
+func (s *Server) callbackRequested(url, secret string) ([]Webhook, error) {
+	if url == "" {
+		return nil, nil
+	}
+	hook, err := s.webhooks.Callback(url, secret)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, "callback_url: %v", err)
+	}
+	return []Webhook{hook}, nil
+}
+
+// @Summary Upload a base64-encoded payload
+// @Description Stores a small payload sent as JSON, for clients that cannot send multipart/form-data (low-code tools, webhook senders). Metadata is kept in the catalog alongside the file. With share_ttl the response also carries a share link.
+// @Accept json
+// @Produce json
+// @Param request body JSONUploadRequest true "File content and metadata"
+// @Success 200 {object} UploadResponse
+// @Security ApiKeyAuth
+// @Router /upload/json [post]
+func (s *Server) handleUploadJSON(c *gin.Context) {
+	// base64 inflates the payload by a third, plus some room for the envelope
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, s.maxJSONUploadBytes*4/3+64<<10)
+
+	var req JSONUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("JSON uploads are limited to %d bytes", s.maxJSONUploadBytes)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	share, shareTTL, err := shareRequested(req.ShareTTL)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	callbacks, err := s.callbackRequested(req.CallbackURL, req.CallbackSecret)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	encryption, err := s.encryption.Requested(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	content, err := base64.StdEncoding.DecodeString(req.ContentBase64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "content_base64 is not valid base64"})
+		return
+	}
+	if len(content) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "content_base64 is empty"})
+		return
+	}
+	if int64(len(content)) > s.maxJSONUploadBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("JSON uploads are limited to %d bytes", s.maxJSONUploadBytes)})
+		return
+	}
+
+	timer := s.stages.NewTimer(c.Request.Context())
+	start := time.Now()
+	staged, err := s.spool.Stage(bytes.NewReader(content), "upload-json-*")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+	defer staged.Release()
+	timer.Since(StageSpool, start)
+
+	resp, err := s.storeUpload(uploadRequest{
+		Tenant:   tenantFrom(c),
+		Class:    requestClassFrom(c),
+		Tuning:   transferTuningFrom(c),
+		KeyID:    c.GetString(keyIDContextKey),
+		Origin:   requestOriginFrom(c),
+		Flags:    featureFlagsFrom(c),
+		Timer:    timer,
+		Path:     staged.Path,
+		Filename: filepath.Base(req.Filename),
+		Size:     int64(len(content)),
+		Metadata: req.Metadata,
+
+		Callbacks:  callbacks,
+		Encryption: encryption,
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	resp.Stages = timer.Millis()
+	if share {
+		s.shareUpload(c, &resp, shareTTL)
+	}
+
+	c.JSON(uploadStatus(resp), resp)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// zeroReader yields n zero bytes without holding them.
+type zeroReader struct{ n int64 }
+
+func (r *zeroReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+	clear(p)
+	r.n -= int64(len(p))
+	return len(p), nil
+}
+
+// heapPeak samples the heap until stop is called and returns the largest
+// size seen.
+func heapPeak() (stop func() uint64) {
+	var peak uint64
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		var m runtime.MemStats
+		for {
+			runtime.ReadMemStats(&m)
+			peak = max(peak, m.HeapAlloc)
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() uint64 {
+		close(done)
+		wg.Wait()
+		return peak
+	}
+}
+
+func TestStreamedFormKeepsTenGigabyteFilesOutOfMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes 10 GiB to the spool")
+	}
+	const size = 10 << 30
+	const heapBound = 64 << 20
+
+	spool, err := NewSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadPolicy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{spool: spool, policy: policy}
+
+	body, w := io.Pipe()
+	mw := multipart.NewWriter(w)
+	go func() {
+		mw.WriteField("paths", "big.bin")
+		part, err := mw.CreateFormFile("files", "big.bin")
+		if err != nil {
+			w.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(part, &zeroReader{n: size}); err != nil {
+			w.CloseWithError(err)
+			return
+		}
+		w.CloseWithError(mw.Close())
+	}()
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/api/v1/publish", body)
+	c.Request.Header.Set("Content-Type", mw.FormDataContentType())
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	stop := heapPeak()
+	form, err := s.readStreamedForm(c, 1)
+	peak := stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer form.Release(spool)
+
+	files := form.Files["files"]
+	if len(files) != 1 || files[0].Size != size {
+		t.Fatalf("files = %+v, want one of %d bytes", files, size)
+	}
+	if peak > before.HeapAlloc+heapBound {
+		t.Errorf("heap grew by %d MiB while streaming, want under %d MiB", (peak-before.HeapAlloc)>>20, heapBound>>20)
+	}
+}
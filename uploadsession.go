@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	uploadSessionTokenPrefix = "ups_"
+	uploadSessionContextKey  = "upload_session"
+	uploadSessionRoute       = "/api/v1/upload-sessions/:id/upload"
+	// uploadSessionMetadataKey is the metadata entry naming the session a
+	// file was uploaded in
+	uploadSessionMetadataKey = "upload_session"
+
+	defaultUploadSessionTTL = 15 * time.Minute
+	maxUploadSessionTTL     = 24 * time.Hour
+	// Tokens travel in a header, so what they carry is kept small
+	maxUploadSessionMetadataBytes = 2 << 10
+)
+
+// UploadSession is what an upload session token vouches for: one file of at
+// most MaxBytes uploaded as Tenant before ExpiresAt, on the gateway named by
+// its audience. The token is readable by whoever holds it, so it carries
+// nothing secret.
+type UploadSession struct {
+	ID          string            `json:"i"`
+	Tenant      string            `json:"t"`
+	MaxBytes    int64             `json:"m"`
+	ExpiresAt   int64             `json:"e"`
+	CallbackURL string            `json:"c,omitempty"`
+	Metadata    map[string]string `json:"d,omitempty"`
+	URLClaims
+}
+
+// UploadSessions signs upload session tokens. Replicas sharing the secret
+// accept each other's tokens.
+type UploadSessions struct {
+	secret []byte
+}
+
+// NewUploadSessions uses secret, or a random one that only this process
+// knows.
+func NewUploadSessions(secret string) *UploadSessions {
+	if secret != "" {
+		return &UploadSessions{secret: []byte(secret)}
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	log.Printf("⚠️  UPLOAD_SESSION_SECRET is not set: upload session tokens only work on this replica")
+	return &UploadSessions{secret: key}
+}
+
+func (t *UploadSessions) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Issue returns the token for session.
+func (t *UploadSessions) Issue(session UploadSession) string {
+	payload, _ := json.Marshal(session)
+	enc := base64.RawURLEncoding
+	return uploadSessionTokenPrefix + enc.EncodeToString(payload) + "." + enc.EncodeToString(t.sign(payload))
+}
+
+// Parse verifies a token presented to audience and returns the session it
+// carries.
+func (t *UploadSessions) Parse(token, audience string) (UploadSession, error) {
+	enc := base64.RawURLEncoding
+	payloadPart, sigPart, ok := strings.Cut(strings.TrimPrefix(token, uploadSessionTokenPrefix), ".")
+	if !ok {
+		return UploadSession{}, fmt.Errorf("malformed upload session token")
+	}
+	payload, err := enc.DecodeString(payloadPart)
+	if err != nil {
+		return UploadSession{}, fmt.Errorf("malformed upload session token")
+	}
+	sig, err := enc.DecodeString(sigPart)
+	if err != nil || !hmac.Equal(sig, t.sign(payload)) {
+		return UploadSession{}, fmt.Errorf("invalid upload session token")
+	}
+
+	var session UploadSession
+	if err := json.Unmarshal(payload, &session); err != nil {
+		return UploadSession{}, fmt.Errorf("malformed upload session token")
+	}
+	if err := session.Check("upload session token", session.ExpiresAt, maxUploadSessionTTL, audience); err != nil {
+		return UploadSession{}, err
+	}
+	return session, nil
+}
+
+// authenticateUploadSession handles a request presenting an upload session
+// token; ok is false when the key is not one. The token only opens its
+// session's upload route.
+func (s *Server) authenticateUploadSession(c *gin.Context, key string) (ok bool) {
+	if !strings.HasPrefix(key, uploadSessionTokenPrefix) {
+		return false
+	}
+	session, err := s.sessions.Parse(key, s.urlAudience(c))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return true
+	}
+	if c.FullPath() != uploadSessionRoute || c.Param("id") != session.ID {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "An upload session token can only upload to its own session"})
+		return true
+	}
+	c.Set(tenantContextKey, session.Tenant)
+	c.Set(keyIDContextKey, "session:"+session.ID)
+	c.Set(uploadSessionContextKey, session)
+	c.Next()
+	return true
+}
+
+// claimUploadSession marks a session used, across replicas when they share
+// a lock backend. unclaim frees it again after an upload that failed.
+func (s *Server) claimUploadSession(ctx context.Context, session UploadSession) (unclaim func(), err error) {
+	name := "upload-session/" + session.ID
+	ttl := time.Until(time.Unix(session.ExpiresAt, 0)) + time.Minute
+	token, ok, err := s.locks.TryAcquire(ctx, name, ttl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim upload session: %v", err)
+	}
+	if !ok {
+		return nil, newAPIError(http.StatusConflict, "Upload session %s was already used", session.ID)
+	}
+	return func() {
+		if err := s.locks.Release(context.Background(), name, token); err != nil {
+			log.Printf("⚠️  Failed to free upload session %s: %v", session.ID, err)
+		}
+	}, nil
+}
+
+type UploadSessionRequest struct {
+	// MaxBytes caps the size of the one file the session uploads
+	MaxBytes int64 `json:"max_bytes" binding:"required"`
+	// TTL is a Go duration, default 15m and at most 24h
+	TTL string `json:"ttl"`
+	// CallbackURL is sent the upload's events, signed with WEBHOOK_SECRET
+	CallbackURL string `json:"callback_url"`
+	// Metadata is stored with the file and sent in its events
+	Metadata map[string]string `json:"metadata"`
+}
+
+type UploadSessionResponse struct {
+	ID string `json:"id"`
+	// Token is sent by the browser as a Bearer token to UploadURL
+	Token     string    `json:"token"`
+	UploadURL string    `json:"upload_url"`
+	MaxBytes  int64     `json:"max_bytes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// @Summary Create a browser upload session
+// @Description For a dApp backend: issues a signed token that lets a browser upload one file of at most max_bytes as the calling tenant before the session expires, without ever holding an API key. The browser POSTs the file as multipart form field "file" to upload_url with "Authorization: Bearer {token}"; the token opens no other route. The outcome reaches callback_url and the tenant's webhooks as an upload.finalized or upload.failed event carrying the session's metadata and its ID under upload_session. A failed upload can be tried again with the same token.
+// @Accept json
+// @Produce json
+// @Param request body UploadSessionRequest true "Size cap, lifetime, callback and metadata"
+// @Success 201 {object} UploadSessionResponse
+// @Failure 400 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /upload-sessions [post]
+func (s *Server) handleCreateUploadSession(c *gin.Context) {
+	var req UploadSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tenant := tenantFrom(c)
+	if req.MaxBytes <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_bytes must be positive"})
+		return
+	}
+	if limit := s.maxFileBytesFor(tenant); limit > 0 && req.MaxBytes > limit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("max_bytes may be at most %d", limit)})
+		return
+	}
+	ttl := defaultUploadSessionTTL
+	if req.TTL != "" {
+		parsed, err := time.ParseDuration(req.TTL)
+		if err != nil || parsed <= 0 || parsed > maxUploadSessionTTL {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ttl must be a positive duration of at most 24h"})
+			return
+		}
+		ttl = parsed
+	}
+	if req.CallbackURL != "" {
+		if _, err := s.webhooks.Callback(req.CallbackURL, ""); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "callback_url: " + err.Error()})
+			return
+		}
+	}
+	if metadata, _ := json.Marshal(req.Metadata); len(metadata) > maxUploadSessionMetadataBytes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("metadata may take at most %d bytes", maxUploadSessionMetadataBytes)})
+		return
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	claims, err := newURLClaims(s.urlAudience(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	expires := time.Now().Add(ttl)
+	token := s.sessions.Issue(UploadSession{
+		ID:          id,
+		Tenant:      tenant,
+		MaxBytes:    req.MaxBytes,
+		ExpiresAt:   expires.Unix(),
+		CallbackURL: req.CallbackURL,
+		Metadata:    req.Metadata,
+		URLClaims:   claims,
+	})
+	c.JSON(http.StatusCreated, UploadSessionResponse{
+		ID:        id,
+		Token:     token,
+		UploadURL: strings.Replace(uploadSessionRoute, ":id", id, 1),
+		MaxBytes:  req.MaxBytes,
+		ExpiresAt: expires.Truncate(time.Second),
+	})
+}
+
+// @Summary Upload a file in a browser upload session
+// @Description Stores the one file an upload session allows, authenticated with the session's token instead of an API key. Files over the session's max_bytes are rejected with 413, and a session that already uploaded a file answers 409.
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "Upload session ID"
+// @Param Authorization header string true "Bearer {token}"
+// @Param file formData file true "File to upload"
+// @Param async query bool false "Answer 202 with a job ID right away and upload in the background"
+// @Success 200 {object} UploadResponse
+// @Success 202 {object} AsyncUploadResponse
+// @Failure 409 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Router /upload-sessions/{id}/upload [post]
+func (s *Server) handleSessionUpload(c *gin.Context) {
+	value, ok := c.Get(uploadSessionContextKey)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This route takes an upload session token"})
+		return
+	}
+	session := value.(UploadSession)
+
+	var callbacks []Webhook
+	if session.CallbackURL != "" {
+		hook, err := s.webhooks.Callback(session.CallbackURL, "")
+		if err != nil {
+			respondError(c, fmt.Errorf("session callback: %v", err))
+			return
+		}
+		callbacks = []Webhook{hook}
+	}
+	unclaim, err := s.claimUploadSession(c.Request.Context(), session)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	part, _, ok := filePart(c)
+	if !ok {
+		unclaim()
+		return
+	}
+	defer part.Close()
+
+	timer := s.stages.NewTimer(c.Request.Context())
+	start := time.Now()
+	admission := s.admissionReader(part, session.Tenant, part.FileName())
+	body := newUploadLimitReader(admission, session.MaxBytes)
+	staged, err := s.spool.Stage(body, "session-*")
+	if err != nil {
+		if body.Exceeded() {
+			err = uploadTooLarge(session.MaxBytes)
+		}
+		if rejected := admission.Rejected(); rejected != nil {
+			err = rejected
+		}
+		unclaim()
+		respondError(c, err)
+		return
+	}
+	timer.Since(StageSpool, start)
+
+	metadata := make(map[string]string, len(session.Metadata)+1)
+	for k, v := range session.Metadata {
+		metadata[k] = v
+	}
+	metadata[uploadSessionMetadataKey] = session.ID
+	s.respondUpload(c, uploadRequest{
+		Tenant:   session.Tenant,
+		Class:    requestClassFrom(c),
+		Tuning:   transferTuningFrom(c),
+		KeyID:    c.GetString(keyIDContextKey),
+		Origin:   requestOriginFrom(c),
+		Flags:    featureFlagsFrom(c),
+		Timer:    timer,
+		Path:     staged.Path,
+		Filename: part.FileName(),
+		Size:     staged.Size(),
+		Metadata: metadata,
+
+		Callbacks: callbacks,
+	}, false, 0, staged.Release)
+	// A session whose upload failed can be tried again
+	if c.Writer.Status() >= http.StatusBadRequest {
+		unclaim()
+	}
+}
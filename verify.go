@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/0glabs/0g-storage-client/node"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	verifyLock        = "catalog-verify"
+	verifyConcurrency = 8
+	// Reports kept on disk; older ones are removed as new ones are written
+	maxVerifyReports = 20
+
+	VerifyHealthy    = "healthy"
+	VerifyMissing    = "missing"
+	VerifyUnverified = "unverified"
+)
+
+var verifyReportName = regexp.MustCompile(`^[0-9a-f]+$`)
+
+type VerifyResult struct {
+	Checked    int `json:"checked"`
+	Healthy    int `json:"healthy"`
+	Missing    int `json:"missing"`
+	Unverified int `json:"unverified"`
+	// Report is where the per-file CSV report is downloaded from
+	Report string `json:"report"`
+}
+
+type VerifyResponse struct {
+	JobID string `json:"job_id"`
+	Files int    `json:"files"`
+}
+
+// objectCheck is what verification found out about one stored object.
+type objectCheck struct {
+	object StoredObject
+	status string
+	detail string
+}
+
+// VerifyReports keeps the CSV reports of catalog verifications in dir.
+type VerifyReports struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewVerifyReports(dir string) (*VerifyReports, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create report directory: %v", err)
+	}
+	return &VerifyReports{dir: dir}, nil
+}
+
+// Path returns the report of job id, if it has been written.
+func (r *VerifyReports) Path(id string) (string, bool) {
+	if !verifyReportName.MatchString(id) {
+		return "", false
+	}
+	path := filepath.Join(r.dir, id+".csv")
+	_, err := os.Stat(path)
+	return path, err == nil
+}
+
+// Write stores the report of job id and removes the oldest reports beyond
+// maxVerifyReports.
+func (r *VerifyReports) Write(id string, checks []objectCheck) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tmp := filepath.Join(r.dir, id+".csv.tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to write report: %v", err)
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"root_hash", "tx_hash", "size", "ref_count", "created_at", "status", "detail"})
+	for _, check := range checks {
+		w.Write([]string{
+			check.object.RootHash,
+			check.object.TxHash,
+			strconv.FormatInt(check.object.Size, 10),
+			strconv.Itoa(check.object.RefCount),
+			check.object.CreatedAt.Format(time.RFC3339),
+			check.status,
+			check.detail,
+		})
+	}
+	w.Flush()
+	err = w.Error()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(r.dir, id+".csv"))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write report: %v", err)
+	}
+
+	reports, _ := filepath.Glob(filepath.Join(r.dir, "*.csv"))
+	if len(reports) <= maxVerifyReports {
+		return nil
+	}
+	modTimes := make(map[string]time.Time, len(reports))
+	for _, path := range reports {
+		if info, err := os.Stat(path); err == nil {
+			modTimes[path] = info.ModTime()
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool { return modTimes[reports[i]].Before(modTimes[reports[j]]) })
+	for _, path := range reports[:len(reports)-maxVerifyReports] {
+		os.Remove(path)
+	}
+	return nil
+}
+
+// checkObject asks nodes about rootHash until one has it finalized. A file
+// no node knows is missing; one only known unfinalized, or not asked
+// successfully, is unverified.
+func checkObject(ctx context.Context, nodes []*node.ZgsClient, obj StoredObject) objectCheck {
+	check := objectCheck{object: obj, status: VerifyMissing}
+	var lastErr error
+	for _, n := range nodes {
+		info, err := n.GetFileInfo(ctx, common.HexToHash(obj.RootHash), true)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if info == nil {
+			continue
+		}
+		if info.Finalized {
+			check.status, check.detail = VerifyHealthy, "finalized on "+n.URL()
+			return check
+		}
+		segments := (info.Tx.Size + segmentSize - 1) / segmentSize
+		check.status = VerifyUnverified
+		check.detail = fmt.Sprintf("not finalized: %d of %d segments on %s", info.UploadedSegNum, segments, n.URL())
+	}
+	if check.status == VerifyMissing && lastErr != nil {
+		check.status, check.detail = VerifyUnverified, lastErr.Error()
+	}
+	return check
+}
+
+// runCatalogVerify checks every object of the catalog on the storage nodes
+// and writes the outcome for each to a CSV report.
+func (s *Server) runCatalogVerify(ctx context.Context, job *JobHandle, objects []StoredObject) (interface{}, error) {
+	nodes, err := s.client.selectNodesFor(ClassBatch)
+	if err != nil {
+		return nil, err
+	}
+
+	checks := make([]objectCheck, len(objects))
+	sem := make(chan struct{}, verifyConcurrency)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		done     int
+		reported int
+	)
+	for i, obj := range objects {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, obj StoredObject) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			lookupCtx, cancel := context.WithTimeout(ctx, time.Minute)
+			checks[i] = checkObject(lookupCtx, nodes, obj)
+			cancel()
+
+			mu.Lock()
+			done++
+			percent := done * 100 / len(objects)
+			if percent > reported {
+				reported = percent
+				job.SetProgress(fmt.Sprintf("verifying: %d of %d files", done, len(objects)), percent)
+			}
+			mu.Unlock()
+		}(i, obj)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result := VerifyResult{Checked: len(checks), Report: "/api/v1/admin/catalog/verify/" + job.id + "/report"}
+	for _, check := range checks {
+		switch check.status {
+		case VerifyHealthy:
+			result.Healthy++
+		case VerifyMissing:
+			result.Missing++
+		default:
+			result.Unverified++
+		}
+	}
+	if err := s.verifyReports.Write(job.id, checks); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// @Summary Verify the whole catalog
+// @Description Starts a job that asks the storage nodes about every object in the catalog and classes each as healthy (finalized on a node), missing (known to no node) or unverified (not finalized yet, or no node could be asked). Follow its progress with GET /admin/jobs/{id}; once it succeeds its result has the counts and the path of the CSV report.
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Success 202 {object} VerifyResponse
+// @Failure 409 {object} map[string]string
+// @Router /admin/catalog/verify [post]
+func (s *Server) handleVerifyCatalog(c *gin.Context) {
+	objects, _ := s.catalog.Export()
+	if len(objects) == 0 {
+		c.JSON(http.StatusOK, VerifyResponse{})
+		return
+	}
+
+	token, ok, err := s.locks.TryAcquire(c.Request.Context(), verifyLock, time.Hour)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "A catalog verification is already in progress"})
+		return
+	}
+
+	job, err := s.jobs.Start(adminJobOwner, "catalog_verify", traceFrom(c), func(ctx context.Context, job *JobHandle) (interface{}, error) {
+		defer s.locks.Release(context.Background(), verifyLock, token)
+		return s.runCatalogVerify(ctx, job, objects)
+	})
+	if err != nil {
+		s.locks.Release(context.Background(), verifyLock, token)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, VerifyResponse{JobID: job.ID, Files: len(objects)})
+}
+
+// @Summary Download a catalog verification report
+// @Description The CSV report of a finished catalog verification: one row per object with its root and transaction hashes, size, reference count, status (healthy, missing or unverified) and what was found. The last 20 reports are kept.
+// @Produce text/csv
+// @Param X-Admin-Token header string true "Admin token"
+// @Param id path string true "Job ID of the verification"
+// @Success 200 {file} binary
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /admin/catalog/verify/{id}/report [get]
+func (s *Server) handleVerifyReport(c *gin.Context) {
+	id := c.Param("id")
+	path, ok := s.verifyReports.Path(id)
+	if !ok {
+		if job, found := s.jobs.Get(adminJobOwner, id); found && !job.finished() {
+			c.JSON(http.StatusConflict, gin.H{"error": "The verification is still running"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Report not found"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "catalog-verify-"+id+".csv"))
+	c.File(path)
+}
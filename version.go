@@ -0,0 +1,122 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Set at build time with
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD)"
+//
+// Without them the commit comes from the VCS information Go embeds.
+var (
+	version = "0.0.0-dev"
+	commit  = ""
+)
+
+// versionModules are the dependencies whose versions are reported, by the
+// name they are reported under.
+var versionModules = map[string]string{
+	"0g-storage-client": "github.com/0glabs/0g-storage-client",
+	"go-ethereum":       "github.com/ethereum/go-ethereum",
+	"web3go":            "github.com/openweb3/web3go",
+	"gin":               "github.com/gin-gonic/gin",
+}
+
+// VersionInfo identifies the build and configuration a gateway runs.
+type VersionInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+	// CommitTime and Dirty come from the VCS information Go embeds
+	CommitTime string `json:"commit_time,omitempty"`
+	Dirty      bool   `json:"dirty,omitempty"`
+	GoVersion  string `json:"go_version"`
+	// SDKs maps dependencies to the versions built in
+	SDKs      map[string]string   `json:"sdks"`
+	Mode      ServiceMode         `json:"mode"`
+	Network   NetworkCapabilities `json:"network"`
+	Features  []string            `json:"features"`
+	StartedAt time.Time           `json:"started_at"`
+}
+
+// buildInfo reads what the binary knows about its own build.
+func buildInfo() VersionInfo {
+	info := VersionInfo{Version: version, Commit: commit, SDKs: make(map[string]string)}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = build.GoVersion
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		case "vcs.time":
+			info.CommitTime = setting.Value
+		case "vcs.modified":
+			info.Dirty = setting.Value == "true"
+		}
+	}
+	for _, dep := range build.Deps {
+		for name, path := range versionModules {
+			if dep.Path == path {
+				info.SDKs[name] = dep.Version
+			}
+		}
+	}
+	return info
+}
+
+// versionInfo is buildInfo with what this instance is configured to do.
+func (s *Server) versionInfo() VersionInfo {
+	info := buildInfo()
+	caps := s.capabilities()
+	info.Mode = caps.Mode
+	info.Network = caps.Network
+	info.Features = []string{}
+	for name, on := range caps.Features {
+		if on {
+			info.Features = append(info.Features, name)
+		}
+	}
+	sort.Strings(info.Features)
+	info.StartedAt = s.startedAt
+	return info
+}
+
+// logBanner logs what is starting, one line per part, so the first lines of
+// a deployment's logs identify it.
+func (s *Server) logBanner() {
+	info := s.versionInfo()
+	commit := info.Commit
+	if commit == "" {
+		commit = "unknown"
+	}
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	if info.Dirty {
+		commit += "-dirty"
+	}
+	log.Printf("🏷️  0G storage gateway %s (commit %s, %s, 0g-storage-client %s)", info.Version, commit, info.GoVersion, info.SDKs["0g-storage-client"])
+	log.Printf("🏷️  Network %s (chain %d), %s mode, %d replicas, waits until %s", info.Network.Name, info.Network.ChainID, info.Mode, info.Network.Replicas, info.Network.Finality)
+	log.Printf("🏷️  Features: %s", strings.Join(info.Features, ", "))
+}
+
+// @Summary Gateway version
+// @Description What this gateway runs, for deployments and support: its release version and git commit, the Go and SDK versions it was built with, the 0G network and service mode it is configured for, and the optional features enabled. It needs no API key.
+// @Produce json
+// @Success 200 {object} VersionInfo
+// @Router /version [get]
+func (s *Server) handleVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, s.versionInfo())
+}
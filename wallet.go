@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/0glabs/0g-storage-client/common/blockchain"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/accounts/usbwallet"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/openweb3/web3go"
+	"github.com/openweb3/web3go/interfaces"
+	"github.com/openweb3/web3go/signers"
+	"golang.org/x/term"
+)
+
+// Wallet backends (WALLET_BACKEND)
+const (
+	WalletRaw      = "raw"
+	WalletKeystore = "keystore"
+	WalletKMS      = "kms"
+	WalletVault    = "vault"
+	WalletLedger   = "ledger"
+)
+
+// walletTimeout bounds one call to a remote signer or key store
+const walletTimeout = 30 * time.Second
+
+// Wallet holds the key the gateway pays for storage with. It has the shape
+// of web3go's Signer, so the SDK signs submissions through it whatever the
+// backend is.
+type Wallet interface {
+	Address() common.Address
+	SignTransaction(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
+	// SignMessage signs text as personal_sign does, with v as 27 or 28
+	SignMessage(text []byte) ([]byte, error)
+}
+
+// NewWallet opens the wallet WALLET_BACKEND selects.
+func NewWallet(cfg *Config) (Wallet, error) {
+	switch strings.ToLower(cfg.WalletBackend) {
+	case "", WalletRaw:
+		if cfg.PrivateKey == "" {
+			return nil, fmt.Errorf("PRIVATE_KEY environment variable is required. Please add it to .env file")
+		}
+		return NewKeyWallet(cfg.PrivateKey)
+	case WalletKeystore:
+		return OpenKeystoreWallet(cfg.WalletKeystore, cfg.WalletKeystorePasswordFile)
+	case WalletKMS:
+		return NewKMSWallet(cfg.WalletKMS)
+	case WalletVault:
+		return NewVaultWallet(cfg.WalletVault)
+	case WalletLedger:
+		return OpenLedgerWallet(cfg.WalletLedgerPath)
+	}
+	return nil, fmt.Errorf("unknown WALLET_BACKEND %q (want raw, keystore, kms, vault or ledger)", cfg.WalletBackend)
+}
+
+// newWeb3 connects to evmRPC with wallet signing transactions. Wallets that
+// hold the key in memory go through the SDK's own constructor, so they get
+// its connection settings.
+func newWeb3(evmRPC string, wallet Wallet) (*web3go.Client, error) {
+	if w, ok := wallet.(*keyWallet); ok {
+		return blockchain.NewWeb3(evmRPC, hex.EncodeToString(crypto.FromECDSA(w.key)))
+	}
+	option := web3go.ClientOption{SignerManager: signers.NewSignerManager([]interfaces.Signer{wallet})}
+	return web3go.NewClientWithOption(evmRPC, option)
+}
+
+// digestWallet is a Wallet built on a function that signs a 32-byte
+// digest, returning [R || S || V] with V as 0 or 1.
+type digestWallet struct {
+	address common.Address
+	sign    func(digest []byte) ([]byte, error)
+}
+
+func (w *digestWallet) Address() common.Address {
+	return w.address
+}
+
+func (w *digestWallet) SignTransaction(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	signer := types.LatestSignerForChainID(chainID)
+	hash := signer.Hash(tx)
+	sig, err := w.sign(hash[:])
+	if err != nil {
+		return nil, err
+	}
+	return tx.WithSignature(signer, sig)
+}
+
+func (w *digestWallet) SignMessage(text []byte) ([]byte, error) {
+	sig, err := w.sign(accounts.TextHash(text))
+	if err != nil {
+		return nil, err
+	}
+	sig[64] += 27
+	return sig, nil
+}
+
+// keyWallet signs with a private key held in memory. The raw, keystore and
+// vault backends all end up with one.
+type keyWallet struct {
+	digestWallet
+	key *ecdsa.PrivateKey
+}
+
+func newKeyWallet(key *ecdsa.PrivateKey) *keyWallet {
+	return &keyWallet{
+		digestWallet: digestWallet{
+			address: crypto.PubkeyToAddress(key.PublicKey),
+			sign: func(digest []byte) ([]byte, error) {
+				return crypto.Sign(digest, key)
+			},
+		},
+		key: key,
+	}
+}
+
+// NewKeyWallet signs with a hex private key.
+func NewKeyWallet(privateKey string) (Wallet, error) {
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(strings.TrimSpace(privateKey), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %v", err)
+	}
+	return newKeyWallet(key), nil
+}
+
+// OpenKeystoreWallet decrypts a geth keystore file. The passphrase is read
+// from passwordFile or, without one, asked for on the terminal.
+func OpenKeystoreWallet(path, passwordFile string) (Wallet, error) {
+	if path == "" {
+		return nil, fmt.Errorf("WALLET_KEYSTORE is required for the keystore wallet")
+	}
+	keyJSON, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore: %v", err)
+	}
+	passphrase, err := keystorePassphrase(path, passwordFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keystore.DecryptKey(keyJSON, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt keystore %s: %v", path, err)
+	}
+	return newKeyWallet(key.PrivateKey), nil
+}
+
+func keystorePassphrase(path, passwordFile string) (string, error) {
+	if passwordFile != "" {
+		data, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read keystore password file: %v", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("WALLET_KEYSTORE_PASSWORD_FILE is required when the server is not started from a terminal")
+	}
+	fmt.Fprintf(os.Stderr, "🔑 Passphrase for %s: ", path)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %v", err)
+	}
+	return string(passphrase), nil
+}
+
+// KMSConfig names an asymmetric ECC_SECG_P256K1 signing key in AWS KMS and
+// the credentials to use it with.
+type KMSConfig struct {
+	KeyID        string
+	Region       string
+	Endpoint     string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// kmsClient calls the KMS JSON API, signed with Signature Version 4.
+type kmsClient struct {
+	cfg  KMSConfig
+	url  string
+	http *http.Client
+}
+
+func (k *kmsClient) call(action string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), walletTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	if k.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", k.cfg.SessionToken)
+	}
+	payloadHash := sha256.Sum256(body)
+	signV4(req, "/", "", hex.EncodeToString(payloadHash[:]), "kms", k.cfg.Region, k.cfg.AccessKey, k.cfg.SecretKey)
+
+	resp, err := k.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("KMS %s returned %s: %s", action, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// NewKMSWallet signs with a key that never leaves AWS KMS. Its address is
+// derived from the public key once, at startup.
+func NewKMSWallet(cfg KMSConfig) (Wallet, error) {
+	if cfg.KeyID == "" || cfg.Region == "" {
+		return nil, fmt.Errorf("WALLET_KMS_KEY_ID and WALLET_KMS_REGION are required for the kms wallet")
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://kms." + cfg.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid KMS endpoint %q", endpoint)
+	}
+	client := &kmsClient{cfg: cfg, url: u.Scheme + "://" + u.Host + "/", http: &http.Client{}}
+
+	// []byte fields are base64 in JSON, as KMS sends and expects them
+	var key struct {
+		PublicKey []byte
+	}
+	if err := client.call("GetPublicKey", map[string]string{"KeyId": cfg.KeyID}, &key); err != nil {
+		return nil, fmt.Errorf("failed to get KMS public key: %v", err)
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(key.PublicKey, &spki); err != nil {
+		return nil, fmt.Errorf("failed to parse KMS public key: %v", err)
+	}
+	pub, err := crypto.UnmarshalPubkey(spki.PublicKey.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("KMS key %s is not a secp256k1 key: %v", cfg.KeyID, err)
+	}
+
+	address := crypto.PubkeyToAddress(*pub)
+	return &digestWallet{
+		address: address,
+		sign: func(digest []byte) ([]byte, error) {
+			return client.sign(digest, address)
+		},
+	}, nil
+}
+
+// sign has KMS sign digest and turns its DER signature into the form
+// Ethereum expects.
+func (k *kmsClient) sign(digest []byte, address common.Address) ([]byte, error) {
+	var out struct {
+		Signature []byte
+	}
+	in := map[string]interface{}{
+		"KeyId":            k.cfg.KeyID,
+		"Message":          digest,
+		"MessageType":      "DIGEST",
+		"SigningAlgorithm": "ECDSA_SHA_256",
+	}
+	if err := k.call("Sign", in, &out); err != nil {
+		return nil, fmt.Errorf("failed to sign with KMS: %v", err)
+	}
+	var der struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(out.Signature, &der); err != nil {
+		return nil, fmt.Errorf("failed to parse KMS signature: %v", err)
+	}
+	// Only the lower of the two equivalent s values is valid on Ethereum
+	n := crypto.S256().Params().N
+	if der.S.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		der.S.Sub(n, der.S)
+	}
+	sig := make([]byte, 65)
+	der.R.FillBytes(sig[:32])
+	der.S.FillBytes(sig[32:64])
+	// KMS gives no recovery id; it is whichever one recovers our address
+	for v := byte(0); v < 2; v++ {
+		sig[64] = v
+		if pub, err := crypto.SigToPub(digest, sig); err == nil && crypto.PubkeyToAddress(*pub) == address {
+			return sig, nil
+		}
+	}
+	return nil, fmt.Errorf("KMS signature does not recover to %s", address.Hex())
+}
+
+// VaultConfig locates a private key encrypted under a HashiCorp Vault
+// transit key.
+type VaultConfig struct {
+	Addr  string
+	Token string
+	// Mount is where the transit engine is mounted (default transit)
+	Mount string
+	Key   string
+	// Ciphertext is the hex private key as transit/encrypt returned it (vault:v1:...)
+	Ciphertext string
+}
+
+// NewVaultWallet has Vault's transit engine decrypt the gateway's key at
+// startup. Transit cannot hold secp256k1 keys, so it cannot sign Ethereum
+// transactions itself; instead the key is kept encrypted under a transit
+// key, only exists in memory while the server runs, and every unseal is
+// subject to Vault's policies and audit log.
+func NewVaultWallet(cfg VaultConfig) (Wallet, error) {
+	if cfg.Addr == "" || cfg.Token == "" || cfg.Key == "" || cfg.Ciphertext == "" {
+		return nil, fmt.Errorf("VAULT_ADDR, VAULT_TOKEN, WALLET_VAULT_KEY and WALLET_VAULT_CIPHERTEXT are required for the vault wallet")
+	}
+	body, err := json.Marshal(map[string]string{"ciphertext": cfg.Ciphertext})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), walletTimeout)
+	defer cancel()
+	target := strings.TrimSuffix(cfg.Addr, "/") + "/v1/" + strings.Trim(cfg.Mount, "/") + "/decrypt/" + url.PathEscape(cfg.Key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", cfg.Token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Vault: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("Vault decrypt returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var out struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to decode Vault response: %v", err)
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(out.Data.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Vault plaintext: %v", err)
+	}
+	return NewKeyWallet(string(plaintext))
+}
+
+// ledgerWallet signs on a Ledger device, which shows every transaction
+// for approval. The device cannot sign arbitrary messages, so responses
+// need RESPONSE_SIGNING_KEY when SIGN_RESPONSES is on.
+type ledgerWallet struct {
+	wallet  accounts.Wallet
+	account accounts.Account
+}
+
+// OpenLedgerWallet opens the first Ledger connected over USB, with the
+// Ethereum app open, and uses the account at path (default m/44'/60'/0'/0/0).
+func OpenLedgerWallet(path string) (Wallet, error) {
+	derivation := accounts.DefaultBaseDerivationPath
+	if path != "" {
+		var err error
+		if derivation, err = accounts.ParseDerivationPath(path); err != nil {
+			return nil, fmt.Errorf("invalid WALLET_LEDGER_PATH: %v", err)
+		}
+	}
+	hub, err := usbwallet.NewLedgerHub()
+	if err != nil {
+		return nil, fmt.Errorf("failed to access USB devices: %v", err)
+	}
+	wallets := hub.Wallets()
+	if len(wallets) == 0 {
+		return nil, fmt.Errorf("no Ledger found; connect and unlock it and open the Ethereum app")
+	}
+	if err := wallets[0].Open(""); err != nil {
+		return nil, fmt.Errorf("failed to open Ledger: %v", err)
+	}
+	account, err := wallets[0].Derive(derivation, true)
+	if err != nil {
+		wallets[0].Close()
+		return nil, fmt.Errorf("failed to derive Ledger account: %v", err)
+	}
+	return &ledgerWallet{wallet: wallets[0], account: account}, nil
+}
+
+func (w *ledgerWallet) Address() common.Address {
+	return w.account.Address
+}
+
+func (w *ledgerWallet) SignTransaction(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return w.wallet.SignTx(w.account, tx, chainID)
+}
+
+func (w *ledgerWallet) SignMessage(text []byte) ([]byte, error) {
+	return w.wallet.SignText(w.account, text)
+}
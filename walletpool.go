@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+
+	"github.com/0glabs/0g-storage-client/node"
+	"github.com/0glabs/0g-storage-client/transfer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"github.com/openweb3/web3go"
+	"github.com/openweb3/web3go/types"
+)
+
+// poolAccount is one funded account in a WalletPool with its own web3
+// client, so transactions sent through it come from its address.
+type poolAccount struct {
+	wallet     Wallet
+	web3Client *web3go.Client
+
+	// Guarded by the pool's mu
+	busy bool
+	// nonce is the next nonce to use, or nil when it has to be read from
+	// the chain again
+	nonce       *big.Int
+	submissions int64
+	failures    int64
+}
+
+// WalletPool spreads submissions across several funded accounts. Uploads
+// sent from one account at once race for the same nonce, so each upload
+// has an account to itself until it returns, and the pool keeps track of
+// the account's next nonce instead of asking the chain every time.
+type WalletPool struct {
+	accounts []*poolAccount
+	idle     chan *poolAccount
+
+	mu      sync.Mutex
+	waiting int
+}
+
+type WalletAccountStats struct {
+	Address string `json:"address"`
+	Busy    bool   `json:"busy"`
+	// Nonce is the next nonce the pool will use, when it knows it
+	Nonce       *uint64 `json:"nonce,omitempty"`
+	Submissions int64   `json:"submissions"`
+	Failures    int64   `json:"failures"`
+	// BalanceWei is read from the chain when the stats are requested
+	BalanceWei string `json:"balance_wei,omitempty"`
+}
+
+type WalletPoolStats struct {
+	Accounts []WalletAccountStats `json:"accounts"`
+	// Waiting counts uploads queued for an idle account
+	Waiting int `json:"waiting"`
+}
+
+// NewWalletPool connects each wallet to evmRPC. Accounts are handed out in
+// turn, the one idle longest first.
+func NewWalletPool(evmRPC string, wallets []Wallet) (*WalletPool, error) {
+	p := &WalletPool{idle: make(chan *poolAccount, len(wallets))}
+	seen := make(map[common.Address]bool)
+	for _, w := range wallets {
+		if seen[w.Address()] {
+			return nil, fmt.Errorf("account %s is in the wallet pool twice", w.Address().Hex())
+		}
+		seen[w.Address()] = true
+		web3Client, err := newWeb3(evmRPC, w)
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("failed to create web3 client for %s: %v", w.Address().Hex(), err)
+		}
+		account := &poolAccount{wallet: w, web3Client: web3Client}
+		p.accounts = append(p.accounts, account)
+		p.idle <- account
+	}
+	return p, nil
+}
+
+func (p *WalletPool) Close() {
+	for _, account := range p.accounts {
+		account.web3Client.Close()
+	}
+}
+
+// Acquire waits for an idle account and returns it with the nonce its next
+// transaction should use (nil lets the SDK look it up).
+func (p *WalletPool) Acquire(ctx context.Context) (*poolAccount, *big.Int, error) {
+	p.mu.Lock()
+	p.waiting++
+	p.mu.Unlock()
+
+	var account *poolAccount
+	select {
+	case account = <-p.idle:
+	case <-ctx.Done():
+	}
+
+	p.mu.Lock()
+	p.waiting--
+	if account == nil {
+		p.mu.Unlock()
+		return nil, nil, fmt.Errorf("no wallet became free: %v", ctx.Err())
+	}
+	account.busy = true
+	nonce := account.nonce
+	p.mu.Unlock()
+
+	// The account is ours until it is released, so no one else can move
+	// its nonce while it is looked up
+	if nonce == nil {
+		pending := types.BlockNumberOrHashWithNumber(types.PendingBlockNumber)
+		count, err := account.web3Client.Eth.TransactionCount(account.wallet.Address(), &pending)
+		if err != nil || count == nil {
+			return account, nil, nil
+		}
+		p.mu.Lock()
+		account.nonce = count
+		p.mu.Unlock()
+		nonce = count
+	}
+	return account, new(big.Int).Set(nonce), nil
+}
+
+// Release returns account to the pool after a submission that sent txHash
+// (zero when the file was already on chain and nothing was sent). After a
+// failure the nonce is read from the chain again, since it is unknown
+// whether the transaction went out.
+func (p *WalletPool) Release(account *poolAccount, txHash common.Hash, err error) {
+	p.mu.Lock()
+	account.busy = false
+	switch {
+	case err != nil:
+		account.nonce = nil
+		account.failures++
+	case txHash != (common.Hash{}):
+		if account.nonce != nil {
+			account.nonce = new(big.Int).Add(account.nonce, big.NewInt(1))
+		}
+		account.submissions++
+	}
+	p.mu.Unlock()
+	p.idle <- account
+}
+
+func (p *WalletPool) Stats() WalletPoolStats {
+	p.mu.Lock()
+	stats := WalletPoolStats{Accounts: make([]WalletAccountStats, 0, len(p.accounts)), Waiting: p.waiting}
+	for _, account := range p.accounts {
+		s := WalletAccountStats{
+			Address:     account.wallet.Address().Hex(),
+			Busy:        account.busy,
+			Submissions: account.submissions,
+			Failures:    account.failures,
+		}
+		if account.nonce != nil {
+			nonce := account.nonce.Uint64()
+			s.Nonce = &nonce
+		}
+		stats.Accounts = append(stats.Accounts, s)
+	}
+	p.mu.Unlock()
+
+	// Balances are read outside the lock, which submissions need
+	for i, account := range p.accounts {
+		if balance, err := account.web3Client.Eth.Balance(account.wallet.Address(), nil); err == nil && balance != nil {
+			stats.Accounts[i].BalanceWei = balance.String()
+		}
+	}
+	return stats
+}
+
+// withAccount runs send with a web3 client whose transactions come from an
+// account of the wallet pool, and the nonce to use (nil to look it up).
+// Without a pool the gateway's own wallet is used. send returns the hash
+// of the transaction it sent.
+func (c *StorageClient) withAccount(ctx context.Context, send func(web3Client *web3go.Client, nonce *big.Int) (common.Hash, error)) error {
+	if c.wallets == nil {
+		_, err := send(c.web3Client, nil)
+		return err
+	}
+	account, nonce, err := c.wallets.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	txHash, err := send(account.web3Client, nonce)
+	c.wallets.Release(account, txHash, err)
+	return err
+}
+
+// withUploader is withAccount for uploads to nodes.
+func (c *StorageClient) withUploader(ctx context.Context, nodes []*node.ZgsClient, send func(uploader *transfer.Uploader, nonce *big.Int) (common.Hash, error)) error {
+	return c.withAccount(ctx, func(web3Client *web3go.Client, nonce *big.Int) (common.Hash, error) {
+		uploader, err := c.uploaderFor(ctx, web3Client, nodes)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		return send(uploader, nonce)
+	})
+}
+
+// @Summary Wallet pool
+// @Description The accounts uploads are submitted from, whether each is in use, the next nonce the pool will give it, its submissions, failures and balance, and how many uploads are waiting for a free account
+// @Produce json
+// @Param X-Admin-Token header string true "Admin token"
+// @Success 200 {object} WalletPoolStats
+// @Failure 404 {object} map[string]string
+// @Router /admin/wallets [get]
+func (s *Server) handleWalletPool(c *gin.Context) {
+	if s.client.wallets == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Wallet pool is not configured"})
+		return
+	}
+	c.JSON(http.StatusOK, s.client.wallets.Stats())
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	EventUploadFinalized = "upload.finalized"
+	EventUploadFailed    = "upload.failed"
+	EventLinkExpiring    = "link.expiring"
+	// EventUploadQuarantined is sent when the upload policy holds an upload
+	EventUploadQuarantined = "upload.quarantined"
+
+	// Status of an upload in its events
+	UploadStatusFinalized   = "finalized"
+	UploadStatusFailed      = "failed"
+	UploadStatusQuarantined = "quarantined"
+	UploadStatusRejected    = "rejected"
+
+	webhookAttempts = 4
+	webhookTimeout  = 10 * time.Second
+	// webhookResolveTimeout bounds the DNS lookup checking a webhook's host
+	webhookResolveTimeout = 5 * time.Second
+)
+
+var webhookEventTypes = map[string]bool{
+	EventUploadFinalized: true,
+	EventUploadFailed:    true,
+	EventLinkExpiring:    true,
+
+	EventUploadQuarantined: true,
+}
+
+// WebhookEvent is the JSON body POSTed to webhook endpoints.
+type WebhookEvent struct {
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	Tenant       string `json:"tenant"`
+	RootHash     string `json:"root_hash,omitempty"`
+	TxHash       string `json:"tx_hash,omitempty"`
+	Filename     string `json:"filename,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+	Status       string `json:"status,omitempty"`
+	Deduplicated bool   `json:"deduplicated,omitempty"`
+	QuarantineID string `json:"quarantine_id,omitempty"`
+	Error        string `json:"error,omitempty"`
+	LinkID       string `json:"link_id,omitempty"`
+	// Metadata is the upload's metadata, such as the upload session it
+	// came from
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	ExpiresAt  *time.Time        `json:"expires_at,omitempty"`
+	OccurredAt time.Time         `json:"occurred_at"`
+}
+
+// Webhook is a tenant-owned endpoint notified about that tenant's uploads.
+// An empty Events list subscribes to every event type.
+type Webhook struct {
+	ID        string    `json:"id"`
+	Tenant    string    `json:"tenant"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (w *Webhook) wants(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookRequest struct {
+	URL    string   `json:"url" binding:"required"`
+	Events []string `json:"events"`
+}
+
+// validate checks a tenant's webhook, which must not point into the
+// gateway's own network.
+func (r *WebhookRequest) validate() error {
+	u, err := r.parse()
+	if err != nil {
+		return err
+	}
+	return checkWebhookHost(u.Hostname())
+}
+
+// parse checks the URL and event types without resolving the host.
+func (r *WebhookRequest) parse() (*url.URL, error) {
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("url must be an absolute http(s) URL")
+	}
+	for _, e := range r.Events {
+		if !webhookEventTypes[e] {
+			return nil, fmt.Errorf("unknown event type %q", e)
+		}
+	}
+	return u, nil
+}
+
+// checkWebhookHost rejects hosts that are, or resolve to, loopback,
+// link-local, private or unspecified addresses, so tenants cannot make the
+// gateway POST to services only it can reach. Deliveries are checked again
+// when they connect, as DNS may answer differently by then.
+func checkWebhookHost(host string) error {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("url must not point at localhost")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookResolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %v", host, err)
+	}
+	for _, addr := range addrs {
+		if internalAddress(addr.IP) {
+			return fmt.Errorf("url must not point at an internal address (%s resolves to %s)", host, addr.IP)
+		}
+	}
+	return nil
+}
+
+func internalAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// webhookDialControl refuses connections to internal addresses, whatever
+// the webhook's host resolved to when it was registered, and after
+// redirects.
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || internalAddress(ip) {
+		return fmt.Errorf("refusing to deliver a webhook to internal address %s", host)
+	}
+	return nil
+}
+
+// newWebhookClient returns the client tenant webhooks are delivered with.
+// It connects directly rather than through a proxy from the environment, so
+// that the address it checks is the one it delivers to.
+func newWebhookClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   webhookTimeout,
+		KeepAlive: 30 * time.Second,
+		Control:   webhookDialControl,
+	}).DialContext
+	return &http.Client{Timeout: webhookTimeout, Transport: transport}
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// WebhookStore holds every tenant's webhooks and delivers events to them.
+type WebhookStore struct {
+	mu       sync.RWMutex
+	path     string
+	webhooks map[string]*Webhook
+	client   *http.Client
+
+	// global, when set, receives the events of every tenant. The operator
+	// configures it, so it may be internal and is delivered with
+	// globalClient
+	global       *Webhook
+	globalClient *http.Client
+	// secret signs deliveries to global and to per-upload callbacks that
+	// bring no secret of their own
+	secret string
+}
+
+func NewWebhookStore(path string) (*WebhookStore, error) {
+	store := &WebhookStore{
+		path:     path,
+		webhooks: make(map[string]*Webhook),
+		client:   newWebhookClient(),
+
+		globalClient: &http.Client{Timeout: webhookTimeout},
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	var hooks []*Webhook
+	if err := readJSONFile(path, &hooks); err != nil {
+		return nil, fmt.Errorf("failed to load webhooks: %v", err)
+	}
+	for _, h := range hooks {
+		store.webhooks[h.ID] = h
+	}
+	return store, nil
+}
+
+// Configure sets the webhook notified about every tenant's events, if url is
+// not empty, and the secret its deliveries are signed with.
+func (s *WebhookStore) Configure(url, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secret = secret
+	if url == "" {
+		return nil
+	}
+	req := WebhookRequest{URL: url}
+	if _, err := req.parse(); err != nil {
+		return err
+	}
+	if secret == "" {
+		return fmt.Errorf("a secret is required to sign deliveries")
+	}
+	s.global = &Webhook{ID: "global", URL: url, Secret: secret}
+	return nil
+}
+
+// Callback returns a webhook for one upload's events, signed with secret or,
+// without one, with the configured secret.
+func (s *WebhookStore) Callback(url, secret string) (Webhook, error) {
+	req := WebhookRequest{URL: url}
+	if err := req.validate(); err != nil {
+		return Webhook{}, err
+	}
+	if secret == "" {
+		s.mu.RLock()
+		secret = s.secret
+		s.mu.RUnlock()
+	}
+	if secret == "" {
+		return Webhook{}, fmt.Errorf("a callback secret is required to sign deliveries")
+	}
+	return Webhook{ID: "callback", URL: url, Secret: secret}, nil
+}
+
+func (s *WebhookStore) Create(tenant string, req WebhookRequest) (Webhook, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return Webhook{}, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hook := &Webhook{
+		ID:        id,
+		Tenant:    tenant,
+		URL:       req.URL,
+		Events:    req.Events,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}
+	s.webhooks[id] = hook
+	return *hook, s.saveLocked()
+}
+
+func (s *WebhookStore) Update(tenant, id string, req WebhookRequest) (Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hook, ok := s.webhooks[id]
+	if !ok || hook.Tenant != tenant {
+		return Webhook{}, ErrNotFound
+	}
+	hook.URL = req.URL
+	hook.Events = req.Events
+	return *hook, s.saveLocked()
+}
+
+func (s *WebhookStore) Delete(tenant, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hook, ok := s.webhooks[id]
+	if !ok || hook.Tenant != tenant {
+		return ErrNotFound
+	}
+	delete(s.webhooks, id)
+	return s.saveLocked()
+}
+
+func (s *WebhookStore) Get(tenant, id string) (Webhook, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hook, ok := s.webhooks[id]
+	if !ok || hook.Tenant != tenant {
+		return Webhook{}, false
+	}
+	return *hook, true
+}
+
+func (s *WebhookStore) List(tenant string) []Webhook {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hooks := []Webhook{}
+	for _, h := range s.webhooks {
+		if h.Tenant == tenant {
+			hooks = append(hooks, *h)
+		}
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].CreatedAt.Before(hooks[j].CreatedAt) })
+	return hooks
+}
+
+func (s *WebhookStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	hooks := make([]*Webhook, 0, len(s.webhooks))
+	for _, h := range s.webhooks {
+		hooks = append(hooks, h)
+	}
+	if err := writeJSONFile(s.path, hooks); err != nil {
+		return fmt.Errorf("failed to write webhooks: %v", err)
+	}
+	return nil
+}
+
+// Publish delivers event in the background to every webhook of the event's
+// tenant that subscribed to its type, to the global webhook and to extra.
+func (s *WebhookStore) Publish(event WebhookEvent, extra ...Webhook) {
+	if event.ID == "" {
+		event.ID, _ = randomHex(8)
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+
+	s.mu.RLock()
+	var targets []Webhook
+	for _, h := range s.webhooks {
+		if h.Tenant == event.Tenant && h.wants(event.Type) {
+			targets = append(targets, *h)
+		}
+	}
+	if s.global != nil {
+		targets = append(targets, *s.global)
+	}
+	s.mu.RUnlock()
+	targets = append(targets, extra...)
+
+	for _, hook := range targets {
+		go s.deliver(hook, event)
+	}
+}
+
+func (s *WebhookStore) deliver(hook Webhook, event WebhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("⚠️  Failed to encode webhook event: %v", err)
+		return
+	}
+
+	backoff := time.Second
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		err = s.post(hook, event, body)
+		if err == nil {
+			return
+		}
+		if attempt < webhookAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	log.Printf("⚠️  Webhook %s delivery of %s failed after %d attempts: %v", hook.ID, event.ID, webhookAttempts, err)
+}
+
+func (s *WebhookStore) post(hook Webhook, event WebhookEvent, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write(body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event.Type)
+	req.Header.Set("X-Webhook-ID", event.ID)
+	req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	client := s.client
+	if hook.ID == "global" {
+		client = s.globalClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// @Summary List webhooks
+// @Description Lists the calling tenant's webhooks
+// @Produce json
+// @Param fields query string false "Comma-separated fields to return"
+// @Param envelope query bool false "Wrap the response in {data, count}"
+// @Success 200 {array} Webhook
+// @Security ApiKeyAuth
+// @Router /webhooks [get]
+func (s *Server) handleListWebhooks(c *gin.Context) {
+	respondSelected(c, http.StatusOK, s.webhooks.List(tenantFrom(c)))
+}
+
+// @Summary Register a webhook
+// @Description Registers an endpoint notified about the calling tenant's uploads and links. events may contain any of upload.finalized, upload.failed, upload.quarantined and link.expiring; empty means all. Deliveries carry an X-Webhook-Signature HMAC-SHA256 of the body keyed with the returned secret.
+// @Accept json
+// @Produce json
+// @Param request body WebhookRequest true "Webhook"
+// @Success 201 {object} Webhook
+// @Security ApiKeyAuth
+// @Router /webhooks [post]
+func (s *Server) handleCreateWebhook(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hook, err := s.webhooks.Create(tenantFrom(c), req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, hook)
+}
+
+// @Summary Get a webhook
+// @Produce json
+// @Param id path string true "Webhook ID"
+// @Param fields query string false "Comma-separated fields to return"
+// @Param envelope query bool false "Wrap the response in {data, count}"
+// @Success 200 {object} Webhook
+// @Security ApiKeyAuth
+// @Router /webhooks/{id} [get]
+func (s *Server) handleGetWebhook(c *gin.Context) {
+	hook, ok := s.webhooks.Get(tenantFrom(c), c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	respondSelected(c, http.StatusOK, hook)
+}
+
+// @Summary Update a webhook
+// @Accept json
+// @Produce json
+// @Param id path string true "Webhook ID"
+// @Param request body WebhookRequest true "Webhook"
+// @Success 200 {object} Webhook
+// @Security ApiKeyAuth
+// @Router /webhooks/{id} [put]
+func (s *Server) handleUpdateWebhook(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hook, err := s.webhooks.Update(tenantFrom(c), c.Param("id"), req)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, hook)
+}
+
+// @Summary Delete a webhook
+// @Param id path string true "Webhook ID"
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /webhooks/{id} [delete]
+func (s *Server) handleDeleteWebhook(c *gin.Context) {
+	err := s.webhooks.Delete(tenantFrom(c), c.Param("id"))
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// capabilitiesVersion is bumped when GatewayCapabilities changes
+// incompatibly.
+const capabilitiesVersion = 1
+
+// GatewayCapabilities describes what this gateway offers, for SDKs and
+// other gateways to configure themselves against it.
+type GatewayCapabilities struct {
+	Version int                 `json:"version"`
+	Mode    ServiceMode         `json:"mode"`
+	Network NetworkCapabilities `json:"network"`
+	Limits  LimitCapabilities   `json:"limits"`
+	Auth    AuthCapabilities    `json:"auth"`
+	// Features lists optional behaviors and whether they are available; for
+	// feature flags this is the default, which tenants may differ from
+	Features map[string]bool `json:"features"`
+	// PublicIDScheme is the scheme of the IDs uploads get, or "root"
+	PublicIDScheme string `json:"public_id_scheme"`
+	// SignerAddress signs downloads, when signed responses are enabled
+	SignerAddress string `json:"signer_address,omitempty"`
+	// Endpoints maps operations to the routes this instance serves them on,
+	// with parameters in {braces}
+	Endpoints map[string]EndpointCapability `json:"endpoints"`
+}
+
+type NetworkCapabilities struct {
+	Name       string `json:"name"`
+	ChainID    uint64 `json:"chain_id"`
+	EvmRPC     string `json:"evm_rpc"`
+	IndexerRPC string `json:"indexer_rpc"`
+	Replicas   uint   `json:"replicas"`
+	// Finality is finalized or packed: what uploads wait for
+	Finality string `json:"finality"`
+}
+
+type LimitCapabilities struct {
+	// MaxUploadBytes is 0 when unlimited
+	MaxUploadBytes     int64 `json:"max_upload_bytes"`
+	MaxJSONUploadBytes int64 `json:"max_json_upload_bytes"`
+	MaxDirectoryFiles  int   `json:"max_directory_files"`
+	SegmentSize        int64 `json:"segment_size"`
+}
+
+type AuthCapabilities struct {
+	// Modes is api_key when API keys are required, otherwise none
+	Modes   []string `json:"modes"`
+	Headers []string `json:"headers,omitempty"`
+}
+
+type EndpointCapability struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+// capabilityEndpoints are the operations advertised, by gin route.
+var capabilityEndpoints = map[string]EndpointCapability{
+	"upload":             {http.MethodPost, "/api/v1/upload"},
+	"upload_constraints": {http.MethodOptions, "/api/v1/upload"},
+	"upload_json":        {http.MethodPost, "/api/v1/upload/json"},
+	"upload_directory":   {http.MethodPost, "/api/v1/upload/dir"},
+	"multipart_initiate": {http.MethodPost, "/api/v1/multipart"},
+	"resumable_create":   {http.MethodPost, "/api/v1/uploads"},
+	"estimate":           {http.MethodPost, "/api/v1/estimate"},
+	"upload_session":     {http.MethodPost, "/api/v1/upload-sessions"},
+	"download":           {http.MethodGet, "/api/v1/download/:root_hash"},
+	"download_directory": {http.MethodGet, "/api/v1/download/dir/:manifest_root/*path"},
+	"gateway":            {http.MethodGet, "/gw/:root_hash/*path"},
+	"zip":                {http.MethodPost, "/api/v1/zip"},
+	"files":              {http.MethodGet, "/api/v1/files"},
+	"file_history":       {http.MethodGet, "/api/v1/files/history"},
+	"file_info":          {http.MethodPost, "/api/v1/files/info"},
+	"file_status":        {http.MethodGet, "/api/v1/files/:root_hash/status"},
+	"search":             {http.MethodGet, "/api/v1/search"},
+	"manifests":          {http.MethodPost, "/api/v1/manifests"},
+	"publish":            {http.MethodPost, "/api/v1/publish"},
+	"job":                {http.MethodGet, "/api/v1/jobs/:id"},
+	"job_events":         {http.MethodGet, "/api/v1/jobs/:id/events"},
+	"receipt":            {http.MethodGet, "/api/v1/receipts/:tx_hash"},
+	"tx_status":          {http.MethodGet, "/api/v1/tx/:tx_hash"},
+	"links":              {http.MethodPost, "/api/v1/links"},
+	"webhooks":           {http.MethodPost, "/api/v1/webhooks"},
+	"account":            {http.MethodGet, "/api/v1/me"},
+	"network":            {http.MethodGet, "/api/v1/network"},
+	"version":            {http.MethodGet, "/api/v1/version"},
+	"openapi":            {http.MethodGet, "/swagger/doc.json"},
+}
+
+var routeParam = regexp.MustCompile(`[:*]([a-z_]+)`)
+
+// capabilities describes this instance as it is configured now.
+func (s *Server) capabilities() GatewayCapabilities {
+	auth := AuthCapabilities{Modes: []string{"none"}}
+	if s.keys.Enabled() {
+		auth = AuthCapabilities{Modes: []string{"api_key"}, Headers: []string{"X-API-Key", "Authorization: Bearer"}}
+	}
+	publicIDs := "root"
+	if s.publicIDs != nil {
+		publicIDs = s.publicIDs.Name
+	}
+	caps := GatewayCapabilities{
+		Version: capabilitiesVersion,
+		Mode:    s.mode,
+		Network: NetworkCapabilities{
+			Name:       s.network.Name,
+			ChainID:    s.network.ChainID,
+			EvmRPC:     s.network.EvmRPC,
+			IndexerRPC: s.network.IndexerRPC,
+			Replicas:   s.network.Replicas,
+			Finality:   finalityName(s.network.Finality),
+		},
+		Limits: LimitCapabilities{
+			MaxUploadBytes:     s.maxUploadBytes,
+			MaxJSONUploadBytes: s.maxJSONUploadBytes,
+			MaxDirectoryFiles:  maxPublishFiles,
+			SegmentSize:        segmentSize,
+		},
+		Auth: auth,
+		Features: map[string]bool{
+			"deduplication":      true,
+			"multipart_upload":   true,
+			"resumable_upload":   true,
+			"directory_upload":   true,
+			"range_requests":     true,
+			"share_links":        true,
+			"webhooks":           true,
+			"upload_callbacks":   true,
+			"encryption":         true,
+			"async_upload":       s.flags.On(nil, FlagAsyncUpload),
+			"streaming_download": s.flags.On(nil, FlagStreamingDownload),
+			"batching":           s.batcher != nil && s.flags.On(nil, FlagBatching),
+			"upload_history":     s.history != nil,
+			"signed_responses":   s.signer != nil,
+			"streams":            s.streams != nil,
+			"kv":                 s.kv != nil,
+			"app_settings":       s.settings != nil,
+			"sponsored_uploads":  s.sponsored != nil,
+			"download_cache":     s.cache != nil,
+			"moderation":         s.moderation != nil,
+		},
+		PublicIDScheme: publicIDs,
+		Endpoints:      make(map[string]EndpointCapability),
+	}
+	if s.signer != nil {
+		caps.SignerAddress = s.signer.Address()
+	}
+	for name, ep := range capabilityEndpoints {
+		if s.mode.Serves(ep.Method, ep.Path) {
+			caps.Endpoints[name] = EndpointCapability{Method: ep.Method, Path: routeParam.ReplaceAllString(ep.Path, "{$1}")}
+		}
+	}
+	return caps
+}
+
+// @Summary Gateway capabilities
+// @Description Machine-readable description of this gateway for SDKs and other gateways to configure themselves: the 0G network it writes to, size limits, authentication, optional features and the endpoints this instance serves. It needs no API key.
+// @Produce json
+// @Success 200 {object} GatewayCapabilities
+// @Router /.well-known/storage-gateway [get]
+func (s *Server) handleWellKnown(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, s.capabilities())
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxZipMembers = 1000
+
+type ZipRequest struct {
+	ManifestRoot string   `json:"manifest_root" binding:"required"`
+	Paths        []string `json:"paths" binding:"required"`
+	Filename     string   `json:"filename"`
+}
+
+// selectManifestEntries resolves requested paths against a manifest. A path
+// ending in "/" selects everything beneath that directory.
+func selectManifestEntries(m *Manifest, requested []string) ([]string, error) {
+	selected := make(map[string]bool)
+	for _, p := range requested {
+		if strings.HasSuffix(p, "/") {
+			prefix := strings.TrimPrefix(path.Clean("/"+p), "/")
+			if prefix != "" {
+				prefix += "/"
+			}
+			matched := false
+			for name := range m.Files {
+				if strings.HasPrefix(name, prefix) {
+					selected[name] = true
+					matched = true
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("no files under %q", p)
+			}
+			continue
+		}
+
+		name, err := normalizeManifestPath(p)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := m.Lookup(name); !ok {
+			return nil, fmt.Errorf("%q not found in manifest", p)
+		}
+		selected[name] = true
+	}
+
+	names := make([]string, 0, len(selected))
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// @Summary Download selected manifest entries as a zip
+// @Description Streams a zip archive containing only the requested paths of a directory manifest. Only the selected files are fetched from 0G. Paths ending in "/" select a whole directory.
+// @Accept json
+// @Produce application/zip
+// @Param request body ZipRequest true "Manifest and member paths"
+// @Success 200 {file} binary
+// @Failure 404 {object} map[string]string
+// @Failure 451 {object} map[string]string
+// @Security ApiKeyAuth
+// @Router /zip [post]
+func (s *Server) handleZip(c *gin.Context) {
+	var req ZipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	manifestRoot := s.resolveRef(req.ManifestRoot)
+	if s.withheld(c, manifestRoot, false) || s.outsideDomain(c, manifestRoot) {
+		return
+	}
+	m, raw, err := s.loadManifest(manifestRoot)
+	if err == errNotManifest {
+		raw.Release()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Object is not a directory manifest"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	names, err := selectManifestEntries(m, req.Paths)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if len(names) > maxZipMembers {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d files can be zipped at once", maxZipMembers)})
+		return
+	}
+
+	filename := req.Filename
+	if filename == "" {
+		filename = "files.zip"
+	}
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(filename)))
+	c.Status(http.StatusOK)
+
+	// Headers are sent: from here on failures can only truncate the archive
+	zw := zip.NewWriter(c.Writer)
+	for _, name := range names {
+		entry, _ := m.Lookup(name)
+		if s.abuse.Blocked(entry.RootHash) || (s.moderation != nil && s.moderation.Withheld(entry.RootHash, true)) {
+			continue
+		}
+		if err := s.writeZipMember(zw, name, entry); err != nil {
+			log.Printf("⚠️  Zip of %s aborted at %s: %v", req.ManifestRoot, name, err)
+			return
+		}
+		c.Writer.Flush()
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("⚠️  Failed to finish zip of %s: %v", req.ManifestRoot, err)
+	}
+}
+
+func (s *Server) writeZipMember(zw *zip.Writer, name string, entry ManifestEntry) error {
+	obj, err := s.fetchObject(entry.RootHash)
+	if err != nil {
+		return err
+	}
+	defer obj.Release()
+
+	f, err := os.Open(obj.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}